@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/scaleway/scaleway-csi/driver"
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-csi/scheduler"
+	"github.com/scaleway/scaleway-sdk-go/scw"
 	"k8s.io/klog/v2"
 )
 
@@ -13,30 +20,253 @@ var (
 	endpoint = flag.String("endpoint", "unix:/tmp/csi.sock", "CSI endpoint")
 	prefix   = flag.String("prefix", "", "Prefix to add in block volume name")
 	version  = flag.Bool("version", false, "Print the version and exit")
+	output   = flag.String("output", "text", "Format of the version printed by --version, text or json")
 	mode     = flag.String("mode", string(driver.AllMode), "The mode in which the CSI driver will be run (all, node, controller)")
+	backend  = flag.String("backend", string(driver.ScalewayBackend), "The backend of the volumes (scaleway, fake), fake keeps them in memory and never mounts anything")
+
+	loggingFormat = flag.String("logging-format", driver.LoggingFormatText, "Format of the logs (text, json)")
+
+	deviceWaitTimeout = flag.Duration("device-wait-timeout", 30*time.Second, "Maximum duration to wait for the device of a volume to appear when staging it (node only)")
+	diskPrefixes      = flag.String("disk-prefixes", strings.Join(driver.DefaultDiskPrefixes, ","), "Comma-separated prefixes of the /dev/disk/by-id symlinks of the volumes, followed by the volume ID (node only)")
+	cryptsetupPath    = flag.String("cryptsetup-path", "", "Path of the cryptsetup binary, looked up in the PATH and the sbin directories if empty (node only)")
+	nodeID            = flag.String("node-id", os.Getenv(driver.NodeIDEnv), "ID of the instance, or providerID of the Kubernetes Node, used when the metadata API is unreachable, defaults to $"+driver.NodeIDEnv+" (node only)")
+	nodeZone          = flag.String("node-zone", os.Getenv(driver.NodeZoneEnv), "Zone of the instance, used with --node-id when the metadata API is unreachable, defaults to $"+driver.NodeZoneEnv+" (node only)")
+
+	nodeLabelTopology = flag.String("node-label-topology", "", "Comma-separated labels of the Kubernetes Node added to the topology of the node along with its zone and region, e.g. a rack label (node only)")
+	nodeName          = flag.String("node-name", os.Getenv(driver.NodeNameEnv), "Name of the Kubernetes Node, used to read the labels of --node-label-topology, defaults to $"+driver.NodeNameEnv+" (node only)")
+
+	reservedVolumeSlots = flag.Int("reserved-volume-slots", 0, "Number of attachment slots of each instance kept for the volumes attached outside of the driver, must be the same on the controller and the nodes")
+
+	stagingGCInterval = flag.Duration("staging-gc-interval", time.Hour, "Interval between two removals of the empty staging directories left by failed stages, disabled if 0 (node only)")
+	stagingGCMinAge   = flag.Duration("staging-gc-min-age", 24*time.Hour, "Age after which an empty and unmounted staging directory is removed (node only)")
+	stagingGCRoot     = flag.String("staging-gc-root", "", "Directory in which the kubelet creates the staging directories of the volumes, /var/lib/kubelet/plugins/kubernetes.io/csi/<driver name> if empty (node only)")
+
+	scratchDir = flag.String("scratch-dir", "", "Writable directory for the temporary files of the node and the tools it runs, to run with a read-only root filesystem (node only)")
+
+	cleanupOnShutdown = flag.Bool("cleanup-on-shutdown", false, "Unmount all the volumes and close their LUKS mappings when stopping, for nodes being terminated (node only)")
+
+	luksJanitorInterval = flag.Duration("luks-janitor-interval", 0, "Interval at which the LUKS mappings whose device no longer exists are closed, disabled if 0 (node only)")
+	luksLazyClose       = flag.Bool("luks-lazy-close", false, "Defer the removal of the LUKS mappings still busy when unstaging their volume instead of failing, device-mapper removing them once released (node only)")
+
+	formatTimeout = flag.Duration("format-timeout", 10*time.Minute, "Timeout of the formatting of the devices of the volumes, mkfs being killed after it, disabled if 0 (node only)")
+
+	enableEphemeralVolumes = flag.Bool("enable-ephemeral-volumes", false, "Create, attach and delete the volumes of the inline ephemeral volumes of the pods, with the Scaleway credentials of the node (node only)")
+
+	requireEncryption = flag.Bool("require-encryption", false, "Reject the creation of volumes without the encrypted parameter set to true (controller only)")
+	disableEncryption = flag.Bool("disable-encryption", false, "Reject the encrypted volumes and never run cryptsetup, for the hosts where it is not installed")
+
+	forceDeleteDetachedGrace = flag.Duration("force-delete-detached-grace", 0, "Detach volumes still attached without any VolumeAttachment after this duration when deleting them, disabled if 0 (controller only)")
+
+	maxAPIReads  = flag.Int("max-api-reads", 0, "Maximum number of concurrent reads (GET) to the Scaleway API, unlimited if 0")
+	maxAPIWrites = flag.Int("max-api-writes", 0, "Maximum number of concurrent writes to the Scaleway API, separate from the reads so that they can't delay the attachments, unlimited if 0")
+
+	createFailureBackoff = flag.Duration("create-failure-backoff", 10*time.Second, "Duration during which a failed volume creation returns its error again without calling the API when retried identically, doubled at each failure up to 5m, disabled if 0 (controller only)")
+
+	zoneExhaustionCooldown = flag.Duration("zone-exhaustion-cooldown", 10*time.Minute, "Duration during which a zone out of stock is tried last when creating the volumes which can be created in several zones, disabled if 0 (controller only)")
+
+	listManagedVolumesOnly = flag.Bool("list-managed-volumes-only", false, "Only list the volumes with the managed-by tag of the driver, hiding the volumes created before it or imported (controller only)")
+
+	refuseDeleteWithSnapshots = flag.Bool("refuse-delete-with-snapshots", false, "Refuse to delete the volumes which still have snapshots not taken with retainSnapshotsOnVolumeDelete, instead of deleting them (controller only)")
+
+	parallelZoneCreate = flag.Bool("parallel-zone-create", false, "Create the volumes in all the zones allowed by their topology at once, keeping the first one created and deleting the others (controller only)")
+
+	enableSnapshotScheduler = flag.Bool("enable-snapshot-scheduler", false, "Create and rotate the VolumeSnapshots of the PersistentVolumeClaims annotated with "+scheduler.ScheduleAnnotation+" (controller only)")
+
+	emitEvents = flag.Bool("emit-events", false, "Post Events on the PersistentVolumeClaims and PersistentVolumes whose volume cannot be created or attached (controller only)")
+
+	controllerRPCTimeout = flag.Duration("controller-rpc-timeout", 0, "Deadline of the controller RPCs, on top of the one set by the sidecars, disabled if 0 (controller only)")
+
+	apiCacheTTL = flag.Duration("api-cache-ttl", scaleway.DefaultAPICacheTTL, "Duration during which the volume and server lookups of ControllerPublishVolume are reused, disabled if 0 (controller only)")
+
+	forceDetachInterval = flag.Duration("force-detach-interval", 0, "Interval at which the volumes of the VolumeAttachments and PersistentVolumes annotated with "+driver.ForceDetachAnnotation+"=true are detached, disabled if 0 (controller only)")
+
+	detachRetryInterval = flag.Duration("detach-retry-interval", 0, "Interval at which the detachments refused by the API with a transient error, e.g. during a maintenance, are retried in the background, disabled if 0 (controller only)")
+
+	clusterID        = flag.String("cluster-id", "", "ID of the cluster, set in a cluster-id= tag of the volumes it creates (controller only)")
+	orphanGCInterval = flag.Duration("orphan-gc-interval", 0, "Interval at which the volumes tagged with the --cluster-id and without PersistentVolume are looked for, disabled if 0 (controller only)")
+	orphanGCGrace    = flag.Duration("orphan-gc-grace", time.Hour, "Duration after which a volume without PersistentVolume is reported as orphaned (controller only)")
+	orphanGCDelete   = flag.Bool("orphan-gc-delete", false, "Delete the orphaned volumes instead of only reporting them (controller only)")
+
+	driverName     = flag.String("driver-name", driver.DefaultDriverName, "Name of the driver, also used in the keys of the publish and volume contexts, the managed-by tag of the volumes and the annotations, for white-label deployments")
+	topologyPrefix = flag.String("topology-prefix", "", "Prefix of the zone and region topology keys, topology.<driver name> if empty")
+
+	configFile = flag.String("config", "", "Path of the YAML file with the verbosity of the logs, the rate limit of the Scaleway API calls and the toggles of the events and metrics, reloaded when it changes, not used if empty")
+
+	metricsAddr = flag.String("metrics-addr", "", "Address on which to serve the metrics on /debug/vars, including the usage of the volumes staged on the node, disabled if empty")
+
+	debugAddr = flag.String("debug-addr", "", "Address on which to serve the pprof profiles on /debug/pprof/ and the state dump on /debug/state, disabled if empty")
+
+	selfTestAddr = flag.String("self-test-addr", "", "Address on which to serve the self-test HTTP trigger, disabled if empty (controller only)")
+	selfTestZone = flag.String("self-test-zone", "", "Zone in which the self-test creates its resources, defaults to the client default zone")
 )
 
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	verbosity, _ := strconv.Atoi(flag.Lookup("v").Value.String())
+	if err := driver.SetupLogging(*loggingFormat, verbosity); err != nil {
+		klog.Fatalln(err)
+	}
+
+	// the name is changed first, the subcommands using it too
+	if *driverName != driver.DefaultDriverName || *topologyPrefix != "" {
+		if err := driver.SetDriverName(*driverName, *topologyPrefix); err != nil {
+			klog.Fatalln(err)
+		}
+	}
+
 	if *version {
-		info := driver.GetVersion()
+		switch *output {
+		case "text":
+			fmt.Printf("%+v", driver.GetVersion())
+		case "json":
+			info, err := driver.GetVersionJSON()
+			if err != nil {
+				klog.Fatalln(err)
+			}
+			fmt.Println(info)
+		default:
+			klog.Fatalf("unknown output format %s", *output)
+		}
+		os.Exit(0)
+	}
 
-		fmt.Printf("%+v", info)
+	if flag.Arg(0) == "manifests" {
+		manifestsFlags := flag.NewFlagSet("manifests", flag.ExitOnError)
+		namespace := manifestsFlags.String("namespace", "kube-system", "Namespace in which the driver is deployed")
+		_ = manifestsFlags.Parse(flag.Args()[1:])
+
+		if err := driver.RenderManifests(os.Stdout, *namespace); err != nil {
+			klog.Fatalln(err)
+		}
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "support-bundle" {
+		supportBundleFlags := flag.NewFlagSet("support-bundle", flag.ExitOnError)
+		addr := supportBundleFlags.String("addr", "", "The --self-test-addr of the controller (e.g. localhost:8080)")
+		_ = supportBundleFlags.Parse(flag.Args()[1:])
+
+		if *addr == "" {
+			klog.Fatalln("-addr must be set to the --self-test-addr of the controller")
+		}
+		if err := driver.WriteSupportBundle(os.Stdout, *addr); err != nil {
+			klog.Fatalln(err)
+		}
+		os.Exit(0)
+	}
+
+	var zone scw.Zone
+	if *selfTestZone != "" {
+		var err error
+		zone, err = scw.ParseZone(*selfTestZone)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+	}
+
+	var parsedNodeZone scw.Zone
+	if *nodeZone != "" {
+		var err error
+		parsedNodeZone, err = scw.ParseZone(*nodeZone)
+		if err != nil {
+			klog.Fatalln(err)
+		}
+	}
+
 	scwDriver, err := driver.NewDriver(&driver.DriverConfig{
 		Endpoint: *endpoint,
 		Mode:     driver.Mode(*mode),
+		Backend:  driver.Backend(*backend),
 		Prefix:   *prefix,
+
+		DriverName:     *driverName,
+		TopologyPrefix: *topologyPrefix,
+
+		DeviceWaitTimeout: *deviceWaitTimeout,
+		DiskPrefixes:      splitList(*diskPrefixes),
+		CryptsetupPath:    *cryptsetupPath,
+		NodeID:            *nodeID,
+		NodeZone:          parsedNodeZone,
+
+		NodeLabelTopology: splitList(*nodeLabelTopology),
+		NodeName:          *nodeName,
+
+		ReservedVolumeSlots: *reservedVolumeSlots,
+
+		StagingGCInterval: *stagingGCInterval,
+		StagingGCMinAge:   *stagingGCMinAge,
+		StagingGCRoot:     *stagingGCRoot,
+
+		ScratchDir:        *scratchDir,
+		CleanupOnShutdown: *cleanupOnShutdown,
+
+		LUKSJanitorInterval: *luksJanitorInterval,
+		LUKSLazyClose:       *luksLazyClose,
+		FormatTimeout:       *formatTimeout,
+
+		EnableEphemeralVolumes: *enableEphemeralVolumes,
+
+		RequireEncryption:        *requireEncryption,
+		DisableEncryption:        *disableEncryption,
+		ForceDeleteDetachedGrace: *forceDeleteDetachedGrace,
+		ParallelZoneCreate:       *parallelZoneCreate,
+		ZoneExhaustionCooldown:   *zoneExhaustionCooldown,
+		CreateFailureBackoff:     *createFailureBackoff,
+		MaxAPIReads:              *maxAPIReads,
+		MaxAPIWrites:             *maxAPIWrites,
+		ForceDetachInterval:      *forceDetachInterval,
+		DetachRetryInterval:      *detachRetryInterval,
+		ClusterID:                *clusterID,
+		OrphanGCInterval:         *orphanGCInterval,
+		OrphanGCGrace:            *orphanGCGrace,
+		OrphanGCDelete:           *orphanGCDelete,
+		ControllerRPCTimeout:     *controllerRPCTimeout,
+		APICacheTTL:              *apiCacheTTL,
+		EnableSnapshotScheduler:  *enableSnapshotScheduler,
+		EmitEvents:               *emitEvents,
+
+		RefuseDeleteWithSnapshots: *refuseDeleteWithSnapshots,
+		ListManagedVolumesOnly:    *listManagedVolumesOnly,
+
+		ConfigFile: *configFile,
+
+		MetricsAddr: *metricsAddr,
+		DebugAddr:   *debugAddr,
+
+		SelfTestAddr: *selfTestAddr,
+		SelfTestZone: zone,
 	})
 	if err != nil {
 		klog.Fatalln(err)
 	}
 
-	if err := scwDriver.Run(); err != nil {
+	shutdownTracing, err := driver.SetupTracing(context.Background(), "scaleway-csi-"+*mode)
+	if err != nil {
 		klog.Fatalln(err)
 	}
+
+	runErr := scwDriver.Run()
+
+	// the last spans are flushed before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		klog.Errorf("error flushing the traces: %s", err)
+	}
+	if runErr != nil {
+		klog.Fatalln(runErr)
+	}
+}
+
+// splitList splits a comma-separated flag value, ignoring the empty elements
+func splitList(value string) []string {
+	var list []string
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
 }
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/scaleway/scaleway-csi/driver"
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+var (
+	all           = flag.Bool("all", false, "List all the volumes and snapshots of the project, not only the ones created by the driver")
+	timeout       = flag.Duration("timeout", 5*time.Minute, "Timeout of the command")
+	kubeconfig    = flag.String("kubeconfig", "", "Path to the kubeconfig file, used by nodes and check-pv")
+	loggingFormat = flag.String("logging-format", driver.LoggingFormatText, "Format of the logs (text, json)")
+	driverName    = flag.String("driver-name", driver.DefaultDriverName, "Name of the driver, for the white-label deployments")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] <command> [argument]
+
+Commands:
+  volumes                list the volumes as seen by the driver
+  snapshots              list the snapshots as seen by the driver
+  nodes                  list the volumes attached to each instance, with its Kubernetes node if -kubeconfig is set
+  force-detach <handle>  detach the volume with the given handle (zone/id or id) from its instance
+  check-pv <name>        check that the handle of the PersistentVolume resolves to a volume
+  check-handle <handle>  check that the handle (zone/id or id) resolves to a volume
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Usage = usage
+	flag.Parse()
+
+	verbosity, _ := strconv.Atoi(flag.Lookup("v").Value.String())
+	if err := driver.SetupLogging(*loggingFormat, verbosity); err != nil {
+		klog.Fatalln(err)
+	}
+
+	if *driverName != driver.DefaultDriverName {
+		if err := driver.SetDriverName(*driverName, ""); err != nil {
+			klog.Fatalln(err)
+		}
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	command, argument := args[0], ""
+	switch command {
+	case "force-detach", "check-pv", "check-handle":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		argument = args[1]
+	default:
+		if len(args) != 1 {
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	scwClient := scaleway.NewScaleway(fmt.Sprintf("%s-debug", driver.DriverName))
+
+	var err error
+	switch command {
+	case "volumes":
+		err = listVolumes(ctx, scwClient)
+	case "snapshots":
+		err = listSnapshots(ctx, scwClient)
+	case "nodes":
+		err = listNodes(ctx, scwClient)
+	case "force-detach":
+		err = forceDetach(ctx, scwClient, argument)
+	case "check-pv":
+		err = checkPersistentVolume(ctx, scwClient, argument)
+	case "check-handle":
+		err = checkHandle(ctx, scwClient, argument)
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		klog.Fatalln(err)
+	}
+}
+
+// zones returns the zones in which the resources are listed
+func zones(scwClient *scaleway.Scaleway) []scw.Zone {
+	if zones := scwClient.Zones(); len(zones) != 0 {
+		return zones
+	}
+	return []scw.Zone{""} // this will use the default zone of the client
+}
+
+// getVolumes returns the volumes of all the zones, only the ones created by the driver unless -all is set
+func getVolumes(ctx context.Context, scwClient *scaleway.Scaleway) ([]*instance.Volume, error) {
+	var volumes []*instance.Volume
+	for _, zone := range zones(scwClient) {
+		req := &instance.ListVolumesRequest{Zone: zone}
+		if !*all {
+			req.Tags = []string{driver.ManagedByTag()}
+		}
+		volumesResp, err := scwClient.ListVolumes(req, scw.WithContext(ctx), scw.WithAllPages())
+		if err != nil {
+			return nil, fmt.Errorf("error listing the volumes of zone %s: %w", zone, err)
+		}
+		volumes = append(volumes, volumesResp.Volumes...)
+	}
+	return volumes, nil
+}
+
+func listVolumes(ctx context.Context, scwClient *scaleway.Scaleway) error {
+	volumes, err := getVolumes(ctx, scwClient)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HANDLE\tNAME\tTYPE\tSIZE\tSTATE\tSERVER")
+	for _, volume := range volumes {
+		server := ""
+		if volume.Server != nil {
+			server = volume.Zone.String() + "/" + volume.Server.ID
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", scaleway.ExpandVolumeID(volume), volume.Name, volume.VolumeType, volume.Size, volume.State, server)
+	}
+	return w.Flush()
+}
+
+func listSnapshots(ctx context.Context, scwClient *scaleway.Scaleway) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HANDLE\tNAME\tSOURCE\tSIZE\tSTATE")
+	for _, zone := range zones(scwClient) {
+		req := &instance.ListSnapshotsRequest{Zone: zone}
+		if !*all {
+			tags := driver.ManagedByTag()
+			req.Tags = &tags
+		}
+		snapshotsResp, err := scwClient.ListSnapshots(req, scw.WithContext(ctx), scw.WithAllPages())
+		if err != nil {
+			return fmt.Errorf("error listing the snapshots of zone %s: %w", zone, err)
+		}
+		for _, snapshot := range snapshotsResp.Snapshots {
+			source := ""
+			if snapshot.BaseVolume != nil {
+				source = snapshot.Zone.String() + "/" + snapshot.BaseVolume.ID
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", scaleway.ExpandSnapshotID(snapshot), snapshot.Name, source, snapshot.Size, snapshot.State)
+		}
+	}
+	return w.Flush()
+}
+
+func listNodes(ctx context.Context, scwClient *scaleway.Scaleway) error {
+	volumes, err := getVolumes(ctx, scwClient)
+	if err != nil {
+		return err
+	}
+
+	// the Kubernetes nodes by server handle, from their provider ID
+	nodeNames := make(map[string]string)
+	if *kubeconfig != "" {
+		client, err := newKubeClient()
+		if err != nil {
+			return err
+		}
+		nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("error listing nodes: %w", err)
+		}
+		for _, node := range nodes.Items {
+			if handle := strings.TrimPrefix(node.Spec.ProviderID, "scaleway://instance/"); handle != node.Spec.ProviderID {
+				nodeNames[handle] = node.Name
+			}
+		}
+	}
+
+	attached := make(map[string][]*instance.Volume)
+	servers := make(map[string]string)
+	for _, volume := range volumes {
+		if volume.Server == nil {
+			continue
+		}
+		server := volume.Zone.String() + "/" + volume.Server.ID
+		attached[server] = append(attached[server], volume)
+		servers[server] = volume.Server.Name
+	}
+	handles := make([]string, 0, len(attached))
+	for handle := range attached {
+		handles = append(handles, handle)
+	}
+	sort.Strings(handles)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tSERVER NAME\tNODE\tVOLUMES")
+	for _, handle := range handles {
+		var volumeHandles []string
+		for _, volume := range attached[handle] {
+			volumeHandles = append(volumeHandles, scaleway.ExpandVolumeID(volume))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", handle, servers[handle], nodeNames[handle], strings.Join(volumeHandles, ","))
+	}
+	return w.Flush()
+}
+
+func forceDetach(ctx context.Context, scwClient *scaleway.Scaleway, handle string) error {
+	volume, err := getVolume(ctx, scwClient, handle)
+	if err != nil {
+		return err
+	}
+	if volume.Server == nil {
+		klog.Infof("volume %s is not attached, nothing to detach", scaleway.ExpandVolumeID(volume))
+		return nil
+	}
+
+	klog.Warningf("detaching volume %s from server %s, which can corrupt its filesystem if it is still mounted", scaleway.ExpandVolumeID(volume), volume.Server.ID)
+	_, err = scwClient.DetachVolume(&instance.DetachVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	volume, err = scwClient.WaitForVolumeContext(ctx, &instance.WaitForVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	})
+	if err != nil {
+		return err
+	}
+	klog.Infof("volume %s detached, in state %s", scaleway.ExpandVolumeID(volume), volume.State)
+	return nil
+}
+
+func checkPersistentVolume(ctx context.Context, scwClient *scaleway.Scaleway, name string) error {
+	client, err := newKubeClient()
+	if err != nil {
+		return err
+	}
+	pv, err := client.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driver.DriverName {
+		return fmt.Errorf("persistent volume %s is not a volume of %s", name, driver.DriverName)
+	}
+	return checkHandle(ctx, scwClient, pv.Spec.CSI.VolumeHandle)
+}
+
+func checkHandle(ctx context.Context, scwClient *scaleway.Scaleway, handle string) error {
+	volume, err := getVolume(ctx, scwClient, handle)
+	if err != nil {
+		return fmt.Errorf("handle %s does not resolve to a volume: %w", handle, err)
+	}
+	if !strings.Contains(handle, "/") {
+		klog.Warningf("handle %s has no zone, the driver looks the volume up in all the zones of the region", handle)
+	}
+	server := "none"
+	if volume.Server != nil {
+		server = volume.Server.ID
+	}
+	fmt.Printf("handle %s resolves to volume %s (%s), of type %s and size %s, in state %s, attached to server %s\n",
+		handle, scaleway.ExpandVolumeID(volume), volume.Name, volume.VolumeType, volume.Size, volume.State, server)
+	return nil
+}
+
+// getVolume returns the volume with the given handle, looking into all zones if the zone is not provided
+func getVolume(ctx context.Context, scwClient *scaleway.Scaleway, handle string) (*instance.Volume, error) {
+	volumeID, zone, err := driver.GetVolumeIDAndZone(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	if zone == scw.Zone("") {
+		return scwClient.GetVolumeInAllZones(volumeID, scw.WithContext(ctx))
+	}
+
+	volumeResp, err := scwClient.GetVolume(&instance.GetVolumeRequest{
+		VolumeID: volumeID,
+		Zone:     zone,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return volumeResp.Volume, nil
+}
+
+// newKubeClient returns a Kubernetes client using the -kubeconfig file or the default loading rules
+func newKubeClient() (kubernetes.Interface, error) {
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: *kubeconfig},
+		&clientcmd.ConfigOverrides{},
+	).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
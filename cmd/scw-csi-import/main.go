@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/scaleway/scaleway-csi/driver"
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	volume        = flag.String("volume", "", "ID of the Scaleway volume to import, as zone/id or id")
+	importBucket  = flag.String("import-bucket", "", "Object Storage bucket containing a snapshot exported by the driver, to import instead of -volume")
+	importKey     = flag.String("import-key", "", "Key of the exported snapshot in the -import-bucket bucket")
+	zone          = flag.String("zone", "", "Zone in which the volume is created from the exported snapshot, used with -import-bucket")
+	pvcName       = flag.String("pvc-name", "", "Name of the PersistentVolumeClaim to generate")
+	pvName        = flag.String("pv-name", "", "Name of the PersistentVolume to generate, defaults to the PersistentVolumeClaim name")
+	namespace     = flag.String("namespace", "default", "Namespace of the PersistentVolumeClaim")
+	storageClass  = flag.String("storage-class", "scw-bssd", "StorageClass of the generated objects")
+	fsType        = flag.String("fs-type", "", "Filesystem type of the volume, the driver default is used if empty")
+	apply         = flag.Bool("apply", false, "Create the objects in the cluster instead of printing them")
+	kubeconfig    = flag.String("kubeconfig", "", "Path to the kubeconfig file, used with -apply")
+	loggingFormat = flag.String("logging-format", driver.LoggingFormatText, "Format of the logs (text, json)")
+
+	driverName     = flag.String("driver-name", driver.DefaultDriverName, "Name of the driver, for the white-label deployments")
+	topologyPrefix = flag.String("topology-prefix", "", "Prefix of the zone topology key, topology.<driver name> if empty")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	verbosity, _ := strconv.Atoi(flag.Lookup("v").Value.String())
+	if err := driver.SetupLogging(*loggingFormat, verbosity); err != nil {
+		klog.Fatalln(err)
+	}
+
+	if *driverName != driver.DefaultDriverName || *topologyPrefix != "" {
+		if err := driver.SetDriverName(*driverName, *topologyPrefix); err != nil {
+			klog.Fatalln(err)
+		}
+	}
+
+	importing := *importBucket != "" || *importKey != ""
+	if (*volume == "") == !importing || *pvcName == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *pvName == "" {
+		*pvName = *pvcName
+	}
+
+	var vol *instance.Volume
+	var err error
+	if importing {
+		vol, err = importVolume(*importBucket, *importKey, *zone)
+	} else {
+		vol, err = getVolume(*volume)
+	}
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	if vol.VolumeType != scaleway.DefaultVolumeType {
+		klog.Fatalf("volume %s is of type %s, only %s volumes can be imported", vol.ID, vol.VolumeType, scaleway.DefaultVolumeType)
+	}
+	if vol.Server != nil {
+		klog.Warningf("volume %s is attached to server %s, it needs to be detached before being used", vol.ID, vol.Server.ID)
+	}
+
+	pv, pvc := newPersistentVolume(vol), newPersistentVolumeClaim(vol)
+
+	if !*apply {
+		for _, obj := range []interface{}{pv, pvc} {
+			out, err := yaml.Marshal(obj)
+			if err != nil {
+				klog.Fatalln(err)
+			}
+			fmt.Printf("---\n%s", out)
+		}
+		return
+	}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: *kubeconfig},
+		&clientcmd.ConfigOverrides{},
+	).ClientConfig()
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	ctx := context.Background()
+	if _, err := client.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{}); err != nil {
+		klog.Fatalln(err)
+	}
+	klog.Infof("persistent volume %s created", pv.Name)
+	if _, err := client.CoreV1().PersistentVolumeClaims(*namespace).Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+		klog.Fatalln(err)
+	}
+	klog.Infof("persistent volume claim %s/%s created", *namespace, pvc.Name)
+}
+
+// getVolume returns the volume with the given handle, looking into all zones if the zone is not provided
+func getVolume(handle string) (*instance.Volume, error) {
+	scwClient := scaleway.NewScaleway(fmt.Sprintf("%s-import", driver.DriverName))
+
+	volumeID, zone, err := driver.GetVolumeIDAndZone(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	if zone == scw.Zone("") {
+		return scwClient.GetVolumeInAllZones(volumeID)
+	}
+
+	volumeResp, err := scwClient.GetVolume(&instance.GetVolumeRequest{
+		VolumeID: volumeID,
+		Zone:     zone,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return volumeResp.Volume, nil
+}
+
+// importVolume creates a volume from a snapshot exported to Object Storage,
+// the intermediate snapshot is deleted once the volume is created
+func importVolume(bucket string, key string, zone string) (*instance.Volume, error) {
+	if bucket == "" || key == "" || zone == "" {
+		return nil, fmt.Errorf("-import-bucket, -import-key and -zone are needed to import an exported snapshot")
+	}
+	parsedZone, err := scw.ParseZone(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	scwClient := scaleway.NewScaleway(fmt.Sprintf("%s-import", driver.DriverName))
+
+	snapshotResp, err := scwClient.CreateSnapshot(&instance.CreateSnapshotRequest{
+		Zone:       parsedZone,
+		Name:       *pvName,
+		VolumeType: instance.SnapshotVolumeType(scaleway.DefaultVolumeType),
+		Bucket:     &bucket,
+		Key:        &key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error importing %s/%s: %w", bucket, key, err)
+	}
+	snapshot, err := scwClient.WaitForSnapshot(&instance.WaitForSnapshotRequest{
+		SnapshotID: snapshotResp.Snapshot.ID,
+		Zone:       parsedZone,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.State != instance.SnapshotStateAvailable {
+		return nil, fmt.Errorf("snapshot %s imported from %s/%s is in state %s", snapshot.ID, bucket, key, snapshot.State)
+	}
+	klog.Infof("snapshot %s imported from %s/%s", snapshot.ID, bucket, key)
+
+	vol, err := scwClient.CreateVolumeFromSnapshot(context.Background(), &instance.CreateVolumeRequest{
+		Zone:         parsedZone,
+		Name:         *pvName,
+		VolumeType:   scaleway.DefaultVolumeType,
+		BaseSnapshot: &snapshot.ID,
+	}, snapshot.Size)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("volume %s created from snapshot %s", vol.ID, snapshot.ID)
+
+	if err := scwClient.DeleteSnapshot(&instance.DeleteSnapshotRequest{
+		SnapshotID: snapshot.ID,
+		Zone:       parsedZone,
+	}); err != nil {
+		klog.Warningf("error deleting the intermediate snapshot %s: %s", snapshot.ID, err)
+	}
+	return vol, nil
+}
+
+func newPersistentVolume(vol *instance.Volume) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "PersistentVolume",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: *pvName,
+			Annotations: map[string]string{
+				"pv.kubernetes.io/provisioned-by": driver.DriverName,
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: *resource.NewQuantity(int64(vol.Size), resource.BinarySI),
+			},
+			AccessModes:                   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			StorageClassName:              *storageClass,
+			ClaimRef: &corev1.ObjectReference{
+				Namespace: *namespace,
+				Name:      *pvcName,
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       driver.DriverName,
+					VolumeHandle: vol.Zone.String() + "/" + vol.ID,
+					FSType:       *fsType,
+				},
+			},
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{
+									Key:      driver.ZoneTopologyKey,
+									Operator: corev1.NodeSelectorOpIn,
+									Values:   []string{vol.Zone.String()},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newPersistentVolumeClaim(vol *instance.Volume) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      *pvcName,
+			Namespace: *namespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: storageClass,
+			VolumeName:       *pvName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: *resource.NewQuantity(int64(vol.Size), resource.BinarySI),
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// capability is a capability advertised by the identity, controller or node service, only one of plugin,
+// controller and node being set
+type capability struct {
+	plugin     *csi.PluginCapability
+	controller csi.ControllerServiceCapability_RPC_Type
+	node       csi.NodeServiceCapability_RPC_Type
+
+	// enabled returns false if the configuration of the driver disables the capability, always enabled if nil
+	enabled func(config *DriverConfig) bool
+}
+
+// capabilities are all the capabilities of the driver, a new one being declared here only
+var capabilities = []capability{
+	{
+		plugin:  pluginServiceCapability(csi.PluginCapability_Service_CONTROLLER_SERVICE),
+		enabled: func(config *DriverConfig) bool { return config.Mode != NodeMode },
+	},
+	{plugin: pluginServiceCapability(csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS)},
+	{plugin: &csi.PluginCapability{
+		Type: &csi.PluginCapability_VolumeExpansion_{
+			VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+				Type: csi.PluginCapability_VolumeExpansion_ONLINE,
+			},
+		},
+	}},
+
+	{controller: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+	{controller: csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME},
+	{controller: csi.ControllerServiceCapability_RPC_LIST_VOLUMES},
+	{controller: csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT},
+	{controller: csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS},
+	{controller: csi.ControllerServiceCapability_RPC_EXPAND_VOLUME},
+	{controller: csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES},
+	{controller: csi.ControllerServiceCapability_RPC_GET_VOLUME},
+	{controller: csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER},
+	{controller: csi.ControllerServiceCapability_RPC_VOLUME_CONDITION},
+
+	{node: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME},
+	{node: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS},
+	{node: csi.NodeServiceCapability_RPC_EXPAND_VOLUME},
+	{node: csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER},
+	{node: csi.NodeServiceCapability_RPC_VOLUME_CONDITION},
+}
+
+func pluginServiceCapability(service csi.PluginCapability_Service_Type) *csi.PluginCapability {
+	return &csi.PluginCapability{
+		Type: &csi.PluginCapability_Service_{
+			Service: &csi.PluginCapability_Service{
+				Type: service,
+			},
+		},
+	}
+}
+
+// isEnabled returns true if the capability is enabled by the configuration, all of them being enabled without one
+func (c capability) isEnabled(config *DriverConfig) bool {
+	return config == nil || c.enabled == nil || c.enabled(config)
+}
+
+// pluginCapabilities returns the capabilities of the plugin enabled by the configuration
+func pluginCapabilities(config *DriverConfig) []*csi.PluginCapability {
+	var pluginCapabilities []*csi.PluginCapability
+	for _, capability := range capabilities {
+		if capability.plugin != nil && capability.isEnabled(config) {
+			pluginCapabilities = append(pluginCapabilities, capability.plugin)
+		}
+	}
+	return pluginCapabilities
+}
+
+// controllerCapabilities returns the capabilities of the controller service enabled by the configuration
+func controllerCapabilities(config *DriverConfig) []csi.ControllerServiceCapability_RPC_Type {
+	var controllerCapabilities []csi.ControllerServiceCapability_RPC_Type
+	for _, capability := range capabilities {
+		if capability.controller != csi.ControllerServiceCapability_RPC_UNKNOWN && capability.isEnabled(config) {
+			controllerCapabilities = append(controllerCapabilities, capability.controller)
+		}
+	}
+	return controllerCapabilities
+}
+
+// nodeCapabilities returns the capabilities of the node service enabled by the configuration
+func nodeCapabilities(config *DriverConfig) []csi.NodeServiceCapability_RPC_Type {
+	var nodeCapabilities []csi.NodeServiceCapability_RPC_Type
+	for _, capability := range capabilities {
+		if capability.node != csi.NodeServiceCapability_RPC_UNKNOWN && capability.isEnabled(config) {
+			nodeCapabilities = append(nodeCapabilities, capability.node)
+		}
+	}
+	return nodeCapabilities
+}
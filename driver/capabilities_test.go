@@ -0,0 +1,33 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func Test_capabilities(t *testing.T) {
+	for _, capability := range capabilities {
+		set := 0
+		if capability.plugin != nil {
+			set++
+		}
+		if capability.controller != csi.ControllerServiceCapability_RPC_UNKNOWN {
+			set++
+		}
+		if capability.node != csi.NodeServiceCapability_RPC_UNKNOWN {
+			set++
+		}
+		Equals(t, 1, set)
+	}
+
+	Equals(t, 3, len(pluginCapabilities(nil)))
+	Equals(t, 3, len(pluginCapabilities(&DriverConfig{Mode: AllMode})))
+	Equals(t, 10, len(controllerCapabilities(nil)))
+	Equals(t, 5, len(nodeCapabilities(nil)))
+
+	// a node plugin does not advertise the controller service
+	for _, capability := range pluginCapabilities(&DriverConfig{Mode: NodeMode}) {
+		AssertTrue(t, capability.GetService().GetType() != csi.PluginCapability_Service_CONTROLLER_SERVICE)
+	}
+}
@@ -2,11 +2,15 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/scaleway/scaleway-csi/scaleway"
@@ -19,60 +23,178 @@ import (
 )
 
 var (
-	// controllerCapabilities represents the capabilites of the Scaleway Block Volumes
-	controllerCapabilities = []csi.ControllerServiceCapability_RPC_Type{
-		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
-		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
-		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
-		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
-		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
-		csi.ControllerServiceCapability_RPC_GET_VOLUME,
-		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-	}
-
-	// supportedAccessModes represents the supported access modes for the Scaleway Block Volumes
-	supportedAccessModes = []csi.VolumeCapability_AccessMode{
-		csi.VolumeCapability_AccessMode{
-			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
-		},
-		csi.VolumeCapability_AccessMode{
-			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
-		},
-	}
-
-	scwVolumeID   = DriverName + "/volume-id"
-	scwVolumeName = DriverName + "/volume-name"
-	scwVolumeZone = DriverName + "/volume-zone"
-
-	volumeTypeKey = "type"
-	encryptedKey  = "encrypted"
+	// the keys derived from DriverName are set by setDerivedNames
+	scwVolumeID   string
+	scwVolumeName string
+	scwVolumeZone string
+
+	// scwVolumeReadOnly is set to true in the publish context of volumes published as read-only.
+	// The Instance API can only attach volumes as read-write, so the node makes the device itself read-only.
+	scwVolumeReadOnly string
+
+	// scwDevicePath is the key of the /dev/disk/by-id symlink expected for the device of the volume, in the publish context.
+	// The node looks it up first, before the ones of its disk prefixes.
+	scwDevicePath string
+
+	// scwVolumeManaged is set to true in the publish context of the volumes with managedByTag,
+	// reported in the volume_stats metrics of the node
+	scwVolumeManaged string
+
+	// scwVolumeCreationDate is the key of the creation date of the volume, in RFC 3339 format, in the volume context
+	scwVolumeCreationDate string
+
+	volumeTypeKey      = "type"
+	encryptedKey       = "encrypted"
+	mkfsOptionsKey     = "mkfsOptions"
+	sourceProjectIDKey = "sourceProjectID"
+	projectIDKey       = "projectID"
+	exportBucketKey    = "exportBucket"
+	snapshotTagsKey    = "tags"
+	descriptionKey     = "description"
+
+	// allowOfflineExpandKey allows to detach the volumes the API cannot expand while attached
+	allowOfflineExpandKey = "allowOfflineExpand"
+	// allowCrossZoneRestoreKey allows to restore the snapshots in another zone of their region, by copying them
+	allowCrossZoneRestoreKey = "allowCrossZoneRestore"
+	// deletionProtectionKey makes DeleteVolume refuse to delete the volume, unless forced with forceDeleteSecretKey
+	deletionProtectionKey = "deletionProtection"
+	// xfsQuotaKey gives each publication of the volume its own directory, limited to the given size by an XFS project quota
+	xfsQuotaKey = "xfsQuota"
+	// retainSnapshotsOnVolumeDeleteKey is the snapshot parameter letting DeleteVolume delete the source volume of the snapshot
+	retainSnapshotsOnVolumeDeleteKey = "retainSnapshotsOnVolumeDelete"
+	// forceDeleteSecretKey is the key of the DeleteVolume secret allowing to delete a protected volume
+	forceDeleteSecretKey = "force-delete"
+	// minSizeKey, maxSizeKey and defaultSizeKey restrict the sizes of the volumes of a StorageClass
+	// within the limits of the API, defaultSizeKey being used when no capacity is requested
+	minSizeKey     = "minSize"
+	maxSizeKey     = "maxSize"
+	defaultSizeKey = "defaultSize"
+
+	// descriptionTagPrefix is the prefix of the tag holding the description of a snapshot,
+	// the Instance snapshots having no description field
+	descriptionTagPrefix = "description="
+
+	// the keys of the snapshot parameters set by the external-snapshotter with --extra-create-metadata
+	volumeSnapshotNameKey        = "csi.storage.k8s.io/volumesnapshot/name"
+	volumeSnapshotNamespaceKey   = "csi.storage.k8s.io/volumesnapshot/namespace"
+	volumeSnapshotContentNameKey = "csi.storage.k8s.io/volumesnapshotcontent/name"
+
+	// managedByTag is the tag set on every volume and snapshot created by the driver
+	managedByTag string
+
+	// the prefixes of the tags of the volumes holding the name and the namespace of their PersistentVolumeClaim
+	pvcNameTagPrefix   = "pvc-name="
+	namespaceTagPrefix = "namespace="
+
+	// attachRetryInterval is the interval between two attachments of a volume in a transient state
+	attachRetryInterval = 2 * time.Second
+	// attachRetryTimeout bounds the attachment retries when the RPC has no deadline
+	attachRetryTimeout = 30 * time.Second
+	// volumeSettleTimeout bounds the wait for the end of the operation running on a volume
+	// (e.g. a snapshot) before attaching, detaching or resizing it
+	volumeSettleTimeout = 30 * time.Second
+
+	// offlineExpandTag is set on the volumes created with allowOfflineExpandKey, the
+	// parameters of the StorageClass not being passed to ControllerExpandVolume
+	offlineExpandTag = "allow-offline-expand"
+	// deletionProtectionTag is set on the volumes created with deletionProtectionKey
+	deletionProtectionTag = "deletion-protection"
+	// maxSizeTagPrefix prefixes the tag holding the maxSizeKey of the volume in bytes, enforced on expansion
+	maxSizeTagPrefix = "max-size="
+	// clusterIDTagPrefix prefixes the tag holding the ClusterID of the cluster which created the volume
+	clusterIDTagPrefix = "cluster-id="
+	// encryptedTagPrefix and sourceSnapshotTagPrefix prefix the tags holding the encryption of the volume
+	// and the ID of the snapshot it was restored from, compared when CreateVolume finds it again
+	encryptedTagPrefix      = "encrypted="
+	sourceSnapshotTagPrefix = "source-snapshot="
+
+	// retainOnVolumeDeleteTag is set on the snapshots created with retainSnapshotsOnVolumeDeleteKey
+	retainOnVolumeDeleteTag = "retain-on-volume-delete"
+	// exportedToTagPrefix prefixes the tag set on the snapshots whose export has been triggered
+	exportedToTagPrefix = "exported-to="
 )
 
 type controllerService struct {
 	scaleway *scaleway.Scaleway
 	config   *DriverConfig
 	mux      sync.Mutex
+
+	// attachLockHolder and attachLockWaiters are the volume holding mux and the number of calls
+	// waiting for it, reported by the state dump
+	attachLockHolder   string
+	attachLockWaiters  int
+	attachLockStateMux sync.Mutex
+
+	// volumeAttachments is only set when ForceDeleteDetachedGrace is enabled
+	volumeAttachments volumeAttachmentChecker
+	// attachedDeletions keeps the first time a deletion was refused because the volume was attached
+	attachedDeletions    map[string]time.Time
+	attachedDeletionsMux sync.Mutex
+
+	// events is only set when EmitEvents is enabled
+	events *volumeEvents
+
+	// exhaustedZones orders the zones tried by CreateVolume, the out of stock ones last
+	exhaustedZones *zoneExhaustion
+
+	// detachRetries is only set when DetachRetryInterval is enabled
+	detachRetries *detachRetryQueue
+
+	// createFailures is only set when CreateFailureBackoff is enabled
+	createFailures *createFailureBackoff
+
+	// scopedClients are the clients using the credentials passed in the secrets of the RPCs
+	scopedClients *scopedClients
 }
 
-func newControllerService(config *DriverConfig) controllerService {
+// userAgent returns the user agent of the driver calls to the Scaleway API
+func userAgent() string {
 	userAgent := fmt.Sprintf("%s %s (%s)", DriverName, driverVersion, gitCommit)
 	if extraUA := os.Getenv(ExtraUserAgentEnv); extraUA != "" {
 		userAgent = userAgent + " " + extraUA
 	}
+	return userAgent
+}
+
+func newControllerService(config *DriverConfig) controllerService {
+	userAgent := userAgent()
+
+	scwClient := scaleway.NewScaleway(userAgent)
+	scwClient.SetCacheTTL(config.APICacheTTL)
+
+	var detachRetries *detachRetryQueue
+	if config.DetachRetryInterval > 0 {
+		detachRetries = newDetachRetryQueue()
+	}
 
 	return controllerService{
-		config:   config,
-		scaleway: scaleway.NewScaleway(userAgent),
+		config:            config,
+		scaleway:          scwClient,
+		attachedDeletions: make(map[string]time.Time),
+		exhaustedZones:    newZoneExhaustion(config.ZoneExhaustionCooldown),
+		detachRetries:     detachRetries,
+		createFailures:    newCreateFailureBackoff(config.CreateFailureBackoff),
+		scopedClients:     newScopedClients(userAgent, config.APICacheTTL),
 	}
 }
 
 // CreateVolume creates a new volume with the given CreateVolumeRequest.
 // This function is idempotent
 func (d *controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	klog.V(4).Infof("CreateVolume: called with %s", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("CreateVolume called", "request", stripSecretFromReq(req))
+
+	key := createVolumeKey(req)
+	if err := d.createFailures.get(key, time.Now()); err != nil {
+		klog.FromContext(ctx).V(4).Info("request for the volume failed recently, returning its error", "name", req.GetName(), "error", err.Error())
+		return nil, err
+	}
+	resp, err := d.provisionVolume(ctx, req)
+	d.createFailures.record(key, err, time.Now())
+	return resp, err
+}
 
+// provisionVolume creates the volume of a CreateVolume request
+func (d *controllerService) provisionVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	volumeName := req.GetName()
 	if volumeName == "" {
 		return nil, status.Error(codes.InvalidArgument, "name not provided")
@@ -83,18 +205,25 @@ func (d *controllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		return nil, status.Error(codes.InvalidArgument, "volumeCapabilities not provided")
 	}
 
-	err := validateVolumeCapabilities(volumeCapabilities)
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "volumeCapabilities not supported: %s", err)
-	}
-
 	encrypted := false
+	encryptedSet := false
+	mkfsOptions := ""
+	sourceProjectID := ""
+	allowOfflineExpand := false
+	deletionProtection := false
+	allowCrossZoneRestore := false
+	fsckBeforeMount := false
+	exportBucket := ""
+	var classMinSize, classMaxSize, classDefaultSize, xfsQuota int64
 
 	volumeType := scaleway.DefaultVolumeType
 	for key, value := range req.GetParameters() {
 		switch strings.ToLower(key) {
 		case volumeTypeKey:
 			volumeType = instance.VolumeVolumeType(value)
+			if volumeType == instance.VolumeVolumeTypeBSSD {
+				legacyParameterLogs.log(volumeTypeKey+"="+value, legacyVolumeTypeNote)
+			}
 		case encryptedKey:
 			encryptedValue, err := strconv.ParseBool(value)
 			if err != nil {
@@ -102,90 +231,258 @@ func (d *controllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 			}
 			// TODO check if this value has changed?
 			encrypted = encryptedValue
+			encryptedSet = true
+		case strings.ToLower(mkfsOptionsKey):
+			if strings.TrimSpace(value) == "" {
+				return nil, status.Errorf(codes.InvalidArgument, "empty value for parameter %s", key)
+			}
+			mkfsOptions = value
+		case strings.ToLower(projectIDKey):
+			// handled by getProjectID
+		case strings.ToLower(sourceProjectIDKey):
+			sourceProjectID = value
+		case strings.ToLower(allowOfflineExpandKey):
+			allowOfflineExpandValue, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid bool value (%s) for parameter %s: %v", value, key, err)
+			}
+			allowOfflineExpand = allowOfflineExpandValue
+		case strings.ToLower(deletionProtectionKey):
+			deletionProtectionValue, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid bool value (%s) for parameter %s: %v", value, key, err)
+			}
+			deletionProtection = deletionProtectionValue
+		case strings.ToLower(allowCrossZoneRestoreKey):
+			allowCrossZoneRestoreValue, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid bool value (%s) for parameter %s: %v", value, key, err)
+			}
+			allowCrossZoneRestore = allowCrossZoneRestoreValue
+		case strings.ToLower(fsckBeforeMountKey):
+			fsckBeforeMountValue, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid bool value (%s) for parameter %s: %v", value, key, err)
+			}
+			fsckBeforeMount = fsckBeforeMountValue
+		case strings.ToLower(exportBucketKey):
+			// the bucket through which the snapshots are copied to another zone
+			exportBucket = value
+		case strings.ToLower(xfsQuotaKey):
+			xfsQuotaValue, err := parseSizeParameter(value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid size value (%s) for parameter %s: %v", value, key, err)
+			}
+			xfsQuota = xfsQuotaValue
+		case strings.ToLower(minSizeKey), strings.ToLower(maxSizeKey), strings.ToLower(defaultSizeKey):
+			sizeValue, err := parseSizeParameter(value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid size value (%s) for parameter %s: %v", value, key, err)
+			}
+			switch strings.ToLower(key) {
+			case strings.ToLower(minSizeKey):
+				classMinSize = sizeValue
+			case strings.ToLower(maxSizeKey):
+				classMaxSize = sizeValue
+			default:
+				classDefaultSize = sizeValue
+			}
+		case pvcNameKey, pvcNamespaceKey, pvNameKey:
+			// set by the external-provisioner with --extra-create-metadata, used for the Events
 		default:
 			return nil, status.Errorf(codes.InvalidArgument, "invalid parameter key %s", key)
 		}
 	}
 
-	minSize, maxSize, err := d.scaleway.GetVolumeLimits(string(volumeType))
+	err := validateVolumeCapabilities(volumeCapabilities, accessModesForClass(scaleway.GetVolumeClass(volumeType)))
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, status.Errorf(codes.InvalidArgument, "volumeCapabilities not supported: %s", err)
+	}
+
+	if d.config.DisableEncryption && encrypted {
+		return nil, status.Errorf(codes.InvalidArgument, "encryption is disabled on the driver, the StorageClass of volume %s must not set the parameter %s: \"true\"", volumeName, encryptedKey)
+	}
+
+	volumeContext := map[string]string{
+		encryptedKey: strconv.FormatBool(encrypted),
+	}
+	if mkfsOptions != "" {
+		volumeContext[mkfsOptionsKey] = mkfsOptions
+	}
+	if xfsQuota > 0 {
+		for _, capability := range volumeCapabilities {
+			if capability.GetMount().GetFsType() != "xfs" {
+				return nil, status.Errorf(codes.InvalidArgument, "the parameter %s of volume %s requires the xfs filesystem type", xfsQuotaKey, volumeName)
+			}
+		}
+		volumeContext[xfsQuotaKey] = strconv.FormatInt(xfsQuota, 10)
+	}
+	if fsckBeforeMount {
+		volumeContext[fsckBeforeMountKey] = "true"
+	}
+	// the node labels the metrics of the volume with them
+	for _, key := range []string{pvNameKey, pvcNameKey, pvcNamespaceKey} {
+		if value := req.GetParameters()[key]; value != "" {
+			volumeContext[key] = value
+		}
 	}
 
-	size, err := getVolumeRequestCapacity(minSize, maxSize, req.GetCapacityRange())
+	minSize, maxSize, err := d.client(ctx).GetVolumeLimits(string(volumeType))
 	if err != nil {
-		return nil, status.Errorf(codes.OutOfRange, "capacityRange invalid: %s", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if classMinSize > minSize {
+		minSize = classMinSize
+	}
+	if classMaxSize > 0 && classMaxSize < maxSize {
+		maxSize = classMaxSize
+	}
+	if minSize > maxSize {
+		return nil, status.Errorf(codes.InvalidArgument, "the sizes allowed by the StorageClass of volume %s, from %d to %d bytes, do not match the ones of volume type %s", volumeName, minSize, maxSize, volumeType)
 	}
 
-	scwVolumeName := d.config.Prefix + volumeName
-	// TODO check all zones
-	volume, err := d.scaleway.GetVolumeByName(scwVolumeName, size, volumeType)
+	size, err := getVolumeRequestCapacity(minSize, maxSize, classDefaultSize, req.GetCapacityRange())
+	if errors.Is(err, errDefaultSizeOutOfRange) {
+		return nil, status.Errorf(codes.InvalidArgument, "the %s of the StorageClass of volume %s is not between %d and %d bytes", defaultSizeKey, volumeName, minSize, maxSize)
+	}
 	if err != nil {
-		switch err {
-		case scaleway.ErrVolumeNotFound: // all good
-		case scaleway.ErrDifferentSize:
-			return nil, status.Error(codes.AlreadyExists, err.Error())
-		case scaleway.ErrMultipleVolumes:
-			return nil, status.Error(codes.Internal, err.Error())
-		default:
-			return nil, status.Error(codes.Internal, err.Error())
-		}
-	} else { // volume exists
-		return &csi.CreateVolumeResponse{
-			Volume: &csi.Volume{
-				VolumeId:           volume.Zone.String() + "/" + volume.ID,
-				CapacityBytes:      int64(volume.Size),
-				AccessibleTopology: newAccessibleTopology(volume.Zone),
-				VolumeContext: map[string]string{
-					encryptedKey: strconv.FormatBool(encrypted),
-				},
-			},
-		}, nil
+		return nil, status.Errorf(codes.OutOfRange, "capacityRange invalid: %s", err)
 	}
 
 	var contentSource *csi.VolumeContentSource
+	var sourceSnapshot *instance.Snapshot
 	var snapshotID *string
 	var snapshotZone scw.Zone
+	var snapshotSize scw.Size
 	if req.GetVolumeContentSource() != nil {
 		if _, ok := req.GetVolumeContentSource().GetType().(*csi.VolumeContentSource_Snapshot); !ok {
 			return nil, status.Error(codes.InvalidArgument, "unsupported volumeContentSource type")
 		}
-		sourceSnapshot := req.GetVolumeContentSource().GetSnapshot()
-		if sourceSnapshot == nil {
+		snapshotSource := req.GetVolumeContentSource().GetSnapshot()
+		if snapshotSource == nil {
 			return nil, status.Error(codes.Internal, "error retrieving snapshot from the volumeContentSource")
 		}
 
-		sourceSnapshotID, sourceSnapshotZone, err := getSnapshotIDAndZone(sourceSnapshot.GetSnapshotId())
+		sourceSnapshotID, sourceSnapshotZone, err := getSnapshotIDAndZone(snapshotSource.GetSnapshotId())
 		if err != nil {
 			return nil, err
 		}
 
-		// TODO check all zones
-		snapshotResp, err := d.scaleway.GetSnapshot(&instance.GetSnapshotRequest{
-			SnapshotID: sourceSnapshotID,
-			Zone:       sourceSnapshotZone,
-		})
+		snapshot, err := d.getSnapshot(ctx, sourceSnapshotID, sourceSnapshotZone)
 		if err != nil {
-			if _, ok := err.(*scw.ResourceNotFoundError); ok {
+			switch err.(type) {
+			case *scw.ResourceNotFoundError:
 				return nil, status.Errorf(codes.NotFound, "snapshot %s not found", sourceSnapshotID)
+			case *scw.PermissionsDeniedError:
+				return nil, status.Errorf(codes.PermissionDenied, "not allowed to access snapshot %s: %s", sourceSnapshotID, err)
 			}
 			return nil, status.Error(codes.Internal, err.Error())
 		}
+		if sourceProjectID != "" && snapshot.Project != sourceProjectID {
+			return nil, status.Errorf(codes.NotFound, "snapshot %s not found in project %s", sourceSnapshotID, sourceProjectID)
+		}
+		// the snapshot of an encrypted volume holds its LUKS container, the restored volume must be opened as well
+		if hasTag(snapshot.Tags, encryptedTagPrefix+"true") && !encrypted {
+			if encryptedSet {
+				return nil, status.Errorf(codes.InvalidArgument, "snapshot %s is encrypted and cannot be restored with the parameter %s: \"false\"", sourceSnapshotID, encryptedKey)
+			}
+			if d.config.DisableEncryption {
+				return nil, status.Errorf(codes.InvalidArgument, "snapshot %s is encrypted and the encryption is disabled on the driver", sourceSnapshotID)
+			}
+			encrypted = true
+			volumeContext[encryptedKey] = strconv.FormatBool(encrypted)
+		}
+		sourceSnapshot = snapshot
 		snapshotID = &sourceSnapshotID
-		snapshotZone = snapshotResp.Snapshot.Zone
+		snapshotZone = snapshot.Zone
+		snapshotSize = snapshot.Size
 		contentSource = &csi.VolumeContentSource{
 			Type: &csi.VolumeContentSource_Snapshot{
 				Snapshot: &csi.VolumeContentSource_SnapshotSource{
-					SnapshotId: scaleway.ExpandSnapshotID(snapshotResp.Snapshot),
+					SnapshotId: scaleway.ExpandSnapshotID(snapshot),
 				},
 			},
 		}
 	}
 
+	// checked once the encryption of the source snapshot is known
+	if d.config.RequireEncryption && !encrypted {
+		return nil, status.Errorf(codes.InvalidArgument, "encryption is required by the driver, the StorageClass of volume %s must set the parameter %s: \"true\"", volumeName, encryptedKey)
+	}
+
+	if contentSource != nil {
+		capacityRange := req.GetCapacityRange()
+		if capacityRange.GetRequiredBytes() <= 0 && capacityRange.GetLimitBytes() <= 0 {
+			size = int64(snapshotSize)
+		} else if size < int64(snapshotSize) {
+			return nil, status.Errorf(codes.OutOfRange, "requested size %d is less than the size %d of the snapshot %s", size, snapshotSize, *snapshotID)
+		}
+		if size > maxSize {
+			return nil, status.Errorf(codes.OutOfRange, "the size %d of the snapshot %s is greater than the maximum size %d", size, *snapshotID, maxSize)
+		}
+	}
+
+	projectID := getProjectID(req.GetParameters(), req.GetSecrets())
+
 	chosenZones, err := chooseZones(req.GetAccessibilityRequirements(), snapshotZone)
+	restoreZone := scw.Zone("")
+	if status.Code(err) == codes.ResourceExhausted && sourceSnapshot != nil && allowCrossZoneRestore {
+		// the snapshot is copied to the first zone of the requested topology
+		chosenZones, err = chooseZones(req.GetAccessibilityRequirements(), "")
+		if err == nil && len(chosenZones) != 0 {
+			restoreZone = chosenZones[0]
+			chosenZones = chosenZones[:1]
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
+
+	scwVolumeName := d.config.Prefix + volumeName
+	sourceSnapshotID := ""
+	if snapshotID != nil {
+		sourceSnapshotID = *snapshotID
+	}
+	volume, err := d.client(ctx).GetVolumeByName(scwVolumeName, projectID, scw.WithContext(ctx))
+	if err != nil {
+		switch err {
+		case scaleway.ErrVolumeNotFound: // all good
+		case scaleway.ErrMultipleVolumes:
+			return nil, status.Error(codes.Internal, err.Error())
+		default:
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	} else { // volume exists
+		// the copy of a snapshot restored in another zone is left when the wait for the volume failed
+		if sourceSnapshot != nil && volume.Zone != snapshotZone {
+			d.deleteRestoreCopy(ctx, scwVolumeName, volume.Zone)
+		}
+		// a restore whose resize failed left the volume at the size of the snapshot, the retry finishes it
+		if sourceSnapshot != nil && volume.Size == snapshotSize && int64(volume.Size) < size && len(existingVolumeMismatches(volume, int64(volume.Size), volumeType, encrypted, sourceSnapshotID)) == 0 {
+			volume, err = d.client(ctx).ResizeRestoredVolume(ctx, volume, scw.Size(size), scw.WithContext(ctx))
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "error resizing volume %s restored from snapshot %s: %s", scwVolumeName, sourceSnapshotID, err)
+			}
+		}
+		mismatches := existingVolumeMismatches(volume, size, volumeType, encrypted, sourceSnapshotID)
+		// a retry with a different topology must not be answered with a volume the CO cannot use
+		zoneRestricted := len(req.GetAccessibilityRequirements().GetRequisite()) != 0 || snapshotZone != scw.Zone("")
+		if zoneRestricted && len(chosenZones) != 0 && !containsZone(chosenZones, volume.Zone) {
+			mismatches = append(mismatches, fmt.Sprintf("zone %s, which does not match the requested topology", volume.Zone))
+		}
+		if len(mismatches) != 0 {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with %s", scwVolumeName, strings.Join(mismatches, ", "))
+		}
+		return &csi.CreateVolumeResponse{
+			Volume: &csi.Volume{
+				VolumeId:           volume.Zone.String() + "/" + volume.ID,
+				CapacityBytes:      int64(volume.Size),
+				AccessibleTopology: newAccessibleTopology(volume.Zone),
+				VolumeContext:      withVolumeMetadata(volumeContext, volume),
+			},
+		}, nil
+	}
+
 	if len(chosenZones) == 0 {
 		chosenZones = append(chosenZones, scw.Zone("")) // this will use the default zone of the client
 	}
@@ -194,6 +491,26 @@ func (d *controllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 	volumeRequest := &instance.CreateVolumeRequest{
 		Name:       scwVolumeName,
 		VolumeType: volumeType,
+		Tags:       getVolumeTags(req.GetParameters()),
+	}
+	if allowOfflineExpand {
+		volumeRequest.Tags = append(volumeRequest.Tags, offlineExpandTag)
+	}
+	if deletionProtection {
+		volumeRequest.Tags = append(volumeRequest.Tags, deletionProtectionTag)
+	}
+	if classMaxSize > 0 {
+		volumeRequest.Tags = append(volumeRequest.Tags, maxSizeTagPrefix+strconv.FormatInt(classMaxSize, 10))
+	}
+	volumeRequest.Tags = append(volumeRequest.Tags, encryptedTagPrefix+strconv.FormatBool(encrypted))
+	if d.config.ClusterID != "" {
+		volumeRequest.Tags = append(volumeRequest.Tags, clusterIDTagPrefix+d.config.ClusterID)
+	}
+	if sourceSnapshotID != "" {
+		volumeRequest.Tags = append(volumeRequest.Tags, sourceSnapshotTagPrefix+sourceSnapshotID)
+	}
+	if projectID != "" {
+		volumeRequest.Project = &projectID
 	}
 	if contentSource != nil {
 		volumeRequest.BaseSnapshot = snapshotID
@@ -201,64 +518,95 @@ func (d *controllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		volumeRequest.Size = &volumeSize
 	}
 
+	if restoreZone != "" {
+		snapshotCopy, err := d.copySnapshotToZone(ctx, sourceSnapshot, restoreZone, exportBucket, scwVolumeName)
+		if err != nil {
+			return nil, err
+		}
+		volumeRequest.BaseSnapshot = &snapshotCopy.ID
+	}
+
+	createVolume := func(ctx context.Context, volumeRequest *instance.CreateVolumeRequest) (*instance.Volume, error) {
+		var volume *instance.Volume
+		var err error
+		if contentSource != nil {
+			volume, err = d.client(ctx).CreateVolumeFromSnapshot(ctx, volumeRequest, volumeSize, scw.WithContext(ctx))
+		} else {
+			var volumeResp *instance.CreateVolumeResponse
+			volumeResp, err = d.client(ctx).CreateVolume(volumeRequest, scw.WithContext(ctx))
+			if err == nil {
+				volume = volumeResp.Volume
+			}
+		}
+		if _, ok := err.(*scw.OutOfStockError); ok {
+			d.exhaustedZones.record(volumeRequest.Zone, time.Now())
+		}
+		return volume, err
+	}
+
 	if len(chosenZones) == 1 { // either it's with an empty zone, the snapshot zone, or just one classic zone
 		if chosenZones[0] != scw.Zone("") {
 			volumeRequest.Zone = chosenZones[0]
 		}
-		volumeResp, err := d.scaleway.CreateVolume(volumeRequest)
+		volume, err := createVolume(ctx, volumeRequest)
+		if restoreZone != "" {
+			d.deleteRestoreCopy(ctx, scwVolumeName, restoreZone)
+		}
 		if err != nil {
-			if _, ok := err.(*scw.ResourceNotFoundError); ok {
+			d.events.createFailed(ctx, pvcReference(req.GetParameters(), volumeName), contentSource != nil, err)
+			switch err.(type) {
+			case *scw.ResourceNotFoundError:
 				return nil, status.Error(codes.NotFound, err.Error())
+			case *scw.PermissionsDeniedError:
+				return nil, status.Error(codes.PermissionDenied, err.Error())
 			}
-			return nil, status.Error(codes.Internal, err.Error())
-		}
-		segments := map[string]string{
-			ZoneTopologyKey: string(volumeResp.Volume.Zone),
+			return nil, waitError(ctx, err)
 		}
+		d.events.normal(pvcReference(req.GetParameters(), volumeName), eventProvisioned, "volume %s of %d bytes created in zone %s", volume.ID, volume.Size, volume.Zone)
 
 		return &csi.CreateVolumeResponse{
 			Volume: &csi.Volume{
-				VolumeId:      volumeResp.Volume.Zone.String() + "/" + volumeResp.Volume.ID,
-				ContentSource: contentSource,
-				CapacityBytes: int64(volumeResp.Volume.Size),
-				AccessibleTopology: []*csi.Topology{
-					{
-						Segments: segments,
-					},
-				},
-				VolumeContext: map[string]string{
-					encryptedKey: strconv.FormatBool(encrypted),
-				},
+				VolumeId:           volume.Zone.String() + "/" + volume.ID,
+				ContentSource:      contentSource,
+				CapacityBytes:      int64(volume.Size),
+				AccessibleTopology: newAccessibleTopology(volume.Zone),
+				VolumeContext:      withVolumeMetadata(volumeContext, volume),
 			},
 		}, nil
 	}
 
-	var errors []string
-	for _, zone := range chosenZones { // if we multiple wanted zone, we try each one
-		volumeRequest.Zone = zone
-		volumeResp, err := d.scaleway.CreateVolume(volumeRequest)
-		if err != nil {
-			errors = append(errors, err.Error())
-			continue
-		}
-
-		segments := map[string]string{
-			ZoneTopologyKey: string(volumeResp.Volume.Zone),
+	// if we multiple wanted zone, we try each one, or all at once with ParallelZoneCreate,
+	// the zones recently out of stock being tried last
+	chosenZones = d.exhaustedZones.order(chosenZones, time.Now())
+	var created *instance.Volume
+	var errs []error
+	if d.config.ParallelZoneCreate {
+		created, errs = d.createVolumeInZones(ctx, chosenZones, volumeRequest, createVolume)
+	} else {
+		for _, zone := range chosenZones {
+			volumeRequest.Zone = zone
+			created, err = createVolume(ctx, volumeRequest)
+			if err == nil {
+				break
+			}
+			errs = append(errs, err)
 		}
+	}
 
+	var errors []string
+	for _, err := range errs {
+		d.events.createFailed(ctx, pvcReference(req.GetParameters(), volumeName), contentSource != nil, err)
+		errors = append(errors, err.Error())
+	}
+	if created != nil {
+		d.events.normal(pvcReference(req.GetParameters(), volumeName), eventProvisioned, "volume %s of %d bytes created in zone %s", created.ID, created.Size, created.Zone)
 		return &csi.CreateVolumeResponse{
 			Volume: &csi.Volume{
-				VolumeId:      volumeResp.Volume.Zone.String() + "/" + volumeResp.Volume.ID,
-				ContentSource: contentSource,
-				CapacityBytes: int64(volumeResp.Volume.Size),
-				AccessibleTopology: []*csi.Topology{
-					{
-						Segments: segments,
-					},
-				},
-				VolumeContext: map[string]string{
-					encryptedKey: strconv.FormatBool(encrypted),
-				},
+				VolumeId:           created.Zone.String() + "/" + created.ID,
+				ContentSource:      contentSource,
+				CapacityBytes:      int64(created.Size),
+				AccessibleTopology: newAccessibleTopology(created.Zone),
+				VolumeContext:      withVolumeMetadata(volumeContext, created),
 			},
 		}, nil
 	}
@@ -270,51 +618,143 @@ func (d *controllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 // DeleteVolume deprovision a volume.
 // This operation MUST be idempotent.
 func (d *controllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	klog.V(4).Infof("DeleteVolume called with %s", stripSecretFromReq(*req))
-	volumeID, volumeZone, err := getVolumeIDAndZone(req.GetVolumeId())
+	klog.FromContext(ctx).V(4).Info("DeleteVolume called", "request", stripSecretFromReq(req))
+	volumeID, volumeZone, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
 
-	volumeResp, err := d.scaleway.GetVolume(&instance.GetVolumeRequest{
-		VolumeID: volumeID,
-		Zone:     volumeZone,
-	})
+	volume, err := d.getVolume(ctx, volumeID, volumeZone)
 	if err != nil {
 		if _, ok := err.(*scw.ResourceNotFoundError); ok {
-			klog.V(4).Infof("volume with ID %s not found", volumeID)
+			klog.FromContext(ctx).V(4).Info("volume not found", "volumeID", volumeID)
 			return &csi.DeleteVolumeResponse{}, nil
 		}
 
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	if volumeResp.Volume.Server != nil {
-		return nil, status.Error(codes.FailedPrecondition, "volume is still atached to a server")
+	if hasTag(volume.Tags, deletionProtectionTag) && req.GetSecrets()[forceDeleteSecretKey] != "true" {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is protected against deletion, remove its %s tag or set %s: \"true\" in the provisioner secret to delete it", volumeID, deletionProtectionTag, forceDeleteSecretKey)
+	}
+	if d.config.RefuseDeleteWithSnapshots {
+		snapshotIDs, err := d.blockingSnapshots(ctx, volume)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if len(snapshotIDs) > 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s still has the snapshots %s, delete their VolumeSnapshots first or take them with a VolumeSnapshotClass setting %s: \"true\" to keep them after the volume deletion", volumeID, strings.Join(snapshotIDs, ", "), retainSnapshotsOnVolumeDeleteKey)
+		}
+	}
+	if volume.Server != nil {
+		if d.config.ForceDeleteDetachedGrace <= 0 || d.volumeAttachments == nil {
+			return nil, status.Error(codes.FailedPrecondition, "volume is still atached to a server")
+		}
+		if err := d.forceDetachVolume(ctx, volume); err != nil {
+			return nil, err
+		}
 	}
 
-	klog.V(4).Infof("deleting volume with ID %s", volumeID)
-	err = d.scaleway.DeleteVolume(&instance.DeleteVolumeRequest{
-		VolumeID: volumeResp.Volume.ID,
-		Zone:     volumeResp.Volume.Zone,
-	})
+	klog.FromContext(ctx).V(4).Info("deleting volume", "volumeID", volumeID)
+	err = d.client(ctx).DeleteVolume(&instance.DeleteVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	}, scw.WithContext(ctx))
 	if err != nil {
 		if _, ok := err.(*scw.ResourceNotFoundError); ok {
-			klog.V(4).Infof("volume with ID %s not found", volumeID)
+			klog.FromContext(ctx).V(4).Info("volume not found", "volumeID", volumeID)
 			return &csi.DeleteVolumeResponse{}, nil
 		}
 
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	klog.V(4).Infof("volume with ID %s deleted", volumeID)
+	klog.FromContext(ctx).V(4).Info("volume deleted", "volumeID", volumeID)
+	d.forgetAttachedDeletion(volume.ID)
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
+// blockingSnapshots returns the IDs of the snapshots of the volume preventing its deletion,
+// the ones taken with retainSnapshotsOnVolumeDeleteKey being kept when the volume is deleted
+func (d *controllerService) blockingSnapshots(ctx context.Context, volume *instance.Volume) ([]string, error) {
+	snapshotsResp, err := d.client(ctx).ListSnapshots(&instance.ListSnapshotsRequest{
+		Zone:         volume.Zone,
+		BaseVolumeID: scw.StringPtr(volume.ID),
+	}, scw.WithContext(ctx), scw.WithAllPages())
+	if err != nil {
+		return nil, fmt.Errorf("error listing the snapshots of volume %s: %w", volume.ID, err)
+	}
+	var snapshotIDs []string
+	for _, snapshot := range snapshotsResp.Snapshots {
+		if snapshot.BaseVolume == nil || snapshot.BaseVolume.ID != volume.ID || hasTag(snapshot.Tags, retainOnVolumeDeleteTag) {
+			continue
+		}
+		snapshotIDs = append(snapshotIDs, snapshot.ID)
+	}
+	sort.Strings(snapshotIDs)
+	return snapshotIDs, nil
+}
+
+// forceDetachVolume detaches a volume that is about to be deleted once it has been
+// seen attached for longer than ForceDeleteDetachedGrace and no VolumeAttachment references it anymore
+func (d *controllerService) forceDetachVolume(ctx context.Context, volume *instance.Volume) error {
+	d.attachedDeletionsMux.Lock()
+	firstSeen, ok := d.attachedDeletions[volume.ID]
+	if !ok {
+		firstSeen = time.Now()
+		d.attachedDeletions[volume.ID] = firstSeen
+	}
+	d.attachedDeletionsMux.Unlock()
+
+	if time.Since(firstSeen) < d.config.ForceDeleteDetachedGrace {
+		return status.Errorf(codes.FailedPrecondition, "volume is still atached to server %s, it will be detached after %s", volume.Server.ID, d.config.ForceDeleteDetachedGrace)
+	}
+
+	attached, err := d.volumeAttachments.IsVolumeAttached(ctx, volume.ID)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if attached {
+		return status.Errorf(codes.FailedPrecondition, "volume is still atached to server %s and referenced by a VolumeAttachment", volume.Server.ID)
+	}
+
+	klog.FromContext(ctx).Info("volume attached without any VolumeAttachment, detaching it before deletion", "volumeID", volume.ID, "serverID", volume.Server.ID)
+
+	unlock := d.lockAttach(volume.ID)
+	_, err = d.client(ctx).DetachVolume(&instance.DetachVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	}, scw.WithContext(ctx))
+	unlock()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	vol, err := d.client(ctx).WaitForVolumeContext(scaleway.ContextWithWriteSlots(ctx), &instance.WaitForVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	})
+	if err != nil {
+		return waitError(ctx, err)
+	}
+	if vol.State != instance.VolumeStateAvailable {
+		return status.Errorf(codes.Internal, "volume %s is in state %s", volume.ID, vol.State)
+	}
+
+	return nil
+}
+
+// forgetAttachedDeletion removes the volume with the given ID from the refused deletions
+func (d *controllerService) forgetAttachedDeletion(volumeID string) {
+	d.attachedDeletionsMux.Lock()
+	delete(d.attachedDeletions, volumeID)
+	d.attachedDeletionsMux.Unlock()
+}
+
 // ControllerPublishVolume perform the work that is necessary for making the volume available on the given node.
 // This operation MUST be idempotent.
 func (d *controllerService) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
-	klog.V(4).Infof("ControllerPublishVolume called with %s", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("ControllerPublishVolume called", "request", stripSecretFromReq(req))
 
-	volumeID, volumeZone, err := getVolumeIDAndZone(req.GetVolumeId())
+	volumeID, volumeZone, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
@@ -329,15 +769,7 @@ func (d *controllerService) ControllerPublishVolume(ctx context.Context, req *cs
 		return nil, status.Error(codes.InvalidArgument, "volumeCapability is not provided")
 	}
 
-	err = validateVolumeCapabilities([]*csi.VolumeCapability{volumeCapability})
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "volumeCapability not supported: %s", err)
-	}
-
-	volumeResp, err := d.scaleway.GetVolume(&instance.GetVolumeRequest{
-		VolumeID: volumeID,
-		Zone:     volumeZone,
-	})
+	volume, err := d.getVolumeCached(ctx, volumeID, volumeZone)
 	if err != nil {
 		if _, ok := err.(*scw.ResourceNotFoundError); ok {
 			return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
@@ -345,10 +777,20 @@ func (d *controllerService) ControllerPublishVolume(ctx context.Context, req *cs
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	serverResp, err := d.scaleway.GetServer(&instance.GetServerRequest{
+	err = validateVolumeCapabilities([]*csi.VolumeCapability{volumeCapability}, accessModesForClass(scaleway.GetVolumeClass(volume.VolumeType)))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volumeCapability not supported: %s", err)
+	}
+
+	// the Instance API error of a cross-zone attachment is confusing, the zone of the node is checked beforehand
+	if nodeZone != "" && volume.Zone != nodeZone {
+		return nil, crossZoneError(volume, nodeID, nodeZone)
+	}
+
+	serverResp, err := d.client(ctx).GetServerCached(&instance.GetServerRequest{
 		ServerID: nodeID,
 		Zone:     nodeZone,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		if _, ok := err.(*scw.ResourceNotFoundError); ok {
 			return nil, status.Errorf(codes.NotFound, "instance %s not found", volumeID)
@@ -356,55 +798,182 @@ func (d *controllerService) ControllerPublishVolume(ctx context.Context, req *cs
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if volumeResp.Volume.Server != nil {
-		if volumeResp.Volume.Server.ID == serverResp.Server.ID {
+	// the volume published again must not be detached by its pending detachment, which may also
+	// have just detached it
+	detachCancelled := d.cancelDetachRetry(volumeID)
+	if volume.Server != nil || detachCancelled {
+		// the cached volume may have been detached since, the attachment is only skipped when it is current
+		volume, err = d.getVolume(ctx, volume.ID, volume.Zone)
+		if err != nil {
+			if _, ok := err.(*scw.ResourceNotFoundError); ok {
+				return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if volume.Server != nil {
+		if volume.Server.ID == serverResp.Server.ID {
 			return &csi.ControllerPublishVolumeResponse{
-				PublishContext: map[string]string{
-					scwVolumeName: volumeResp.Volume.Name,
-					scwVolumeID:   volumeResp.Volume.ID,
-					scwVolumeZone: volumeResp.Volume.Zone.String(),
-				},
+				PublishContext: publishContext(volume, req.GetReadonly()),
 			}, nil
 		}
-		return nil, status.Errorf(codes.FailedPrecondition, "volume %s already attached to another node %s", volumeID, volumeResp.Volume.Server.ID)
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s already attached to another node %s", volumeID, volume.Server.ID)
+	}
+
+	blockStorage, err := d.client(ctx).SupportsBlockStorage(serverResp.Server.CommercialType, serverResp.Server.Zone, scw.WithContext(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !blockStorage {
+		return nil, status.Errorf(codes.FailedPrecondition, "instance type %s of instance %s does not support the attachment of block volumes (b_ssd)", serverResp.Server.CommercialType, serverResp.Server.ID)
 	}
 
-	volumesCount := len(serverResp.Server.Volumes)
+	localVolumes := scaleway.ServerLocalVolumesCount(serverResp.Server)
+	maxVolumes := attachableVolumes(scaleway.MaxVolumesPerNode, localVolumes, d.config.ReservedVolumeSlots)
 
-	if volumesCount == maxVolumesPerNode {
-		return nil, status.Error(codes.ResourceExhausted, "max number of volumes for this instance")
+	// the volumes attached outside of the driver use the reserved slots, only the ones of the driver
+	// are counted, which are only listed when the attached volumes could exceed the limit
+	volumesCount := len(serverResp.Server.Volumes) - localVolumes
+	if volumesCount >= maxVolumes {
+		volumesCount, err = d.countManagedVolumes(ctx, serverResp.Server)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
 	}
 
-	if volumeResp.Volume.Zone != serverResp.Server.Zone {
-		return nil, status.Error(codes.InvalidArgument, "volume and node are not in the same zone")
+	if volumesCount >= maxVolumes {
+		d.events.warn(pvReference(pvNameFromVolumeName(volume.Name, d.config.Prefix)), eventAttachLimitReached,
+			"volume cannot be attached to instance %s of type %s, which already has the maximum number of volumes (%d)", serverResp.Server.ID, serverResp.Server.CommercialType, maxVolumes)
+		return nil, status.Errorf(codes.ResourceExhausted, "max number of volumes (%d) for instance %s of type %s", maxVolumes, serverResp.Server.ID, serverResp.Server.CommercialType)
 	}
 
-	d.mux.Lock()
-	defer d.mux.Unlock()
-	_, err = d.scaleway.AttachVolume(&instance.AttachVolumeRequest{
+	if volume.Zone != serverResp.Server.Zone {
+		return nil, crossZoneError(volume, serverResp.Server.ID, serverResp.Server.Zone)
+	}
+
+	if _, err := d.waitVolumeSettled(ctx, volume); err != nil {
+		return nil, err
+	}
+
+	err = d.attachVolume(ctx, &instance.AttachVolumeRequest{
 		ServerID: nodeID,
 		VolumeID: volumeID,
-		Zone:     volumeResp.Volume.Zone,
+		Zone:     volume.Zone,
 	})
 	if err != nil {
+		if isRateLimitedError(err) {
+			d.events.warn(pvReference(pvNameFromVolumeName(volume.Name, d.config.Prefix)), eventRateLimited,
+				"the Scaleway API is rate limiting the controller, the attachment will be retried: %s", err)
+		}
+		if isTransientAttachError(err) {
+			return nil, status.Errorf(codes.Aborted, "volume %s is still in a transient state: %s", volumeID, err)
+		}
+		if isConflictError(err) {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s cannot be attached to instance %s: %s", volumeID, nodeID, err)
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	d.events.normal(pvReference(pvNameFromVolumeName(volume.Name, d.config.Prefix)), eventAttached, "volume %s attached to instance %s", volumeID, nodeID)
 
 	return &csi.ControllerPublishVolumeResponse{
-		PublishContext: map[string]string{
-			scwVolumeName: volumeResp.Volume.Name,
-			scwVolumeID:   volumeResp.Volume.ID,
-			scwVolumeZone: volumeResp.Volume.Zone.String(),
-		},
+		PublishContext: publishContext(volume, req.GetReadonly()),
 	}, nil
 }
 
+// crossZoneError returns the error of the attachment of the volume to an instance of another zone,
+// which happens when the volume was not provisioned in the zone where the pod is scheduled
+func crossZoneError(volume *instance.Volume, serverID string, serverZone scw.Zone) error {
+	return status.Errorf(codes.FailedPrecondition,
+		"volume %s is in zone %s and cannot be attached to instance %s in zone %s, the volumes cannot be attached across zones: "+
+			"restrict the zones of the StorageClass with allowedTopologies, or use volumeBindingMode WaitForFirstConsumer",
+		volume.ID, volume.Zone, serverID, serverZone)
+}
+
+// attachVolume attaches the volume, retrying while the volume or the server is in a transient state
+// (e.g. a volume freshly detached from another server) until the deadline of the context or attachRetryTimeout
+func (d *controllerService) attachVolume(ctx context.Context, req *instance.AttachVolumeRequest) error {
+	retryCtx := ctx
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		retryCtx, cancel = context.WithTimeout(ctx, attachRetryTimeout)
+		defer cancel()
+	}
+
+	for {
+		unlock := d.lockAttach(req.VolumeID)
+		_, err := d.client(ctx).AttachVolume(req, scw.WithContext(ctx))
+		unlock()
+		if err == nil || !isTransientAttachError(err) {
+			return err
+		}
+
+		klog.FromContext(ctx).Info("volume in a transient state, retrying attachment", "volumeID", req.VolumeID, "serverID", req.ServerID, "error", err.Error())
+		select {
+		case <-retryCtx.Done():
+			return err
+		case <-time.After(attachRetryInterval):
+		}
+	}
+}
+
+// waitVolumeSettled waits for the end of the operation running on the volume, if any, and returns the
+// refreshed volume. It fails with Aborted if the operation is still running, so that the CO retries the call later.
+func (d *controllerService) waitVolumeSettled(ctx context.Context, volume *instance.Volume) (*instance.Volume, error) {
+	if !scaleway.IsVolumeSettled(volume.State) {
+		klog.FromContext(ctx).Info("waiting for the operation running on the volume", "volumeID", volume.ID, "state", volume.State)
+	}
+	// the volume is waited for before being attached, detached or resized, like the writes
+	settled, err := d.client(ctx).WaitUntilVolumeSettled(scaleway.ContextWithWriteSlots(ctx), volume, volumeSettleTimeout)
+	if err != nil {
+		if _, ok := err.(*scaleway.VolumeBusyError); ok {
+			return nil, status.Errorf(codes.Aborted, "%s, the call will be retried", err)
+		}
+		return nil, waitError(ctx, err)
+	}
+	return settled, nil
+}
+
+// isTransientAttachError returns true if an attachment failed because the volume
+// or the server is in a transient state, and can be retried shortly. The other conflicts,
+// e.g. a volume already attached to another server, do not resolve by themselves.
+func isTransientAttachError(err error) bool {
+	switch err.(type) {
+	case *scw.TransientStateError, *scw.ResourceLockedError:
+		return true
+	}
+	return false
+}
+
+// isConflictError returns true if a call failed with a conflict which is not a transient state
+func isConflictError(err error) bool {
+	e, ok := err.(*scw.ResponseError)
+	return ok && e.StatusCode == http.StatusConflict
+}
+
+// countManagedVolumes returns the number of volumes of the driver attached to the server
+func (d *controllerService) countManagedVolumes(ctx context.Context, server *instance.Server) (int, error) {
+	volumesResp, err := d.client(ctx).ListVolumes(&instance.ListVolumesRequest{
+		Zone: server.Zone,
+		Tags: []string{managedByTag},
+	}, scw.WithContext(ctx), scw.WithAllPages())
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, volume := range volumesResp.Volumes {
+		if volume.Server != nil && volume.Server.ID == server.ID {
+			count++
+		}
+	}
+	return count, nil
+}
+
 // ControllerUnpublishVolume is the reverse operation of ControllerPublishVolume
 // This operation MUST be idempotent.
 func (d *controllerService) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
-	klog.V(4).Infof("ControllerUnpublishVolume called with %s", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("ControllerUnpublishVolume called", "request", stripSecretFromReq(req))
 
-	volumeID, volumeZone, err := getVolumeIDAndZone(req.GetVolumeId())
+	volumeID, volumeZone, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
@@ -414,10 +983,7 @@ func (d *controllerService) ControllerUnpublishVolume(ctx context.Context, req *
 		return nil, err
 	}
 
-	volumeResp, err := d.scaleway.GetVolume(&instance.GetVolumeRequest{
-		VolumeID: volumeID,
-		Zone:     volumeZone,
-	})
+	volume, err := d.getVolume(ctx, volumeID, volumeZone)
 	if err != nil {
 		if _, ok := err.(*scw.ResourceNotFoundError); ok {
 			return &csi.ControllerUnpublishVolumeResponse{}, nil
@@ -425,14 +991,14 @@ func (d *controllerService) ControllerUnpublishVolume(ctx context.Context, req *
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if volumeResp.Volume.Server == nil {
+	if volume.Server == nil {
 		return &csi.ControllerUnpublishVolumeResponse{}, nil
 	}
 
-	_, err = d.scaleway.GetServer(&instance.GetServerRequest{
+	_, err = d.client(ctx).GetServerCached(&instance.GetServerRequest{
 		ServerID: nodeID,
 		Zone:     nodeZone,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		if _, ok := err.(*scw.ResourceNotFoundError); ok {
 			return &csi.ControllerUnpublishVolumeResponse{}, nil
@@ -440,13 +1006,34 @@ func (d *controllerService) ControllerUnpublishVolume(ctx context.Context, req *
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	d.mux.Lock()
-	defer d.mux.Unlock()
-	_, err = d.scaleway.DetachVolume(&instance.DetachVolumeRequest{
+	// the detachment already refused by the API is retried in the background, it is not requested again
+	if d.detachRetries.has(volumeID) {
+		return nil, status.Errorf(codes.Aborted, "the detachment of volume %s is being retried", volumeID)
+	}
+
+	if _, err := d.waitVolumeSettled(ctx, volume); err != nil {
+		return nil, err
+	}
+
+	unlock := d.lockAttach(volumeID)
+	defer unlock()
+	_, err = d.client(ctx).DetachVolume(&instance.DetachVolumeRequest{
 		VolumeID: volumeID,
-		Zone:     volumeResp.Volume.Zone,
-	})
+		Zone:     volume.Zone,
+	}, scw.WithContext(ctx))
 	if err != nil {
+		if isRetryableDetachError(err) && d.detachRetries.add(&pendingDetach{
+			volumeID: volumeID,
+			zone:     volume.Zone,
+			serverID: volume.Server.ID,
+			client:   d.client(ctx),
+			queuedAt: time.Now(),
+		}) {
+			return nil, status.Errorf(codes.Aborted, "volume %s could not be detached, the detachment will be retried: %s", volumeID, err)
+		}
+		if isTransientAttachError(err) {
+			return nil, status.Errorf(codes.Aborted, "volume %s is still in a transient state: %s", volumeID, err)
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
@@ -458,8 +1045,8 @@ func (d *controllerService) ControllerUnpublishVolume(ctx context.Context, req *
 // volume capabilities specified in the request are supported.
 // This operation MUST be idempotent.
 func (d *controllerService) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	klog.V(4).Infof("ValidateVolumeCapabilities called with %s", stripSecretFromReq(*req))
-	volumeID, volumeZone, err := getVolumeIDAndZone(req.GetVolumeId())
+	klog.FromContext(ctx).V(4).Info("ValidateVolumeCapabilities called", "request", stripSecretFromReq(req))
+	volumeID, volumeZone, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
@@ -469,10 +1056,7 @@ func (d *controllerService) ValidateVolumeCapabilities(ctx context.Context, req
 		return nil, status.Error(codes.InvalidArgument, "volumeCapabilities is not provided")
 	}
 
-	_, err = d.scaleway.GetVolume(&instance.GetVolumeRequest{
-		VolumeID: volumeID,
-		Zone:     volumeZone,
-	})
+	volume, err := d.getVolume(ctx, volumeID, volumeZone)
 	if err != nil {
 		if _, ok := err.(*scw.ResourceNotFoundError); ok {
 			return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
@@ -480,51 +1064,66 @@ func (d *controllerService) ValidateVolumeCapabilities(ctx context.Context, req
 
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	// TODO check stuff
+
+	err = validateVolumeCapabilities(volumeCapabilities, accessModesForClass(scaleway.GetVolumeClass(volume.VolumeType)))
+	if err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{
+			Message: err.Error(),
+		}, nil
+	}
+
 	return &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
-			VolumeCapabilities: []*csi.VolumeCapability{
-				{
-					AccessMode: &supportedAccessModes[0], // TODO refactor
-				},
-			},
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: volumeCapabilities,
+			Parameters:         req.GetParameters(),
 		},
 	}, nil
 }
 
 // ListVolumes returns the list of the requested volumes
 func (d *controllerService) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	klog.V(4).Infof("ListVolumes called with %s", stripSecretFromReq(*req))
-	var numberResults int
-	var err error
+	klog.FromContext(ctx).V(4).Info("ListVolumes called", "request", stripSecretFromReq(req))
+
+	cursor, err := decodeListCursor(req.GetStartingToken(), volumesListKind)
+	if err != nil {
+		return nil, status.Error(codes.Aborted, "invalid startingToken")
+	}
 
-	startingToken := req.GetStartingToken()
-	if startingToken != "" {
-		numberResults, err = strconv.Atoi(startingToken)
+	zones := d.client(ctx).Zones()
+	if len(zones) == 0 {
+		zones = []scw.Zone{""} // this will use the default zone of the client
+	}
+	var tags []string
+	if d.config.ListManagedVolumesOnly {
+		tags = []string{managedByTag}
+	}
+	volumes, nextPage, zoneErrs, err := paginateVolumes(zones, cursor, int(req.GetMaxEntries()), func(zone scw.Zone) ([]*instance.Volume, error) {
+		volumesResp, err := d.client(ctx).ListVolumes(&instance.ListVolumesRequest{
+			Zone: zone,
+			Tags: tags,
+		}, scw.WithContext(ctx), scw.WithAllPages())
+		setZoneAvailability(zone, err)
 		if err != nil {
-			return nil, status.Error(codes.Aborted, "invalid startingToken")
+			return nil, err
 		}
+		return volumesResp.Volumes, nil
+	})
+	if err == errInvalidListCursor {
+		return nil, status.Error(codes.Aborted, "invalid startingToken")
 	}
-
-	volumesResp, err := d.scaleway.ListVolumes(&instance.ListVolumesRequest{}, scw.WithContext(ctx), scw.WithAllPages())
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	volumes := volumesResp.Volumes
-
-	nextPage := ""
-	maxEntries := req.GetMaxEntries()
-	if maxEntries == 0 {
-		if numberResults != 0 {
-			volumes = volumes[numberResults:]
-		}
-	} else {
-		if int(maxEntries) > (len(volumes) - numberResults) {
-			volumes = volumes[numberResults:]
-		} else {
-			volumes = volumes[numberResults : numberResults+int(maxEntries)]
-			nextPage = strconv.Itoa(numberResults + int(maxEntries))
+	// partial results would report the volumes of the unavailable zones as detached from all nodes
+	if len(zoneErrs) > 0 {
+		var unavailable []string
+		for zone, zoneErr := range zoneErrs {
+			klog.FromContext(ctx).Error(zoneErr, "error listing the volumes of a zone", "zone", zone)
+			unavailable = append(unavailable, zone.String())
 		}
+		sort.Strings(unavailable)
+		return nil, status.Errorf(codes.Unavailable, "error listing the volumes of zones %s", strings.Join(unavailable, ", "))
 	}
 
 	var volumesEntries []*csi.ListVolumesResponse_Entry
@@ -540,6 +1139,7 @@ func (d *controllerService) ListVolumes(ctx context.Context, req *csi.ListVolume
 			},
 			Status: &csi.ListVolumesResponse_VolumeStatus{
 				PublishedNodeIds: serversID,
+				VolumeCondition:  volumeCondition(volume, nil),
 			},
 		})
 	}
@@ -552,15 +1152,15 @@ func (d *controllerService) ListVolumes(ctx context.Context, req *csi.ListVolume
 
 // GetCapacity returns the capacity of the storage pool from which the controller provisions volumes.
 func (d *controllerService) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	klog.V(4).Infof("GetCapacity is not yet implemented")
+	klog.FromContext(ctx).V(4).Info("GetCapacity is not yet implemented")
 	return nil, status.Error(codes.Unimplemented, "GetCapacity is not yet implemented")
 }
 
 // ControllerGetCapabilities returns  the supported capabilities of controller service provided by the Plugin.
 func (d *controllerService) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	klog.V(4).Infof("ControllerGetCapabilities called with %v", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("ControllerGetCapabilities called", "request", stripSecretFromReq(req))
 	var capabilities []*csi.ControllerServiceCapability
-	for _, capability := range controllerCapabilities {
+	for _, capability := range controllerCapabilities(d.config) {
 		capabilities = append(capabilities, &csi.ControllerServiceCapability{
 			Type: &csi.ControllerServiceCapability_Rpc{
 				Rpc: &csi.ControllerServiceCapability_RPC{
@@ -574,7 +1174,7 @@ func (d *controllerService) ControllerGetCapabilities(ctx context.Context, req *
 
 // CreateSnapshot creates a snapshot of the given volume
 func (d *controllerService) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	klog.V(4).Infof("CreateSnapshot called with %v", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("CreateSnapshot called", "request", stripSecretFromReq(req))
 	sourceVolumeID, sourceVolumeZone, err := getSourceVolumeIDAndZone(req.GetSourceVolumeId())
 	if err != nil {
 		return nil, err
@@ -585,7 +1185,9 @@ func (d *controllerService) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		return nil, status.Error(codes.InvalidArgument, "name not provided")
 	}
 
-	snapshot, err := d.scaleway.GetSnapshotByName(name, sourceVolumeID, sourceVolumeZone)
+	// the snapshot is never waited for: while it is being created, ReadyToUse is false
+	// and the CO polls its state by calling CreateSnapshot again with the same name
+	snapshot, err := d.client(ctx).GetSnapshotByName(name, sourceVolumeID, sourceVolumeZone, scw.WithContext(ctx))
 	if err != nil {
 		switch err {
 		case scaleway.ErrSnapshotNotFound: // all good
@@ -596,42 +1198,60 @@ func (d *controllerService) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		}
 	}
 
-	if snapshot != nil {
-		snapshotResp := &csi.Snapshot{
-			SizeBytes:      int64(snapshot.Size), // TODO(pcyvoct) ugly cast
-			SnapshotId:     scaleway.ExpandSnapshotID(snapshot),
-			SourceVolumeId: sourceVolumeZone.String() + "/" + sourceVolumeID,
-			ReadyToUse:     snapshot.State == instance.SnapshotStateAvailable,
+	if snapshot == nil {
+		sourceVolume, err := d.getVolume(ctx, sourceVolumeID, sourceVolumeZone)
+		if err != nil {
+			if _, ok := err.(*scw.ResourceNotFoundError); ok {
+				return nil, status.Errorf(codes.NotFound, "volume %s not found", sourceVolumeID)
+			}
+			return nil, status.Error(codes.Internal, err.Error())
 		}
-
-		if snapshot.CreationDate != nil {
-			snapshotResp.CreationTime = timestamppb.New(*snapshot.CreationDate)
+		tags := getSnapshotTags(req.GetParameters())
+		// the encryption of the volume is restored along with its snapshot
+		if encryptedTag, ok := getTagValue(sourceVolume.Tags, encryptedTagPrefix); ok {
+			tags = append(tags, encryptedTagPrefix+encryptedTag)
+		}
+		snapshotRequest := &instance.CreateSnapshotRequest{
+			VolumeID: &sourceVolumeID,
+			Name:     name,
+			Zone:     sourceVolumeZone,
+			Tags:     &tags,
+		}
+		if projectID := getProjectID(req.GetParameters(), req.GetSecrets()); projectID != "" {
+			snapshotRequest.Project = &projectID
 		}
 
-		return &csi.CreateSnapshotResponse{
-			Snapshot: snapshotResp,
-		}, nil
+		snapshotResp, err := d.client(ctx).CreateSnapshot(snapshotRequest, scw.WithContext(ctx))
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		snapshot = snapshotResp.Snapshot
+		// the progress is only known from the task of the creation, the snapshots do not expose it
+		if snapshotResp.Task != nil {
+			klog.FromContext(ctx).V(2).Info("snapshot in progress", "snapshotID", snapshot.ID, "volumeID", sourceVolumeID, "progress", snapshotResp.Task.Progress, "taskID", snapshotResp.Task.ID, "taskStatus", snapshotResp.Task.Status)
+		}
+	}
 
+	if snapshot.State == instance.SnapshotStateError {
+		return nil, status.Errorf(codes.Internal, "snapshot %s is in state %s", snapshot.ID, snapshot.State)
 	}
 
-	snapshotResp, err := d.scaleway.CreateSnapshot(&instance.CreateSnapshotRequest{
-		VolumeID: &sourceVolumeID,
-		Name:     name,
-		Zone:     sourceVolumeZone,
-	})
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	readyToUse := snapshot.State == instance.SnapshotStateAvailable
+	if exportBucket := getExportBucket(req.GetParameters()); exportBucket != "" && readyToUse {
+		if err := d.exportSnapshot(ctx, snapshot, exportBucket); err != nil {
+			return nil, status.Errorf(codes.Internal, "error exporting snapshot %s to bucket %s: %s", snapshot.ID, exportBucket, err)
+		}
 	}
 
 	snapshotProtoResp := &csi.Snapshot{
-		SizeBytes:      int64(snapshotResp.Snapshot.Size), // TODO(pcyvoct) ugly cast
-		SnapshotId:     scaleway.ExpandSnapshotID(snapshotResp.Snapshot),
+		SizeBytes:      int64(snapshot.Size), // TODO(pcyvoct) ugly cast
+		SnapshotId:     scaleway.ExpandSnapshotID(snapshot),
 		SourceVolumeId: sourceVolumeZone.String() + "/" + sourceVolumeID,
-		ReadyToUse:     snapshotResp.Snapshot.State == instance.SnapshotStateAvailable,
+		ReadyToUse:     readyToUse,
 	}
 
-	if snapshotResp.Snapshot.CreationDate != nil {
-		snapshotProtoResp.CreationTime = timestamppb.New(*snapshotResp.Snapshot.CreationDate)
+	if snapshot.CreationDate != nil {
+		snapshotProtoResp.CreationTime = timestamppb.New(*snapshot.CreationDate)
 	}
 
 	return &csi.CreateSnapshotResponse{
@@ -639,44 +1259,98 @@ func (d *controllerService) CreateSnapshot(ctx context.Context, req *csi.CreateS
 	}, nil
 }
 
+// exportSnapshot exports the given snapshot to an Object Storage bucket of the same region.
+// The snapshot is tagged with the destination of the export so that it is only triggered once.
+func (d *controllerService) exportSnapshot(ctx context.Context, snapshot *instance.Snapshot, bucket string) error {
+	key := snapshot.Name + ".qcow2"
+	exportedTag := exportedToTagPrefix + bucket + "/" + key
+	for _, tag := range snapshot.Tags {
+		if tag == exportedTag {
+			return nil
+		}
+	}
+
+	_, err := d.client(ctx).ExportSnapshot(&instance.ExportSnapshotRequest{
+		SnapshotID: snapshot.ID,
+		Zone:       snapshot.Zone,
+		Bucket:     bucket,
+		Key:        key,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	klog.FromContext(ctx).Info("snapshot export started", "snapshotID", snapshot.ID, "bucket", bucket, "key", key)
+
+	tags := append(append([]string{}, snapshot.Tags...), exportedTag)
+	_, err = d.client(ctx).UpdateSnapshot(&instance.UpdateSnapshotRequest{
+		SnapshotID: snapshot.ID,
+		Zone:       snapshot.Zone,
+		Tags:       &tags,
+	}, scw.WithContext(ctx))
+	return err
+}
+
 // DeleteSnapshot deletes the given snapshot
 func (d *controllerService) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	klog.V(4).Infof("DeleteSnapshot called with %s", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("DeleteSnapshot called", "request", stripSecretFromReq(req))
 	snapshotID, snapshotZone, err := getSnapshotIDAndZone(req.GetSnapshotId())
 	if err != nil {
 		return nil, err
 	}
 
-	err = d.scaleway.DeleteSnapshot(&instance.DeleteSnapshotRequest{
+	if snapshotZone == scw.Zone("") {
+		snapshot, err := d.getSnapshot(ctx, snapshotID, snapshotZone)
+		if err != nil {
+			if _, ok := err.(*scw.ResourceNotFoundError); ok {
+				klog.FromContext(ctx).V(4).Info("snapshot not found", "snapshotID", snapshotID)
+				return &csi.DeleteSnapshotResponse{}, nil
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		snapshotZone = snapshot.Zone
+	}
+
+	err = d.client(ctx).DeleteSnapshot(&instance.DeleteSnapshotRequest{
 		SnapshotID: snapshotID,
 		Zone:       snapshotZone,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		if _, ok := err.(*scw.ResourceNotFoundError); ok {
-			klog.V(4).Infof("snapshot with ID %s not found", snapshotID)
+			klog.FromContext(ctx).V(4).Info("snapshot not found", "snapshotID", snapshotID)
 			return &csi.DeleteSnapshotResponse{}, nil
 		}
+		if isSnapshotInUseError(err) {
+			// the snapshotter retries the deletion with a backoff until the snapshot is released
+			return nil, status.Errorf(codes.FailedPrecondition, "snapshot %s is in use, e.g. by a volume being created from it, its deletion will be retried once it is released: %s", snapshotID, err)
+		}
 
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	return &csi.DeleteSnapshotResponse{}, nil
 }
 
+// isSnapshotInUseError returns true if a snapshot could not be deleted because it is still used,
+// e.g. by a volume being created from it, or is in a transient state
+func isSnapshotInUseError(err error) bool {
+	switch e := err.(type) {
+	case *scw.PreconditionFailedError, *scw.ResourceLockedError, *scw.TransientStateError:
+		return true
+	case *scw.ResponseError:
+		return e.StatusCode == http.StatusConflict || e.StatusCode == http.StatusPreconditionFailed
+	}
+	return false
+}
+
 // ListSnapshots return the information about all snapshots on the
 // storage system within the given parameters regardless of how
 // they were created. ListSnapshots SHALL NOT list a snapshot that
 // is being created but has not been cut successfully yet.
 func (d *controllerService) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	klog.V(4).Infof("ListSnapshots called with %s", stripSecretFromReq(*req))
-	var numberResults int
-	var err error
+	klog.FromContext(ctx).V(4).Info("ListSnapshots called", "request", stripSecretFromReq(req))
 
-	startingToken := req.GetStartingToken()
-	if startingToken != "" {
-		numberResults, err = strconv.Atoi(startingToken)
-		if err != nil {
-			return nil, status.Error(codes.Aborted, "invalid startingToken")
-		}
+	cursor, err := decodeListCursor(req.GetStartingToken(), snapshotsListKind)
+	if err != nil {
+		return nil, status.Error(codes.Aborted, "invalid startingToken")
 	}
 
 	// TODO fix zones
@@ -684,12 +1358,10 @@ func (d *controllerService) ListSnapshots(ctx context.Context, req *csi.ListSnap
 	sourceVolumeID, sourceVolumeZone, _ := getSourceVolumeIDAndZone(req.GetSourceVolumeId())
 
 	snapshots := []*instance.Snapshot{}
+	nextPage := ""
 	switch {
 	case req.SnapshotId != "":
-		snapshotResp, err := d.scaleway.GetSnapshot(&instance.GetSnapshotRequest{
-			SnapshotID: snapshotID,
-			Zone:       snapshotZone,
-		}, scw.WithContext(ctx))
+		snapshot, err := d.getSnapshot(ctx, snapshotID, snapshotZone)
 		if err != nil {
 			// not found should return empty list
 			if _, ok := err.(*scw.ResourceNotFoundError); ok {
@@ -699,36 +1371,32 @@ func (d *controllerService) ListSnapshots(ctx context.Context, req *csi.ListSnap
 			}
 			return nil, status.Error(codes.Internal, err.Error())
 		}
-		snapshots = []*instance.Snapshot{snapshotResp.Snapshot}
-	case sourceVolumeID != "":
-		snapshotsResp, err := d.scaleway.ListSnapshots(&instance.ListSnapshotsRequest{
-			BaseVolumeID: &sourceVolumeID,
-			Zone:         sourceVolumeZone,
-		}, scw.WithContext(ctx), scw.WithAllPages())
-		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
-		}
-		snapshots = snapshotsResp.Snapshots
+		snapshots = []*instance.Snapshot{snapshot}
 	default:
-		snapshotsResp, err := d.scaleway.ListSnapshots(&instance.ListSnapshotsRequest{}, scw.WithContext(ctx), scw.WithAllPages())
-		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+		listRequest := instance.ListSnapshotsRequest{}
+		zones := d.client(ctx).Zones()
+		if sourceVolumeID != "" {
+			listRequest.BaseVolumeID = &sourceVolumeID
+			zones = []scw.Zone{sourceVolumeZone}
+		}
+		if len(zones) == 0 {
+			zones = []scw.Zone{""} // this will use the default zone of the client
 		}
-		snapshots = snapshotsResp.Snapshots
-	}
 
-	nextPage := ""
-	maxEntries := req.GetMaxEntries()
-	if maxEntries == 0 {
-		if numberResults != 0 {
-			snapshots = snapshots[numberResults:]
+		snapshots, nextPage, err = paginateSnapshots(zones, cursor, int(req.GetMaxEntries()), func(zone scw.Zone) ([]*instance.Snapshot, error) {
+			zoneRequest := listRequest
+			zoneRequest.Zone = zone
+			snapshotsResp, err := d.client(ctx).ListSnapshots(&zoneRequest, scw.WithContext(ctx), scw.WithAllPages())
+			if err != nil {
+				return nil, err
+			}
+			return snapshotsResp.Snapshots, nil
+		})
+		if err == errInvalidListCursor {
+			return nil, status.Error(codes.Aborted, "invalid startingToken")
 		}
-	} else {
-		if int(maxEntries) > (len(snapshots) - numberResults) {
-			snapshots = snapshots[numberResults:]
-		} else {
-			snapshots = snapshots[numberResults : numberResults+int(maxEntries)]
-			nextPage = strconv.Itoa(numberResults + int(maxEntries))
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
 		}
 	}
 
@@ -749,6 +1417,9 @@ func (d *controllerService) ListSnapshots(ctx context.Context, req *csi.ListSnap
 		if snap.CreationDate != nil {
 			snapshotProtoResp.CreationTime = timestamppb.New(*snap.CreationDate)
 		}
+		if !snapshotProtoResp.ReadyToUse && snap.CreationDate != nil {
+			klog.FromContext(ctx).V(2).Info("snapshot not ready", "snapshotID", snapshotProtoResp.SnapshotId, "state", snap.State, "since", time.Since(*snap.CreationDate).Round(time.Second))
+		}
 
 		snapshotsEntries = append(snapshotsEntries, &csi.ListSnapshotsResponse_Entry{
 			Snapshot: snapshotProtoResp,
@@ -763,17 +1434,25 @@ func (d *controllerService) ListSnapshots(ctx context.Context, req *csi.ListSnap
 
 // ControllerExpandVolume expands the given volume
 func (d *controllerService) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	klog.V(4).Infof("ControllerExpandVolume called with %s", stripSecretFromReq(*req))
-	volumeID, volumeZone, err := getVolumeIDAndZone(req.GetVolumeId())
+	klog.FromContext(ctx).V(4).Info("ControllerExpandVolume called", "request", stripSecretFromReq(req))
+	volumeID, volumeZone, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
 
+	volume, err := d.getVolume(ctx, volumeID, volumeZone)
+	if err != nil {
+		if _, ok := err.(*scw.ResourceNotFoundError); ok {
+			return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
 	nodeExpansionRequired := true
 
 	volumeCapability := req.GetVolumeCapability()
 	if volumeCapability != nil {
-		err := validateVolumeCapability(volumeCapability)
+		err := validateVolumeCapability(volumeCapability, accessModesForClass(scaleway.GetVolumeClass(volume.VolumeType)))
 		if err != nil {
 			return nil, status.Errorf(codes.InvalidArgument, "volumeCapabilities not supported: %s", err)
 		}
@@ -783,66 +1462,128 @@ func (d *controllerService) ControllerExpandVolume(ctx context.Context, req *csi
 		}
 	}
 
-	volumeResp, err := d.scaleway.GetVolume(&instance.GetVolumeRequest{
-		VolumeID: volumeID,
-		Zone:     volumeZone,
-	})
+	minSize, maxSize, err := d.client(ctx).GetVolumeLimits(string(volume.VolumeType))
 	if err != nil {
-		if _, ok := err.(*scw.ResourceNotFoundError); ok {
-			return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
-		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-
-	minSize, maxSize, err := d.scaleway.GetVolumeLimits(string(volumeResp.Volume.VolumeType))
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if classMaxSize := getMaxSizeTag(volume.Tags); classMaxSize > 0 && classMaxSize < maxSize {
+		maxSize = classMaxSize
 	}
 
-	newSize, err := getVolumeRequestCapacity(minSize, maxSize, req.GetCapacityRange())
+	newSize, err := getVolumeRequestCapacity(minSize, maxSize, 0, req.GetCapacityRange())
 	if err != nil {
 		return nil, status.Errorf(codes.OutOfRange, "capacityRange invalid: %s", err)
 	}
 
-	if newSize < int64(volumeResp.Volume.Size) {
+	if newSize < int64(volume.Size) {
 		return nil, status.Error(codes.InvalidArgument, "the new size of the volume will be less than the actual size")
 	}
 
-	_, err = d.scaleway.UpdateVolume(&instance.UpdateVolumeRequest{
-		Zone:     volumeZone,
-		VolumeID: volumeID,
-		Size:     scw.SizePtr(scw.Size(newSize)),
-	})
+	volume, err = d.waitVolumeSettled(ctx, volume)
 	if err != nil {
+		return nil, err
+	}
+
+	err = d.resizeVolume(ctx, volume, newSize)
+	if _, ok := err.(*scw.PreconditionFailedError); ok && volume.Server != nil {
+		if !hasTag(volume.Tags, offlineExpandTag) {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s is in use by server %s and can only be expanded once detached, stop its workload or set the parameter %s: \"true\" on its StorageClass: %s", volumeID, volume.Server.ID, allowOfflineExpandKey, err)
+		}
+		err = d.resizeVolumeOffline(ctx, volume, newSize)
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	d.events.normal(pvReference(pvNameFromVolumeName(volume.Name, d.config.Prefix)), eventExpanded, "volume %s expanded from %d to %d bytes", volumeID, volume.Size, newSize)
 
-	vol, err := d.scaleway.WaitForVolume(&instance.WaitForVolumeRequest{
-		VolumeID: volumeID,
-		Zone:     volumeZone,
+	return &csi.ControllerExpandVolumeResponse{CapacityBytes: newSize, NodeExpansionRequired: nodeExpansionRequired}, nil
+}
+
+// resizeVolume resizes the volume and waits for it to be available
+func (d *controllerService) resizeVolume(ctx context.Context, volume *instance.Volume, newSize int64) error {
+	_, err := d.client(ctx).UpdateVolume(&instance.UpdateVolumeRequest{
+		Zone:     volume.Zone,
+		VolumeID: volume.ID,
+		Size:     scw.SizePtr(scw.Size(newSize)),
+	}, scw.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	vol, err := d.client(ctx).WaitForVolumeContext(ctx, &instance.WaitForVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
 	})
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return waitError(ctx, err)
 	}
 	if vol.State != instance.VolumeStateAvailable {
-		return nil, status.Errorf(codes.Internal, "volume %s is in state %s", volumeID, vol.State)
+		return status.Errorf(codes.Internal, "volume %s is in state %s", volume.ID, vol.State)
 	}
+	return nil
+}
 
-	return &csi.ControllerExpandVolumeResponse{CapacityBytes: newSize, NodeExpansionRequired: nodeExpansionRequired}, nil
+// resizeVolumeOffline resizes a volume the API refuses to resize while attached: the volume is detached,
+// resized and attached back to its server. The server must be stopped, a volume detached under
+// a running server would be removed from under its filesystem.
+func (d *controllerService) resizeVolumeOffline(ctx context.Context, volume *instance.Volume, newSize int64) error {
+	serverResp, err := d.client(ctx).GetServer(&instance.GetServerRequest{
+		Zone:     volume.Zone,
+		ServerID: volume.Server.ID,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	server := serverResp.Server
+	if server.State != instance.ServerStateStopped && server.State != instance.ServerStateStoppedInPlace {
+		return status.Errorf(codes.FailedPrecondition, "volume %s can only be expanded once detached, and its server %s is %s: stop the server or the workload using the volume", volume.ID, server.ID, server.State)
+	}
+
+	logger := klog.FromContext(ctx)
+	logger.Info("detaching volume from its stopped server to expand it", "volumeID", volume.ID, "serverID", server.ID)
+	unlock := d.lockAttach(volume.ID)
+	_, err = d.client(ctx).DetachVolume(&instance.DetachVolumeRequest{
+		Zone:     volume.Zone,
+		VolumeID: volume.ID,
+	}, scw.WithContext(ctx))
+	unlock()
+	if err != nil {
+		return err
+	}
+	if _, err := d.client(ctx).WaitForVolumeContext(scaleway.ContextWithWriteSlots(ctx), &instance.WaitForVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	}); err != nil {
+		return waitError(ctx, err)
+	}
+
+	resizeErr := d.resizeVolume(ctx, volume, newSize)
+
+	// the volume is attached back even if the resize failed, the CO still considers it published
+	err = d.attachVolume(ctx, &instance.AttachVolumeRequest{
+		Zone:     volume.Zone,
+		VolumeID: volume.ID,
+		ServerID: server.ID,
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "error attaching volume %s back to server %s after its expansion: %s", volume.ID, server.ID, err)
+	}
+	logger.Info("volume attached back to its server", "volumeID", volume.ID, "serverID", server.ID)
+	return resizeErr
 }
 
 // ControllerGetVolume gets a specific volume.
 func (d *controllerService) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	klog.V(4).Infof("ControllerGetVolume called with %s", stripSecretFromReq(*req))
-	volumeID, volumeZone, err := getVolumeIDAndZone(req.GetVolumeId())
+	klog.FromContext(ctx).V(4).Info("ControllerGetVolume called", "request", stripSecretFromReq(req))
+	volumeID, volumeZone, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
 
-	volumeResp, err := d.scaleway.GetVolume(&instance.GetVolumeRequest{
-		VolumeID: volumeID,
-		Zone:     volumeZone,
-	})
+	volume, err := d.getVolume(ctx, volumeID, volumeZone)
 	if err != nil {
 		if _, ok := err.(*scw.ResourceNotFoundError); ok {
 			return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
@@ -851,17 +1592,163 @@ func (d *controllerService) ControllerGetVolume(ctx context.Context, req *csi.Co
 	}
 
 	var serversID []string
-	if volumeResp.Volume.Server != nil {
-		serversID = append(serversID, volumeResp.Volume.Zone.String()+"/"+volumeResp.Volume.Server.ID)
+	var server *instance.Server
+	if volume.Server != nil {
+		serversID = append(serversID, volume.Zone.String()+"/"+volume.Server.ID)
+		serverResp, err := d.client(ctx).GetServerCached(&instance.GetServerRequest{
+			Zone:     volume.Zone,
+			ServerID: volume.Server.ID,
+		}, scw.WithContext(ctx))
+		if err != nil {
+			if _, ok := err.(*scw.ResourceNotFoundError); !ok {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		} else {
+			server = serverResp.Server
+		}
 	}
 
 	return &csi.ControllerGetVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      volumeResp.Volume.Zone.String() + "/" + volumeResp.Volume.ID,
-			CapacityBytes: int64(volumeResp.Volume.Size),
+			VolumeId:      volume.Zone.String() + "/" + volume.ID,
+			CapacityBytes: int64(volume.Size),
 		},
 		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
 			PublishedNodeIds: serversID,
+			VolumeCondition:  volumeCondition(volume, server),
 		},
 	}, nil
 }
+
+// getVolume returns the volume with the given ID and zone.
+// If the zone is unknown, the volume is looked up in all the zones.
+func (d *controllerService) getVolume(ctx context.Context, volumeID string, volumeZone scw.Zone) (*instance.Volume, error) {
+	volumeResp, err := d.client(ctx).GetVolume(&instance.GetVolumeRequest{
+		VolumeID: volumeID,
+		Zone:     volumeZone,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		if _, ok := err.(*scw.ResourceNotFoundError); ok && volumeZone == scw.Zone("") {
+			klog.FromContext(ctx).V(4).Info("volume not found in default zone, looking into all zones", "volumeID", volumeID)
+			return d.client(ctx).GetVolumeInAllZones(volumeID, scw.WithContext(ctx))
+		}
+		return nil, err
+	}
+	return volumeResp.Volume, nil
+}
+
+// getVolumeCached is getVolume reusing the recent lookups of the volume, see scaleway.GetVolumeCached
+func (d *controllerService) getVolumeCached(ctx context.Context, volumeID string, volumeZone scw.Zone) (*instance.Volume, error) {
+	volumeResp, err := d.client(ctx).GetVolumeCached(&instance.GetVolumeRequest{
+		VolumeID: volumeID,
+		Zone:     volumeZone,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		if _, ok := err.(*scw.ResourceNotFoundError); ok && volumeZone == scw.Zone("") {
+			klog.FromContext(ctx).V(4).Info("volume not found in default zone, looking into all zones", "volumeID", volumeID)
+			return d.client(ctx).GetVolumeInAllZones(volumeID, scw.WithContext(ctx))
+		}
+		return nil, err
+	}
+	return volumeResp.Volume, nil
+}
+
+// getSnapshot returns the snapshot with the given ID and zone.
+// If the zone is unknown, the snapshot is looked up in all the zones.
+func (d *controllerService) getSnapshot(ctx context.Context, snapshotID string, snapshotZone scw.Zone) (*instance.Snapshot, error) {
+	snapshotResp, err := d.client(ctx).GetSnapshot(&instance.GetSnapshotRequest{
+		SnapshotID: snapshotID,
+		Zone:       snapshotZone,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		if _, ok := err.(*scw.ResourceNotFoundError); ok && snapshotZone == scw.Zone("") {
+			klog.FromContext(ctx).V(4).Info("snapshot not found in default zone, looking into all zones", "snapshotID", snapshotID)
+			return d.client(ctx).GetSnapshotInAllZones(snapshotID, scw.WithContext(ctx))
+		}
+		return nil, err
+	}
+	return snapshotResp.Snapshot, nil
+}
+
+// createVolumeInZones creates the volume in all the zones at once, the first creation to succeed winning.
+// The other creations are cancelled, and the volumes created anyway before the cancellation are deleted.
+// It returns the errors of the creations which failed before one succeeded.
+func (d *controllerService) createVolumeInZones(ctx context.Context, zones []scw.Zone, volumeRequest *instance.CreateVolumeRequest, createVolume func(context.Context, *instance.CreateVolumeRequest) (*instance.Volume, error)) (*instance.Volume, []error) {
+	createCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type creation struct {
+		volume *instance.Volume
+		err    error
+	}
+	creations := make(chan creation, len(zones))
+	for _, zone := range zones {
+		zoneRequest := *volumeRequest
+		zoneRequest.Zone = zone
+		go func() {
+			volume, err := createVolume(createCtx, &zoneRequest)
+			creations <- creation{volume: volume, err: err}
+		}()
+	}
+
+	var volume *instance.Volume
+	var errs []error
+	for range zones {
+		c := <-creations
+		switch {
+		case c.err == nil && volume == nil:
+			volume = c.volume
+			cancel()
+		case c.err == nil:
+			klog.FromContext(ctx).Info("deleting the volume created in another zone", "volumeID", c.volume.ID, "zone", c.volume.Zone, "chosenVolumeID", volume.ID)
+			d.deleteVolumeCopy(ctx, c.volume)
+		case volume == nil:
+			errs = append(errs, c.err)
+		}
+	}
+	if volume != nil {
+		d.deleteVolumeStragglers(ctx, zones, volumeRequest, volume)
+	}
+	return volume, errs
+}
+
+// deleteVolumeStragglers deletes the volumes with the name of the chosen one created in the other zones by
+// createVolumeInZones, whose creation was cancelled after reaching the API: they would make every later
+// lookup of the volume by name fail with ErrMultipleVolumes
+func (d *controllerService) deleteVolumeStragglers(ctx context.Context, zones []scw.Zone, volumeRequest *instance.CreateVolumeRequest, chosen *instance.Volume) {
+	for _, zone := range zones {
+		volumesResp, err := d.client(ctx).ListVolumes(&instance.ListVolumesRequest{
+			Zone: zone,
+			Name: scw.StringPtr(volumeRequest.Name),
+			Tags: []string{managedByTag},
+		}, scw.WithContext(ctx), scw.WithAllPages())
+		if err != nil {
+			klog.FromContext(ctx).Error(err, "error looking for the volumes created in another zone", "zone", zone, "chosenVolumeID", chosen.ID)
+			continue
+		}
+		for _, volume := range volumesResp.Volumes {
+			if volume.Name != volumeRequest.Name || volume.ID == chosen.ID {
+				continue
+			}
+			klog.FromContext(ctx).Info("deleting the volume created in another zone after its cancellation", "volumeID", volume.ID, "zone", volume.Zone, "chosenVolumeID", chosen.ID)
+			d.deleteVolumeCopy(ctx, volume)
+		}
+	}
+}
+
+// deleteVolumeCopy deletes a volume created in several zones by createVolumeInZones
+func (d *controllerService) deleteVolumeCopy(ctx context.Context, volume *instance.Volume) {
+	if _, err := d.client(ctx).WaitForVolumeContext(ctx, &instance.WaitForVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	}, scw.WithContext(ctx)); err != nil {
+		klog.FromContext(ctx).Error(err, "error waiting for the volume created in another zone", "volumeID", volume.ID, "zone", volume.Zone)
+	}
+	err := d.client(ctx).DeleteVolume(&instance.DeleteVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		klog.FromContext(ctx).Error(err, "error deleting the volume created in another zone", "volumeID", volume.ID, "zone", volume.Zone)
+	}
+}
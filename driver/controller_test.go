@@ -0,0 +1,1023 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newFakeInstanceAPI returns a fake Instance API in fr-par-1 holding the given volumes, servers and snapshots
+func newFakeInstanceAPI(volumes []*instance.Volume, servers []*instance.Server, snapshots []*instance.Snapshot) *fakeHelper {
+	fake := &fakeHelper{
+		fakeDiskUtils: fakeDiskUtils{devices: map[string]*mountpoint{}},
+		fakeInstanceAPI: fakeInstanceAPI{
+			volumesMap:   map[string]*instance.Volume{},
+			serversMap:   map[string]*instance.Server{},
+			snapshotsMap: map[string]*instance.Snapshot{},
+			defaultZone:  scw.ZoneFrPar1,
+		},
+	}
+	for _, volume := range volumes {
+		fake.volumesMap[volume.ID] = volume
+	}
+	for _, server := range servers {
+		fake.serversMap[server.ID] = server
+	}
+	for _, snapshot := range snapshots {
+		fake.snapshotsMap[snapshot.ID] = snapshot
+	}
+	return fake
+}
+
+// newTestController returns a controller calling the given Instance API
+func newTestController(api scaleway.InstanceAPI, config *DriverConfig) *controllerService {
+	return &controllerService{
+		scaleway: &scaleway.Scaleway{InstanceAPI: api},
+		config:   config,
+	}
+}
+
+// mountCapability is the capability of a filesystem volume written by a single node
+func mountCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func Test_exportSnapshot(t *testing.T) {
+	snapshot := &instance.Snapshot{
+		ID:    "snapshot-id",
+		Name:  "snapshot-1234",
+		Zone:  scw.ZoneFrPar1,
+		State: instance.SnapshotStateAvailable,
+		Tags:  []string{managedByTag},
+	}
+	fake := newFakeInstanceAPI(nil, nil, []*instance.Snapshot{snapshot})
+	d := newTestController(fake, &DriverConfig{})
+
+	exportedTags := []string{managedByTag, exportedToTagPrefix + "backups/snapshot-1234.qcow2"}
+	AssertNoError(t, d.exportSnapshot(context.Background(), snapshot, "backups"))
+	Equals(t, exportedTags, snapshot.Tags)
+
+	// the export is only triggered once
+	AssertNoError(t, d.exportSnapshot(context.Background(), snapshot, "backups"))
+	Equals(t, exportedTags, snapshot.Tags)
+}
+
+// transientAttachFake fails the first attachments with a transient state error, or with err if set
+type transientAttachFake struct {
+	*fakeHelper
+	failures int
+	err      error
+}
+
+func (f *transientAttachFake) AttachVolume(req *instance.AttachVolumeRequest, opts ...scw.RequestOption) (*instance.AttachVolumeResponse, error) {
+	if f.failures > 0 {
+		f.failures--
+		if f.err != nil {
+			return nil, f.err
+		}
+		return nil, &scw.TransientStateError{Resource: "instance_volume", ResourceID: req.VolumeID, CurrentState: "hotsyncing"}
+	}
+	return f.fakeHelper.AttachVolume(req, opts...)
+}
+
+func Test_attachVolume(t *testing.T) {
+	defaultInterval := attachRetryInterval
+	attachRetryInterval = time.Millisecond
+	defer func() { attachRetryInterval = defaultInterval }()
+
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1, VolumeType: instance.VolumeVolumeTypeBSSD}
+	server := &instance.Server{ID: "server-id", Zone: scw.ZoneFrPar1, Volumes: map[string]*instance.VolumeServer{}}
+	fake := &transientAttachFake{
+		fakeHelper: newFakeInstanceAPI([]*instance.Volume{volume}, []*instance.Server{server}, nil),
+		failures:   2,
+	}
+	d := newTestController(fake, &DriverConfig{})
+	req := &instance.AttachVolumeRequest{ServerID: server.ID, VolumeID: volume.ID, Zone: scw.ZoneFrPar1}
+
+	AssertNoError(t, d.attachVolume(context.Background(), req))
+	Equals(t, 0, fake.failures)
+	Equals(t, 1, len(server.Volumes))
+
+	// the retries stop with the context
+	fake.failures = 1000
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := d.attachVolume(ctx, req)
+	AssertTrue(t, isTransientAttachError(err))
+
+	// the other conflicts are not retried
+	fake.failures = 2
+	fake.err = &scw.ResponseError{StatusCode: http.StatusConflict, Message: "volume is already attached to another server"}
+	err = d.attachVolume(context.Background(), req)
+	AssertTrue(t, isConflictError(err))
+	Equals(t, 1, fake.failures)
+}
+
+// blockingWaitFake never returns from WaitForVolume until released
+type blockingWaitFake struct {
+	*fakeHelper
+	release chan struct{}
+}
+
+func (f *blockingWaitFake) WaitForVolume(req *instance.WaitForVolumeRequest, opts ...scw.RequestOption) (*instance.Volume, error) {
+	<-f.release
+	return f.fakeHelper.WaitForVolume(req, opts...)
+}
+
+func Test_waitForVolumeContext(t *testing.T) {
+	fake := &blockingWaitFake{
+		fakeHelper: &fakeHelper{},
+		release:    make(chan struct{}),
+	}
+	defer close(fake.release)
+	s := &scaleway.Scaleway{InstanceAPI: fake}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := s.WaitForVolumeContext(ctx, &instance.WaitForVolumeRequest{VolumeID: "volume-id"})
+	AssertTrue(t, err != nil)
+	Equals(t, codes.Aborted, status.Code(waitError(ctx, err)))
+
+	Equals(t, codes.Internal, status.Code(waitError(context.Background(), err)))
+}
+
+func Test_CreateSnapshotStates(t *testing.T) {
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1}
+	fake := newFakeInstanceAPI([]*instance.Volume{volume}, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.CreateSnapshotRequest{Name: "snapshot-1234", SourceVolumeId: "fr-par-1/volume-id"}
+
+	resp, err := d.CreateSnapshot(context.Background(), req)
+	AssertNoError(t, err)
+	AssertFalse(t, resp.GetSnapshot().GetReadyToUse())
+
+	// the next call only looks up the snapshot, which is now available in the fake
+	resp, err = d.CreateSnapshot(context.Background(), req)
+	AssertNoError(t, err)
+	AssertTrue(t, resp.GetSnapshot().GetReadyToUse())
+
+	for _, snapshot := range fake.snapshotsMap {
+		snapshot.State = instance.SnapshotStateError
+	}
+	_, err = d.CreateSnapshot(context.Background(), req)
+	Equals(t, codes.Internal, status.Code(err))
+}
+
+func Test_CreateSnapshotTags(t *testing.T) {
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1}
+	fake := newFakeInstanceAPI([]*instance.Volume{volume}, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+
+	_, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name:           "snapshot-1234",
+		SourceVolumeId: "fr-par-1/volume-id",
+		Parameters: map[string]string{
+			snapshotTagsKey:            "cluster=prod, namespace=${volumesnapshot.namespace},",
+			descriptionKey:             "backup of ${volumesnapshot.namespace}/${volumesnapshot.name}",
+			volumeSnapshotNameKey:      "data-backup",
+			volumeSnapshotNamespaceKey: "default",
+		},
+	})
+	AssertNoError(t, err)
+	for _, snapshot := range fake.snapshotsMap {
+		Equals(t, []string{managedByTag, "cluster=prod", "namespace=default", "description=backup of default/data-backup"}, snapshot.Tags)
+	}
+}
+
+// countingLookupsFake counts the volume and server lookups reaching the API
+type countingLookupsFake struct {
+	*fakeHelper
+	getVolumes int
+	getServers int
+}
+
+func (f *countingLookupsFake) GetVolume(req *instance.GetVolumeRequest, opts ...scw.RequestOption) (*instance.GetVolumeResponse, error) {
+	f.getVolumes++
+	return f.fakeHelper.GetVolume(req, opts...)
+}
+
+func (f *countingLookupsFake) GetServer(req *instance.GetServerRequest, opts ...scw.RequestOption) (*instance.GetServerResponse, error) {
+	f.getServers++
+	return f.fakeHelper.GetServer(req, opts...)
+}
+
+func Test_ControllerPublishVolumeCache(t *testing.T) {
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1, VolumeType: instance.VolumeVolumeTypeBSSD}
+	server := &instance.Server{ID: "server-id", Zone: scw.ZoneFrPar1, CommercialType: "DEV1-S", Volumes: map[string]*instance.VolumeServer{}}
+	fake := &countingLookupsFake{
+		fakeHelper: newFakeInstanceAPI([]*instance.Volume{volume}, []*instance.Server{server}, nil),
+	}
+	s := &scaleway.Scaleway{InstanceAPI: fake}
+	s.SetCacheTTL(time.Minute)
+	d := &controllerService{scaleway: s, config: &DriverConfig{}}
+
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "fr-par-1/volume-id",
+		NodeId:           "fr-par-1/server-id",
+		VolumeCapability: mountCapability(),
+	}
+	publish := func() {
+		_, err := d.ControllerPublishVolume(context.Background(), req)
+		AssertNoError(t, err)
+	}
+
+	// the attachment invalidates the lookups, and an attached volume is read again before skipping the attachment
+	publish()
+	publish()
+	Equals(t, 3, fake.getVolumes)
+	Equals(t, 2, fake.getServers)
+
+	// the next lookups of the server are served from the cache
+	publish()
+	Equals(t, 4, fake.getVolumes)
+	Equals(t, 2, fake.getServers)
+
+	_, err := s.DetachVolume(&instance.DetachVolumeRequest{VolumeID: volume.ID, Zone: scw.ZoneFrPar1})
+	AssertNoError(t, err)
+	publish()
+	Equals(t, 5, fake.getVolumes)
+	Equals(t, 3, fake.getServers)
+
+	// the volume detached outside of the driver is attached again, even if the cache says it is attached
+	publish()
+	_, err = fake.DetachVolume(&instance.DetachVolumeRequest{VolumeID: volume.ID, Zone: scw.ZoneFrPar1})
+	AssertNoError(t, err)
+	AssertTrue(t, volume.Server == nil)
+	publish()
+	AssertTrue(t, volume.Server != nil && volume.Server.ID == server.ID)
+}
+
+func Test_CreateVolumeExistingInOtherZone(t *testing.T) {
+	size := scw.Size(10 * 1000 * 1000 * 1000)
+	volume := &instance.Volume{ID: "volume-id", Name: "pvc-1234", Zone: scw.ZoneFrPar2, Size: size, VolumeType: scaleway.DefaultVolumeType}
+	fake := newFakeInstanceAPI([]*instance.Volume{volume}, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+	topology := func(zone scw.Zone) *csi.TopologyRequirement {
+		return &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{{Segments: map[string]string{ZoneTopologyKey: zone.String()}}},
+		}
+	}
+	req := &csi.CreateVolumeRequest{
+		Name:                      "pvc-1234",
+		CapacityRange:             &csi.CapacityRange{RequiredBytes: int64(size)},
+		VolumeCapabilities:        []*csi.VolumeCapability{mountCapability()},
+		AccessibilityRequirements: topology(scw.ZoneFrPar1),
+	}
+
+	_, err := d.CreateVolume(context.Background(), req)
+	Equals(t, codes.AlreadyExists, status.Code(err))
+	Equals(t, 1, len(fake.volumesMap))
+
+	req.AccessibilityRequirements = topology(scw.ZoneFrPar2)
+	resp, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, "fr-par-2/volume-id", resp.GetVolume().GetVolumeId())
+}
+
+// onlineResizeRefusedFake refuses to resize the attached volumes, like the API for some volume types
+type onlineResizeRefusedFake struct {
+	*fakeHelper
+}
+
+func (f *onlineResizeRefusedFake) UpdateVolume(req *instance.UpdateVolumeRequest, opts ...scw.RequestOption) (*instance.UpdateVolumeResponse, error) {
+	if vol, ok := f.volumesMap[req.VolumeID]; ok && vol.Server != nil && req.Size != nil {
+		return nil, &scw.PreconditionFailedError{Precondition: "resource_not_usable"}
+	}
+	return f.fakeHelper.UpdateVolume(req, opts...)
+}
+
+func Test_ControllerExpandVolumeInUse(t *testing.T) {
+	size := scw.Size(10 * 1000 * 1000 * 1000)
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1, Size: size, VolumeType: scaleway.DefaultVolumeType, State: instance.VolumeStateAvailable}
+	server := &instance.Server{ID: "server-id", Zone: scw.ZoneFrPar1, State: instance.ServerStateRunning, Volumes: map[string]*instance.VolumeServer{}}
+	fake := &onlineResizeRefusedFake{newFakeInstanceAPI([]*instance.Volume{volume}, []*instance.Server{server}, nil)}
+	_, err := fake.AttachVolume(&instance.AttachVolumeRequest{VolumeID: volume.ID, ServerID: server.ID})
+	AssertNoError(t, err)
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "fr-par-1/volume-id",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: int64(2 * size)},
+	}
+
+	_, err = d.ControllerExpandVolume(context.Background(), req)
+	Equals(t, codes.FailedPrecondition, status.Code(err))
+
+	volume.Tags = []string{offlineExpandTag}
+	_, err = d.ControllerExpandVolume(context.Background(), req)
+	Equals(t, codes.FailedPrecondition, status.Code(err))
+	Equals(t, size, volume.Size)
+
+	server.State = instance.ServerStateStopped
+	// the volume is detached under the attach/detach lock, like in ControllerUnpublishVolume
+	unlockAttach := d.lockAttach("other-volume-id")
+	done := make(chan struct{})
+	var resp *csi.ControllerExpandVolumeResponse
+	go func() {
+		resp, err = d.ControllerExpandVolume(context.Background(), req)
+		close(done)
+	}()
+	for waiting := 0; waiting == 0; {
+		time.Sleep(time.Millisecond)
+		d.attachLockStateMux.Lock()
+		waiting = d.attachLockWaiters
+		d.attachLockStateMux.Unlock()
+	}
+	Equals(t, "server-id", volume.Server.ID)
+	unlockAttach()
+	<-done
+	AssertNoError(t, err)
+	Equals(t, int64(2*size), resp.GetCapacityBytes())
+	Equals(t, 2*size, volume.Size)
+	AssertTrue(t, volume.Server != nil && volume.Server.ID == server.ID)
+}
+
+func Test_CreateVolumeEncryptionDisabled(t *testing.T) {
+	d := newTestController(&fakeHelper{}, &DriverConfig{DisableEncryption: true})
+	_, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1234",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{encryptedKey: "true"},
+	})
+	Equals(t, codes.InvalidArgument, status.Code(err))
+}
+
+func Test_CreateVolumeMkfsOptions(t *testing.T) {
+	fake := newFakeInstanceAPI(nil, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1234",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{mkfsOptionsKey: " "},
+	}
+
+	_, err := d.CreateVolume(context.Background(), req)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+
+	// the options are passed to the node in the volume context
+	req.Parameters[mkfsOptionsKey] = "-E lazy_itable_init=1"
+	resp, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, "-E lazy_itable_init=1", resp.GetVolume().GetVolumeContext()[mkfsOptionsKey])
+}
+
+func Test_CreateVolumeRequireEncryption(t *testing.T) {
+	fake := newFakeInstanceAPI(nil, nil, nil)
+	d := newTestController(fake, &DriverConfig{RequireEncryption: true})
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1234",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{},
+	}
+
+	_, err := d.CreateVolume(context.Background(), req)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+	AssertTrue(t, strings.Contains(status.Convert(err).Message(), encryptedKey))
+	Equals(t, 0, len(fake.volumesMap))
+
+	req.Parameters[encryptedKey] = "true"
+	_, err = d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, 1, len(fake.volumesMap))
+
+	// the encryption cannot be required by a driver which disables it
+	_, err = NewDriver(&DriverConfig{Mode: AllMode, Backend: FakeBackend, RequireEncryption: true, DisableEncryption: true})
+	AssertTrue(t, err != nil)
+}
+
+func Test_CreateVolumeProjectID(t *testing.T) {
+	fake := newFakeInstanceAPI(nil, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1234",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{projectIDKey: "param-project"},
+	}
+
+	resp, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	volumeID, _, err := GetVolumeIDAndZone(resp.GetVolume().GetVolumeId())
+	AssertNoError(t, err)
+	Equals(t, "param-project", fake.volumesMap[volumeID].Project)
+
+	// the project of the secrets wins over the one of the StorageClass
+	req.Name = "pvc-5678"
+	req.Secrets = map[string]string{projectIDKey: "secret-project"}
+	resp, err = d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	volumeID, _, err = GetVolumeIDAndZone(resp.GetVolume().GetVolumeId())
+	AssertNoError(t, err)
+	Equals(t, "secret-project", fake.volumesMap[volumeID].Project)
+}
+
+func Test_DeleteVolumeProtected(t *testing.T) {
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1, Tags: []string{managedByTag, deletionProtectionTag}}
+	fake := newFakeInstanceAPI([]*instance.Volume{volume}, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.DeleteVolumeRequest{VolumeId: "fr-par-1/volume-id"}
+
+	_, err := d.DeleteVolume(context.Background(), req)
+	Equals(t, codes.FailedPrecondition, status.Code(err))
+	Equals(t, 1, len(fake.volumesMap))
+
+	req.Secrets = map[string]string{forceDeleteSecretKey: "true"}
+	_, err = d.DeleteVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, 0, len(fake.volumesMap))
+}
+
+func Test_ControllerPublishVolumeReservedSlots(t *testing.T) {
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1, VolumeType: instance.VolumeVolumeTypeBSSD}
+	server := &instance.Server{ID: "server-id", Zone: scw.ZoneFrPar1, CommercialType: "DEV1-S", Volumes: map[string]*instance.VolumeServer{}}
+	volumes := []*instance.Volume{volume}
+	// the root volume, 12 volumes of the driver and one attached outside of it
+	server.Volumes["0"] = &instance.VolumeServer{ID: "root", VolumeType: instance.VolumeServerVolumeTypeLSSD}
+	for i := 1; i <= 12; i++ {
+		id := "managed-" + strconv.Itoa(i)
+		server.Volumes[strconv.Itoa(i)] = &instance.VolumeServer{ID: id, VolumeType: instance.VolumeServerVolumeTypeBSSD}
+		volumes = append(volumes, &instance.Volume{ID: id, Zone: scw.ZoneFrPar1, Tags: []string{managedByTag}, Server: &instance.ServerSummary{ID: server.ID}})
+	}
+	server.Volumes["13"] = &instance.VolumeServer{ID: "outside", VolumeType: instance.VolumeServerVolumeTypeBSSD}
+	fake := newFakeInstanceAPI(volumes, []*instance.Server{server}, nil)
+	d := newTestController(fake, &DriverConfig{ReservedVolumeSlots: 3})
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "fr-par-1/volume-id",
+		NodeId:           "fr-par-1/server-id",
+		VolumeCapability: mountCapability(),
+	}
+
+	// 16 slots - 1 local - 3 reserved leave 12 volumes to the driver, like reported by the node
+	_, err := d.ControllerPublishVolume(context.Background(), req)
+	Equals(t, codes.ResourceExhausted, status.Code(err))
+
+	// the volume attached outside of the driver is in the reserved slots and not counted twice
+	d.config.ReservedVolumeSlots = 2
+	_, err = d.ControllerPublishVolume(context.Background(), req)
+	AssertNoError(t, err)
+}
+
+func Test_ControllerPublishVolumeWithoutBlockStorage(t *testing.T) {
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1, VolumeType: instance.VolumeVolumeTypeBSSD}
+	server := &instance.Server{ID: "server-id", Zone: scw.ZoneFrPar1, CommercialType: "C2S", Volumes: map[string]*instance.VolumeServer{}}
+	blockStorage := false
+	fake := newFakeInstanceAPI([]*instance.Volume{volume}, []*instance.Server{server}, nil)
+	fake.serverTypes = map[string]*instance.ServerType{
+		"C2S": {Capabilities: &instance.ServerTypeCapabilities{BlockStorage: &blockStorage}},
+	}
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "fr-par-1/volume-id",
+		NodeId:           "fr-par-1/server-id",
+		VolumeCapability: mountCapability(),
+	}
+
+	_, err := d.ControllerPublishVolume(context.Background(), req)
+	Equals(t, codes.FailedPrecondition, status.Code(err))
+	AssertTrue(t, strings.Contains(err.Error(), "instance type C2S"))
+	AssertTrue(t, volume.Server == nil)
+}
+
+func Test_ControllerPublishVolumeCrossZone(t *testing.T) {
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1, VolumeType: instance.VolumeVolumeTypeBSSD}
+	server := &instance.Server{ID: "server-id", Zone: scw.ZoneFrPar2, CommercialType: "DEV1-S", Volumes: map[string]*instance.VolumeServer{}}
+	fake := newFakeInstanceAPI([]*instance.Volume{volume}, []*instance.Server{server}, nil)
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "fr-par-1/volume-id",
+		NodeId:           "fr-par-2/server-id",
+		VolumeCapability: mountCapability(),
+	}
+
+	_, err := d.ControllerPublishVolume(context.Background(), req)
+	Equals(t, codes.FailedPrecondition, status.Code(err))
+	AssertTrue(t, strings.Contains(err.Error(), "zone fr-par-1"))
+	AssertTrue(t, strings.Contains(err.Error(), "zone fr-par-2"))
+	AssertTrue(t, volume.Server == nil)
+}
+
+func Test_CreateVolumeClassSizes(t *testing.T) {
+	fake := newFakeInstanceAPI(nil, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1234",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{maxSizeKey: "10Gi", defaultSizeKey: "5Gi"},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 20 << 30},
+	}
+
+	_, err := d.CreateVolume(context.Background(), req)
+	Equals(t, codes.OutOfRange, status.Code(err))
+
+	req.CapacityRange = nil
+	resp, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, int64(5<<30), resp.GetVolume().GetCapacityBytes())
+
+	_, err = d.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      resp.GetVolume().GetVolumeId(),
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 20 << 30},
+	})
+	Equals(t, codes.OutOfRange, status.Code(err))
+
+	// a default size outside of the allowed sizes is an error of the StorageClass
+	req.Parameters = map[string]string{minSizeKey: "10Gi", defaultSizeKey: "5Gi"}
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+
+	req.Parameters = map[string]string{maxSizeKey: "-1"}
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+}
+
+func Test_CreateVolumeAlreadyExists(t *testing.T) {
+	snapshot := &instance.Snapshot{
+		ID:         "snapshot-id",
+		Zone:       scw.ZoneFrPar1,
+		Size:       10 * scw.GB,
+		VolumeType: instance.VolumeVolumeTypeBSSD,
+		State:      instance.SnapshotStateAvailable,
+	}
+	fake := newFakeInstanceAPI(nil, nil, []*instance.Snapshot{snapshot})
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1234",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 10 * 1000 * 1000 * 1000},
+	}
+
+	resp, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	retry, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, resp.GetVolume().GetVolumeId(), retry.GetVolume().GetVolumeId())
+
+	req.CapacityRange = &csi.CapacityRange{RequiredBytes: 20 * 1000 * 1000 * 1000}
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.AlreadyExists, status.Code(err))
+	req.CapacityRange = &csi.CapacityRange{RequiredBytes: 10 * 1000 * 1000 * 1000}
+
+	req.Parameters = map[string]string{encryptedKey: "true"}
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.AlreadyExists, status.Code(err))
+	req.Parameters = nil
+
+	req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "fr-par-1/snapshot-id"},
+	}}
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.AlreadyExists, status.Code(err))
+
+	req.Name = "pvc-5678"
+	_, err = d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	req.VolumeContentSource = nil
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.AlreadyExists, status.Code(err))
+
+	// the volumes created by older versions of the driver are only compared on their size and type
+	fake.volumesMap["legacy-id"] = &instance.Volume{
+		ID:         "legacy-id",
+		Name:       "pvc-legacy",
+		Zone:       scw.ZoneFrPar1,
+		Size:       10 * scw.GB,
+		VolumeType: instance.VolumeVolumeTypeLSSD,
+		Tags:       []string{managedByTag},
+	}
+	req.Name = "pvc-legacy"
+	req.Parameters = map[string]string{encryptedKey: "true"}
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.AlreadyExists, status.Code(err))
+	fake.volumesMap["legacy-id"].VolumeType = instance.VolumeVolumeTypeBSSD
+	_, err = d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+}
+
+// resizeFailingFake fails the given number of resizes, like the API when the resize of a restored volume times out
+type resizeFailingFake struct {
+	*fakeHelper
+	failures int
+}
+
+func (f *resizeFailingFake) UpdateVolume(req *instance.UpdateVolumeRequest, opts ...scw.RequestOption) (*instance.UpdateVolumeResponse, error) {
+	if req.Size != nil && f.failures > 0 {
+		f.failures--
+		return nil, &scw.ResponseError{StatusCode: 500}
+	}
+	return f.fakeHelper.UpdateVolume(req, opts...)
+}
+
+// forbiddenSnapshotFake refuses the access to the snapshots of another project
+type forbiddenSnapshotFake struct {
+	*fakeHelper
+	forbidden string
+}
+
+func (f *forbiddenSnapshotFake) GetSnapshot(req *instance.GetSnapshotRequest, opts ...scw.RequestOption) (*instance.GetSnapshotResponse, error) {
+	if req.SnapshotID == f.forbidden {
+		return nil, &scw.PermissionsDeniedError{}
+	}
+	return f.fakeHelper.GetSnapshot(req, opts...)
+}
+
+func Test_CreateVolumeFromSnapshotOfAnotherProject(t *testing.T) {
+	snapshot := &instance.Snapshot{
+		ID:         "snapshot-id",
+		Zone:       scw.ZoneFrPar1,
+		Project:    "golden-project-id",
+		Size:       10 * scw.GB,
+		VolumeType: instance.VolumeVolumeTypeBSSD,
+		State:      instance.SnapshotStateAvailable,
+	}
+	fake := &forbiddenSnapshotFake{fakeHelper: newFakeInstanceAPI(nil, nil, []*instance.Snapshot{snapshot}), forbidden: "forbidden-snapshot-id"}
+	d := newTestController(fake, &DriverConfig{})
+	newRequest := func(snapshotID string, sourceProjectID string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name: "pvc-1234",
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: map[string]string{sourceProjectIDKey: sourceProjectID},
+			VolumeContentSource: &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "fr-par-1/" + snapshotID},
+			}},
+		}
+	}
+
+	// the snapshot must belong to the given project
+	_, err := d.CreateVolume(context.Background(), newRequest(snapshot.ID, "other-project-id"))
+	Equals(t, codes.NotFound, status.Code(err))
+	Equals(t, 0, len(fake.volumesMap))
+
+	// the snapshots the credentials cannot access are reported as such
+	_, err = d.CreateVolume(context.Background(), newRequest("forbidden-snapshot-id", snapshot.Project))
+	Equals(t, codes.PermissionDenied, status.Code(err))
+
+	resp, err := d.CreateVolume(context.Background(), newRequest(snapshot.ID, snapshot.Project))
+	AssertNoError(t, err)
+	Equals(t, "fr-par-1/snapshot-id", resp.GetVolume().GetContentSource().GetSnapshot().GetSnapshotId())
+	Equals(t, 1, len(fake.volumesMap))
+}
+
+func Test_CreateVolumeFromSnapshotResizeFailed(t *testing.T) {
+	snapshot := &instance.Snapshot{
+		ID:         "snapshot-id",
+		Zone:       scw.ZoneFrPar1,
+		Size:       10 * scw.GB,
+		VolumeType: instance.VolumeVolumeTypeBSSD,
+		State:      instance.SnapshotStateAvailable,
+	}
+	fake := &resizeFailingFake{fakeHelper: newFakeInstanceAPI(nil, nil, []*instance.Snapshot{snapshot}), failures: 1}
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1234",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 20 * 1000 * 1000 * 1000},
+		VolumeContentSource: &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "fr-par-1/snapshot-id"},
+		}},
+	}
+
+	// the volume is created at the size of the snapshot but its resize fails
+	_, err := d.CreateVolume(context.Background(), req)
+	AssertTrue(t, err != nil)
+	Equals(t, 1, len(fake.volumesMap))
+
+	// the retry finds the undersized volume and resizes it
+	resp, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, int64(20*scw.GB), resp.GetVolume().GetCapacityBytes())
+	Equals(t, 1, len(fake.volumesMap))
+	for _, volume := range fake.volumesMap {
+		Equals(t, 20*scw.GB, volume.Size)
+	}
+}
+
+func Test_createVolumeInZones(t *testing.T) {
+	volumes := map[scw.Zone]*instance.Volume{
+		scw.ZoneFrPar2: {ID: "volume-2", Zone: scw.ZoneFrPar2, State: instance.VolumeStateAvailable},
+		scw.ZoneFrPar3: {ID: "volume-3", Zone: scw.ZoneFrPar3, State: instance.VolumeStateAvailable},
+	}
+	fake := newFakeInstanceAPI([]*instance.Volume{volumes[scw.ZoneFrPar2], volumes[scw.ZoneFrPar3]}, nil, nil)
+	d := newTestController(fake, &DriverConfig{ParallelZoneCreate: true})
+
+	failed := make(chan struct{})
+	createVolume := func(ctx context.Context, req *instance.CreateVolumeRequest) (*instance.Volume, error) {
+		switch req.Zone {
+		case scw.ZoneFrPar1:
+			defer close(failed)
+			return nil, errors.New("out of stock")
+		case scw.ZoneFrPar2:
+			<-failed
+			return volumes[req.Zone], nil
+		}
+		// created before noticing the cancellation
+		<-ctx.Done()
+		return volumes[req.Zone], nil
+	}
+
+	volume, errs := d.createVolumeInZones(context.Background(), []scw.Zone{scw.ZoneFrPar1, scw.ZoneFrPar2, scw.ZoneFrPar3}, &instance.CreateVolumeRequest{}, createVolume)
+	Equals(t, "volume-2", volume.ID)
+	Equals(t, 1, len(errs))
+	_, ok := fake.volumesMap["volume-3"]
+	AssertFalse(t, ok)
+	_, ok = fake.volumesMap["volume-2"]
+	AssertTrue(t, ok)
+}
+
+func Test_createVolumeInZonesStraggler(t *testing.T) {
+	fake := newFakeInstanceAPI(nil, nil, nil)
+	d := newTestController(fake, &DriverConfig{ParallelZoneCreate: true})
+
+	created := make(chan struct{})
+	createVolume := func(ctx context.Context, req *instance.CreateVolumeRequest) (*instance.Volume, error) {
+		volume := &instance.Volume{ID: "volume-" + req.Zone.String(), Name: req.Name, Zone: req.Zone, Tags: req.Tags, State: instance.VolumeStateAvailable}
+		if req.Zone == scw.ZoneFrPar1 {
+			defer close(created)
+			fake.volumesMap[volume.ID] = volume
+			return volume, nil
+		}
+		// the creation reached the API, but its response is lost with the cancellation
+		<-created
+		<-ctx.Done()
+		fake.volumesMap[volume.ID] = volume
+		return nil, ctx.Err()
+	}
+
+	volume, errs := d.createVolumeInZones(context.Background(), []scw.Zone{scw.ZoneFrPar1, scw.ZoneFrPar2}, &instance.CreateVolumeRequest{Name: "pvc-1234", Tags: []string{managedByTag}}, createVolume)
+	Equals(t, "volume-fr-par-1", volume.ID)
+	Equals(t, 0, len(errs))
+	Equals(t, 1, len(fake.volumesMap))
+
+	// the volume is found again by name
+	found, err := d.scaleway.GetVolumeByName("pvc-1234", "")
+	AssertNoError(t, err)
+	Equals(t, volume.ID, found.ID)
+}
+
+func Test_CreateVolumeXFSQuota(t *testing.T) {
+	fake := newFakeInstanceAPI(nil, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.CreateVolumeRequest{
+		Name: "pvc-1234",
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER},
+		}},
+		Parameters: map[string]string{xfsQuotaKey: "1Gi"},
+	}
+
+	_, err := d.CreateVolume(context.Background(), req)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+
+	req.VolumeCapabilities[0].GetMount().FsType = "xfs"
+	resp, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, strconv.Itoa(1<<30), resp.GetVolume().GetVolumeContext()[xfsQuotaKey])
+}
+
+func Test_CreateVolumeFromEncryptedSnapshot(t *testing.T) {
+	fake := newFakeInstanceAPI(nil, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1234",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{encryptedKey: "true"},
+	}
+	volume, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+
+	snapshot, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name:           "snapshot-1234",
+		SourceVolumeId: volume.GetVolume().GetVolumeId(),
+	})
+	AssertNoError(t, err)
+
+	req.Name = "pvc-5678"
+	req.Parameters = map[string]string{encryptedKey: "false"}
+	req.VolumeContentSource = &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshot.GetSnapshot().GetSnapshotId()},
+	}}
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+
+	req.Parameters = nil
+	restored, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, "true", restored.GetVolume().GetVolumeContext()[encryptedKey])
+}
+
+func Test_ControllerPublishVolumeBusy(t *testing.T) {
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1, VolumeType: instance.VolumeVolumeTypeBSSD, State: instance.VolumeStateSnapshotting}
+	server := &instance.Server{ID: "server-id", Zone: scw.ZoneFrPar1, CommercialType: "DEV1-S", Volumes: map[string]*instance.VolumeServer{}}
+	fake := newFakeInstanceAPI([]*instance.Volume{volume}, []*instance.Server{server}, nil)
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "fr-par-1/volume-id",
+		NodeId:           "fr-par-1/server-id",
+		VolumeCapability: mountCapability(),
+	}
+
+	// the fake returns the volume still snapshotting at the end of the wait
+	_, err := d.ControllerPublishVolume(context.Background(), req)
+	Equals(t, codes.Aborted, status.Code(err))
+	AssertTrue(t, volume.Server == nil)
+
+	volume.State = instance.VolumeStateAvailable
+	_, err = d.ControllerPublishVolume(context.Background(), req)
+	AssertNoError(t, err)
+}
+
+func Test_CreateVolumeCrossZoneRestore(t *testing.T) {
+	snapshot := &instance.Snapshot{
+		ID:         "snapshot-id",
+		Name:       "snapshot-name",
+		Zone:       scw.ZoneFrPar1,
+		Size:       10 * scw.GB,
+		VolumeType: instance.VolumeVolumeTypeBSSD,
+		State:      instance.SnapshotStateAvailable,
+	}
+	fake := newFakeInstanceAPI(nil, nil, []*instance.Snapshot{snapshot})
+	d := newTestController(fake, &DriverConfig{})
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1234",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		VolumeContentSource: &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "fr-par-1/snapshot-id"},
+		}},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{{Segments: map[string]string{ZoneTopologyKey: "fr-par-2"}}},
+		},
+	}
+
+	_, err := d.CreateVolume(context.Background(), req)
+	Equals(t, codes.ResourceExhausted, status.Code(err))
+
+	req.Parameters = map[string]string{allowCrossZoneRestoreKey: "true"}
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+
+	// the snapshot is exported, then imported in the zone of the topology
+	req.Parameters[exportBucketKey] = "bucket"
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.Aborted, status.Code(err))
+	Equals(t, []string{exportedToTagPrefix + "bucket/snapshot-name.qcow2"}, snapshot.Tags)
+
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.Aborted, status.Code(err))
+	Equals(t, 2, len(fake.snapshotsMap))
+	var snapshotCopy *instance.Snapshot
+	for _, s := range fake.snapshotsMap {
+		if s.ID != snapshot.ID {
+			snapshotCopy = s
+		}
+	}
+	Equals(t, scw.ZoneFrPar2, snapshotCopy.Zone)
+	// each restored volume gets its own copy
+	Equals(t, restoreCopyNamePrefix+req.GetName(), snapshotCopy.Name)
+
+	_, err = d.CreateVolume(context.Background(), req)
+	Equals(t, codes.Aborted, status.Code(err))
+
+	snapshotCopy.State = instance.SnapshotStateAvailable
+	snapshotCopy.Size = snapshot.Size
+	resp, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, "fr-par-2", resp.GetVolume().GetAccessibleTopology()[0].GetSegments()[ZoneTopologyKey])
+	Equals(t, 1, len(fake.snapshotsMap))
+
+	// the copy left by a restore whose wait failed is deleted by the retry finding the volume
+	fake.snapshotsMap[snapshotCopy.ID] = snapshotCopy
+	retry, err := d.CreateVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, resp.GetVolume().GetVolumeId(), retry.GetVolume().GetVolumeId())
+	Equals(t, 1, len(fake.snapshotsMap))
+}
+
+func Test_DeleteVolumeWithSnapshots(t *testing.T) {
+	fake := newFakeInstanceAPI(nil, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+	createVolumeWithSnapshots := func(name string) (string, string) {
+		volume, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name: name,
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+		})
+		AssertNoError(t, err)
+		snapshot, err := d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+			Name:           name + "-snapshot",
+			SourceVolumeId: volume.GetVolume().GetVolumeId(),
+		})
+		AssertNoError(t, err)
+		_, err = d.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+			Name:           name + "-retained",
+			SourceVolumeId: volume.GetVolume().GetVolumeId(),
+			Parameters:     map[string]string{retainSnapshotsOnVolumeDeleteKey: "true"},
+		})
+		AssertNoError(t, err)
+		return volume.GetVolume().GetVolumeId(), snapshot.GetSnapshot().GetSnapshotId()
+	}
+
+	// the Instance API deletes the volumes with snapshots, so does the driver by default
+	volumeID, _ := createVolumeWithSnapshots("pvc-1234")
+	_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	AssertNoError(t, err)
+	Equals(t, 2, len(fake.snapshotsMap))
+
+	// the snapshot taken without retainSnapshotsOnVolumeDeleteKey prevents the deletion when refused
+	d.config.RefuseDeleteWithSnapshots = true
+	volumeID, snapshotID := createVolumeWithSnapshots("pvc-5678")
+	deleteReq := &csi.DeleteVolumeRequest{VolumeId: volumeID}
+	_, err = d.DeleteVolume(context.Background(), deleteReq)
+	Equals(t, codes.FailedPrecondition, status.Code(err))
+	scwSnapshotID, _, _ := getSnapshotIDAndZone(snapshotID)
+	AssertTrue(t, strings.Contains(status.Convert(err).Message(), scwSnapshotID))
+
+	_, err = d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: snapshotID})
+	AssertNoError(t, err)
+	_, err = d.DeleteVolume(context.Background(), deleteReq)
+	AssertNoError(t, err)
+	Equals(t, 3, len(fake.snapshotsMap))
+}
+
+// inUseSnapshotFake fails the deletion of the snapshots as if they were used by a volume being created
+type inUseSnapshotFake struct {
+	*fakeHelper
+}
+
+func (f *inUseSnapshotFake) DeleteSnapshot(req *instance.DeleteSnapshotRequest, opts ...scw.RequestOption) error {
+	return &scw.PreconditionFailedError{Precondition: "resource_still_in_use", HelpMessage: "snapshot is used by a volume"}
+}
+
+func Test_DeleteSnapshotInUse(t *testing.T) {
+	snapshot := &instance.Snapshot{ID: "snapshot-id", Zone: scw.ZoneFrPar1, State: instance.SnapshotStateAvailable}
+	fake := &inUseSnapshotFake{
+		fakeHelper: newFakeInstanceAPI(nil, nil, []*instance.Snapshot{snapshot}),
+	}
+	d := newTestController(fake, &DriverConfig{})
+
+	_, err := d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: "fr-par-1/snapshot-id"})
+	Equals(t, codes.FailedPrecondition, status.Code(err))
+	AssertTrue(t, strings.Contains(err.Error(), "snapshot snapshot-id is in use"))
+}
+
+// failingListVolumesFake fails the listings of the volumes, as during an outage of a zone
+type failingListVolumesFake struct {
+	*fakeHelper
+}
+
+func (f *failingListVolumesFake) ListVolumes(req *instance.ListVolumesRequest, opts ...scw.RequestOption) (*instance.ListVolumesResponse, error) {
+	return nil, &scw.ResponseError{StatusCode: http.StatusServiceUnavailable}
+}
+
+func Test_ListVolumesUnavailableZone(t *testing.T) {
+	fake := newFakeInstanceAPI([]*instance.Volume{{ID: "volume-id", Zone: scw.ZoneFrPar1}}, nil, nil)
+	d := newTestController(&failingListVolumesFake{fakeHelper: fake}, &DriverConfig{})
+
+	// no partial results, the volumes of the unavailable zone would be reported as detached
+	_, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	Equals(t, codes.Unavailable, status.Code(err))
+	Equals(t, "1", unavailableZones.Get("default").String())
+
+	d = newTestController(fake, &DriverConfig{})
+	_, err = d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	AssertNoError(t, err)
+	Equals(t, "0", unavailableZones.Get("default").String())
+}
+
+func Test_ListVolumesManaged(t *testing.T) {
+	fake := newFakeInstanceAPI([]*instance.Volume{
+		{ID: "managed", Zone: scw.ZoneFrPar1, Tags: []string{managedByTag}},
+		{ID: "imported", Zone: scw.ZoneFrPar1},
+	}, nil, nil)
+	d := newTestController(fake, &DriverConfig{})
+
+	// the volumes created before the tag or imported are still listed by default
+	resp, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	AssertNoError(t, err)
+	Equals(t, 2, len(resp.GetEntries()))
+
+	d.config.ListManagedVolumesOnly = true
+	resp, err = d.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	AssertNoError(t, err)
+	Equals(t, 1, len(resp.GetEntries()))
+	Equals(t, "fr-par-1/managed", resp.GetEntries()[0].GetVolume().GetVolumeId())
+
+	Equals(t, "true", publishContext(fake.volumesMap["managed"], false)[scwVolumeManaged])
+	Equals(t, "", publishContext(fake.volumesMap["imported"], false)[scwVolumeManaged])
+}
@@ -0,0 +1,114 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	protov1 "github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protov2 "google.golang.org/protobuf/proto"
+)
+
+// maxCreateFailureBackoff is the maximum duration during which a failed CreateVolume is not retried
+const maxCreateFailureBackoff = 5 * time.Minute
+
+// createFailure is the last error of a CreateVolume request failing repeatedly
+type createFailure struct {
+	err      error
+	failures int
+	until    time.Time
+}
+
+// createFailureBackoff remembers the CreateVolume requests which failed, and returns their error again
+// without calling the API when the same request is retried before the end of its backoff, which doubles
+// at each failure up to maxCreateFailureBackoff. A nil *createFailureBackoff remembers nothing.
+type createFailureBackoff struct {
+	initial time.Duration
+
+	// failures are the failed requests by key
+	failures map[string]*createFailure
+	mux      sync.Mutex
+}
+
+func newCreateFailureBackoff(initial time.Duration) *createFailureBackoff {
+	if initial <= 0 {
+		return nil
+	}
+	return &createFailureBackoff{
+		initial:  initial,
+		failures: make(map[string]*createFailure),
+	}
+}
+
+// createVolumeKey returns the key of a CreateVolume request, identical for the identical requests,
+// the secrets included as they may change the outcome
+func createVolumeKey(req *csi.CreateVolumeRequest) string {
+	content, err := protov2.MarshalOptions{Deterministic: true}.Marshal(protov1.MessageV2(req))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the error of the request with the given key if it is still in its backoff at now
+func (b *createFailureBackoff) get(key string, now time.Time) error {
+	if b == nil || key == "" {
+		return nil
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	failure, ok := b.failures[key]
+	if !ok || !now.Before(failure.until) {
+		return nil
+	}
+	st := status.Convert(failure.err)
+	return status.Errorf(st.Code(), "%s (the same request failed %d time(s), it is not retried before %s)",
+		st.Message(), failure.failures, failure.until.Format(time.RFC3339))
+}
+
+// record remembers the error of the request with the given key at now, or forgets it if the request succeeded.
+// Only the errors of the invalid requests are remembered, the other ones (e.g. Internal or ResourceExhausted,
+// returned when the API fails or a zone is out of stock) may not happen again on the next call.
+func (b *createFailureBackoff) record(key string, err error, now time.Time) {
+	if b == nil || key == "" {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for k, failure := range b.failures {
+		if now.Sub(failure.until) > maxCreateFailureBackoff {
+			delete(b.failures, k)
+		}
+	}
+
+	switch status.Code(err) {
+	case codes.OK:
+		delete(b.failures, key)
+		return
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+	default:
+		return
+	}
+
+	failure, ok := b.failures[key]
+	if !ok {
+		failure = &createFailure{}
+		b.failures[key] = failure
+	}
+	failure.failures++
+	backoff := b.initial
+	for i := 1; i < failure.failures && backoff < maxCreateFailureBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxCreateFailureBackoff {
+		backoff = maxCreateFailureBackoff
+	}
+	failure.err = err
+	failure.until = now.Add(backoff)
+}
@@ -0,0 +1,56 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_createFailureBackoff(t *testing.T) {
+	backoff := newCreateFailureBackoff(10 * time.Second)
+	req := &csi.CreateVolumeRequest{Name: "pvc-1234", Parameters: map[string]string{"type": "b_ssd", "iops": "3"}}
+	key := createVolumeKey(req)
+	Equals(t, key, createVolumeKey(&csi.CreateVolumeRequest{Name: "pvc-1234", Parameters: map[string]string{"iops": "3", "type": "b_ssd"}}))
+	AssertTrue(t, key != createVolumeKey(&csi.CreateVolumeRequest{Name: "pvc-1234", Parameters: map[string]string{"type": "b_ssd", "iops": "5"}}))
+
+	now := time.Now()
+	AssertNoError(t, backoff.get(key, now))
+
+	backoff.record(key, status.Error(codes.InvalidArgument, "invalid iops"), now)
+	err := backoff.get(key, now.Add(5*time.Second))
+	Equals(t, codes.InvalidArgument, status.Code(err))
+	AssertNoError(t, backoff.get(key, now.Add(10*time.Second)))
+
+	// the backoff doubles at each failure
+	backoff.record(key, status.Error(codes.InvalidArgument, "invalid iops"), now)
+	AssertTrue(t, backoff.get(key, now.Add(15*time.Second)) != nil)
+	AssertNoError(t, backoff.get(key, now.Add(20*time.Second)))
+	for i := 0; i < 10; i++ {
+		backoff.record(key, status.Error(codes.InvalidArgument, "invalid iops"), now)
+	}
+	AssertTrue(t, backoff.get(key, now.Add(maxCreateFailureBackoff-time.Second)) != nil)
+	AssertNoError(t, backoff.get(key, now.Add(maxCreateFailureBackoff)))
+
+	// a success forgets the failures
+	backoff.record(key, nil, now)
+	AssertNoError(t, backoff.get(key, now))
+
+	// only the errors of the invalid requests are remembered
+	for _, code := range []codes.Code{codes.Aborted, codes.Internal, codes.ResourceExhausted, codes.Unavailable} {
+		backoff.record(key, status.Error(code, "may not happen again"), now)
+		AssertNoError(t, backoff.get(key, now))
+	}
+	for _, code := range []codes.Code{codes.OutOfRange, codes.FailedPrecondition} {
+		backoff.record(key, status.Error(code, "invalid request"), now)
+		Equals(t, code, status.Code(backoff.get(key, now)))
+		backoff.record(key, nil, now)
+	}
+
+	// nothing is remembered when disabled
+	backoff = newCreateFailureBackoff(0)
+	backoff.record(key, status.Error(codes.InvalidArgument, "invalid iops"), now)
+	AssertNoError(t, backoff.get(key, now))
+}
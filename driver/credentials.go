@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the keys of the secrets holding the credentials of the Scaleway account in which the controller RPCs are run
+	accessKeySecretKey        = "SCW_ACCESS_KEY"
+	secretKeySecretKey        = "SCW_SECRET_KEY"
+	defaultProjectIDSecretKey = "SCW_DEFAULT_PROJECT_ID"
+
+	// maxScopedClients is the number of clients built from secrets kept by the controller
+	maxScopedClients = 32
+)
+
+// scopedClientKey is the context key of the client built from the secrets of the RPC
+type scopedClientKey struct{}
+
+// secretsRequest is implemented by the CSI requests carrying secrets
+type secretsRequest interface {
+	GetSecrets() map[string]string
+}
+
+// scopedClients caches the clients built from the credentials of the secrets, by access key,
+// the least recently used one being dropped when the cache is full
+type scopedClients struct {
+	userAgent string
+	cacheTTL  time.Duration
+
+	clients map[string]*scopedClient
+	mux     sync.Mutex
+}
+
+type scopedClient struct {
+	client    *scaleway.Scaleway
+	secretKey string
+	projectID string
+	lastUsed  time.Time
+}
+
+func newScopedClients(userAgent string, cacheTTL time.Duration) *scopedClients {
+	return &scopedClients{
+		userAgent: userAgent,
+		cacheTTL:  cacheTTL,
+		clients:   make(map[string]*scopedClient),
+	}
+}
+
+// get returns the client using the given credentials, building it if it is not cached
+// or if the secret key or the project changed since it was built
+func (c *scopedClients) get(accessKey string, secretKey string, projectID string) (*scaleway.Scaleway, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if cached, ok := c.clients[accessKey]; ok && cached.secretKey == secretKey && cached.projectID == projectID {
+		cached.lastUsed = time.Now()
+		return cached.client, nil
+	}
+
+	client, err := scaleway.NewScalewayWithCredentials(c.userAgent, accessKey, secretKey, projectID)
+	if err != nil {
+		return nil, err
+	}
+	client.SetCacheTTL(c.cacheTTL)
+
+	if _, ok := c.clients[accessKey]; !ok && len(c.clients) >= maxScopedClients {
+		var oldest string
+		for key, cached := range c.clients {
+			if oldest == "" || cached.lastUsed.Before(c.clients[oldest].lastUsed) {
+				oldest = key
+			}
+		}
+		delete(c.clients, oldest)
+	}
+	c.clients[accessKey] = &scopedClient{
+		client:    client,
+		secretKey: secretKey,
+		projectID: projectID,
+		lastUsed:  time.Now(),
+	}
+	return client, nil
+}
+
+// interceptor returns a grpc unary interceptor running the controller RPCs whose secrets carry
+// Scaleway credentials with a client using them, instead of the credentials of the controller
+func (c *scopedClients) interceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, "/csi.v1.Controller/") {
+			return handler(ctx, req)
+		}
+		secretsReq, ok := req.(secretsRequest)
+		if !ok {
+			return handler(ctx, req)
+		}
+		secrets := secretsReq.GetSecrets()
+		accessKey, secretKey, projectID := secrets[accessKeySecretKey], secrets[secretKeySecretKey], secrets[defaultProjectIDSecretKey]
+		if accessKey == "" && secretKey == "" {
+			return handler(ctx, req)
+		}
+		if accessKey == "" || secretKey == "" || projectID == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "secrets must set %s, %s and %s together", accessKeySecretKey, secretKeySecretKey, defaultProjectIDSecretKey)
+		}
+
+		client, err := c.get(accessKey, secretKey, projectID)
+		if err != nil {
+			// the error of the SDK would leak the secret key
+			return nil, status.Errorf(codes.InvalidArgument, "invalid Scaleway credentials in secrets for access key %s", accessKey)
+		}
+		return handler(context.WithValue(ctx, scopedClientKey{}, client), req)
+	}
+}
+
+// client returns the client to use for the RPC of the given context, the one built from
+// the secrets of the RPC if any, or the one using the credentials of the controller
+func (d *controllerService) client(ctx context.Context) *scaleway.Scaleway {
+	if client, ok := ctx.Value(scopedClientKey{}).(*scaleway.Scaleway); ok {
+		return client
+	}
+	return d.scaleway
+}
@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_scopedClientsInterceptor(t *testing.T) {
+	d := &controllerService{
+		scaleway:      &scaleway.Scaleway{},
+		scopedClients: newScopedClients("test", 0),
+	}
+	interceptor := d.scopedClients.interceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+
+	var used *scaleway.Scaleway
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		used = d.client(ctx)
+		return nil, nil
+	}
+
+	credentials := map[string]string{
+		accessKeySecretKey:        "SCWXXXXXXXXXXXXXXXXX",
+		secretKeySecretKey:        "11111111-1111-1111-1111-111111111111",
+		defaultProjectIDSecretKey: "22222222-2222-2222-2222-222222222222",
+	}
+
+	_, err := interceptor(context.Background(), &csi.CreateVolumeRequest{}, info, handler)
+	AssertNoError(t, err)
+	AssertTrue(t, used == d.scaleway)
+
+	_, err = interceptor(context.Background(), &csi.CreateVolumeRequest{Secrets: credentials}, info, handler)
+	AssertNoError(t, err)
+	AssertFalse(t, used == d.scaleway)
+	scoped := used
+
+	_, err = interceptor(context.Background(), &csi.DeleteVolumeRequest{Secrets: credentials}, info, handler)
+	AssertNoError(t, err)
+	AssertTrue(t, used == scoped)
+
+	_, err = interceptor(context.Background(), &csi.CreateVolumeRequest{Secrets: map[string]string{
+		accessKeySecretKey: credentials[accessKeySecretKey],
+		secretKeySecretKey: credentials[secretKeySecretKey],
+	}}, info, handler)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = interceptor(context.Background(), &csi.CreateVolumeRequest{Secrets: map[string]string{
+		accessKeySecretKey:        credentials[accessKeySecretKey],
+		secretKeySecretKey:        "not-a-secret-key",
+		defaultProjectIDSecretKey: credentials[defaultProjectIDSecretKey],
+	}}, info, handler)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+}
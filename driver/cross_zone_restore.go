@@ -0,0 +1,123 @@
+package driver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+)
+
+// restoreCopyNamePrefix prefixes the name of the copies of the snapshots restored in another zone, followed by the name
+// of the volume restored from the copy, so that the concurrent restores of a snapshot each get their own copy
+const restoreCopyNamePrefix = "restore-"
+
+// copySnapshotToZone returns the copy of the snapshot in the given zone of its region, which is made by exporting
+// the snapshot to an Object Storage bucket and importing it back in the zone. The bucket is the one of a previous
+// export of the snapshot (see exportBucketKey), or the given one. A copy takes longer than a CreateVolume call:
+// Aborted is returned while it is in progress, each retry of the CO resuming it at the step it reached.
+// The copy is only used to restore the volume with the given name, it is deleted by deleteRestoreCopy.
+func (d *controllerService) copySnapshotToZone(ctx context.Context, snapshot *instance.Snapshot, zone scw.Zone, bucket string, volumeName string) (*instance.Snapshot, error) {
+	snapshotRegion, err := snapshot.Zone.Region()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	zoneRegion, err := zone.Region()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if snapshotRegion != zoneRegion {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshot %s of zone %s cannot be restored in zone %s, which is in another region", snapshot.ID, snapshot.Zone, zone)
+	}
+
+	logger := klog.FromContext(ctx)
+	copyName := restoreCopyNamePrefix + volumeName
+	snapshotCopy, err := d.client(ctx).GetSnapshotByName(copyName, "", zone, scw.WithContext(ctx))
+	switch err {
+	case nil:
+		switch snapshotCopy.State {
+		case instance.SnapshotStateAvailable:
+			return snapshotCopy, nil
+		case instance.SnapshotStateImporting:
+			return nil, status.Errorf(codes.Aborted, "copy %s of snapshot %s is still being imported in zone %s", snapshotCopy.ID, snapshot.ID, zone)
+		}
+		// the failed copy is deleted so that the next retry imports the snapshot again
+		if err := d.client(ctx).DeleteSnapshot(&instance.DeleteSnapshotRequest{
+			SnapshotID: snapshotCopy.ID,
+			Zone:       zone,
+		}, scw.WithContext(ctx)); err != nil {
+			logger.Error(err, "error deleting the failed copy of the snapshot", "snapshotID", snapshot.ID, "copyID", snapshotCopy.ID)
+		}
+		return nil, status.Errorf(codes.Internal, "copy %s of snapshot %s in zone %s is in state %s", snapshotCopy.ID, snapshot.ID, zone, snapshotCopy.State)
+	case scaleway.ErrSnapshotNotFound:
+	default:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	exportBucket, exportKey := snapshotExport(snapshot)
+	if exportKey == "" {
+		if bucket == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "snapshot %s must be copied to zone %s through Object Storage, set the parameter %s on the StorageClass", snapshot.ID, zone, exportBucketKey)
+		}
+		if err := d.exportSnapshot(ctx, snapshot, bucket); err != nil {
+			return nil, status.Errorf(codes.Internal, "error exporting snapshot %s to bucket %s: %s", snapshot.ID, bucket, err)
+		}
+		return nil, status.Errorf(codes.Aborted, "snapshot %s is being exported to bucket %s to be copied to zone %s", snapshot.ID, bucket, zone)
+	}
+	if snapshot.State == instance.SnapshotStateExporting {
+		return nil, status.Errorf(codes.Aborted, "snapshot %s is still being exported to bucket %s", snapshot.ID, exportBucket)
+	}
+
+	projectID := snapshot.Project
+	tags := []string{managedByTag}
+	_, err = d.client(ctx).CreateSnapshot(&instance.CreateSnapshotRequest{
+		Zone:       zone,
+		Name:       copyName,
+		VolumeType: instance.SnapshotVolumeType(snapshot.VolumeType),
+		Bucket:     &exportBucket,
+		Key:        &exportKey,
+		Project:    &projectID,
+		Tags:       &tags,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error importing snapshot %s in zone %s from bucket %s: %s", snapshot.ID, zone, exportBucket, err)
+	}
+	logger.Info("snapshot import started to restore it in another zone", "snapshotID", snapshot.ID, "zone", zone, "bucket", exportBucket, "key", exportKey)
+	return nil, status.Errorf(codes.Aborted, "snapshot %s is being imported in zone %s", snapshot.ID, zone)
+}
+
+// deleteRestoreCopy deletes the copy of a snapshot made in the given zone to restore the volume with the given name,
+// if any: once the volume is restored, or when its creation failed, the next retry copying the snapshot again
+func (d *controllerService) deleteRestoreCopy(ctx context.Context, volumeName string, zone scw.Zone) {
+	logger := klog.FromContext(ctx)
+	snapshotCopy, err := d.client(ctx).GetSnapshotByName(restoreCopyNamePrefix+volumeName, "", zone, scw.WithContext(ctx))
+	if err != nil {
+		if err != scaleway.ErrSnapshotNotFound {
+			logger.Error(err, "error looking for the copy of the restored snapshot", "volumeName", volumeName, "zone", zone)
+		}
+		return
+	}
+	err = d.client(ctx).DeleteSnapshot(&instance.DeleteSnapshotRequest{
+		SnapshotID: snapshotCopy.ID,
+		Zone:       snapshotCopy.Zone,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		logger.Error(err, "error deleting the copy of the restored snapshot", "copyID", snapshotCopy.ID, "zone", snapshotCopy.Zone)
+	}
+}
+
+// snapshotExport returns the bucket and the key of the export of the snapshot, empty if it was never exported
+func snapshotExport(snapshot *instance.Snapshot) (string, string) {
+	for _, tag := range snapshot.Tags {
+		if strings.HasPrefix(tag, exportedToTagPrefix) {
+			bucket, key, _ := strings.Cut(strings.TrimPrefix(tag, exportedToTagPrefix), "/")
+			return bucket, key
+		}
+	}
+	return "", ""
+}
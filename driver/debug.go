@@ -0,0 +1,207 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"k8s.io/klog/v2"
+)
+
+// inflightOperation is an RPC currently handled by the driver
+type inflightOperation struct {
+	method    string
+	volumeID  string
+	startedAt time.Time
+}
+
+// inflightOperations keeps track of the RPCs currently handled by the driver
+type inflightOperations struct {
+	operations map[uint64]inflightOperation
+	nextID     uint64
+	mux        sync.Mutex
+}
+
+// volumeIDGetter is implemented by all the CSI requests targeting a volume
+type volumeIDGetter interface {
+	GetVolumeId() string
+}
+
+// interceptor is a grpc unary interceptor registering the RPC while it is handled
+func (o *inflightOperations) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	op := inflightOperation{
+		method:    info.FullMethod,
+		startedAt: time.Now(),
+	}
+	if r, ok := req.(volumeIDGetter); ok {
+		op.volumeID = r.GetVolumeId()
+	}
+
+	o.mux.Lock()
+	if o.operations == nil {
+		o.operations = make(map[uint64]inflightOperation)
+	}
+	id := o.nextID
+	o.nextID++
+	o.operations[id] = op
+	o.mux.Unlock()
+
+	defer func() {
+		o.mux.Lock()
+		delete(o.operations, id)
+		o.mux.Unlock()
+	}()
+
+	return handler(ctx, req)
+}
+
+// list returns the inflight operations, the oldest first
+func (o *inflightOperations) list() []inflightOperation {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	operations := make([]inflightOperation, 0, len(o.operations))
+	for _, op := range o.operations {
+		operations = append(operations, op)
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		return operations[i].startedAt.Before(operations[j].startedAt)
+	})
+	return operations
+}
+
+// lockAttach takes the attach/detach lock for the given volume and returns the function releasing it,
+// keeping track of its holder and of the calls waiting for it
+func (d *controllerService) lockAttach(volumeID string) func() {
+	d.attachLockStateMux.Lock()
+	d.attachLockWaiters++
+	d.attachLockStateMux.Unlock()
+
+	d.mux.Lock()
+
+	d.attachLockStateMux.Lock()
+	d.attachLockWaiters--
+	d.attachLockHolder = volumeID
+	d.attachLockStateMux.Unlock()
+
+	return func() {
+		d.attachLockStateMux.Lock()
+		d.attachLockHolder = ""
+		d.attachLockStateMux.Unlock()
+		d.mux.Unlock()
+	}
+}
+
+// handleStateDump dumps the state of the driver each time a SIGQUIT is received,
+// instead of the default behaviour of the go runtime which is to exit
+func (d *Driver) handleStateDump() {
+	dump := make(chan os.Signal, 1)
+	signal.Notify(dump, syscall.SIGQUIT)
+	go func() {
+		for range dump {
+			klog.Info(d.stateDump())
+		}
+	}()
+}
+
+// stateDumpHandler serves the state dump of the driver
+func (d *Driver) stateDumpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, d.stateDump())
+	})
+}
+
+// stateDump returns the held locks, inflight operations, cache sizes and goroutines stacks of the driver
+func (d *Driver) stateDump() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "state dump of %s %s (%s)\n", DriverName, driverVersion, gitCommit)
+
+	if d.config.Mode != NodeMode {
+		// TryLock is only used for reporting, the lock is released right away
+		attachLockHeld := !d.controllerService.mux.TryLock()
+		if !attachLockHeld {
+			d.controllerService.mux.Unlock()
+		}
+		fmt.Fprintf(&b, "attach/detach lock held: %t\n", attachLockHeld)
+		d.controllerService.attachLockStateMux.Lock()
+		if d.controllerService.attachLockHolder != "" {
+			fmt.Fprintf(&b, "attach/detach lock holder: volume %s\n", d.controllerService.attachLockHolder)
+		}
+		fmt.Fprintf(&b, "attach/detach lock waiters: %d\n", d.controllerService.attachLockWaiters)
+		d.controllerService.attachLockStateMux.Unlock()
+
+		d.controllerService.attachedDeletionsMux.Lock()
+		fmt.Fprintf(&b, "attached deletions cache size: %d\n", len(d.controllerService.attachedDeletions))
+		d.controllerService.attachedDeletionsMux.Unlock()
+
+		for _, detach := range d.controllerService.detachRetries.list() {
+			fmt.Fprintf(&b, "pending detachment: volume %s from server %s, queued at %s, %d attempt(s)\n",
+				detach.volumeID, detach.serverID, detach.queuedAt.Format(time.RFC3339), detach.attempts)
+		}
+
+		if d.controllerService.scaleway != nil {
+			fmt.Fprintf(&b, "server types cache size: %d zone(s)\n", d.controllerService.scaleway.CachedServerTypesZones())
+		}
+	}
+
+	if d.config.Mode != ControllerMode {
+		pathLocks := d.nodeService.pathLocks.waiting()
+		paths := make([]string, 0, len(pathLocks))
+		for path := range pathLocks {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		fmt.Fprintf(&b, "locked paths: %d\n", len(paths))
+		for _, path := range paths {
+			// the first call holds the lock, the others wait for it
+			fmt.Fprintf(&b, "  %s waiters=%d\n", path, pathLocks[path]-1)
+		}
+	}
+
+	operations := d.inflight.list()
+	fmt.Fprintf(&b, "inflight operations: %d\n", len(operations))
+	for _, op := range operations {
+		fmt.Fprintf(&b, "  %s volume=%q running for %s\n", op.method, op.volumeID, time.Since(op.startedAt).Round(time.Millisecond))
+	}
+
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	fmt.Fprintf(&b, "goroutines: %d\n%s", runtime.NumGoroutine(), buf)
+
+	return b.String()
+}
+
+// debugStatePath is the path of the state dump on the debug address
+const debugStatePath = "/debug/state"
+
+// debugHandler serves the pprof profiles and the state dump, which unlike SIGQUIT can be
+// fetched without access to the logs of the driver
+func (d *Driver) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle(debugStatePath, d.stateDumpHandler())
+	return mux
+}
@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+)
+
+func Test_inflightOperations(t *testing.T) {
+	inflight := &inflightOperations{}
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/DeleteVolume"}
+
+	_, err := inflight.interceptor(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "fr-par-1/volume-id"}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		operations := inflight.list()
+		Equals(t, 1, len(operations))
+		Equals(t, info.FullMethod, operations[0].method)
+		Equals(t, "fr-par-1/volume-id", operations[0].volumeID)
+		return nil, nil
+	})
+	AssertNoError(t, err)
+	Equals(t, 0, len(inflight.list()))
+}
+
+func Test_debugHandler(t *testing.T) {
+	d := &Driver{config: &DriverConfig{Mode: AllMode}}
+	unlockAttach := d.controllerService.lockAttach("volume-id")
+	unlockPath := d.nodeService.pathLocks.lock("/staging/volume-id")
+
+	rec := httptest.NewRecorder()
+	d.debugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, debugStatePath, nil))
+	Equals(t, http.StatusOK, rec.Code)
+	AssertTrue(t, strings.Contains(rec.Body.String(), "attach/detach lock holder: volume volume-id\n"))
+	AssertTrue(t, strings.Contains(rec.Body.String(), "  /staging/volume-id waiters=0\n"))
+
+	unlockAttach()
+	unlockPath()
+	Equals(t, 0, len(d.nodeService.pathLocks.waiting()))
+
+	rec = httptest.NewRecorder()
+	d.debugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	Equals(t, http.StatusOK, rec.Code)
+}
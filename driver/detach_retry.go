@@ -0,0 +1,204 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"k8s.io/klog/v2"
+)
+
+// detachRetryMaxAge is the duration after which a detachment still failing is dropped from the queue,
+// the next ControllerUnpublishVolume of the volume queueing it again
+const detachRetryMaxAge = time.Hour
+
+// pendingDetach is a detachment refused by the API, retried in the background
+type pendingDetach struct {
+	volumeID string
+	zone     scw.Zone
+	serverID string
+	// client is the one of the ControllerUnpublishVolume call, which may use the credentials of its secrets
+	client   *scaleway.Scaleway
+	queuedAt time.Time
+	attempts int
+}
+
+// detachRetryQueue keeps the detachments refused with a transient error, e.g. during a maintenance of the
+// Block API, and retries them until they succeed, after the deadline of the ControllerUnpublishVolume call.
+// There is at most one pending detachment per volume.
+type detachRetryQueue struct {
+	pending map[string]*pendingDetach
+	mux     sync.Mutex
+}
+
+func newDetachRetryQueue() *detachRetryQueue {
+	return &detachRetryQueue{pending: make(map[string]*pendingDetach)}
+}
+
+// add queues the detachment of the volume from the server, unless the volume already has one.
+// It returns false if the queue is not enabled.
+func (q *detachRetryQueue) add(detach *pendingDetach) bool {
+	if q == nil {
+		return false
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, ok := q.pending[detach.volumeID]; !ok {
+		q.pending[detach.volumeID] = detach
+	}
+	return true
+}
+
+// has returns true if the volume has a pending detachment
+func (q *detachRetryQueue) has(volumeID string) bool {
+	if q == nil {
+		return false
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	_, ok := q.pending[volumeID]
+	return ok
+}
+
+// remove drops the pending detachment of the volume, if any
+func (q *detachRetryQueue) remove(volumeID string) {
+	if q == nil {
+		return
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	delete(q.pending, volumeID)
+}
+
+// attempt counts an attempt of the pending detachment of the volume, returning the number of attempts so far
+func (q *detachRetryQueue) attempt(volumeID string) int {
+	if q == nil {
+		return 0
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	detach, ok := q.pending[volumeID]
+	if !ok {
+		return 0
+	}
+	detach.attempts++
+	return detach.attempts
+}
+
+// list returns a copy of the pending detachments, oldest first
+func (q *detachRetryQueue) list() []*pendingDetach {
+	if q == nil {
+		return nil
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	detaches := make([]*pendingDetach, 0, len(q.pending))
+	for _, detach := range q.pending {
+		detachCopy := *detach
+		detaches = append(detaches, &detachCopy)
+	}
+	sort.Slice(detaches, func(i, j int) bool {
+		return detaches[i].queuedAt.Before(detaches[j].queuedAt)
+	})
+	return detaches
+}
+
+// cancelDetachRetry drops the pending detachment of the volume under the attach lock, so that it is not
+// detached once the call returns. It returns true if there was one, which may have detached the volume already
+func (d *controllerService) cancelDetachRetry(volumeID string) bool {
+	if !d.detachRetries.has(volumeID) {
+		return false
+	}
+	unlock := d.lockAttach(volumeID)
+	defer unlock()
+	cancelled := d.detachRetries.has(volumeID)
+	d.detachRetries.remove(volumeID)
+	return cancelled
+}
+
+// isRetryableDetachError returns true if a detachment failed with an error the API returns
+// for a while during its maintenances, and should be retried later
+func isRetryableDetachError(err error) bool {
+	if e, ok := err.(*scw.ResponseError); ok && e.StatusCode == http.StatusServiceUnavailable {
+		return true
+	}
+	return isTransientAttachError(err)
+}
+
+// runDetachRetries retries the pending detachments every interval until stop is closed
+func (d *controllerService) runDetachRetries(interval time.Duration, stop <-chan struct{}) {
+	klog.Infof("retrying the detachments refused by the API every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			d.retryDetaches(ctx, time.Now())
+			cancel()
+		}
+	}
+}
+
+// retryDetaches retries each pending detachment once, dropping the ones which succeeded, are no longer needed
+// or are older than detachRetryMaxAge
+func (d *controllerService) retryDetaches(ctx context.Context, now time.Time) {
+	for _, detach := range d.detachRetries.list() {
+		if now.Sub(detach.queuedAt) > detachRetryMaxAge {
+			klog.Errorf("giving up detaching volume %s from server %s after %d attempts in %s", detach.volumeID, detach.serverID, detach.attempts, detachRetryMaxAge)
+			d.detachRetries.remove(detach.volumeID)
+			continue
+		}
+		if err := d.retryDetach(ctx, detach); err != nil {
+			klog.Warningf("error detaching volume %s from server %s, it will be retried: %s", detach.volumeID, detach.serverID, err)
+			continue
+		}
+		d.detachRetries.remove(detach.volumeID)
+	}
+}
+
+// retryDetach detaches the volume of the pending detachment, if still attached to its server.
+// It returns an error if the detachment must be retried.
+func (d *controllerService) retryDetach(ctx context.Context, detach *pendingDetach) error {
+	attempts := d.detachRetries.attempt(detach.volumeID)
+	volumeResp, err := detach.client.GetVolume(&instance.GetVolumeRequest{
+		VolumeID: detach.volumeID,
+		Zone:     detach.zone,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		if _, ok := err.(*scw.ResourceNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+	if volumeResp.Volume.Server == nil || volumeResp.Volume.Server.ID != detach.serverID {
+		return nil
+	}
+
+	unlock := d.lockAttach(detach.volumeID)
+	defer unlock()
+	// a ControllerPublishVolume of the volume cancels its pending detachment
+	if !d.detachRetries.has(detach.volumeID) {
+		return nil
+	}
+	_, err = detach.client.DetachVolume(&instance.DetachVolumeRequest{
+		VolumeID: detach.volumeID,
+		Zone:     detach.zone,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		if !isRetryableDetachError(err) {
+			klog.Errorf("error detaching volume %s from server %s: %s", detach.volumeID, detach.serverID, err)
+		}
+		return err
+	}
+	klog.Infof("volume %s detached from server %s after %d attempt(s)", detach.volumeID, detach.serverID, attempts)
+	return nil
+}
@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+func Test_retryDetaches(t *testing.T) {
+	server := &instance.Server{ID: "server-id", Zone: scw.ZoneFrPar1}
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1, Server: &instance.ServerSummary{ID: server.ID}}
+	server.Volumes = map[string]*instance.VolumeServer{"1": {ID: volume.ID}}
+
+	fakeAPI := &fakeHelper{
+		fakeDiskUtils: fakeDiskUtils{devices: map[string]*mountpoint{}},
+		fakeInstanceAPI: fakeInstanceAPI{
+			volumesMap:  map[string]*instance.Volume{volume.ID: volume},
+			serversMap:  map[string]*instance.Server{server.ID: server},
+			defaultZone: scw.ZoneFrPar1,
+		},
+	}
+	client := &scaleway.Scaleway{InstanceAPI: fakeAPI}
+	d := &controllerService{
+		scaleway:      client,
+		config:        &DriverConfig{},
+		detachRetries: newDetachRetryQueue(),
+	}
+
+	now := time.Now()
+	detach := &pendingDetach{volumeID: volume.ID, zone: volume.Zone, serverID: server.ID, client: client, queuedAt: now}
+	AssertTrue(t, d.detachRetries.add(detach))
+	// the first detachment queued is kept
+	AssertTrue(t, d.detachRetries.add(&pendingDetach{volumeID: volume.ID, queuedAt: now.Add(time.Minute)}))
+	Equals(t, []*pendingDetach{detach}, d.detachRetries.list())
+	Equals(t, 1, d.detachRetries.attempt(volume.ID))
+
+	// expired detachments are dropped without being retried
+	d.retryDetaches(context.Background(), now.Add(2*detachRetryMaxAge))
+	AssertFalse(t, d.detachRetries.has(volume.ID))
+	AssertTrue(t, volume.Server != nil)
+
+	// cancelled detachments are not retried
+	d.detachRetries.add(detach)
+	d.detachRetries.remove(volume.ID)
+	d.retryDetaches(context.Background(), now)
+	AssertTrue(t, volume.Server != nil)
+
+	d.detachRetries.add(detach)
+	d.retryDetaches(context.Background(), now)
+	AssertFalse(t, d.detachRetries.has(volume.ID))
+	AssertTrue(t, volume.Server == nil)
+
+	// the queue is disabled when not created
+	var disabled *detachRetryQueue
+	AssertFalse(t, disabled.add(detach))
+	AssertFalse(t, disabled.has(volume.ID))
+}
+
+func Test_ControllerPublishVolumeCancelsDetachRetry(t *testing.T) {
+	server := &instance.Server{ID: "server-id", Zone: scw.ZoneFrPar1, CommercialType: "DEV1-S"}
+	volume := &instance.Volume{ID: "volume-id", Zone: scw.ZoneFrPar1, VolumeType: instance.VolumeVolumeTypeBSSD, Server: &instance.ServerSummary{ID: server.ID}}
+	server.Volumes = map[string]*instance.VolumeServer{"1": {ID: volume.ID}}
+	fake := newFakeInstanceAPI([]*instance.Volume{volume}, []*instance.Server{server}, nil)
+	d := newTestController(fake, &DriverConfig{})
+	d.detachRetries = newDetachRetryQueue()
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "fr-par-1/volume-id",
+		NodeId:           "fr-par-1/server-id",
+		VolumeCapability: mountCapability(),
+	}
+
+	// the volume still attached to the node is published again while its detachment is queued
+	d.detachRetries.add(&pendingDetach{volumeID: volume.ID, zone: volume.Zone, serverID: server.ID, client: d.scaleway, queuedAt: time.Now()})
+	_, err := d.ControllerPublishVolume(context.Background(), req)
+	AssertNoError(t, err)
+	AssertFalse(t, d.detachRetries.has(volume.ID))
+	d.retryDetaches(context.Background(), time.Now())
+	AssertTrue(t, volume.Server != nil && volume.Server.ID == server.ID)
+}
@@ -1,13 +1,17 @@
 package driver
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"k8s.io/klog/v2"
@@ -22,8 +26,18 @@ const (
 	diskLuksMapperPrefix = "scw-luks-"
 	diskLuksMapperPath   = "/dev/mapper/"
 
+	sysClassNVMePath = "/sys/class/nvme"
+	sysBlockPath     = "/sys/block"
+
 	defaultFSType = "ext4"
 
+	devicePollInterval = time.Second
+	udevSettleTimeout  = 5
+
+	// scsiScanInterval is the minimum delay between two INQUIRY scans of the SCSI devices for the same volume,
+	// each scan sending a command to all of them while GetDevicePath is polled every devicePollInterval
+	scsiScanInterval = 10 * time.Second
+
 	procMountInfoMaxListTries             = 3
 	procMountsExpectedNumFieldsPerLine    = 6
 	procMountInfoExpectedAtLeastNumFields = 10
@@ -32,10 +46,19 @@ const (
 	expectedAtLeastNumFieldsPerMountInfo  = 10
 )
 
+// DefaultDiskPrefixes are the default prefixes of the /dev/disk/by-id symlinks of the volumes, followed by their ID
+var DefaultDiskPrefixes = []string{diskSCWPrefix}
+
+// nvmeNamespaceRegexp matches the NVMe namespaces block devices, but not the per-controller paths used by NVMe multipath
+var nvmeNamespaceRegexp = regexp.MustCompile(`^nvme[0-9]+n[0-9]+$`)
+
 type DiskUtils interface {
 	// FormatAndMount tries to mount `devicePath` on `targetPath` as `fsType` with `mountOptions`
-	// If it fails it will try to format `devicePath` as `fsType` first and retry
-	FormatAndMount(targetPath string, devicePath string, fsType string, mountOptions []string) error
+	// If it fails it will try to format `devicePath` as `fsType` with `formatOptions` first and retry
+	FormatAndMount(targetPath string, devicePath string, fsType string, mountOptions []string, formatOptions []string) error
+
+	// CheckFilesystem checks the filesystem of `devicePath`, if any, before mounting it
+	CheckFilesystem(ctx context.Context, devicePath string) error
 
 	// Unmount unmounts the given target
 	Unmount(target string) error
@@ -49,6 +72,13 @@ type DiskUtils interface {
 	// GetDevicePath returns the path for the specified volumeID
 	GetDevicePath(volumeID string) (string, error)
 
+	// WaitDevicePath returns the path for the specified volumeID, waiting up to `timeout` for it to appear.
+	// `devicePathHint` is the path expected by the controller, looked up first if not empty
+	WaitDevicePath(ctx context.Context, volumeID string, devicePathHint string, timeout time.Duration) (string, error)
+
+	// ForgetDevicePath drops the device path hint of the specified volumeID, once it is unstaged
+	ForgetDevicePath(volumeID string)
+
 	// IsSharedMounted returns true is `devicePath` is shared mounted on `targetPath`
 	IsSharedMounted(targetPath string, devicePath string) (bool, error)
 
@@ -62,6 +92,12 @@ type DiskUtils interface {
 	// Resize resizes the given volumes, it will try to resize the LUKS device first if the passphrase is provided
 	Resize(targetPath string, devicePath, passphrase string) error
 
+	// NeedResize returns true if the filesystem of `devicePath` mounted on `targetPath` is smaller than the device
+	NeedResize(devicePath string, targetPath string) (bool, error)
+
+	// ResizeEncryptedDevice grows the opened LUKS device mapped on `mappedDevicePath` to the size of its underlying device
+	ResizeEncryptedDevice(mappedDevicePath string, passphrase string) error
+
 	// IsEncrypted returns true if the device with the given path is encrypted with LUKS
 	IsEncrypted(devicePath string) (bool, error)
 
@@ -74,18 +110,52 @@ type DiskUtils interface {
 
 	// GetMappedDevicePath returns the path on where the encrypted device with the given ID is mapped
 	GetMappedDevicePath(volumeID string) (string, error)
+
+	// CloseStaleDevices closes the encrypted devices whose underlying device no longer exists
+	// and which are not mounted, and returns the number of closed devices
+	CloseStaleDevices() (int, error)
+
+	// SetReadOnly marks the block device with the given path as read-only, or writable
+	SetReadOnly(devicePath string, readOnly bool) error
+
+	// SetXFSProjectQuota creates the directory of the XFS filesystem mounted on mountPath, assigns it
+	// to the project and limits the size of the project to the given number of bytes
+	SetXFSProjectQuota(mountPath string, dir string, projectID uint32, limit int64) error
+
+	// RemoveXFSProjectQuota removes the limit of the project, takes the directory out of it and removes it with its content
+	RemoveXFSProjectQuota(mountPath string, dir string, projectID uint32) error
 }
 
 type diskUtils struct {
 	kMounter *kmount.SafeFormatAndMount
+
+	// diskPrefixes are the prefixes of the /dev/disk/by-id symlinks of the volumes
+	diskPrefixes []string
+
+	// devicePathHints are the device paths expected by the controller, by volume ID
+	devicePathHints    map[string]string
+	devicePathHintsMux sync.Mutex
+
+	// scsiScans are the times of the last INQUIRY scans of the SCSI devices, by volume ID
+	scsiScans    map[string]time.Time
+	scsiScansMux sync.Mutex
+
+	// luksLazyClose defers the removal of the LUKS mappings still busy after the close retries
+	luksLazyClose bool
+
+	// formatTimeout bounds the formatting of a device, if not zero
+	formatTimeout time.Duration
 }
 
-func newDiskUtils() *diskUtils {
+func newDiskUtils(diskPrefixes []string) *diskUtils {
 	return &diskUtils{
 		kMounter: &kmount.SafeFormatAndMount{
 			Interface: kmount.New(""),
 			Exec:      kexec.New(),
 		},
+		diskPrefixes:    diskPrefixes,
+		devicePathHints: make(map[string]string),
+		scsiScans:       make(map[string]time.Time),
 	}
 }
 
@@ -96,8 +166,19 @@ func (d *diskUtils) EncryptAndOpenDevice(volumeID string, passphrase string) (st
 	}
 
 	if encryptedDevicePath != "" {
-		// device is already encrypted and open
-		return encryptedDevicePath, nil
+		stale, err := isStaleMapping(diskLuksMapperPrefix + volumeID)
+		if err != nil {
+			return "", err
+		}
+		if !stale {
+			// device is already encrypted and open
+			return encryptedDevicePath, nil
+		}
+		// the mapping was left open on the device of a previous attachment of the volume
+		klog.Warningf("closing LUKS mapping %s of volume %s, whose device no longer exists", encryptedDevicePath, volumeID)
+		if err := closeLuksMapping(diskLuksMapperPrefix+volumeID, false); err != nil {
+			return "", fmt.Errorf("error closing stale luks mapping %s: %w", encryptedDevicePath, err)
+		}
 	}
 
 	// let's check if the device is aready a luks device
@@ -132,7 +213,7 @@ func (d *diskUtils) CloseDevice(volumeID string) error {
 	}
 
 	if encryptedDevicePath != "" {
-		err = luksClose(diskLuksMapperPrefix + volumeID)
+		err = closeLuksMapping(diskLuksMapperPrefix+volumeID, d.luksLazyClose)
 		if err != nil {
 			return fmt.Errorf("error luks closing %s: %w", encryptedDevicePath, err)
 		}
@@ -141,6 +222,68 @@ func (d *diskUtils) CloseDevice(volumeID string) error {
 	return nil
 }
 
+func (d *diskUtils) CloseStaleDevices() (int, error) {
+	mappings, err := filepath.Glob(path.Join(diskLuksMapperPath, diskLuksMapperPrefix+"*"))
+	if err != nil {
+		return 0, err
+	}
+	if len(mappings) == 0 {
+		return 0, nil
+	}
+	content, err := utilsio.ConsistentRead(procMountInfoPath, procMountInfoMaxListTries)
+	if err != nil {
+		return 0, err
+	}
+
+	closed := 0
+	for _, mapping := range mappings {
+		mapperFile := filepath.Base(mapping)
+		stale, err := isStaleMapping(mapperFile)
+		if err != nil {
+			klog.Warningf("error checking LUKS mapping %s: %s", mapping, err)
+			continue
+		}
+		if !stale {
+			continue
+		}
+		mountPoints, err := managedMountPoints(string(content), []string{mapping})
+		if err != nil {
+			klog.Warningf("error looking up the mount points of LUKS mapping %s: %s", mapping, err)
+			continue
+		}
+		if len(mountPoints) > 0 {
+			klog.Warningf("LUKS mapping %s has no device anymore but is still mounted on %s", mapping, strings.Join(mountPoints, ", "))
+			continue
+		}
+		if err := closeLuksMapping(mapperFile, d.luksLazyClose); err != nil {
+			klog.Warningf("error closing stale LUKS mapping %s: %s", mapping, err)
+			continue
+		}
+		closed++
+	}
+	return closed, nil
+}
+
+// isStaleMapping returns true if the underlying device of the opened mapping no longer exists,
+// which happens when a volume is detached while its mapping is still open
+func isStaleMapping(mapperFile string) (bool, error) {
+	statusStdout, err := luksStatus(mapperFile)
+	if err != nil {
+		return false, fmt.Errorf("error checking luks status on %s: %w", mapperFile, err)
+	}
+	device := luksStatusDevice(statusStdout)
+	if device == "" {
+		return false, nil
+	}
+	if _, err := os.Stat(device); err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
 func (d *diskUtils) GetMappedDevicePath(volumeID string) (string, error) {
 	mappedPath := diskLuksMapperPath + diskLuksMapperPrefix + volumeID
 	_, err := os.Stat(mappedPath)
@@ -176,20 +319,32 @@ func (d *diskUtils) GetMappedDevicePath(volumeID string) (string, error) {
 	return mappedPath, nil
 }
 
-func (d *diskUtils) FormatAndMount(targetPath string, devicePath string, fsType string, mountOptions []string) error {
+func (d *diskUtils) FormatAndMount(targetPath string, devicePath string, fsType string, mountOptions []string, formatOptions []string) error {
 	if fsType == "" {
 		fsType = defaultFSType
 	}
 
 	klog.V(4).Infof("Attempting to mount %s on %s with type %s", devicePath, targetPath, fsType)
 
-	if err := d.kMounter.FormatAndMount(devicePath, targetPath, fsType, mountOptions); err != nil {
+	// the mount refuses to format by itself the devices mounted read-only, so an empty device is
+	// formatted beforehand in every case, the device being only made read-only once mounted
+	if err := formatIfEmpty(devicePath, fsType, formatOptions, d.formatTimeout, d.kMounter.GetDiskFormat, runCommand); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := d.kMounter.FormatAndMountSensitiveWithFormatOptions(devicePath, targetPath, fsType, mountOptions, nil, formatOptions); err != nil {
 		return fmt.Errorf("failed to optionnaly format and mount: %w", err)
 	}
+	mountDurations.since(start)
 
 	return nil
 }
 
+func (d *diskUtils) CheckFilesystem(ctx context.Context, devicePath string) error {
+	return checkFilesystem(ctx, devicePath, d.kMounter.GetDiskFormat, runCommand)
+}
+
 func (d *diskUtils) Unmount(target string) error {
 	return kmount.CleanupMountPoint(target, d.kMounter, true)
 }
@@ -207,10 +362,49 @@ func (d *diskUtils) MountToTarget(sourcePath, targetPath, fsType string, mountOp
 }
 
 func (d *diskUtils) GetDevicePath(volumeID string) (string, error) {
-	devicePath := path.Join(diskByIDPath, diskSCWPrefix+volumeID)
-	realDevicePath, err := filepath.EvalSymlinks(devicePath)
+	candidates := devicePathCandidates(d.diskPrefixes, volumeID)
+	d.devicePathHintsMux.Lock()
+	if hint, ok := d.devicePathHints[volumeID]; ok {
+		candidates = append([]string{hint}, candidates...)
+	}
+	d.devicePathHintsMux.Unlock()
+
+	var devicePath, realDevicePath string
+	var err error
+	for _, candidate := range candidates {
+		devicePath = candidate
+		realDevicePath, err = filepath.EvalSymlinks(devicePath)
+		if err == nil || !os.IsNotExist(err) {
+			break
+		}
+	}
 	if err != nil {
-		return "", err
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		// some instance types expose the volumes as NVMe namespaces, without the SCSI by-id symlink
+		sysDevicePath, sysErr := findNVMeDevicePath(sysClassNVMePath, volumeID)
+		if sysErr != nil {
+			klog.V(5).Infof("error looking for NVMe device of volume %s: %s", volumeID, sysErr)
+		}
+		// and the images without udev have no by-id symlinks at all
+		if sysDevicePath == "" {
+			sysDevicePath, sysErr = findSysBlockDevicePath(sysBlockPath, volumeID)
+			if sysErr != nil {
+				klog.V(5).Infof("error looking for block device of volume %s: %s", volumeID, sysErr)
+			}
+		}
+		// and the custom udev rules may rename them, the serial is then asked to the SCSI devices themselves
+		if sysDevicePath == "" && d.shouldScanSCSI(volumeID, time.Now()) {
+			sysDevicePath, sysErr = findSCSIGenericDevicePath(sysClassSCSIGenericPath, volumeID, inquireSerial)
+			if sysErr != nil {
+				klog.V(5).Infof("error looking for SCSI device of volume %s: %s", volumeID, sysErr)
+			}
+		}
+		if sysDevicePath == "" {
+			return "", err
+		}
+		devicePath, realDevicePath = sysDevicePath, sysDevicePath
 	}
 
 	deviceInfo, err := os.Stat(realDevicePath)
@@ -226,6 +420,156 @@ func (d *diskUtils) GetDevicePath(volumeID string) (string, error) {
 	return devicePath, nil
 }
 
+// devicePathCandidates returns the /dev/disk/by-id symlinks which may point to the device of the volume:
+// the ones of the given prefixes, then the one of the serial of the architecture, if any
+func devicePathCandidates(diskPrefixes []string, volumeID string) []string {
+	if len(diskPrefixes) == 0 {
+		diskPrefixes = DefaultDiskPrefixes
+	}
+	candidates := make([]string, 0, len(diskPrefixes)+1)
+	for _, prefix := range diskPrefixes {
+		candidates = append(candidates, path.Join(diskByIDPath, prefix+volumeID))
+	}
+	if archDiskSerialPrefix != "" {
+		serial := volumeID
+		if len(serial) > archDiskSerialMaxLength {
+			serial = serial[:archDiskSerialMaxLength]
+		}
+		candidates = append(candidates, path.Join(diskByIDPath, archDiskSerialPrefix+serial))
+	}
+	return candidates
+}
+
+// findNVMeDevicePath returns the path of the NVMe namespace whose identifiers (wwid, uuid, nguid)
+// contain the given volume ID, or an empty string if there is none
+func findNVMeDevicePath(sysClassNVMe string, volumeID string) (string, error) {
+	namespaces, err := filepath.Glob(filepath.Join(sysClassNVMe, "*", "nvme*n*"))
+	if err != nil {
+		return "", err
+	}
+
+	wantedID := normalizeDeviceID(volumeID)
+
+	for _, namespace := range namespaces {
+		name := filepath.Base(namespace)
+		if !nvmeNamespaceRegexp.MatchString(name) {
+			continue
+		}
+		for _, attribute := range []string{"wwid", "uuid", "nguid"} {
+			content, err := os.ReadFile(filepath.Join(namespace, attribute))
+			if err != nil {
+				continue
+			}
+			if strings.Contains(normalizeDeviceID(string(content)), wantedID) {
+				return path.Join("/dev", name), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// findSysBlockDevicePath returns the path of the block device whose serial (virtio-blk serial,
+// SCSI serial or VPD pages) contains the given volume ID, or an empty string if there is none
+func findSysBlockDevicePath(sysBlock string, volumeID string) (string, error) {
+	devices, err := os.ReadDir(sysBlock)
+	if err != nil {
+		return "", err
+	}
+
+	wantedID := normalizeDeviceID(volumeID)
+	for _, device := range devices {
+		for _, attribute := range []string{"serial", "device/serial", "device/vpd_pg80", "device/vpd_pg83", "device/wwid"} {
+			content, err := os.ReadFile(filepath.Join(sysBlock, device.Name(), attribute))
+			if err != nil {
+				continue
+			}
+			if strings.Contains(normalizeDeviceID(string(content)), wantedID) {
+				return path.Join("/dev", device.Name()), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// normalizeDeviceID returns the given identifier in lower case and without dashes,
+// as the volume IDs are found formatted differently in the device identifiers
+func normalizeDeviceID(id string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(id), "-", ""))
+}
+
+func (d *diskUtils) WaitDevicePath(ctx context.Context, volumeID string, devicePathHint string, timeout time.Duration) (string, error) {
+	if devicePathHint != "" {
+		// the hint is only used as a /dev/disk/by-id symlink, the publish context not being trusted for any other path
+		if path.Dir(path.Clean(devicePathHint)) == diskByIDPath {
+			d.devicePathHintsMux.Lock()
+			d.devicePathHints[volumeID] = path.Clean(devicePathHint)
+			d.devicePathHintsMux.Unlock()
+		} else {
+			klog.FromContext(ctx).Info("ignoring device path hint outside of "+diskByIDPath, "volumeID", volumeID, "devicePathHint", devicePathHint)
+		}
+	}
+
+	devicePath, err := d.GetDevicePath(volumeID)
+	if err == nil || !os.IsNotExist(err) || timeout <= 0 {
+		return devicePath, err
+	}
+
+	klog.FromContext(ctx).V(4).Info("device not found, waiting for it to appear", "volumeID", volumeID, "timeout", timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(devicePollInterval)
+	defer ticker.Stop()
+	for {
+		udevSettle(ctx)
+
+		devicePath, err = d.GetDevicePath(volumeID)
+		if err == nil || !os.IsNotExist(err) {
+			return devicePath, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *diskUtils) ForgetDevicePath(volumeID string) {
+	d.devicePathHintsMux.Lock()
+	delete(d.devicePathHints, volumeID)
+	d.devicePathHintsMux.Unlock()
+
+	d.scsiScansMux.Lock()
+	delete(d.scsiScans, volumeID)
+	d.scsiScansMux.Unlock()
+}
+
+// shouldScanSCSI returns true if the SCSI devices were not scanned for the volume during the last scsiScanInterval,
+// and records the scan. The serials are not cached, the names of the devices being reused by the next attachments
+func (d *diskUtils) shouldScanSCSI(volumeID string, now time.Time) bool {
+	d.scsiScansMux.Lock()
+	defer d.scsiScansMux.Unlock()
+	if last, ok := d.scsiScans[volumeID]; ok && now.Sub(last) < scsiScanInterval {
+		return false
+	}
+	d.scsiScans[volumeID] = now
+	return true
+}
+
+// udevSettle waits for the pending udev events to be processed, if udevadm is available
+func udevSettle(ctx context.Context) {
+	udevadmPath, err := exec.LookPath("udevadm")
+	if err != nil {
+		return
+	}
+	out, err := exec.CommandContext(ctx, udevadmPath, "settle", fmt.Sprintf("--timeout=%d", udevSettleTimeout)).CombinedOutput()
+	if err != nil {
+		klog.V(5).Infof("udevadm settle failed: %s: %s", err, string(out))
+	}
+}
+
 func (d *diskUtils) IsSharedMounted(targetPath string, devicePath string) (bool, error) {
 	if targetPath == "" {
 		return false, errTargetPathEmpty
@@ -251,13 +595,31 @@ func (d *diskUtils) IsSharedMounted(targetPath string, devicePath string) (bool,
 		return false, errTargetNotSharedMounter
 	}
 
-	if devicePath != "" && mountInfo.source != devicePath {
+	if devicePath != "" && !sameDevicePath(mountInfo.source, devicePath) {
+		klog.V(4).Infof("%s is mounted on %s instead of %s", mountInfo.source, targetPath, devicePath)
 		return false, errTargetNotMounterOnRightDevice
 	}
 
 	return true, nil
 }
 
+// sameDevicePath returns true if the mount source and the device path are the same device, the device path
+// being usually a /dev/disk/by-id symlink while the mount source is the device it points to
+func sameDevicePath(source string, devicePath string) bool {
+	if source == devicePath {
+		return true
+	}
+	realSource, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return false
+	}
+	realDevicePath, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return false
+	}
+	return realSource == realDevicePath
+}
+
 // taken from https://github.com/kubernetes/kubernetes/blob/master/pkg/util/mount/mount_linux.go
 // This represents a single line in /proc/<pid>/mountinfo.
 type mountInfo struct {
@@ -358,6 +720,21 @@ func (d *diskUtils) IsBlockDevice(path string) (bool, error) {
 
 }
 
+func (d *diskUtils) SetReadOnly(devicePath string, readOnly bool) error {
+	device, err := os.Open(devicePath)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	// same as `blockdev --setro` and `--setrw`, which are not available in the image
+	value := 0
+	if readOnly {
+		value = 1
+	}
+	return unix.IoctlSetPointerInt(int(device.Fd()), unix.BLKROSET, value)
+}
+
 func (d *diskUtils) GetStatfs(path string) (*unix.Statfs_t, error) {
 	fs := &unix.Statfs_t{}
 	err := unix.Statfs(path, fs)
@@ -383,22 +760,38 @@ func (d *diskUtils) Resize(targetPath string, devicePath, passphrase string) err
 
 	klog.V(4).Infof("resizing filesystem %s on %s", mountInfo.fsType, devicePath)
 
-	switch mountInfo.fsType {
+	resizeCmd, resizeArgs, err := resizeFsCommand(mountInfo.fsType, devicePath, targetPath)
+	if err != nil {
+		return err
+	}
+
+	resizeCmdPath, err := exec.LookPath(resizeCmd)
+	if err != nil {
+		return err
+	}
+	return exec.Command(resizeCmdPath, resizeArgs...).Run()
+}
+
+func (d *diskUtils) NeedResize(devicePath string, targetPath string) (bool, error) {
+	return kmount.NewResizeFs(d.kMounter.Exec).NeedResize(devicePath, targetPath)
+}
+
+func (d *diskUtils) ResizeEncryptedDevice(mappedDevicePath string, passphrase string) error {
+	klog.V(4).Infof("resizing LUKS device %s", mappedDevicePath)
+	return luksResize(mappedDevicePath, passphrase)
+}
+
+// resizeFsCommand returns the command and its arguments needed to grow a filesystem of the given type
+// to the size of its underlying device
+func resizeFsCommand(fsType string, devicePath string, targetPath string) (string, []string, error) {
+	switch fsType {
 	case "ext3", "ext4":
-		resize2fsPath, err := exec.LookPath("resize2fs")
-		if err != nil {
-			return err
-		}
-		resize2fsArgs := []string{devicePath}
-		return exec.Command(resize2fsPath, resize2fsArgs...).Run()
+		return "resize2fs", []string{devicePath}, nil
 	case "xfs":
-		xfsGrowfsPath, err := exec.LookPath("xfs_growfs")
-		if err != nil {
-			return err
-		}
-		xfsGrowfsArgs := []string{"-d", targetPath}
-		return exec.Command(xfsGrowfsPath, xfsGrowfsArgs...).Run()
+		return "xfs_growfs", []string{"-d", targetPath}, nil
+	case "btrfs":
+		return "btrfs", []string{"filesystem", "resize", "max", targetPath}, nil
 	}
 
-	return fmt.Errorf("filesystem %s does not support resizing", mountInfo.fsType)
+	return "", nil, fmt.Errorf("filesystem %s does not support resizing", fsType)
 }
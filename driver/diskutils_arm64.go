@@ -0,0 +1,11 @@
+//go:build arm64
+
+package driver
+
+const (
+	// archDiskSerialPrefix is the prefix of the /dev/disk/by-id symlinks named after the serial of the devices:
+	// the ARM instances expose the volumes as virtio-blk devices whose serial is the volume ID
+	archDiskSerialPrefix = "virtio-"
+	// archDiskSerialMaxLength is the length of the serials of the virtio-blk devices, the volume IDs being truncated
+	archDiskSerialMaxLength = 20
+)
@@ -0,0 +1,9 @@
+//go:build !arm64
+
+package driver
+
+const (
+	// archDiskSerialPrefix is empty, the volumes of the other architectures have a by-id symlink with their full ID
+	archDiskSerialPrefix    = ""
+	archDiskSerialMaxLength = 0
+)
@@ -0,0 +1,295 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_resizeFsCommand(t *testing.T) {
+	testsBench := []struct {
+		fsType string
+		cmd    string
+		args   []string
+		err    error
+	}{
+		{
+			fsType: "ext3",
+			cmd:    "resize2fs",
+			args:   []string{"/dev/sda"},
+		},
+		{
+			fsType: "ext4",
+			cmd:    "resize2fs",
+			args:   []string{"/dev/sda"},
+		},
+		{
+			fsType: "xfs",
+			cmd:    "xfs_growfs",
+			args:   []string{"-d", "/mnt/target"},
+		},
+		{
+			fsType: "btrfs",
+			cmd:    "btrfs",
+			args:   []string{"filesystem", "resize", "max", "/mnt/target"},
+		},
+		{
+			fsType: "vfat",
+			err:    fmt.Errorf("filesystem vfat does not support resizing"),
+		},
+	}
+
+	for _, test := range testsBench {
+		t.Run(test.fsType, func(t *testing.T) {
+			cmd, args, err := resizeFsCommand(test.fsType, "/dev/sda", "/mnt/target")
+			Equals(t, test.err, err)
+			Equals(t, test.cmd, cmd)
+			Equals(t, test.args, args)
+		})
+	}
+}
+
+func Test_findNVMeDevicePath(t *testing.T) {
+	sysClassNVMe := t.TempDir()
+	volumeID := "6f3b6e1a-2f5c-4c8e-9f4b-1d2e3f4a5b6c"
+
+	writeAttribute := func(namespace string, attribute string, value string) {
+		dir := filepath.Join(sysClassNVMe, namespace[:len("nvme0")], namespace)
+		AssertNoError(t, os.MkdirAll(dir, 0750))
+		AssertNoError(t, os.WriteFile(filepath.Join(dir, attribute), []byte(value+"\n"), 0600))
+	}
+	writeAttribute("nvme0n1", "wwid", "eui.0025385b71b07e2f")
+	writeAttribute("nvme1c1n1", "wwid", "uuid."+volumeID)
+	writeAttribute("nvme1n1", "nguid", "6F3B6E1A-2F5C-4C8E-9F4B-1D2E3F4A5B6C")
+
+	devicePath, err := findNVMeDevicePath(sysClassNVMe, volumeID)
+	AssertNoError(t, err)
+	Equals(t, "/dev/nvme1n1", devicePath)
+
+	devicePath, err = findNVMeDevicePath(sysClassNVMe, "00000000-0000-0000-0000-000000000000")
+	AssertNoError(t, err)
+	Equals(t, "", devicePath)
+}
+
+func Test_findSysBlockDevicePath(t *testing.T) {
+	sysBlock := t.TempDir()
+	volumeID := "6f3b6e1a-2f5c-4c8e-9f4b-1d2e3f4a5b6c"
+
+	writeAttribute := func(device string, attribute string, value string) {
+		file := filepath.Join(sysBlock, device, attribute)
+		AssertNoError(t, os.MkdirAll(filepath.Dir(file), 0750))
+		AssertNoError(t, os.WriteFile(file, []byte(value), 0600))
+	}
+	writeAttribute("sda", "device/vpd_pg80", "\x00\x80\x00\x24SCW_b_ssd_volume-00000000-0000-0000-0000-000000000000")
+	writeAttribute("sdb", "device/vpd_pg80", "\x00\x80\x00\x24SCW_b_ssd_volume-"+volumeID)
+	writeAttribute("vda", "serial", "root\n")
+
+	devicePath, err := findSysBlockDevicePath(sysBlock, volumeID)
+	AssertNoError(t, err)
+	Equals(t, "/dev/sdb", devicePath)
+
+	// virtio-blk serials have no dashes
+	AssertNoError(t, os.RemoveAll(filepath.Join(sysBlock, "sdb")))
+	writeAttribute("vdb", "serial", "6F3B6E1A2F5C4C8E9F4B1D2E3F4A5B6C\n")
+	devicePath, err = findSysBlockDevicePath(sysBlock, volumeID)
+	AssertNoError(t, err)
+	Equals(t, "/dev/vdb", devicePath)
+
+	devicePath, err = findSysBlockDevicePath(sysBlock, "11111111-0000-0000-0000-000000000000")
+	AssertNoError(t, err)
+	Equals(t, "", devicePath)
+}
+
+func Test_devicePathCandidates(t *testing.T) {
+	volumeID := "6f3b6e1a-2f5c-4c8e-9f4b-1d2e3f4a5b6c"
+
+	expected := []string{"/dev/disk/by-id/scsi-0SCW_b_ssd_volume-" + volumeID}
+	if archDiskSerialPrefix != "" {
+		expected = append(expected, "/dev/disk/by-id/"+archDiskSerialPrefix+volumeID[:archDiskSerialMaxLength])
+	}
+	Equals(t, expected, devicePathCandidates(nil, volumeID))
+
+	expected[0] = "/dev/disk/by-id/custom-" + volumeID
+	Equals(t, expected, devicePathCandidates([]string{"custom-"}, volumeID))
+}
+
+func Test_managedMountPoints(t *testing.T) {
+	mountInfo := `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+25 22 0:5 / /dev rw,nosuid shared:2 - devtmpfs udev rw,size=1000k
+30 22 1:3 / /var/lib/kubelet/plugins/kubernetes.io/csi/csi.scaleway.com/1234/globalmount rw shared:3 - ext4 /dev/null rw
+31 22 1:3 / /var/lib/kubelet/pods/5678/volumes/kubernetes.io~csi/pvc-1234/mount rw shared:3 - ext4 /dev/null rw
+32 22 0:5 /null /var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-9012/5678 rw shared:2 - devtmpfs udev rw
+33 22 0:5 /zero /var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-3456/5678 rw shared:2 - devtmpfs udev rw
+`
+	// /dev/null is 1:3 on linux
+	mountPoints, err := managedMountPoints(mountInfo, []string{"/dev/null", "/dev/does-not-exist"})
+	AssertNoError(t, err)
+	Equals(t, []string{
+		"/var/lib/kubelet/plugins/kubernetes.io/csi/csi.scaleway.com/1234/globalmount",
+		"/var/lib/kubelet/pods/5678/volumes/kubernetes.io~csi/pvc-1234/mount",
+		"/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-9012/5678",
+	}, mountPoints)
+}
+
+func Test_luksStatusDevice(t *testing.T) {
+	status := `/dev/mapper/scw-luks-6f3b6e1a-2f5c-4c8e-9f4b-1d2e3f4a5b6c is active and is in use.
+  type:    LUKS2
+  cipher:  aes-xts-plain64
+  keysize: 256 bits
+  key location: keyring
+  device:  /dev/sdb
+  sector size:  512
+  offset:  32768 sectors
+  size:    20938752 sectors
+  mode:    read/write
+`
+	Equals(t, "/dev/sdb", luksStatusDevice([]byte(status)))
+	Equals(t, "", luksStatusDevice([]byte("/dev/mapper/scw-luks-id is inactive.\n")))
+}
+
+func Test_formatIfEmpty(t *testing.T) {
+	empty := func(string) (string, error) { return "", nil }
+	formatted := func(string) (string, error) { return "ext4", nil }
+	unreadable := func(string) (string, error) { return "", errors.New("input/output error") }
+	// the device looks empty to the first check only
+	formattedMeanwhile := func() func(string) (string, error) {
+		checks := 0
+		return func(string) (string, error) {
+			checks++
+			if checks > 1 {
+				return "ext4", nil
+			}
+			return "", nil
+		}
+	}
+
+	tests := []struct {
+		name          string
+		getDiskFormat func(string) (string, error)
+		wipefsOut     string
+		wipefsErr     error
+		mkfsCode      int
+		wantMkfs      []string
+		wantErr       bool
+		wantNotEmpty  bool
+	}{
+		{name: "formatted device", getDiskFormat: formatted},
+		{name: "unreadable device", getDiskFormat: unreadable, wantErr: true},
+		{name: "empty device", getDiskFormat: empty, wantMkfs: []string{"mkfs.ext4", "-E", "nodiscard", "-F", "-m0", "/dev/sdz"}},
+		{name: "no wipefs", getDiskFormat: empty, wipefsErr: exec.ErrNotFound, wantMkfs: []string{"mkfs.ext4", "-E", "nodiscard", "-F", "-m0", "/dev/sdz"}},
+		{name: "signatures unknown to blkid", getDiskFormat: empty, wipefsOut: "zfs_member 0x3f000\n", wantErr: true, wantNotEmpty: true},
+		{name: "formatted between the checks", getDiskFormat: formattedMeanwhile(), wantErr: true},
+		{name: "failed mkfs", getDiskFormat: empty, mkfsCode: 1, wantMkfs: []string{"mkfs.ext4", "-E", "nodiscard", "-F", "-m0", "/dev/sdz"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mkfs []string
+			run := func(ctx context.Context, name string, args ...string) (int, string, error) {
+				if name == wipefsCmd {
+					return 0, tt.wipefsOut, tt.wipefsErr
+				}
+				mkfs = append([]string{name}, args...)
+				return tt.mkfsCode, "", nil
+			}
+			err := formatIfEmpty("/dev/sdz", "ext4", []string{"-E", "nodiscard"}, time.Second, tt.getDiskFormat, run)
+			Equals(t, tt.wantErr, err != nil)
+			Equals(t, tt.wantNotEmpty, errors.Is(err, errDeviceNotEmpty))
+			Equals(t, tt.wantMkfs, mkfs)
+		})
+	}
+}
+
+func Test_sameDevicePath(t *testing.T) {
+	dir := t.TempDir()
+	device := filepath.Join(dir, "sda")
+	other := filepath.Join(dir, "sdb")
+	link := filepath.Join(dir, "scsi-0SCW_b_ssd_volume-1234")
+	AssertNoError(t, os.WriteFile(device, nil, 0600))
+	AssertNoError(t, os.WriteFile(other, nil, 0600))
+	AssertNoError(t, os.Symlink(device, link))
+
+	AssertTrue(t, sameDevicePath(device, device))
+	AssertTrue(t, sameDevicePath(device, link))
+	AssertFalse(t, sameDevicePath(other, link))
+	// the source of a bind mount of a block device is not a path
+	AssertFalse(t, sameDevicePath("udev", link))
+}
+
+func Test_findSCSIGenericDevicePath(t *testing.T) {
+	sysClassSCSIGeneric := t.TempDir()
+	volumeID := "6f3b6e1a-2f5c-4c8e-9f4b-1d2e3f4a5b6c"
+
+	serials := map[string]string{
+		"/dev/sg0": "SCW_b_ssd_volume-00000000-0000-0000-0000-000000000000",
+		"/dev/sg1": "SCW_b_ssd_volume-" + volumeID,
+	}
+	inquire := func(devicePath string) (string, error) {
+		serial, ok := serials[devicePath]
+		if !ok {
+			return "", errors.New("no serial")
+		}
+		return serial, nil
+	}
+	for device, block := range map[string]string{"sg0": "sda", "sg1": "data0", "sg2": "sdc"} {
+		AssertNoError(t, os.MkdirAll(filepath.Join(sysClassSCSIGeneric, device, "device", "block", block), 0750))
+	}
+
+	// the block device renamed by udev is found from its serial
+	devicePath, err := findSCSIGenericDevicePath(sysClassSCSIGeneric, volumeID, inquire)
+	AssertNoError(t, err)
+	Equals(t, "/dev/data0", devicePath)
+
+	devicePath, err = findSCSIGenericDevicePath(sysClassSCSIGeneric, "11111111-0000-0000-0000-000000000000", inquire)
+	AssertNoError(t, err)
+	Equals(t, "", devicePath)
+}
+
+func Test_parseVPDSerial(t *testing.T) {
+	serial, err := parseVPDSerial([]byte("\x00\x80\x00\x28SCW_b_ssd_volume-6f3b6e1a-2f5c-4c8e-9f4b\x00\x00"))
+	AssertNoError(t, err)
+	Equals(t, "SCW_b_ssd_volume-6f3b6e1a-2f5c-4c8e-9f4b", serial)
+
+	_, err = parseVPDSerial([]byte("\x00\x83\x00\x04abcd"))
+	AssertTrue(t, err != nil)
+
+	_, err = parseVPDSerial([]byte("\x00\x80\x00\x24SCW"))
+	AssertTrue(t, err != nil)
+}
+
+func Test_WaitDevicePathHint(t *testing.T) {
+	d := newDiskUtils(nil)
+	volumeID := "4b7d9f1a-2c3e-4a5b-8d6f-7e8a9b0c1d2e"
+	hint := diskByIDPath + "/nvme-Scaleway_Block_Storage_" + volumeID
+
+	// the hint is looked up first by the following lookups of the volume, until it is unstaged
+	_, err := d.WaitDevicePath(context.Background(), volumeID, hint+"/", 0)
+	AssertTrue(t, os.IsNotExist(err))
+	Equals(t, map[string]string{volumeID: hint}, d.devicePathHints)
+	d.ForgetDevicePath(volumeID)
+	Equals(t, 0, len(d.devicePathHints))
+
+	// the hints outside of /dev/disk/by-id are ignored
+	_, err = d.WaitDevicePath(context.Background(), volumeID, "/etc/passwd", 0)
+	AssertTrue(t, os.IsNotExist(err))
+	Equals(t, 0, len(d.devicePathHints))
+}
+
+func Test_shouldScanSCSI(t *testing.T) {
+	d := newDiskUtils(nil)
+	now := time.Now()
+
+	// the polls of the same volume only scan once per interval
+	AssertTrue(t, d.shouldScanSCSI("volume-1", now))
+	AssertFalse(t, d.shouldScanSCSI("volume-1", now.Add(devicePollInterval)))
+	AssertTrue(t, d.shouldScanSCSI("volume-2", now.Add(devicePollInterval)))
+	AssertTrue(t, d.shouldScanSCSI("volume-1", now.Add(scsiScanInterval)))
+
+	// the next stage of the volume scans right away
+	d.ForgetDevicePath("volume-1")
+	AssertTrue(t, d.shouldScanSCSI("volume-1", now.Add(scsiScanInterval)))
+}
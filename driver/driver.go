@@ -4,25 +4,47 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-csi/scheduler"
+	"github.com/scaleway/scaleway-sdk-go/scw"
 	"google.golang.org/grpc"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 )
 
-const (
+// DriverName and the topology keys are variables for the white-label deployments, see SetDriverName
+var (
 	// DriverName is the official name for the Scaleway CSI plugin
-	DriverName      = "csi.scaleway.com"
-	ZoneTopologyKey = "topology." + DriverName + "/zone"
+	DriverName      string
+	ZoneTopologyKey string
+	// RegionTopologyKey is the topology key of the region of the zone, published along with ZoneTopologyKey
+	RegionTopologyKey string
+)
+
+const (
+	// DefaultDriverName is the name of the driver unless changed with SetDriverName
+	DefaultDriverName = "csi.scaleway.com"
 
 	// ExtraUserAgentEnv is the environment variable that adds some string at the end of the user agent
 	ExtraUserAgentEnv = "EXTRA_USER_AGENT"
+	// NodeIDEnv and NodeZoneEnv are the environment variables setting the default NodeID and NodeZone
+	NodeIDEnv   = "NODE_ID"
+	NodeZoneEnv = "NODE_ZONE"
+
+	// NodeNameEnv is the environment variable setting the default NodeName
+	NodeNameEnv = "NODE_NAME"
 )
 
 // Mode represents the mode in which the CSI driver started
@@ -42,6 +64,148 @@ type DriverConfig struct {
 	Endpoint string
 	Prefix   string
 	Mode     Mode
+	// Backend is the backend of the volumes, ScalewayBackend if empty
+	Backend Backend
+
+	// DriverName replaces DefaultDriverName and TopologyPrefix the prefix of the topology keys if not empty, see SetDriverName
+	DriverName     string
+	TopologyPrefix string
+
+	// DeviceWaitTimeout is the maximum duration the node waits for the device of a volume to appear when staging it
+	DeviceWaitTimeout time.Duration
+
+	// ReservedVolumeSlots is the number of attachment slots of each instance kept for the volumes attached
+	// outside of the driver: they are not reported to the CO by the node and kept free by the controller
+	ReservedVolumeSlots int
+
+	// DiskPrefixes are the prefixes of the /dev/disk/by-id symlinks of the volumes, followed by their ID,
+	// DefaultDiskPrefixes if empty
+	DiskPrefixes []string
+	// CryptsetupPath is the path of the cryptsetup binary, looked up in the PATH and the sbin directories if empty
+	CryptsetupPath string
+
+	// NodeID is the ID of the instance of the node, or the providerID of its Kubernetes Node, and NodeZone its zone.
+	// They are only used when the metadata API is unreachable
+	NodeID   string
+	NodeZone scw.Zone
+
+	// NodeLabelTopology are the labels of the Kubernetes Node added to the topology of the node, along with
+	// the zone and region, their Node being the one of NodeName
+	NodeLabelTopology []string
+	NodeName          string
+
+	// StagingGCInterval is the interval between two sweeps of the stale staging directories, disabled if zero
+	StagingGCInterval time.Duration
+	// StagingGCMinAge is the age after which an empty and unmounted staging directory is considered stale
+	StagingGCMinAge time.Duration
+	// StagingGCRoot is the directory containing the staging directories of the volumes, DefaultStagingGCRoot if empty
+	StagingGCRoot string
+
+	// ScratchDir is the writable directory used by the node and the tools it runs for their temporary files,
+	// allowing to run with a read-only root filesystem. The default temporary directory is used if empty
+	ScratchDir string
+
+	// CleanupOnShutdown makes the node unmount all the volumes and close their LUKS mappings when stopped
+	CleanupOnShutdown bool
+
+	// LUKSJanitorInterval is the interval at which the node closes the LUKS mappings whose device
+	// no longer exists, disabled if zero
+	LUKSJanitorInterval time.Duration
+	// LUKSLazyClose makes the node defer the removal of the LUKS mappings still busy when unstaging
+	// their volume, instead of failing
+	LUKSLazyClose bool
+
+	// FormatTimeout bounds the formatting of the devices of the volumes, mkfs being killed after it if not zero
+	FormatTimeout time.Duration
+
+	// RequireEncryption makes the controller reject the creation of unencrypted volumes
+	RequireEncryption bool
+	// DisableEncryption makes the controller reject the creation of encrypted volumes and the node
+	// refuse to stage them, cryptsetup being never run, for the hosts where it is not installed
+	DisableEncryption bool
+
+	// EnableEphemeralVolumes makes the node create, attach and delete the volumes of the inline ephemeral volumes,
+	// with the Scaleway credentials of the node
+	EnableEphemeralVolumes bool
+
+	// ForceDeleteDetachedGrace is the duration after which a volume that is still attached
+	// to a server without any VolumeAttachment is detached on deletion, disabled if zero
+	ForceDeleteDetachedGrace time.Duration
+
+	// RefuseDeleteWithSnapshots makes the controller refuse to delete the volumes which still have snapshots,
+	// except the ones taken with retainSnapshotsOnVolumeDeleteKey, instead of deleting them along with their volume
+	RefuseDeleteWithSnapshots bool
+
+	// ParallelZoneCreate makes the controller create a volume in all the zones allowed by its topology
+	// at once, keeping the first one created, instead of trying the zones one after the other
+	ParallelZoneCreate bool
+
+	// MaxAPIReads and MaxAPIWrites limit the concurrent reads and writes to the Scaleway API, unlimited if zero
+	MaxAPIReads  int
+	MaxAPIWrites int
+
+	// CreateFailureBackoff is the duration during which a failed CreateVolume request returns its error again
+	// without calling the API, doubled at each new failure of the same request, disabled if zero
+	CreateFailureBackoff time.Duration
+
+	// ZoneExhaustionCooldown is the duration during which a zone out of stock is tried last
+	// when creating the volumes which can be created in several zones, disabled if zero
+	ZoneExhaustionCooldown time.Duration
+
+	// EnableSnapshotScheduler makes the controller create and rotate the VolumeSnapshots
+	// of the PersistentVolumeClaims annotated with scheduler.ScheduleAnnotation
+	EnableSnapshotScheduler bool
+
+	// EmitEvents makes the controller post Events on the PersistentVolumeClaims and the PersistentVolumes
+	// when their volume cannot be created or attached
+	EmitEvents bool
+
+	// ControllerRPCTimeout is the deadline of the controller RPCs, on top of the one of the caller, disabled if zero
+	ControllerRPCTimeout time.Duration
+
+	// APICacheTTL is the duration during which the volume and server lookups of the controller
+	// publications are reused, disabled if zero
+	APICacheTTL time.Duration
+
+	// ForceDetachInterval is the interval at which the controller looks for the VolumeAttachments
+	// and PersistentVolumes annotated with ForceDetachAnnotation, disabled if zero
+	ForceDetachInterval time.Duration
+
+	// ClusterID is the ID of the cluster set in a tag of the volumes it creates, not set if empty
+	ClusterID string
+
+	// DetachRetryInterval is the interval at which the detachments refused by the API with a transient error
+	// are retried in the background, after the deadline of their ControllerUnpublishVolume call, disabled if zero
+	DetachRetryInterval time.Duration
+
+	// OrphanGCInterval is the interval at which the controller looks for the volumes of ClusterID
+	// without PersistentVolume, disabled if zero
+	OrphanGCInterval time.Duration
+	// OrphanGCGrace is the duration after which a volume without PersistentVolume is reported as orphaned
+	OrphanGCGrace time.Duration
+	// OrphanGCDelete makes the controller delete the orphaned volumes instead of only reporting them
+	OrphanGCDelete bool
+
+	// ListManagedVolumesOnly makes ListVolumes only return the volumes with the managed-by tag of the driver,
+	// hiding the volumes created before the tag or imported
+	ListManagedVolumesOnly bool
+
+	// ConfigFile is the path of the YAML file with the settings changed while running without restarting:
+	// the verbosity of the logs, the rate limit of the Scaleway API calls, the events and the metrics.
+	// It is checked for changes every configReloadInterval, not used if empty.
+	ConfigFile string
+
+	// MetricsAddr is the address on which the metrics are served in the expvar format on /debug/vars, disabled if empty
+	MetricsAddr string
+
+	// DebugAddr is the address on which the pprof profiles and the state dump are served, on /debug/pprof/
+	// and debugStatePath, disabled if empty
+	DebugAddr string
+
+	// SelfTestAddr is the address on which the self-test HTTP trigger listens, disabled if empty
+	SelfTestAddr string
+	// SelfTestZone is the zone in which the self-test resources are created
+	SelfTestZone scw.Zone
 }
 
 // Driver implements the interfaces csi.IdentityServer, csi.ControllerServer and csi.NodeServer
@@ -51,29 +215,122 @@ type Driver struct {
 
 	config *DriverConfig
 
+	// kubeClient is only set when a controller feature needs to access the Kubernetes API
+	kubeClient kubernetes.Interface
+
+	// snapshotScheduler is only set when EnableSnapshotScheduler is enabled
+	snapshotScheduler *scheduler.Scheduler
+
+	// inflight keeps track of the RPCs being handled, for the state dump
+	inflight inflightOperations
+
+	// metricsDisabled is set by the runtime configuration of ConfigFile
+	metricsDisabled atomic.Bool
+
 	srv *grpc.Server
 }
 
 // NewDriver returns a CSI plugin
 func NewDriver(config *DriverConfig) (*Driver, error) {
+	scaleway.SetMaxConcurrentAPICalls(config.MaxAPIReads, config.MaxAPIWrites)
+
+	if config.DriverName != "" {
+		if err := SetDriverName(config.DriverName, config.TopologyPrefix); err != nil {
+			return nil, err
+		}
+	}
 	klog.Infof("Driver: %s Version: %s", DriverName, driverVersion)
 
 	driver := &Driver{
 		config: config,
 	}
 
+	if config.StagingGCRoot == "" {
+		config.StagingGCRoot = DefaultStagingGCRoot
+	}
+
+	if config.Mode != ControllerMode && config.ScratchDir != "" {
+		if err := useScratchDir(config.ScratchDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ReservedVolumeSlots < 0 {
+		return nil, fmt.Errorf("invalid number of reserved volume slots: %d", config.ReservedVolumeSlots)
+	}
+
+	if config.RequireEncryption && config.DisableEncryption {
+		return nil, fmt.Errorf("encryption cannot be both required and disabled")
+	}
+
+	if config.OrphanGCInterval > 0 && config.ClusterID == "" {
+		return nil, fmt.Errorf("the orphaned volumes can only be collected with a cluster ID")
+	}
+
+	newController, newNode := newControllerService, newNodeService
+	switch config.Backend {
+	case "", ScalewayBackend:
+	case FakeBackend:
+		klog.Warning("using the fake backend, volumes are only kept in memory and no device is ever mounted")
+		newController, newNode = newFakeServices()
+	default:
+		return nil, fmt.Errorf("unknown backend for driver: %s", config.Backend)
+	}
+
 	switch config.Mode {
 	case ControllerMode:
-		driver.controllerService = newControllerService(config)
+		driver.controllerService = newController(config)
 	case NodeMode:
-		driver.nodeService = newNodeService()
+		driver.nodeService = newNode(config)
 	case AllMode:
-		driver.controllerService = newControllerService(config)
-		driver.nodeService = newNodeService()
+		driver.controllerService = newController(config)
+		driver.nodeService = newNode(config)
 	default:
 		return nil, fmt.Errorf("unknown mode for driver: %s", config.Mode)
 	}
 
+	if config.Mode != ControllerMode && config.DisableEncryption {
+		driver.nodeService.diskUtils = noLUKSDiskUtils{driver.nodeService.diskUtils}
+		driver.nodeService.encryptionDisabled = true
+	}
+
+	if config.Mode != NodeMode && (config.ForceDeleteDetachedGrace > 0 || config.ForceDetachInterval > 0 || config.OrphanGCInterval > 0 || config.EmitEvents || config.ConfigFile != "") {
+		client, err := newKubeClient()
+		if err != nil {
+			return nil, err
+		}
+		driver.kubeClient = client
+		if config.ForceDeleteDetachedGrace > 0 {
+			driver.controllerService.volumeAttachments = &kubeVolumeAttachmentChecker{
+				client: client,
+			}
+		}
+		// the events can be enabled by the runtime configuration of ConfigFile
+		if config.EmitEvents || config.ConfigFile != "" {
+			driver.controllerService.events = newVolumeEvents(client)
+			driver.controllerService.events.muted.Store(!config.EmitEvents)
+		}
+	}
+
+	if config.Mode != ControllerMode && len(config.NodeLabelTopology) != 0 {
+		client, err := newKubeClient()
+		if err != nil {
+			return nil, err
+		}
+		driver.nodeService.labelTopology, err = newNodeLabelTopology(client, config.NodeName, config.NodeLabelTopology)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Mode != NodeMode && config.EnableSnapshotScheduler {
+		snapshotScheduler, err := scheduler.NewInCluster(DriverName)
+		if err != nil {
+			return nil, err
+		}
+		driver.snapshotScheduler = snapshotScheduler
+	}
+
 	return driver, nil
 }
 
@@ -114,13 +371,24 @@ func (d *Driver) Run() error {
 	logErrorHandler := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		resp, err := handler(ctx, req)
 		if err != nil {
-			klog.Errorf("error for %s: %v", info.FullMethod, err)
+			klog.FromContext(ctx).Error(err, "error for "+info.FullMethod)
 		}
 		return resp, err
 	}
 
+	interceptors := []grpc.UnaryServerInterceptor{tracingInterceptor, requestIDInterceptor, d.inflight.interceptor, logErrorHandler, apiErrorDetailsInterceptor}
+	if d.config.ControllerRPCTimeout > 0 {
+		interceptors = append(interceptors, controllerTimeoutInterceptor(d.config.ControllerRPCTimeout))
+	}
+	if d.controllerService.scopedClients != nil {
+		interceptors = append(interceptors, d.controllerService.scopedClients.interceptor())
+	}
+	if d.config.Backend == FakeBackend {
+		interceptors = append(interceptors, serializeInterceptor())
+	}
+
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logErrorHandler),
+		grpc.ChainUnaryInterceptor(interceptors...),
 	}
 
 	d.srv = grpc.NewServer(opts...)
@@ -140,14 +408,146 @@ func (d *Driver) Run() error {
 
 	}
 
+	var selfTestSrv *http.Server
+	if d.config.SelfTestAddr != "" && d.config.Mode != NodeMode {
+		mux := http.NewServeMux()
+		mux.Handle(selfTestPath, d.controllerService.selfTestHandler(d.config.SelfTestZone))
+		mux.Handle(supportBundlePath, d.supportBundleHandler())
+		selfTestSrv = &http.Server{
+			Addr:    d.config.SelfTestAddr,
+			Handler: mux,
+		}
+		go func() {
+			klog.Infof("self-test trigger listening on %s%s", d.config.SelfTestAddr, selfTestPath)
+			if err := selfTestSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				klog.Errorf("error serving self-test trigger: %s", err)
+			}
+		}()
+	}
+
+	var metricsSrv *http.Server
+	if d.config.MetricsAddr != "" {
+		metricsSrv = &http.Server{
+			Addr:    d.config.MetricsAddr,
+			Handler: d.metricsMux(),
+		}
+		go func() {
+			klog.Infof("metrics listening on %s/debug/vars", d.config.MetricsAddr)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				klog.Errorf("error serving metrics: %s", err)
+			}
+		}()
+	}
+
+	var debugSrv *http.Server
+	if d.config.DebugAddr != "" {
+		debugSrv = &http.Server{
+			Addr:    d.config.DebugAddr,
+			Handler: d.debugHandler(),
+		}
+		go func() {
+			klog.Infof("debug endpoints listening on %s/debug/pprof/ and %s%s", d.config.DebugAddr, d.config.DebugAddr, debugStatePath)
+			if err := debugSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				klog.Errorf("error serving debug endpoints: %s", err)
+			}
+		}()
+	}
+
+	d.handleStateDump()
+
+	stopConfigReload := make(chan struct{})
+	if d.config.ConfigFile != "" {
+		watcher := d.newRuntimeConfigWatcher()
+		if err := watcher.reload(); err != nil {
+			return err
+		}
+		go watcher.run(configReloadInterval, stopConfigReload)
+	}
+
+	stopStagingGC := make(chan struct{})
+	if d.config.StagingGCInterval > 0 && d.config.Mode != ControllerMode {
+		go d.nodeService.runStagingGC(d.config.StagingGCRoot, d.config.StagingGCInterval, d.config.StagingGCMinAge, stopStagingGC)
+	}
+
+	stopLUKSJanitor := make(chan struct{})
+	if d.config.LUKSJanitorInterval > 0 && d.config.Mode != ControllerMode {
+		go d.nodeService.runLUKSJanitor(d.config.LUKSJanitorInterval, stopLUKSJanitor)
+	}
+
+	stopForceDetach := make(chan struct{})
+	if d.config.ForceDetachInterval > 0 && d.config.Mode != NodeMode {
+		go d.controllerService.runForceDetachWatcher(d.kubeClient, d.config.ForceDetachInterval, stopForceDetach)
+	}
+
+	stopDetachRetries := make(chan struct{})
+	if d.config.DetachRetryInterval > 0 && d.config.Mode != NodeMode {
+		go d.controllerService.runDetachRetries(d.config.DetachRetryInterval, stopDetachRetries)
+	}
+
+	stopOrphanGC := make(chan struct{})
+	if d.config.OrphanGCInterval > 0 && d.config.Mode != NodeMode {
+		go d.controllerService.runOrphanGC(d.kubeClient, d.config.OrphanGCInterval, stopOrphanGC)
+	}
+
+	stopSnapshotScheduler := make(chan struct{})
+	if d.snapshotScheduler != nil {
+		go d.snapshotScheduler.Run(stopSnapshotScheduler)
+	}
+
+	cleanupOnShutdown := d.config.CleanupOnShutdown && d.config.Mode != ControllerMode
+	shutdownDone := make(chan struct{})
+
 	// graceful shutdown
 	gracefulStop := make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
+		defer close(shutdownDone)
 		<-gracefulStop
+		close(stopConfigReload)
+		close(stopStagingGC)
+		close(stopLUKSJanitor)
+		close(stopForceDetach)
+		close(stopDetachRetries)
+		close(stopOrphanGC)
+		close(stopSnapshotScheduler)
+		if selfTestSrv != nil {
+			selfTestSrv.Close()
+		}
+		if metricsSrv != nil {
+			metricsSrv.Close()
+		}
+		if debugSrv != nil {
+			debugSrv.Close()
+		}
 		d.srv.GracefulStop()
+
+		if cleanupOnShutdown {
+			klog.Infof("cleaning up the volumes of the node before exiting")
+			if err := d.nodeService.cleanupVolumes(); err != nil {
+				klog.Errorf("error cleaning up the volumes of the node: %s", err)
+			}
+		}
 	}()
 
 	klog.Infof("CSI server started on %s", d.config.Endpoint)
-	return d.srv.Serve(listener)
+	if err := d.srv.Serve(listener); err != nil {
+		return err
+	}
+	if cleanupOnShutdown {
+		// Serve returns as soon as the server is stopping, the volumes are cleaned up afterwards
+		<-shutdownDone
+	}
+	return nil
+}
+
+// controllerTimeoutInterceptor returns a grpc unary interceptor setting the given deadline on the controller RPCs
+func controllerTimeoutInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, "/csi.v1.Controller/") {
+			return handler(ctx, req)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
 }
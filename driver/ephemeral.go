@@ -0,0 +1,252 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ephemeralContextKey is set to "true" by the kubelet in the volume context of the inline ephemeral volumes
+	ephemeralContextKey = "csi.storage.k8s.io/ephemeral"
+	// ephemeralSizeKey is the volume attribute holding the size of an ephemeral volume, defaultEphemeralSize if not set
+	ephemeralSizeKey = "size"
+	// defaultEphemeralSize is the size of the ephemeral volumes without ephemeralSizeKey
+	defaultEphemeralSize = 5 * 1000 * 1000 * 1000
+
+	// ephemeralVolumePrefix prefixes the name of the volumes created for the inline ephemeral volumes,
+	// followed by the volume ID generated by the kubelet
+	ephemeralVolumePrefix = "ephemeral-"
+	// ephemeralVolumeIDPrefix prefixes the volume IDs generated by the kubelet for the inline ephemeral volumes
+	ephemeralVolumeIDPrefix = "csi-"
+	// ephemeralTag is set on the volumes created for the inline ephemeral volumes
+	ephemeralTag = "ephemeral"
+)
+
+// isEphemeralVolume returns true if the volume context is the one of an inline ephemeral volume
+func isEphemeralVolume(volumeContext map[string]string) bool {
+	return volumeContext[ephemeralContextKey] == "true"
+}
+
+// isEphemeralVolumeID returns true if the volume ID was generated by the kubelet for an inline ephemeral volume,
+// the IDs of the volumes created by the controller being UUIDs prefixed by their zone
+func isEphemeralVolumeID(volumeID string) bool {
+	return strings.HasPrefix(volumeID, ephemeralVolumeIDPrefix) && !strings.Contains(volumeID, "/")
+}
+
+// publishEphemeralVolume creates the volume of an inline ephemeral volume in the zone of the node, attaches it
+// to the node, formats it and mounts it on the target path. It is idempotent, the volume being found by its name.
+func (d *nodeService) publishEphemeralVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if d.scaleway == nil {
+		return nil, status.Error(codes.InvalidArgument, "ephemeral volumes are not enabled on this node, see --enable-ephemeral-volumes")
+	}
+	if err := d.hostError(); err != nil {
+		return nil, err
+	}
+
+	targetPath := req.GetTargetPath()
+	if targetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "targetPath not provided")
+	}
+
+	mount := req.GetVolumeCapability().GetMount()
+	if mount == nil {
+		return nil, status.Error(codes.InvalidArgument, "ephemeral volumes can only be mounted")
+	}
+
+	size := int64(defaultEphemeralSize)
+	if value := req.GetVolumeContext()[ephemeralSizeKey]; value != "" {
+		parsedSize, err := parseSizeParameter(value)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %s: %s", ephemeralSizeKey, value, err)
+		}
+		size = parsedSize
+	}
+	volumeType := scaleway.DefaultVolumeType
+	if value := req.GetVolumeContext()[volumeTypeKey]; value != "" {
+		volumeType = instance.VolumeVolumeType(value)
+	}
+	passphrase := ""
+	if encrypted, _ := strconv.ParseBool(req.GetVolumeContext()[encryptedKey]); encrypted {
+		if d.encryptionDisabled {
+			return nil, status.Errorf(codes.FailedPrecondition, "ephemeral volume %s is encrypted and the encryption is disabled on this node", req.GetVolumeId())
+		}
+		// the passphrase comes from the nodePublishSecretRef of the inline volume
+		var ok bool
+		passphrase, ok = req.GetSecrets()[encryptionPassphraseKey]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "missing passphrase secret for key %s", encryptionPassphraseKey)
+		}
+	}
+
+	unlock := d.pathLocks.lock(targetPath)
+	defer unlock()
+
+	name := ephemeralVolumePrefix + req.GetVolumeId()
+	volume, err := d.getEphemeralVolume(ctx, name)
+	if errors.Is(err, scaleway.ErrVolumeNotFound) {
+		klog.FromContext(ctx).V(4).Info("creating ephemeral volume", "name", name, "size", size, "zone", d.nodeZone)
+		volumeResp, err := d.scaleway.CreateVolume(&instance.CreateVolumeRequest{
+			Zone:       d.nodeZone,
+			Name:       name,
+			Size:       scw.SizePtr(scw.Size(size)),
+			VolumeType: volumeType,
+			Tags:       []string{managedByTag, ephemeralTag},
+		}, scw.WithContext(ctx))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "error creating ephemeral volume %s: %s", name, err)
+		}
+		volume = volumeResp.Volume
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "error looking for ephemeral volume %s: %s", name, err)
+	}
+
+	if volume.Server == nil {
+		volume, err = d.scaleway.WaitForVolumeContext(ctx, &instance.WaitForVolumeRequest{
+			VolumeID: volume.ID,
+			Zone:     volume.Zone,
+		})
+		if err != nil {
+			return nil, waitError(ctx, err)
+		}
+		if err := d.attachEphemeralVolume(ctx, volume); err != nil {
+			return nil, err
+		}
+	} else if volume.Server.ID != d.nodeID {
+		return nil, status.Errorf(codes.FailedPrecondition, "ephemeral volume %s is attached to server %s", volume.ID, volume.Server.ID)
+	}
+
+	devicePath, err := d.diskUtils.WaitDevicePath(ctx, volume.ID, expectedDevicePath(volume), d.deviceWaitTimeout)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "device of ephemeral volume %s not found: %s", volume.ID, err)
+	}
+
+	if passphrase != "" {
+		devicePath, err = d.diskUtils.EncryptAndOpenDevice(volume.ID, passphrase)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "error encrypting/opening ephemeral volume %s: %s", volume.ID, err)
+		}
+	}
+
+	isMounted, err := d.diskUtils.IsSharedMounted(targetPath, devicePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error checking mount point of ephemeral volume %s on path %s: %s", volume.ID, targetPath, err)
+	}
+	if isMounted {
+		klog.FromContext(ctx).V(4).Info("ephemeral volume already mounted", "volumeID", volume.ID, "targetPath", targetPath)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	if err := createMountPoint(targetPath, false); err != nil {
+		return nil, status.Errorf(codes.Internal, "error creating mount point %s for ephemeral volume %s: %s", targetPath, volume.ID, err)
+	}
+	mountOptions := mount.GetMountFlags()
+	if req.GetReadonly() {
+		mountOptions = append(mountOptions, "ro")
+	}
+	if err := d.diskUtils.FormatAndMount(targetPath, devicePath, mount.GetFsType(), mountOptions, nil); err != nil {
+		return nil, status.Errorf(codes.Internal, "error formatting and mounting ephemeral volume %s on %s: %s", volume.ID, targetPath, err)
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// attachEphemeralVolume attaches the volume to the node, unless the node already has the maximum number of volumes
+// it reports to the CO attached, the ephemeral volumes using the same slots as the volumes published by the controller
+func (d *nodeService) attachEphemeralVolume(ctx context.Context, volume *instance.Volume) error {
+	d.ephemeralAttachMux.Lock()
+	defer d.ephemeralAttachMux.Unlock()
+
+	serverResp, err := d.scaleway.GetServer(&instance.GetServerRequest{
+		Zone:     d.nodeZone,
+		ServerID: d.nodeID,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		return status.Errorf(codes.Internal, "error getting node %s: %s", d.nodeID, err)
+	}
+	volumesCount := len(serverResp.Server.Volumes) - scaleway.ServerLocalVolumesCount(serverResp.Server)
+	if int64(volumesCount) >= d.maxVolumes {
+		return status.Errorf(codes.ResourceExhausted, "max number of volumes (%d) for node %s", d.maxVolumes, d.nodeID)
+	}
+
+	klog.FromContext(ctx).V(4).Info("attaching ephemeral volume", "volumeID", volume.ID, "nodeID", d.nodeID)
+	_, err = d.scaleway.AttachVolume(&instance.AttachVolumeRequest{
+		Zone:     volume.Zone,
+		ServerID: d.nodeID,
+		VolumeID: volume.ID,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		return status.Errorf(codes.Internal, "error attaching ephemeral volume %s to node %s: %s", volume.ID, d.nodeID, err)
+	}
+	return nil
+}
+
+// getEphemeralVolume returns the ephemeral volume with the given name, always created in the zone of the node
+// whatever the zones of the client, or scaleway.ErrVolumeNotFound
+func (d *nodeService) getEphemeralVolume(ctx context.Context, name string) (*instance.Volume, error) {
+	volumesResp, err := d.scaleway.ListVolumes(&instance.ListVolumesRequest{
+		Zone: d.nodeZone,
+		Name: &name,
+		Tags: []string{ephemeralTag},
+	}, scw.WithContext(ctx), scw.WithAllPages())
+	if err != nil {
+		return nil, err
+	}
+	for _, volume := range volumesResp.Volumes {
+		if volume.Name == name { // fuzzy search on the API
+			return volume, nil
+		}
+	}
+	return nil, scaleway.ErrVolumeNotFound
+}
+
+// deleteEphemeralVolume detaches and deletes the volume of the inline ephemeral volume with the given ID, once unmounted
+func (d *nodeService) deleteEphemeralVolume(ctx context.Context, volumeID string) error {
+	name := ephemeralVolumePrefix + volumeID
+	volume, err := d.getEphemeralVolume(ctx, name)
+	if errors.Is(err, scaleway.ErrVolumeNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error looking for ephemeral volume %s: %w", name, err)
+	}
+
+	if volume.Server != nil {
+		if err := d.diskUtils.CloseDevice(volume.ID); err != nil {
+			return fmt.Errorf("error closing encrypted ephemeral volume %s: %w", volume.ID, err)
+		}
+		klog.FromContext(ctx).V(4).Info("detaching ephemeral volume", "volumeID", volume.ID, "nodeID", volume.Server.ID)
+		_, err = d.scaleway.DetachVolume(&instance.DetachVolumeRequest{
+			Zone:     volume.Zone,
+			VolumeID: volume.ID,
+		}, scw.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("error detaching ephemeral volume %s: %w", volume.ID, err)
+		}
+		if _, err := d.scaleway.WaitForVolumeContext(scaleway.ContextWithWriteSlots(ctx), &instance.WaitForVolumeRequest{
+			VolumeID: volume.ID,
+			Zone:     volume.Zone,
+		}); err != nil {
+			return fmt.Errorf("error waiting for the detachment of ephemeral volume %s: %w", volume.ID, err)
+		}
+	}
+
+	klog.FromContext(ctx).V(4).Info("deleting ephemeral volume", "volumeID", volume.ID)
+	err = d.scaleway.DeleteVolume(&instance.DeleteVolumeRequest{
+		Zone:     volume.Zone,
+		VolumeID: volume.ID,
+	}, scw.WithContext(ctx))
+	if _, ok := err.(*scw.ResourceNotFoundError); err != nil && !ok {
+		return fmt.Errorf("error deleting ephemeral volume %s: %w", volume.ID, err)
+	}
+	return nil
+}
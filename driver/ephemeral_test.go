@@ -0,0 +1,114 @@
+package driver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	kmount "k8s.io/mount-utils"
+	kexec "k8s.io/utils/exec"
+)
+
+func Test_publishEphemeralVolume(t *testing.T) {
+	server := &instance.Server{ID: "node-id", Zone: scw.ZoneFrPar2, Volumes: map[string]*instance.VolumeServer{}}
+	fake := &fakeHelper{
+		fakeDiskUtils: fakeDiskUtils{
+			kMounter: &kmount.SafeFormatAndMount{
+				Interface: kmount.New(""),
+				Exec:      kexec.New(),
+			},
+			devices:     map[string]*mountpoint{},
+			allAttached: true,
+		},
+		fakeInstanceAPI: fakeInstanceAPI{
+			volumesMap:  map[string]*instance.Volume{},
+			serversMap:  map[string]*instance.Server{server.ID: server},
+			defaultZone: scw.ZoneFrPar1,
+		},
+	}
+	d := &nodeService{
+		diskUtils:  fake,
+		nodeID:     server.ID,
+		nodeZone:   server.Zone,
+		maxVolumes: 1,
+		scaleway:   &scaleway.Scaleway{InstanceAPI: fake},
+	}
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "csi-0123456789abcdef",
+		TargetPath: filepath.Join(t.TempDir(), "target"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+		VolumeContext: map[string]string{ephemeralContextKey: "true", ephemeralSizeKey: "10G"},
+	}
+	_, err := d.NodePublishVolume(context.Background(), req)
+	AssertNoError(t, err)
+	_, err = d.NodePublishVolume(context.Background(), req)
+	AssertNoError(t, err)
+
+	// the volume is created once, in the zone of the node, and attached to it
+	Equals(t, 1, len(fake.volumesMap))
+	for _, volume := range fake.volumesMap {
+		Equals(t, ephemeralVolumePrefix+req.GetVolumeId(), volume.Name)
+		Equals(t, server.Zone, volume.Zone)
+		Equals(t, scw.Size(10*1000*1000*1000), volume.Size)
+		Equals(t, server.ID, volume.Server.ID)
+	}
+
+	_, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   req.GetVolumeId(),
+		TargetPath: req.GetTargetPath(),
+	})
+	AssertNoError(t, err)
+	Equals(t, 0, len(fake.volumesMap))
+
+	// the ephemeral volumes use the attachment slots reported to the CO
+	server.Volumes["0"] = &instance.VolumeServer{ID: "published-volume", VolumeType: instance.VolumeServerVolumeTypeBSSD}
+	_, err = d.NodePublishVolume(context.Background(), req)
+	Equals(t, codes.ResourceExhausted, status.Code(err))
+	server.Volumes["0"].VolumeType = instance.VolumeServerVolumeTypeLSSD
+	_, err = d.NodePublishVolume(context.Background(), req)
+	AssertNoError(t, err)
+	_, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   req.GetVolumeId(),
+		TargetPath: req.GetTargetPath(),
+	})
+	AssertNoError(t, err)
+
+	// the encrypted ephemeral volumes need the passphrase of their nodePublishSecretRef
+	req.VolumeContext[encryptedKey] = "true"
+	_, err = d.NodePublishVolume(context.Background(), req)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+	req.Secrets = map[string]string{encryptionPassphraseKey: "passphrase"}
+	d.encryptionDisabled = true
+	_, err = d.NodePublishVolume(context.Background(), req)
+	Equals(t, codes.FailedPrecondition, status.Code(err))
+	d.encryptionDisabled = false
+	_, err = d.NodePublishVolume(context.Background(), req)
+	AssertNoError(t, err)
+	_, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   req.GetVolumeId(),
+		TargetPath: req.GetTargetPath(),
+	})
+	AssertNoError(t, err)
+
+	// the ephemeral volumes are refused by the nodes missing dependencies
+	d.hostProblems = []string{"cryptsetup not found"}
+	_, err = d.NodePublishVolume(context.Background(), req)
+	Equals(t, codes.FailedPrecondition, status.Code(err))
+	Equals(t, 0, len(fake.volumesMap))
+	d.hostProblems = nil
+
+	// the ephemeral volumes are refused by the nodes where they are not enabled
+	d.scaleway = nil
+	_, err = d.NodePublishVolume(context.Background(), req)
+	Equals(t, codes.InvalidArgument, status.Code(err))
+}
@@ -25,4 +25,5 @@ var (
 	errLimitBytesLessThanMinimum       = errors.New("limit size is less than the minimun size")
 	errRequiredBytesGreaterThanMaximun = errors.New("required size is greater than the maximum size")
 	errLimitBytesGreaterThanMaximum    = errors.New("limit size is greater than the maximum size")
+	errDefaultSizeOutOfRange           = errors.New("default size is not between the minimum and maximum sizes")
 )
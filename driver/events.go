@@ -0,0 +1,112 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// the keys of the volume parameters set by the external-provisioner with --extra-create-metadata
+	pvcNameKey      = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+	pvNameKey       = "csi.storage.k8s.io/pv/name"
+
+	// the reasons of the Warning Events posted by the controller
+	eventZoneExhausted      = "ZoneExhausted"
+	eventAttachLimitReached = "AttachLimitReached"
+	eventRateLimited        = "RateLimited"
+	eventSnapshotWait       = "SnapshotWaitTimeout"
+
+	// the reasons of the Normal Events marking the milestones of the lifecycle of the volumes
+	eventProvisioned = "VolumeProvisioned"
+	eventAttached    = "VolumeAttached"
+	eventExpanded    = "VolumeExpanded"
+)
+
+// volumeEvents posts Events on the Kubernetes objects of the volumes, Warning ones so that the users can understand
+// a stuck PersistentVolumeClaim without reading the logs of the controller, and Normal ones for the milestones
+// of the volumes, provisioned, attached and expanded.
+// A nil or muted *volumeEvents posts nothing.
+type volumeEvents struct {
+	recorder record.EventRecorder
+	// muted is set by the runtime configuration to stop posting Events without restarting
+	muted atomic.Bool
+}
+
+// newVolumeEvents returns a volumeEvents posting with the given client. The Events of an object are
+// aggregated and rate limited by the recorder, so a failing call retried by the sidecars does not flood the API.
+func newVolumeEvents(client kubernetes.Interface) *volumeEvents {
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: 5,
+		QPS:       1. / 60,
+	})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return &volumeEvents{
+		recorder: broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: DriverName}),
+	}
+}
+
+func (e *volumeEvents) warn(object *corev1.ObjectReference, reason string, messageFmt string, args ...interface{}) {
+	e.post(object, corev1.EventTypeWarning, reason, messageFmt, args...)
+}
+
+func (e *volumeEvents) normal(object *corev1.ObjectReference, reason string, messageFmt string, args ...interface{}) {
+	e.post(object, corev1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+func (e *volumeEvents) post(object *corev1.ObjectReference, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if e == nil || object == nil || e.muted.Load() {
+		return
+	}
+	e.recorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+// createFailed posts the Event explaining a failed volume creation, if any
+func (e *volumeEvents) createFailed(ctx context.Context, object *corev1.ObjectReference, fromSnapshot bool, err error) {
+	switch err.(type) {
+	case *scw.OutOfStockError, *scw.QuotasExceededError:
+		e.warn(object, eventZoneExhausted, "volume could not be created: %s", err)
+		return
+	}
+	switch {
+	case isRateLimitedError(err):
+		e.warn(object, eventRateLimited, "the Scaleway API is rate limiting the controller, the creation will be retried: %s", err)
+	case fromSnapshot && ctx.Err() != nil:
+		e.warn(object, eventSnapshotWait, "the volume is still being restored from its snapshot at the deadline of the call, it will be waited for again: %s", err)
+	}
+}
+
+// pvcReference returns the reference of the PersistentVolumeClaim of a volume being created,
+// or of its future PersistentVolume when the external-provisioner does not pass the metadata of the claim
+func pvcReference(parameters map[string]string, volumeName string) *corev1.ObjectReference {
+	if name, namespace := parameters[pvcNameKey], parameters[pvcNamespaceKey]; name != "" && namespace != "" {
+		return &corev1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Name: name, Namespace: namespace}
+	}
+	return pvReference(volumeName)
+}
+
+// pvReference returns the reference of the PersistentVolume with the given name
+func pvReference(pvName string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{Kind: "PersistentVolume", APIVersion: "v1", Name: pvName}
+}
+
+// pvNameFromVolumeName returns the name of the PersistentVolume of a Scaleway volume created by the driver,
+// its name being the one of the PersistentVolume with the prefix of the driver
+func pvNameFromVolumeName(volumeName string, prefix string) string {
+	return strings.TrimPrefix(volumeName, prefix)
+}
+
+// isRateLimitedError returns true if the Scaleway API refused the call because of its rate limits
+func isRateLimitedError(err error) bool {
+	responseErr, ok := err.(*scw.ResponseError)
+	return ok && responseErr.StatusCode == http.StatusTooManyRequests
+}
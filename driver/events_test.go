@@ -0,0 +1,53 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_volumeEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	events := &volumeEvents{recorder: recorder}
+
+	pvc := pvcReference(map[string]string{pvcNameKey: "data", pvcNamespaceKey: "default"}, "pvc-1234")
+	Equals(t, "PersistentVolumeClaim", pvc.Kind)
+	Equals(t, "PersistentVolume", pvcReference(map[string]string{}, "pvc-1234").Kind)
+
+	events.createFailed(context.Background(), pvc, false, &scw.OutOfStockError{Resource: "volume"})
+	events.createFailed(context.Background(), pvc, false, &scw.ResponseError{StatusCode: http.StatusTooManyRequests})
+	events.createFailed(context.Background(), pvc, false, &scw.ResourceNotFoundError{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	events.createFailed(ctx, pvc, true, context.Canceled)
+
+	posted := []string{}
+	close(recorder.Events)
+	for event := range recorder.Events {
+		posted = append(posted, event)
+	}
+	Equals(t, 3, len(posted))
+	AssertTrue(t, strings.HasPrefix(posted[0], "Warning "+eventZoneExhausted))
+	AssertTrue(t, strings.HasPrefix(posted[1], "Warning "+eventRateLimited))
+	AssertTrue(t, strings.HasPrefix(posted[2], "Warning "+eventSnapshotWait))
+
+	// the milestones of the volumes are posted as Normal Events
+	recorder = record.NewFakeRecorder(10)
+	events = &volumeEvents{recorder: recorder}
+	events.normal(pvReference("pvc-1234"), eventAttached, "volume %s attached to instance %s", "volume-id", "server-id")
+	Equals(t, "Normal "+eventAttached+" volume volume-id attached to instance server-id", <-recorder.Events)
+
+	// a muted volumeEvents posts nothing
+	events.muted.Store(true)
+	events.normal(pvReference("pvc-1234"), eventExpanded, "not posted")
+	Equals(t, 0, len(recorder.Events))
+
+	// a nil volumeEvents posts nothing
+	var disabled *volumeEvents
+	disabled.warn(pvc, eventRateLimited, "not posted")
+}
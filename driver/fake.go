@@ -0,0 +1,620 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"golang.org/x/sys/unix"
+	kmount "k8s.io/mount-utils"
+	utilsio "k8s.io/utils/io"
+)
+
+// fakeHelper implements scaleway.InstanceAPI and DiskUtils in memory, for the tests and the fake backend
+type fakeHelper struct {
+	fakeDiskUtils
+	fakeInstanceAPI
+}
+
+func convertVolumeVolumeServer(vol *instance.Volume) *instance.VolumeServer {
+	return &instance.VolumeServer{
+		ID:               vol.ID,
+		Name:             vol.Name,
+		Organization:     vol.Organization,
+		Size:             vol.Size,
+		VolumeType:       instance.VolumeServerVolumeType(vol.VolumeType),
+		CreationDate:     vol.CreationDate,
+		ModificationDate: vol.ModificationDate,
+		State:            instance.VolumeServerStateAvailable,
+		Project:          vol.Project,
+		Boot:             false,
+		Zone:             vol.Zone,
+	}
+}
+
+type fakeInstanceAPI struct {
+	volumesMap   map[string]*instance.Volume
+	serversMap   map[string]*instance.Server
+	snapshotsMap map[string]*instance.Snapshot
+	defaultZone  scw.Zone
+
+	// createMissingServers makes GetServer create the servers it does not know
+	createMissingServers bool
+
+	// serverTypes are returned by ListServersTypes
+	serverTypes map[string]*instance.ServerType
+}
+
+func (s *fakeHelper) ListVolumesTypes(req *instance.ListVolumesTypesRequest, opts ...scw.RequestOption) (*instance.ListVolumesTypesResponse, error) {
+	return &instance.ListVolumesTypesResponse{
+		Volumes: map[string]*instance.VolumeType{
+			instance.VolumeVolumeTypeBSSD.String(): {
+				Constraints: &instance.VolumeTypeConstraints{
+					Max: 10 * 1000 * 1000 * 1000 * 1000,
+					Min: 1 * 1000 * 1000 * 1000,
+				},
+			},
+		},
+	}, nil
+}
+
+func (s *fakeHelper) ListVolumes(req *instance.ListVolumesRequest, opts ...scw.RequestOption) (*instance.ListVolumesResponse, error) {
+	volumes := make([]*instance.Volume, 0)
+	for _, v := range s.volumesMap {
+		if req.Name == nil || strings.Contains(v.Name, *req.Name) {
+			if hasTags(v.Tags, req.Tags) {
+				volumes = append(volumes, v)
+			}
+		}
+	}
+	return &instance.ListVolumesResponse{Volumes: volumes, TotalCount: uint32(len(volumes))}, nil
+}
+
+// hasTags returns true if all the wanted tags are in tags
+func hasTags(tags []string, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *fakeHelper) CreateVolume(req *instance.CreateVolumeRequest, opts ...scw.RequestOption) (*instance.CreateVolumeResponse, error) {
+	if req.Zone == "" {
+		req.Zone = s.defaultZone
+	}
+	volume := &instance.Volume{}
+	volume.ID = uuid.New().String()
+	volume.Zone = req.Zone
+	volume.VolumeType = req.VolumeType
+	if req.Size != nil {
+		volume.Size = *req.Size
+	} else if req.BaseVolume != nil {
+		baseVol, ok := s.volumesMap[*req.BaseVolume]
+		if !ok {
+			return nil, &scw.ResourceNotFoundError{}
+		}
+		volume.Size = baseVol.Size
+	} else if req.BaseSnapshot != nil {
+		baseSnap, ok := s.snapshotsMap[*req.BaseSnapshot]
+		if !ok {
+			return nil, &scw.ResourceNotFoundError{}
+		}
+		volume.Size = baseSnap.Size
+	} else {
+		return nil, &scw.ResponseError{StatusCode: 400}
+	}
+	volume.State = instance.VolumeStateAvailable
+	volume.Name = req.Name
+	volume.Tags = req.Tags
+	if req.Project != nil {
+		volume.Project = *req.Project
+	}
+
+	s.volumesMap[volume.ID] = volume
+	return &instance.CreateVolumeResponse{Volume: volume}, nil
+}
+
+func (s *fakeHelper) GetVolume(req *instance.GetVolumeRequest, opts ...scw.RequestOption) (*instance.GetVolumeResponse, error) {
+	if vol, ok := s.volumesMap[req.VolumeID]; ok {
+		return &instance.GetVolumeResponse{Volume: vol}, nil
+	}
+	return nil, &scw.ResourceNotFoundError{}
+}
+
+func (s *fakeHelper) UpdateVolume(req *instance.UpdateVolumeRequest, opts ...scw.RequestOption) (*instance.UpdateVolumeResponse, error) {
+	vol, ok := s.volumesMap[req.VolumeID]
+	if !ok {
+		return nil, &scw.ResourceNotFoundError{}
+	}
+
+	if req.Name != nil {
+		vol.Name = *req.Name
+	}
+	if req.Size != nil {
+		vol.Size = *req.Size
+	}
+	return &instance.UpdateVolumeResponse{
+		Volume: vol,
+	}, nil
+}
+
+func (s *fakeHelper) DeleteVolume(req *instance.DeleteVolumeRequest, opts ...scw.RequestOption) error {
+	if _, ok := s.volumesMap[req.VolumeID]; ok {
+		delete(s.volumesMap, req.VolumeID)
+		return nil
+	}
+	return &scw.ResourceNotFoundError{}
+}
+
+func (s *fakeHelper) ListServersTypes(req *instance.ListServersTypesRequest, opts ...scw.RequestOption) (*instance.ListServersTypesResponse, error) {
+	serverTypes := s.serverTypes
+	if serverTypes == nil {
+		serverTypes = map[string]*instance.ServerType{}
+	}
+	return &instance.ListServersTypesResponse{
+		Servers: serverTypes,
+	}, nil
+}
+
+func (s *fakeHelper) GetServer(req *instance.GetServerRequest, opts ...scw.RequestOption) (*instance.GetServerResponse, error) {
+	if srv, ok := s.serversMap[req.ServerID]; ok {
+		return &instance.GetServerResponse{Server: srv}, nil
+	}
+	if s.createMissingServers {
+		zone := req.Zone
+		if zone == "" {
+			zone = s.defaultZone
+		}
+		srv := &instance.Server{ID: req.ServerID, Zone: zone, Volumes: map[string]*instance.VolumeServer{}}
+		s.serversMap[srv.ID] = srv
+		return &instance.GetServerResponse{Server: srv}, nil
+	}
+	return nil, &scw.ResourceNotFoundError{}
+}
+
+func (s *fakeHelper) AttachVolume(req *instance.AttachVolumeRequest, opts ...scw.RequestOption) (*instance.AttachVolumeResponse, error) {
+	if vol, ok := s.volumesMap[req.VolumeID]; ok {
+		if srv, ok := s.serversMap[req.ServerID]; ok {
+			// emulate instance error if volume is already attached to server
+			for i := 0; i < maxVolumesPerNode; i++ {
+				key := fmt.Sprintf("%d", i)
+				if existingVol, ok := srv.Volumes[key]; ok && existingVol.ID == vol.ID {
+					return nil, &scw.InvalidArgumentsError{}
+				}
+			}
+
+			// add volume to volumes list
+			// We loop through all the possible volume keys (0 to len(volumes))
+			// to find a non existing key and assign it to the requested volume.
+			// A key should always be found. However we return an error if no keys were found.
+			found := false
+			for i := 0; i < maxVolumesPerNode; i++ {
+				key := fmt.Sprintf("%d", i)
+				if _, ok := srv.Volumes[key]; !ok {
+					vol.Server = &instance.ServerSummary{
+						ID: req.ServerID,
+					}
+					srv.Volumes[key] = convertVolumeVolumeServer(vol)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("could not find key to attach volume %s", req.VolumeID)
+			}
+
+			s.devices[path.Join(diskByIDPath, diskSCWPrefix+req.VolumeID)] = &mountpoint{
+				block: true,
+			}
+			return &instance.AttachVolumeResponse{Server: srv}, nil
+		}
+	}
+	return nil, &scw.ResourceNotFoundError{}
+}
+
+func (s *fakeHelper) DetachVolume(req *instance.DetachVolumeRequest, opts ...scw.RequestOption) (*instance.DetachVolumeResponse, error) {
+	if vol, ok := s.volumesMap[req.VolumeID]; ok {
+		if srv, ok := s.serversMap[vol.Server.ID]; ok {
+			// remove volume from volumes list
+			for key, volume := range srv.Volumes {
+				if volume.ID == req.VolumeID {
+					vol.Server = nil
+					delete(srv.Volumes, key)
+					break
+				}
+			}
+
+			delete(s.devices, path.Join(diskByIDPath, diskSCWPrefix+req.VolumeID))
+			return &instance.DetachVolumeResponse{Server: srv}, nil
+		}
+	}
+	return nil, &scw.ResourceNotFoundError{}
+}
+
+func (s *fakeHelper) WaitForVolume(req *instance.WaitForVolumeRequest, opts ...scw.RequestOption) (*instance.Volume, error) {
+	if vol, ok := s.volumesMap[req.VolumeID]; ok {
+		return vol, nil
+	}
+	return nil, &scw.ResourceNotFoundError{}
+}
+
+func (s *fakeHelper) GetSnapshot(req *instance.GetSnapshotRequest, opts ...scw.RequestOption) (*instance.GetSnapshotResponse, error) {
+	snapshot, ok := s.snapshotsMap[req.SnapshotID]
+	if !ok {
+		return nil, &scw.ResourceNotFoundError{}
+	}
+	return &instance.GetSnapshotResponse{
+		Snapshot: snapshot,
+	}, nil
+}
+
+func (s *fakeHelper) ListSnapshots(req *instance.ListSnapshotsRequest, opts ...scw.RequestOption) (*instance.ListSnapshotsResponse, error) {
+	snapshots := make([]*instance.Snapshot, 0)
+	for _, snap := range s.snapshotsMap {
+		if req.BaseVolumeID != nil && (snap.BaseVolume == nil || *req.BaseVolumeID != snap.BaseVolume.ID) {
+			continue
+		}
+
+		if req.Name != nil && !strings.Contains(snap.Name, *req.Name) {
+			continue
+		}
+
+		if snap.State == instance.SnapshotStateSnapshotting {
+			snap.State = instance.SnapshotStateAvailable
+		}
+		snapshots = append(snapshots, snap)
+	}
+	return &instance.ListSnapshotsResponse{Snapshots: snapshots, TotalCount: uint32(len(snapshots))}, nil
+}
+
+func (s *fakeHelper) CreateSnapshot(req *instance.CreateSnapshotRequest, opts ...scw.RequestOption) (*instance.CreateSnapshotResponse, error) {
+	if req.Zone == "" {
+		req.Zone = s.defaultZone
+	}
+
+	if req.VolumeID == nil {
+		// imported from Object Storage, the size of the object is unknown
+		snapshot := &instance.Snapshot{
+			ID:           uuid.New().String(),
+			Zone:         req.Zone,
+			Name:         req.Name,
+			VolumeType:   instance.VolumeVolumeType(req.VolumeType),
+			State:        instance.SnapshotStateImporting,
+			CreationDate: scw.TimePtr(time.Now()),
+		}
+		if req.Tags != nil {
+			snapshot.Tags = *req.Tags
+		}
+		s.snapshotsMap[snapshot.ID] = snapshot
+		return &instance.CreateSnapshotResponse{Snapshot: snapshot}, nil
+	}
+
+	volume, ok := s.volumesMap[*req.VolumeID]
+	if !ok {
+		return nil, &scw.ResourceNotFoundError{}
+	}
+	snapshot := &instance.Snapshot{}
+	snapshot.ID = uuid.New().String()
+	snapshot.Zone = req.Zone
+	snapshot.Name = req.Name
+	snapshot.VolumeType = volume.VolumeType
+	snapshot.Size = volume.Size
+	snapshot.State = instance.SnapshotStateSnapshotting
+	snapshot.BaseVolume = &instance.SnapshotBaseVolume{
+		ID:   volume.ID,
+		Name: volume.Name,
+	}
+	snapshot.CreationDate = scw.TimePtr(time.Now())
+	if req.Tags != nil {
+		snapshot.Tags = *req.Tags
+	}
+	s.snapshotsMap[snapshot.ID] = snapshot
+
+	return &instance.CreateSnapshotResponse{
+		Snapshot: snapshot,
+	}, nil
+}
+
+func (s *fakeHelper) DeleteSnapshot(req *instance.DeleteSnapshotRequest, opts ...scw.RequestOption) error {
+	if _, ok := s.snapshotsMap[req.SnapshotID]; ok {
+		delete(s.snapshotsMap, req.SnapshotID)
+		return nil
+	}
+	return &scw.ResourceNotFoundError{}
+}
+
+func (s *fakeHelper) ExportSnapshot(req *instance.ExportSnapshotRequest, opts ...scw.RequestOption) (*instance.ExportSnapshotResponse, error) {
+	if _, ok := s.snapshotsMap[req.SnapshotID]; ok {
+		return &instance.ExportSnapshotResponse{Task: &instance.Task{}}, nil
+	}
+	return nil, &scw.ResourceNotFoundError{}
+}
+
+func (s *fakeHelper) UpdateSnapshot(req *instance.UpdateSnapshotRequest, opts ...scw.RequestOption) (*instance.UpdateSnapshotResponse, error) {
+	if snap, ok := s.snapshotsMap[req.SnapshotID]; ok {
+		if req.Name != nil {
+			snap.Name = *req.Name
+		}
+		if req.Tags != nil {
+			snap.Tags = *req.Tags
+		}
+		return &instance.UpdateSnapshotResponse{Snapshot: snap}, nil
+	}
+	return nil, &scw.ResourceNotFoundError{}
+}
+
+func (s *fakeHelper) WaitForSnapshot(req *instance.WaitForSnapshotRequest, opts ...scw.RequestOption) (*instance.Snapshot, error) {
+	snapshot, ok := s.snapshotsMap[req.SnapshotID]
+	if !ok {
+		return nil, &scw.ResourceNotFoundError{}
+	}
+	snapshot.State = instance.SnapshotStateAvailable
+	return snapshot, nil
+}
+
+type mountpoint struct {
+	targetPath    string
+	fsType        string
+	mountOptions  []string
+	formatOptions []string
+	block         bool
+}
+
+type fakeDiskUtils struct {
+	kMounter *kmount.SafeFormatAndMount
+	devices  map[string]*mountpoint
+
+	// allAttached makes the devices of all the volumes present, attached or not
+	allAttached bool
+
+	// readOnlyDevices and formattedDevices are the devices set read-only and holding a filesystem
+	readOnlyDevices  map[string]bool
+	formattedDevices map[string]bool
+}
+
+func (s *fakeHelper) CheckFilesystem(ctx context.Context, devicePath string) error {
+	if s.readOnlyDevices[devicePath] {
+		return fmt.Errorf("fsck cannot open read-only device %s", devicePath)
+	}
+	return nil
+}
+
+// FormatAndMount is only used for non block devices
+func (s *fakeHelper) FormatAndMount(targetPath string, devicePath string, fsType string, mountOptions []string, formatOptions []string) error {
+	if fsType == "" {
+		fsType = defaultFSType
+	}
+
+	if s.readOnlyDevices[devicePath] {
+		if !s.formattedDevices[devicePath] {
+			return fmt.Errorf("cannot format read-only device %s", devicePath)
+		}
+		readOnly := false
+		for _, option := range mountOptions {
+			readOnly = readOnly || option == "ro"
+		}
+		if !readOnly {
+			return fmt.Errorf("cannot mount read-only device %s read-write", devicePath)
+		}
+	}
+	if s.formattedDevices == nil {
+		s.formattedDevices = map[string]bool{}
+	}
+	s.formattedDevices[devicePath] = true
+
+	s.devices[devicePath] = &mountpoint{
+		targetPath:    targetPath,
+		fsType:        fsType,
+		mountOptions:  mountOptions,
+		formatOptions: formatOptions,
+		block:         false,
+	}
+	return nil
+}
+
+func (s *fakeHelper) Unmount(target string) error {
+	for _, mp := range s.devices {
+		if mp.targetPath == target {
+			mp.targetPath = ""
+		}
+	}
+	return kmount.CleanupMountPoint(target, s.kMounter, true)
+}
+
+func (s *fakeHelper) MountToTarget(sourcePath, targetPath, fsType string, mountOptions []string) error {
+	if fsType == "" {
+		fsType = defaultFSType
+	}
+
+	s.devices[sourcePath] = &mountpoint{
+		targetPath:   targetPath,
+		fsType:       fsType,
+		mountOptions: mountOptions,
+		block:        strings.HasPrefix(sourcePath, diskByIDPath),
+	}
+	return nil
+}
+
+func (s *fakeHelper) GetDevicePath(volumeID string) (string, error) {
+	if _, ok := s.devices[path.Join(diskByIDPath, diskSCWPrefix+volumeID)]; ok || s.allAttached {
+		return path.Join(diskByIDPath, diskSCWPrefix+volumeID), nil
+	}
+
+	return "", os.ErrNotExist
+}
+
+func (s *fakeHelper) WaitDevicePath(ctx context.Context, volumeID string, devicePathHint string, timeout time.Duration) (string, error) {
+	return s.GetDevicePath(volumeID)
+}
+
+func (s *fakeHelper) ForgetDevicePath(volumeID string) {}
+
+func (s *fakeHelper) IsSharedMounted(targetPath string, devicePath string) (bool, error) {
+	if targetPath == "" {
+		return false, errTargetPathEmpty
+	}
+	if d, ok := s.devices[devicePath]; ok {
+		if d.targetPath == targetPath {
+			return true, nil
+		}
+		// another device mounted on the target path
+		for source, tp := range s.devices {
+			if tp.targetPath == targetPath && strings.HasPrefix(source, "/dev/") {
+				return false, errTargetNotMounterOnRightDevice
+			}
+		}
+		return false, nil
+	}
+
+	for _, tp := range s.devices {
+		if tp.targetPath == targetPath {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// taken from https://github.com/kubernetes/kubernetes/blob/master/pkg/util/mount/mount_linux.go
+func (s *fakeHelper) GetMountInfo(targetPath string) (*mountInfo, error) {
+	content, err := utilsio.ConsistentRead(procMountInfoPath, procMountInfoMaxListTries)
+	if err != nil {
+		return &mountInfo{}, err
+	}
+	contentStr := string(content)
+
+	for _, line := range strings.Split(contentStr, "\n") {
+		if line == "" {
+			// the last split() item is empty string following the last \n
+			continue
+		}
+		// See `man proc` for authoritative description of format of the file.
+		fields := strings.Fields(line)
+		if len(fields) < expectedAtLeastNumFieldsPerMountInfo {
+			return nil, fmt.Errorf("wrong number of fields in (expected at least %d, got %d): %s", expectedAtLeastNumFieldsPerMountInfo, len(fields), line)
+		}
+		if fields[4] != targetPath {
+			continue
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		parentID, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		info := &mountInfo{
+			id:           id,
+			parentID:     parentID,
+			majorMinor:   fields[2],
+			root:         fields[3],
+			mountPoint:   fields[4],
+			mountOptions: strings.Split(fields[5], ","),
+		}
+		// All fields until "-" are "optional fields".
+		i := 6
+		for ; i < len(fields) && fields[i] != "-"; i++ {
+			info.optionalFields = append(info.optionalFields, fields[i])
+		}
+		// Parse the rest 3 fields.
+		i++
+		if len(fields)-i < 3 {
+			return nil, fmt.Errorf("expect 3 fields in %s, got %d", line, len(fields)-i)
+		}
+		info.fsType = fields[i]
+		info.source = fields[i+1]
+		info.superOptions = strings.Split(fields[i+2], ",")
+		return info, nil
+	}
+	return &mountInfo{}, nil
+}
+
+func (s *fakeHelper) IsBlockDevice(path string) (bool, error) {
+	for _, mp := range s.devices {
+		if mp.targetPath == path {
+			return mp.block, nil
+		}
+	}
+	return false, fmt.Errorf("not found") // enough for csi sanity?
+}
+
+func (s *fakeHelper) GetStatfs(path string) (*unix.Statfs_t, error) {
+	return &unix.Statfs_t{
+		Blocks: 1000,
+		Bsize:  4,
+		Bfree:  500,
+		Files:  1000,
+		Ffree:  500,
+	}, nil
+}
+
+func (s *fakeHelper) Resize(targetPath string, devicePath, passphrase string) error {
+	for _, mp := range s.devices {
+		if mp.targetPath == targetPath && !mp.block {
+			_, _, err := resizeFsCommand(mp.fsType, devicePath, targetPath)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeHelper) NeedResize(devicePath string, targetPath string) (bool, error) {
+	return false, nil
+}
+
+func (s *fakeHelper) ResizeEncryptedDevice(mappedDevicePath string, passphrase string) error {
+	return nil
+}
+
+func (s *fakeHelper) IsEncrypted(devicePath string) (bool, error) {
+	return false, nil
+}
+
+func (s *fakeHelper) EncryptAndOpenDevice(volumeID string, passphrase string) (string, error) {
+	return "", nil
+}
+
+func (s *fakeHelper) CloseDevice(volumeID string) error {
+	return nil
+}
+
+func (s *fakeHelper) GetMappedDevicePath(volumeID string) (string, error) {
+	return "", nil
+}
+
+func (s *fakeHelper) CloseStaleDevices() (int, error) {
+	return 0, nil
+}
+
+func (s *fakeHelper) SetReadOnly(devicePath string, readOnly bool) error {
+	if s.readOnlyDevices == nil {
+		s.readOnlyDevices = map[string]bool{}
+	}
+	s.readOnlyDevices[devicePath] = readOnly
+	return nil
+}
+
+func (s *fakeHelper) SetXFSProjectQuota(mountPath string, dir string, projectID uint32, limit int64) error {
+	return os.MkdirAll(dir, 0750)
+}
+
+func (s *fakeHelper) RemoveXFSProjectQuota(mountPath string, dir string, projectID uint32) error {
+	return os.RemoveAll(dir)
+}
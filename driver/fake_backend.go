@@ -0,0 +1,86 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"google.golang.org/grpc"
+	kmount "k8s.io/mount-utils"
+	kexec "k8s.io/utils/exec"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+)
+
+// Backend is the backend of the volumes managed by the driver
+type Backend string
+
+const (
+	// ScalewayBackend manages Scaleway Block volumes through the Instance API
+	ScalewayBackend Backend = "scaleway"
+	// FakeBackend keeps the volumes and the snapshots in memory and never touches any device,
+	// to run the driver without Scaleway credentials nor instances (e.g. to test manifests in CI)
+	FakeBackend Backend = "fake"
+
+	// fakeBackendZone is the zone of the resources of the fake backend
+	fakeBackendZone = scw.ZoneFrPar1
+)
+
+// newFakeServices returns the constructors of the controller and node services of the fake backend,
+// sharing the same in-memory Instance API when the driver runs in all mode
+func newFakeServices() (func(*DriverConfig) controllerService, func(*DriverConfig) nodeService) {
+	fake := &fakeHelper{
+		fakeDiskUtils: fakeDiskUtils{
+			kMounter: &kmount.SafeFormatAndMount{
+				Interface: kmount.New(""),
+				Exec:      kexec.New(),
+			},
+			devices: make(map[string]*mountpoint),
+			// the node does not see the attachments made by the controller when they run separately
+			allAttached: true,
+		},
+		fakeInstanceAPI: fakeInstanceAPI{
+			volumesMap:   make(map[string]*instance.Volume),
+			serversMap:   make(map[string]*instance.Server),
+			snapshotsMap: make(map[string]*instance.Snapshot),
+			defaultZone:  fakeBackendZone,
+			// the controller does not know the nodes registered by the other processes
+			createMissingServers: true,
+		},
+	}
+
+	newController := func(config *DriverConfig) controllerService {
+		return controllerService{
+			config:            config,
+			scaleway:          &scaleway.Scaleway{InstanceAPI: fake},
+			attachedDeletions: make(map[string]time.Time),
+		}
+	}
+	newNode := func(config *DriverConfig) nodeService {
+		nodeID, err := os.Hostname()
+		if err != nil || nodeID == "" {
+			nodeID = "fake-node"
+		}
+		return nodeService{
+			diskUtils:         fake,
+			nodeID:            nodeID,
+			nodeZone:          fakeBackendZone,
+			deviceWaitTimeout: config.DeviceWaitTimeout,
+			maxVolumes:        maxVolumesPerNode - 1,
+		}
+	}
+	return newController, newNode
+}
+
+// serializeInterceptor runs the calls one at a time, the in-memory fakes not being safe for concurrent use
+func serializeInterceptor() grpc.UnaryServerInterceptor {
+	var mux sync.Mutex
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		mux.Lock()
+		defer mux.Unlock()
+		return handler(ctx, req)
+	}
+}
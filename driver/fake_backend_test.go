@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestFakeBackend(t *testing.T) {
+	d, err := NewDriver(&DriverConfig{Mode: AllMode, Backend: FakeBackend})
+	AssertNoError(t, err)
+	ctx := context.Background()
+
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	volume, err := d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-1234",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 10 * 1000 * 1000 * 1000},
+		VolumeCapabilities: []*csi.VolumeCapability{capability},
+	})
+	AssertNoError(t, err)
+
+	nodeInfo, err := d.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	AssertNoError(t, err)
+
+	publish, err := d.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         volume.GetVolume().GetVolumeId(),
+		NodeId:           nodeInfo.GetNodeId(),
+		VolumeCapability: capability,
+	})
+	AssertNoError(t, err)
+
+	_, err = d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volume.GetVolume().GetVolumeId(),
+		PublishContext:    publish.GetPublishContext(),
+		StagingTargetPath: filepath.Join(t.TempDir(), "globalmount"),
+		VolumeCapability:  capability,
+	})
+	AssertNoError(t, err)
+
+	_, err = NewDriver(&DriverConfig{Mode: AllMode, Backend: "unknown"})
+	AssertTrue(t, err != nil)
+}
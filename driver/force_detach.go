@@ -0,0 +1,146 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// ForceDetachAnnotation is the annotation an administrator sets to "true" on a VolumeAttachment
+// or a PersistentVolume of the driver to detach its volume, whatever the state of the attachment
+var ForceDetachAnnotation string
+
+// removeForceDetachAnnotationPatch is the merge patch removing ForceDetachAnnotation once the volume is detached
+var removeForceDetachAnnotationPatch []byte
+
+// runForceDetachWatcher detaches the volumes annotated with ForceDetachAnnotation every interval until stop is closed
+func (d *controllerService) runForceDetachWatcher(client kubernetes.Interface, interval time.Duration, stop <-chan struct{}) {
+	klog.Infof("looking for volumes annotated with %s every %s", ForceDetachAnnotation, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			detached, err := d.forceDetachAnnotated(ctx, client)
+			cancel()
+			if err != nil {
+				klog.Errorf("error detaching the volumes annotated with %s: %s", ForceDetachAnnotation, err)
+			}
+			if detached > 0 {
+				klog.Infof("force detached %d volume(s)", detached)
+			}
+		}
+	}
+}
+
+// forceDetachAnnotated detaches the volumes of the VolumeAttachments and PersistentVolumes annotated with ForceDetachAnnotation
+// and removes the annotation once done. It returns the number of detached volumes.
+func (d *controllerService) forceDetachAnnotated(ctx context.Context, client kubernetes.Interface) (int, error) {
+	detached := 0
+
+	attachments, err := client.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return detached, fmt.Errorf("error listing volume attachments: %w", err)
+	}
+	for _, attachment := range attachments.Items {
+		if attachment.Spec.Attacher != DriverName || attachment.Annotations[ForceDetachAnnotation] != "true" {
+			continue
+		}
+
+		var handle string
+		if inline := attachment.Spec.Source.InlineVolumeSpec; inline != nil && inline.CSI != nil {
+			handle = inline.CSI.VolumeHandle
+		} else if pvName := attachment.Spec.Source.PersistentVolumeName; pvName != nil {
+			pv, err := client.CoreV1().PersistentVolumes().Get(ctx, *pvName, metav1.GetOptions{})
+			if err != nil && !kerrors.IsNotFound(err) {
+				klog.Errorf("error getting persistent volume %s of volume attachment %s: %s", *pvName, attachment.Name, err)
+				continue
+			}
+			if err == nil && pv.Spec.CSI != nil {
+				handle = pv.Spec.CSI.VolumeHandle
+			}
+		}
+		if handle == "" {
+			klog.Warningf("volume attachment %s is annotated with %s but its volume could not be found", attachment.Name, ForceDetachAnnotation)
+			continue
+		}
+
+		if err := d.forceDetachHandle(ctx, handle, "volume attachment "+attachment.Name); err != nil {
+			klog.Errorf("error force detaching volume %s of volume attachment %s: %s", handle, attachment.Name, err)
+			continue
+		}
+		detached++
+
+		_, err = client.StorageV1().VolumeAttachments().Patch(ctx, attachment.Name, types.MergePatchType, removeForceDetachAnnotationPatch, metav1.PatchOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			klog.Errorf("error removing annotation %s from volume attachment %s: %s", ForceDetachAnnotation, attachment.Name, err)
+		}
+	}
+
+	pvs, err := client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return detached, fmt.Errorf("error listing persistent volumes: %w", err)
+	}
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != DriverName || pv.Annotations[ForceDetachAnnotation] != "true" {
+			continue
+		}
+
+		if err := d.forceDetachHandle(ctx, pv.Spec.CSI.VolumeHandle, "persistent volume "+pv.Name); err != nil {
+			klog.Errorf("error force detaching volume %s of persistent volume %s: %s", pv.Spec.CSI.VolumeHandle, pv.Name, err)
+			continue
+		}
+		detached++
+
+		_, err = client.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, removeForceDetachAnnotationPatch, metav1.PatchOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			klog.Errorf("error removing annotation %s from persistent volume %s: %s", ForceDetachAnnotation, pv.Name, err)
+		}
+	}
+
+	return detached, nil
+}
+
+// forceDetachHandle detaches the volume with the given CSI volume handle from its server, if any.
+// source is the annotated object, for the logs.
+func (d *controllerService) forceDetachHandle(ctx context.Context, handle string, source string) error {
+	volumeID, volumeZone, err := GetVolumeIDAndZone(handle)
+	if err != nil {
+		return err
+	}
+
+	volume, err := d.getVolume(ctx, volumeID, volumeZone)
+	if err != nil {
+		if _, ok := err.(*scw.ResourceNotFoundError); ok {
+			klog.Warningf("volume %s of %s not found, nothing to detach", volumeID, source)
+			return nil
+		}
+		return err
+	}
+	if volume.Server == nil {
+		klog.Infof("volume %s of %s is not attached, nothing to detach", volumeID, source)
+		return nil
+	}
+
+	klog.Warningf("force detaching volume %s from server %s as requested by the %s annotation on %s", volume.ID, volume.Server.ID, ForceDetachAnnotation, source)
+
+	unlock := d.lockAttach(volume.ID)
+	_, err = d.scaleway.DetachVolume(&instance.DetachVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	}, scw.WithContext(ctx))
+	unlock()
+	return err
+}
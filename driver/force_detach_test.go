@@ -0,0 +1,75 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_forceDetachAnnotated(t *testing.T) {
+	server := &instance.Server{ID: "server-id", Zone: scw.ZoneFrPar1}
+	volume := &instance.Volume{ID: "stuck-volume", Zone: scw.ZoneFrPar1, Server: &instance.ServerSummary{ID: server.ID}}
+	server.Volumes = map[string]*instance.VolumeServer{"1": {ID: volume.ID}}
+
+	fakeAPI := &fakeHelper{
+		fakeDiskUtils: fakeDiskUtils{devices: map[string]*mountpoint{}},
+		fakeInstanceAPI: fakeInstanceAPI{
+			volumesMap:  map[string]*instance.Volume{volume.ID: volume},
+			serversMap:  map[string]*instance.Server{server.ID: server},
+			defaultZone: scw.ZoneFrPar1,
+		},
+	}
+	d := &controllerService{
+		scaleway: &scaleway.Scaleway{InstanceAPI: fakeAPI},
+		config:   &DriverConfig{},
+	}
+
+	pvName := "pvc-1234"
+	client := fake.NewSimpleClientset(
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: pvName},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{
+						Driver:       DriverName,
+						VolumeHandle: "fr-par-1/stuck-volume",
+					},
+				},
+			},
+		},
+		&storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "csi-1234",
+				Annotations: map[string]string{ForceDetachAnnotation: "true"},
+			},
+			Spec: storagev1.VolumeAttachmentSpec{
+				Attacher: DriverName,
+				Source: storagev1.VolumeAttachmentSource{
+					PersistentVolumeName: &pvName,
+				},
+			},
+		},
+	)
+
+	detached, err := d.forceDetachAnnotated(context.Background(), client)
+	AssertNoError(t, err)
+	Equals(t, 1, detached)
+	AssertTrue(t, volume.Server == nil)
+
+	attachment, err := client.StorageV1().VolumeAttachments().Get(context.Background(), "csi-1234", metav1.GetOptions{})
+	AssertNoError(t, err)
+	_, annotated := attachment.Annotations[ForceDetachAnnotation]
+	AssertFalse(t, annotated)
+
+	// nothing is left to detach
+	detached, err = d.forceDetachAnnotated(context.Background(), client)
+	AssertNoError(t, err)
+	Equals(t, 0, detached)
+}
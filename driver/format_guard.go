@@ -0,0 +1,101 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// formatCheckDelay is the delay between the two checks of the signatures of a device before formatting it,
+	// so that a transient read error of the first check does not make a formatted device look empty
+	formatCheckDelay = time.Second
+
+	wipefsCmd = "wipefs"
+)
+
+// errDeviceNotEmpty is returned when the checks of a device with no filesystem found other signatures on it
+var errDeviceNotEmpty = errors.New("device is not empty")
+
+// formatIfEmpty formats the device with the filesystem type if it is explicitly empty: blkid finds no
+// filesystem nor partition table on it and wipefs no signature, twice with formatCheckDelay in between.
+// A device with a filesystem is left untouched, and the mount formats nothing as it finds the filesystem.
+// mkfs is killed after the timeout if not zero.
+func formatIfEmpty(devicePath string, fsType string, formatOptions []string, timeout time.Duration, getDiskFormat func(string) (string, error), run commandRunner) error {
+	empty, err := isEmptyDevice(devicePath, getDiskFormat, run)
+	if err != nil || !empty {
+		return err
+	}
+	time.Sleep(formatCheckDelay)
+	empty, err = isEmptyDevice(devicePath, getDiskFormat, run)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return fmt.Errorf("a signature appeared on device %s between two checks, not formatting it", devicePath)
+	}
+
+	args := []string{devicePath}
+	switch fsType {
+	case "ext4", "ext3":
+		args = []string{"-F", "-m0", devicePath}
+	case "xfs":
+		args = []string{"-f", devicePath}
+	}
+	args = append(formatOptions, args...)
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	klog.Infof("formatting empty device %s with type %s and args %v", devicePath, fsType, args)
+	start := time.Now()
+	code, out, err := run(ctx, "mkfs."+fsType, args...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("formatting device %s timed out after %s", devicePath, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("error formatting device %s: %w: %s", devicePath, err, out)
+	}
+	if code != 0 {
+		return fmt.Errorf("error formatting device %s (exit code %d): %s", devicePath, code, out)
+	}
+	formatDurations.since(start)
+	return nil
+}
+
+// isEmptyDevice returns true if the device has no filesystem nor partition table according to blkid,
+// and no signature according to wipefs when available. It returns errDeviceNotEmpty when wipefs
+// finds signatures blkid does not know, and an error whenever a check fails.
+func isEmptyDevice(devicePath string, getDiskFormat func(string) (string, error), run commandRunner) (bool, error) {
+	format, err := getDiskFormat(devicePath)
+	if err != nil {
+		return false, fmt.Errorf("error getting the format of device %s: %w", devicePath, err)
+	}
+	if format != "" {
+		return false, nil
+	}
+
+	code, out, err := run(context.Background(), wipefsCmd, "--no-act", "--noheadings", "--output", "TYPE,OFFSET", devicePath)
+	if errors.Is(err, exec.ErrNotFound) {
+		klog.V(4).Infof("%s not found, relying on blkid only to check that device %s is empty", wipefsCmd, devicePath)
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error listing the signatures of device %s: %w: %s", devicePath, err, out)
+	}
+	if code != 0 {
+		return false, fmt.Errorf("error listing the signatures of device %s (exit code %d): %s", devicePath, code, out)
+	}
+	if signatures := strings.TrimSpace(out); signatures != "" {
+		return false, fmt.Errorf("%w: %s has the signatures %s unknown to blkid, wipe them if the device must be formatted", errDeviceNotEmpty, devicePath, strings.Join(strings.Fields(signatures), " "))
+	}
+	return true, nil
+}
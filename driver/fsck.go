@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// fsckBeforeMountKey makes the node check the filesystem of the volume before mounting it when staging it
+	fsckBeforeMountKey = "fsckBeforeMount"
+
+	// e2fsckUncorrected is the lowest bit of the exit code of e2fsck meaning the errors were not all corrected,
+	// 1 and 2 meaning they were
+	e2fsckUncorrected = 4
+)
+
+// commandRunner runs a command, returning its exit code and its output
+type commandRunner func(ctx context.Context, name string, args ...string) (int, string, error)
+
+// runCommand runs the command on the host, the exit code being non zero without error when the command failed
+func runCommand(ctx context.Context, name string, args ...string) (int, string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return exitErr.ExitCode(), string(out), nil
+	}
+	if err != nil {
+		return 0, string(out), err
+	}
+	return 0, string(out), nil
+}
+
+// checkFilesystem checks the filesystem of the device before mounting it, e.g. after an unclean detachment:
+// e2fsck -p fixes what is safe to fix on the ext filesystems, and xfs_repair -n only looks for corruptions
+// on the xfs ones, its repairs being able to lose data. It returns an error if the filesystem still has errors.
+// The devices without filesystem and the other filesystems are not checked.
+func checkFilesystem(ctx context.Context, devicePath string, getDiskFormat func(string) (string, error), run commandRunner) error {
+	format, err := getDiskFormat(devicePath)
+	if err != nil {
+		return fmt.Errorf("error getting the filesystem of device %s: %w", devicePath, err)
+	}
+
+	switch {
+	case strings.HasPrefix(format, "ext"):
+		klog.FromContext(ctx).V(4).Info("checking the filesystem with e2fsck", "devicePath", devicePath, "fsType", format)
+		code, out, err := run(ctx, "e2fsck", "-p", devicePath)
+		if err != nil {
+			return fmt.Errorf("error running e2fsck on device %s: %w", devicePath, err)
+		}
+		if code >= e2fsckUncorrected {
+			return fmt.Errorf("e2fsck found errors it could not correct on device %s (exit code %d): %s", devicePath, code, out)
+		}
+		if code != 0 {
+			klog.FromContext(ctx).Info("e2fsck corrected errors", "devicePath", devicePath, "output", out)
+		}
+	case format == "xfs":
+		klog.FromContext(ctx).V(4).Info("checking the filesystem with xfs_repair", "devicePath", devicePath, "fsType", format)
+		code, out, err := run(ctx, "xfs_repair", "-n", devicePath)
+		if err != nil {
+			return fmt.Errorf("error running xfs_repair on device %s: %w", devicePath, err)
+		}
+		if code != 0 {
+			return fmt.Errorf("xfs_repair found corruptions on device %s (exit code %d), it must be repaired by hand: %s", devicePath, code, out)
+		}
+	default:
+		klog.FromContext(ctx).V(4).Info("no filesystem to check", "devicePath", devicePath, "fsType", format)
+	}
+	return nil
+}
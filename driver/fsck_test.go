@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_checkFilesystem(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		code    int
+		wantCmd string
+		wantErr bool
+	}{
+		{name: "clean ext4", format: "ext4", code: 0, wantCmd: "e2fsck"},
+		{name: "corrected ext4", format: "ext4", code: 1, wantCmd: "e2fsck"},
+		{name: "uncorrected ext4", format: "ext4", code: 4, wantCmd: "e2fsck", wantErr: true},
+		{name: "clean xfs", format: "xfs", code: 0, wantCmd: "xfs_repair"},
+		{name: "corrupted xfs", format: "xfs", code: 1, wantCmd: "xfs_repair", wantErr: true},
+		{name: "no filesystem", format: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranCmd := ""
+			run := func(ctx context.Context, name string, args ...string) (int, string, error) {
+				ranCmd = name
+				Equals(t, "/dev/sdb", args[len(args)-1])
+				return tt.code, "", nil
+			}
+			getDiskFormat := func(string) (string, error) {
+				return tt.format, nil
+			}
+			err := checkFilesystem(context.Background(), "/dev/sdb", getDiskFormat, run)
+			Equals(t, tt.wantErr, err != nil)
+			Equals(t, tt.wantCmd, ranCmd)
+		})
+	}
+
+	run := func(ctx context.Context, name string, args ...string) (int, string, error) {
+		return 0, "", errors.New("executable file not found")
+	}
+	err := checkFilesystem(context.Background(), "/dev/sdb", func(string) (string, error) { return "ext4", nil }, run)
+	AssertTrue(t, err != nil)
+}
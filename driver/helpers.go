@@ -1,17 +1,29 @@
 package driver
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
 	"github.com/scaleway/scaleway-sdk-go/scw"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/klog/v2"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
 )
 
 func getSnapshotIDAndZone(id string) (string, scw.Zone, error) {
@@ -22,7 +34,9 @@ func getSourceVolumeIDAndZone(id string) (string, scw.Zone, error) {
 	return extractIDAndZone(id, "sourceVolumeID")
 }
 
-func getVolumeIDAndZone(id string) (string, scw.Zone, error) {
+// GetVolumeIDAndZone returns the ID and the zone of the volume with the given CSI volume handle, like zone/uuid,
+// the zone being empty for the legacy handles without zone or with an unknown one
+func GetVolumeIDAndZone(id string) (string, scw.Zone, error) {
 	return extractIDAndZone(id, "volumeID")
 }
 
@@ -42,7 +56,7 @@ func extractIDAndZone(id string, name string) (string, scw.Zone, error) {
 	} else { // id like zone/uuid
 		zone, err := scw.ParseZone(splitID[0])
 		if err != nil {
-			klog.Warningf("wrong zone in %s, will try default zone", name)
+			klog.Warningf("wrong zone in %s, will look into all zones", name)
 			return splitID[1], scw.Zone(""), nil
 		}
 		return splitID[1], zone, nil
@@ -53,20 +67,9 @@ func chooseZones(accessibilityRequirements *csi.TopologyRequirement, snapshotZon
 	if accessibilityRequirements != nil {
 		requestedZones := map[string]scw.Zone{}
 		for _, req := range accessibilityRequirements.GetRequisite() {
-			topologyKeys := req.GetSegments()
-			for topologyKey, topologyValue := range topologyKeys {
-				switch topologyKey {
-				case ZoneTopologyKey:
-					zone, err := scw.ParseZone(topologyValue)
-					if err != nil {
-						klog.Warningf("the given value for requisite %s: %s is not a valid zone", ZoneTopologyKey, topologyValue)
-						continue
-					}
-					if snapshotZone == scw.Zone("") || snapshotZone == zone {
-						requestedZones[topologyValue] = zone
-					}
-				default:
-					klog.Warningf("unknow topology key %s for requisite", topologyKey)
+			for _, zone := range topologyZones(req.GetSegments(), "requisite") {
+				if snapshotZone == scw.Zone("") || snapshotZone == zone {
+					requestedZones[zone.String()] = zone
 				}
 			}
 		}
@@ -74,30 +77,19 @@ func chooseZones(accessibilityRequirements *csi.TopologyRequirement, snapshotZon
 		preferredZones := []scw.Zone{}
 		preferredZonesMap := map[string]scw.Zone{}
 		for _, pref := range accessibilityRequirements.GetPreferred() {
-			topologyKeys := pref.GetSegments()
-			for topologyKey, topologyValue := range topologyKeys {
-				switch topologyKey {
-				case ZoneTopologyKey:
-					zone, err := scw.ParseZone(topologyValue)
-					if err != nil {
-						klog.Warningf("the given value for preferred %s: %s is not a valid zone", ZoneTopologyKey, topologyValue)
-						continue
-					}
-					if snapshotZone == scw.Zone("") || snapshotZone == zone {
-						if _, ok := preferredZonesMap[topologyValue]; !ok {
-							if accessibilityRequirements.GetRequisite() != nil {
-								if _, ok := requestedZones[topologyValue]; !ok {
-									return nil, status.Errorf(codes.InvalidArgument, "%s: %s is specified in preferred but not in requisite", topologyKey, topologyValue)
-								}
-								delete(requestedZones, topologyValue)
+			for _, zone := range topologyZones(pref.GetSegments(), "preferred") {
+				if snapshotZone == scw.Zone("") || snapshotZone == zone {
+					if _, ok := preferredZonesMap[zone.String()]; !ok {
+						if accessibilityRequirements.GetRequisite() != nil {
+							if _, ok := requestedZones[zone.String()]; !ok {
+								return nil, status.Errorf(codes.InvalidArgument, "%s: %s is specified in preferred but not in requisite", ZoneTopologyKey, zone)
 							}
-
-							preferredZonesMap[topologyValue] = zone
-							preferredZones = append(preferredZones, zone)
+							delete(requestedZones, zone.String())
 						}
+
+						preferredZonesMap[zone.String()] = zone
+						preferredZones = append(preferredZones, zone)
 					}
-				default:
-					klog.Warningf("unknow topology key %s for preferred", topologyKey)
 				}
 			}
 		}
@@ -119,7 +111,7 @@ func chooseZones(accessibilityRequirements *csi.TopologyRequirement, snapshotZon
 	return []scw.Zone{}, nil
 }
 
-func validateVolumeCapabilities(volumeCapabilities []*csi.VolumeCapability) error {
+func validateVolumeCapabilities(volumeCapabilities []*csi.VolumeCapability, accessModes []csi.VolumeCapability_AccessMode_Mode) error {
 	if volumeCapabilities == nil {
 		return errVolumeCapabilitiesIsNil
 	}
@@ -128,7 +120,7 @@ func validateVolumeCapabilities(volumeCapabilities []*csi.VolumeCapability) erro
 	mount := false
 
 	for _, volumeCapability := range volumeCapabilities {
-		err := validateVolumeCapability(volumeCapability)
+		err := validateVolumeCapability(volumeCapability, accessModes)
 		if err != nil {
 			return err
 		}
@@ -147,22 +139,63 @@ func validateVolumeCapabilities(volumeCapabilities []*csi.VolumeCapability) erro
 	return nil
 }
 
-func validateVolumeCapability(volumeCapability *csi.VolumeCapability) error {
+func validateVolumeCapability(volumeCapability *csi.VolumeCapability, accessModes []csi.VolumeCapability_AccessMode_Mode) error {
 	if volumeCapability == nil {
 		return errVolumeCapabilityIsNil
 	}
 
-	for _, accessMode := range supportedAccessModes {
-		if accessMode.Mode == volumeCapability.GetAccessMode().GetMode() {
+	for _, accessMode := range accessModes {
+		if accessMode == volumeCapability.GetAccessMode().GetMode() {
 			return nil
 		}
 	}
 	return errAccessModeNotSupported
 }
 
-func getVolumeRequestCapacity(minSize int64, maxSize int64, capacityRange *csi.CapacityRange) (int64, error) {
+// accessModesForClass returns the access modes supported by the volumes of the given class
+func accessModesForClass(class scaleway.VolumeClass) []csi.VolumeCapability_AccessMode_Mode {
+	accessModes := []csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+	}
+	if class.MaxAttachments > 1 {
+		accessModes = append(accessModes,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		)
+		if class.MultiWriter {
+			accessModes = append(accessModes, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)
+		}
+	}
+	return accessModes
+}
+
+// nodeAccessModes returns the access modes supported by at least one class of volumes,
+// the node does not know the class of the volumes it handles
+func nodeAccessModes() []csi.VolumeCapability_AccessMode_Mode {
+	seen := make(map[csi.VolumeCapability_AccessMode_Mode]bool)
+	accessModes := []csi.VolumeCapability_AccessMode_Mode{}
+	for _, class := range scaleway.VolumeClasses() {
+		for _, accessMode := range accessModesForClass(class) {
+			if !seen[accessMode] {
+				seen[accessMode] = true
+				accessModes = append(accessModes, accessMode)
+			}
+		}
+	}
+	return accessModes
+}
+
+func getVolumeRequestCapacity(minSize int64, maxSize int64, defaultSize int64, capacityRange *csi.CapacityRange) (int64, error) {
+	if defaultSize == 0 {
+		defaultSize = minSize
+	}
+	if defaultSize < minSize || defaultSize > maxSize {
+		return 0, errDefaultSizeOutOfRange
+	}
+
 	if capacityRange == nil {
-		return minSize, nil
+		return defaultSize, nil
 	}
 
 	requiredBytes := capacityRange.GetRequiredBytes()
@@ -172,7 +205,7 @@ func getVolumeRequestCapacity(minSize int64, maxSize int64, capacityRange *csi.C
 	limitBytesSet := limitBytes > 0
 
 	if !requiredBytesSet && !limitBytesSet {
-		return minSize, nil
+		return defaultSize, nil
 	}
 
 	if requiredBytesSet && limitBytesSet && limitBytes < requiredBytes {
@@ -210,14 +243,304 @@ func getVolumeRequestCapacity(minSize int64, maxSize int64, capacityRange *csi.C
 	return minSize, nil
 }
 
+// getProjectID returns the Scaleway project ID in which the resources must be created,
+// the one from the secrets taking precedence over the one from the parameters
+func getProjectID(parameters map[string]string, secrets map[string]string) string {
+	if projectID := secrets[projectIDKey]; projectID != "" {
+		return projectID
+	}
+	if projectID := secrets[defaultProjectIDSecretKey]; projectID != "" {
+		return projectID
+	}
+	for key, value := range parameters {
+		if strings.EqualFold(key, projectIDKey) {
+			return value
+		}
+	}
+	return ""
+}
+
+// withVolumeMetadata returns a copy of the given volume context with the Scaleway ID
+// and the creation date of the volume, so that they are visible on the Kubernetes objects
+func withVolumeMetadata(volumeContext map[string]string, volume *instance.Volume) map[string]string {
+	newVolumeContext := make(map[string]string, len(volumeContext)+2)
+	for key, value := range volumeContext {
+		newVolumeContext[key] = value
+	}
+	newVolumeContext[scwVolumeID] = volume.ID
+	if volume.CreationDate != nil {
+		newVolumeContext[scwVolumeCreationDate] = volume.CreationDate.UTC().Format(time.RFC3339)
+	}
+	return newVolumeContext
+}
+
+// publishContext returns the publish context of the given volume
+func publishContext(volume *instance.Volume, readOnly bool) map[string]string {
+	publishContext := map[string]string{
+		scwVolumeName: volume.Name,
+		scwVolumeID:   volume.ID,
+		scwVolumeZone: volume.Zone.String(),
+		scwDevicePath: expectedDevicePath(volume),
+	}
+	if readOnly {
+		publishContext[scwVolumeReadOnly] = "true"
+	}
+	if hasTag(volume.Tags, managedByTag) {
+		publishContext[scwVolumeManaged] = "true"
+	}
+	return publishContext
+}
+
+// waitError returns the gRPC error of a failed operation, Aborted if the context of the RPC is done,
+// so that the CO retries the call instead of piling up new calls behind the one still running
+func waitError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return status.Errorf(codes.Aborted, "operation still in progress at the deadline of the call: %s", err)
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// getExportBucket returns the Object Storage bucket the snapshots must be exported to, if any
+func getExportBucket(parameters map[string]string) string {
+	for key, value := range parameters {
+		if strings.EqualFold(key, exportBucketKey) {
+			return value
+		}
+	}
+	return ""
+}
+
+// attachableVolumes returns the number of volumes of the driver which can be attached to an instance accepting
+// maxVolumes volumes, once its local volumes and the slots reserved for the volumes attached outside of the
+// driver are subtracted. The node reports it in NodeGetInfo and the controller enforces it when publishing.
+func attachableVolumes(maxVolumes int, localVolumes int, reservedSlots int) int {
+	attachable := maxVolumes - localVolumes - reservedSlots
+	if attachable < 1 {
+		// 0 would mean no limit for the CO
+		attachable = 1
+	}
+	return attachable
+}
+
+// containsZone returns true if zone is in zones
+func containsZone(zones []scw.Zone, zone scw.Zone) bool {
+	for _, z := range zones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeCondition returns the condition of the volume from its state and, if known, the one of the server it is attached to
+func volumeCondition(volume *instance.Volume, server *instance.Server) *csi.VolumeCondition {
+	switch volume.State {
+	case instance.VolumeStateError:
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("volume %s is in error state", volume.ID)}
+	case instance.VolumeStateSnapshotting, instance.VolumeStateResizing, instance.VolumeStateFetching, instance.VolumeStateSaving, instance.VolumeStateHotsyncing:
+		return &csi.VolumeCondition{Message: fmt.Sprintf("volume %s is %s", volume.ID, volume.State)}
+	}
+	if server != nil && server.State == instance.ServerStateLocked {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("server %s of volume %s is locked", server.ID, volume.ID)}
+	}
+	return &csi.VolumeCondition{Message: fmt.Sprintf("volume %s is %s", volume.ID, volume.State)}
+}
+
+// expectedDevicePath returns the /dev/disk/by-id symlink of the device of the volume, named after its SCSI serial
+func expectedDevicePath(volume *instance.Volume) string {
+	return filepath.Join(diskByIDPath, "scsi-0SCW_"+string(volume.VolumeType)+"_volume-"+volume.ID)
+}
+
+// parseSizeParameter parses a size parameter of a StorageClass, a quantity such as 500Gi or 500G
+func parseSizeParameter(value string) (int64, error) {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, err
+	}
+	if quantity.Sign() <= 0 {
+		return 0, errors.New("the size must be positive")
+	}
+	return quantity.Value(), nil
+}
+
+// getMaxSizeTag returns the maximum size of the volume held by its maxSizeTagPrefix tag, 0 if there is none
+func getMaxSizeTag(tags []string) int64 {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, maxSizeTagPrefix) {
+			maxSize, err := strconv.ParseInt(strings.TrimPrefix(tag, maxSizeTagPrefix), 10, 64)
+			if err == nil {
+				return maxSize
+			}
+		}
+	}
+	return 0
+}
+
+// hasTag returns true if the given tag is in the tags
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// getTagValue returns the value of the tag with the given prefix, and false if there is none
+func getTagValue(tags []string, prefix string) (string, bool) {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, prefix) {
+			return strings.TrimPrefix(tag, prefix), true
+		}
+	}
+	return "", false
+}
+
+// existingVolumeMismatches returns how a volume found by name differs from the one requested to CreateVolume.
+// The encryption and the source snapshot are only compared on the volumes tagged with encryptedTagPrefix,
+// the volumes created by older versions of the driver not recording them.
+func existingVolumeMismatches(volume *instance.Volume, size int64, volumeType instance.VolumeVolumeType, encrypted bool, sourceSnapshotID string) []string {
+	var mismatches []string
+	if int64(volume.Size) != size {
+		mismatches = append(mismatches, fmt.Sprintf("size %d instead of %d", volume.Size, size))
+	}
+	if volume.VolumeType != volumeType {
+		mismatches = append(mismatches, fmt.Sprintf("type %s instead of %s", volume.VolumeType, volumeType))
+	}
+	volumeEncrypted, ok := getTagValue(volume.Tags, encryptedTagPrefix)
+	if !ok {
+		return mismatches
+	}
+	if volumeEncrypted != strconv.FormatBool(encrypted) {
+		mismatches = append(mismatches, fmt.Sprintf("encryption %s instead of %t", volumeEncrypted, encrypted))
+	}
+	volumeSnapshotID, _ := getTagValue(volume.Tags, sourceSnapshotTagPrefix)
+	if volumeSnapshotID != sourceSnapshotID {
+		switch {
+		case volumeSnapshotID == "":
+			mismatches = append(mismatches, fmt.Sprintf("no source instead of snapshot %s", sourceSnapshotID))
+		case sourceSnapshotID == "":
+			mismatches = append(mismatches, fmt.Sprintf("snapshot %s as source instead of none", volumeSnapshotID))
+		default:
+			mismatches = append(mismatches, fmt.Sprintf("snapshot %s as source instead of %s", volumeSnapshotID, sourceSnapshotID))
+		}
+	}
+	return mismatches
+}
+
+// getVolumeTags returns the tags of a new volume: managedByTag and, when the external-provisioner
+// passes them with --extra-create-metadata, the name and the namespace of the PersistentVolumeClaim
+func getVolumeTags(parameters map[string]string) []string {
+	tags := []string{managedByTag}
+	if pvcName := parameters[pvcNameKey]; pvcName != "" {
+		tags = append(tags, pvcNameTagPrefix+pvcName)
+	}
+	if pvcNamespace := parameters[pvcNamespaceKey]; pvcNamespace != "" {
+		tags = append(tags, namespaceTagPrefix+pvcNamespace)
+	}
+	return tags
+}
+
+// getSnapshotTags returns the tags of a new snapshot: managedByTag, the comma-separated tags
+// and the description given in the parameters of the VolumeSnapshotClass.
+// The ${volumesnapshot.name}, ${volumesnapshot.namespace} and ${volumesnapshotcontent.name} variables
+// are replaced with the metadata passed by the external-snapshotter.
+func getSnapshotTags(parameters map[string]string) []string {
+	replacer := strings.NewReplacer(
+		"${volumesnapshot.name}", parameters[volumeSnapshotNameKey],
+		"${volumesnapshot.namespace}", parameters[volumeSnapshotNamespaceKey],
+		"${volumesnapshotcontent.name}", parameters[volumeSnapshotContentNameKey],
+	)
+
+	tags := []string{managedByTag}
+	description := ""
+	for key, value := range parameters {
+		switch {
+		case strings.EqualFold(key, snapshotTagsKey):
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					tags = append(tags, replacer.Replace(tag))
+				}
+			}
+		case strings.EqualFold(key, descriptionKey):
+			description = strings.TrimSpace(value)
+		case strings.EqualFold(key, retainSnapshotsOnVolumeDeleteKey):
+			if retain, _ := strconv.ParseBool(value); retain {
+				tags = append(tags, retainOnVolumeDeleteTag)
+			}
+		}
+	}
+	if description != "" {
+		tags = append(tags, descriptionTagPrefix+replacer.Replace(description))
+	}
+	return tags
+}
+
 func newAccessibleTopology(zone scw.Zone) []*csi.Topology {
 	return []*csi.Topology{
 		{
-			Segments: map[string]string{ZoneTopologyKey: zone.String()},
+			Segments: topologySegments(zone),
 		},
 	}
 }
 
+// topologySegments returns the topology segments of the given zone, with its region
+func topologySegments(zone scw.Zone) map[string]string {
+	segments := map[string]string{ZoneTopologyKey: zone.String()}
+	if region, err := zone.Region(); err == nil {
+		segments[RegionTopologyKey] = region.String()
+	}
+	return segments
+}
+
+// topologyZones returns the zones of the given topology segments: the one of ZoneTopologyKey,
+// or all the zones of the region of RegionTopologyKey for the segments without a zone
+func topologyZones(segments map[string]string, kind string) []scw.Zone {
+	var zones []scw.Zone
+	var region scw.Region
+	for topologyKey, topologyValue := range segments {
+		switch topologyKey {
+		case ZoneTopologyKey:
+			zone, err := scw.ParseZone(topologyValue)
+			if err != nil {
+				klog.Warningf("the given value for %s %s: %s is not a valid zone", kind, ZoneTopologyKey, topologyValue)
+				continue
+			}
+			zones = append(zones, zone)
+		case RegionTopologyKey:
+			parsedRegion, err := scw.ParseRegion(topologyValue)
+			if err != nil {
+				klog.Warningf("the given value for %s %s: %s is not a valid region", kind, RegionTopologyKey, topologyValue)
+				continue
+			}
+			region = parsedRegion
+		default:
+			klog.Warningf("unknow topology key %s for %s", topologyKey, kind)
+		}
+	}
+	if len(zones) == 0 && region != "" {
+		return region.GetZones()
+	}
+	return zones
+}
+
+// useScratchDir makes the driver and the tools it runs (mkfs, blkid, cryptsetup...)
+// write their temporary files in the given directory
+func useScratchDir(dir string) error {
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		return fmt.Errorf("error creating scratch directory %s: %w", dir, err)
+	}
+	for key, value := range map[string]string{
+		"TMPDIR":     dir,
+		"BLKID_FILE": filepath.Join(dir, "blkid.tab"),
+	} {
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func createMountPoint(path string, file bool) error {
 	_, err := os.Stat(path)
 	if err != nil {
@@ -230,28 +553,128 @@ func createMountPoint(path string, file bool) error {
 		dir := filepath.Dir(path)
 		err := os.MkdirAll(dir, os.FileMode(0755))
 		if err != nil {
-			return err
+			return readOnlyMountPointError(path, err)
 		}
 		file, err := os.OpenFile(path, os.O_CREATE, os.FileMode(0644))
 		defer file.Close()
 		if err != nil {
-			return err
+			return readOnlyMountPointError(path, err)
 		}
 	} else {
 		err := os.MkdirAll(path, os.FileMode(0755))
 		if err != nil {
-			return err
+			return readOnlyMountPointError(path, err)
 		}
 	}
 	return nil
 }
 
+// readOnlyMountPointError explains that the mount points must be on a writable volume
+// when the node runs with a read-only root filesystem
+func readOnlyMountPointError(path string, err error) error {
+	if errors.Is(err, syscall.EROFS) {
+		return fmt.Errorf("%s is on a read-only filesystem, the kubelet directory must be mounted read-write in the node plugin: %w", path, err)
+	}
+	return err
+}
+
 var secretsField = "Secrets"
 
-func stripSecretFromReq(req interface{}) string {
+// redactedSecret replaces the values of the secrets in the logs
+const redactedSecret = "<redacted>"
+
+// strippedReq lazily formats a request without its secrets, so that nothing
+// is computed when the log line using it is disabled by the klog verbosity
+type strippedReq struct {
+	req interface{}
+}
+
+// stripSecretFromReq returns a fmt.Stringer printing the given request with its secrets redacted
+func stripSecretFromReq(req interface{}) fmt.Stringer {
+	return strippedReq{req: req}
+}
+
+func (r strippedReq) String() string {
+	message, ok := r.req.(protov1.Message)
+	if !ok {
+		return stripSecretFromReqReflect(r.req)
+	}
+	stripped := protov1.Clone(message)
+	redactMessage(protov1.MessageReflect(stripped))
+	return stripped.String()
+}
+
+// redactMessage redacts in place the secrets of the message and of the messages it contains,
+// the fields named secrets or marked with the csi_secret option of the CSI spec
+func redactMessage(message protoreflect.Message) {
+	// the fields are collected first, the message cannot be modified while ranging over it
+	var fields []protoreflect.FieldDescriptor
+	message.Range(func(field protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, field)
+		return true
+	})
+
+	for _, field := range fields {
+		value := message.Get(field)
+		switch {
+		case isSecretField(field):
+			redactField(message, field, value)
+		case field.IsMap():
+			if field.MapValue().Kind() == protoreflect.MessageKind {
+				value.Map().Range(func(_ protoreflect.MapKey, entry protoreflect.Value) bool {
+					redactMessage(entry.Message())
+					return true
+				})
+			}
+		case field.IsList():
+			if field.Kind() == protoreflect.MessageKind {
+				for i := 0; i < value.List().Len(); i++ {
+					redactMessage(value.List().Get(i).Message())
+				}
+			}
+		case field.Kind() == protoreflect.MessageKind:
+			redactMessage(value.Message())
+		}
+	}
+}
+
+// isSecretField returns true if the field holds secrets
+func isSecretField(field protoreflect.FieldDescriptor) bool {
+	if field.Name() == "secrets" {
+		return true
+	}
+	secret, _ := protov2.GetExtension(field.Options(), csi.E_CsiSecret).(bool)
+	return secret
+}
+
+// redactField replaces the value of the secret field with redactedSecret, keeping the keys of the maps of secrets
+func redactField(message protoreflect.Message, field protoreflect.FieldDescriptor, value protoreflect.Value) {
+	switch {
+	case field.IsMap():
+		if field.MapValue().Kind() != protoreflect.StringKind {
+			message.Clear(field)
+			return
+		}
+		var keys []protoreflect.MapKey
+		value.Map().Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+			keys = append(keys, key)
+			return true
+		})
+		for _, key := range keys {
+			value.Map().Set(key, protoreflect.ValueOfString(redactedSecret))
+		}
+	case field.Kind() == protoreflect.StringKind && !field.IsList():
+		message.Set(field, protoreflect.ValueOfString(redactedSecret))
+	default:
+		message.Clear(field)
+	}
+}
+
+// stripSecretFromReqReflect formats any request struct by reflection, redacting its Secrets field
+func stripSecretFromReqReflect(req interface{}) string {
 	ret := "{"
 
-	reqValue := reflect.ValueOf(req)
+	reqValue := reflect.Indirect(reflect.ValueOf(req))
 	reqType := reqValue.Type()
 	if reqType.Kind() == reflect.Struct {
 		for i := 0; i < reqValue.NumField(); i++ {
@@ -263,7 +686,7 @@ func stripSecretFromReq(req interface{}) string {
 			if field.Name == secretsField && value.Kind() == reflect.Map {
 				valueToPrint = "["
 				for j := 0; j < len(value.MapKeys()); j++ {
-					valueToPrint += fmt.Sprintf("%s:<redacted>", value.MapKeys()[j].String())
+					valueToPrint += fmt.Sprintf("%s:%s", value.MapKeys()[j].String(), redactedSecret)
 					if j != len(value.MapKeys())-1 {
 						valueToPrint += " "
 					}
@@ -1,16 +1,29 @@
 package driver
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
 	"github.com/scaleway/scaleway-sdk-go/scw"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/runtime/protoimpl"
+	"k8s.io/klog/v2"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
 )
 
 // AssertTrue fails the test if is not true.
@@ -478,6 +491,34 @@ func Test_chooseZones(t *testing.T) {
 			expected: nil,
 			err:      status.Errorf(codes.InvalidArgument, "%s: %s is specified in preferred but not in requisite", ZoneTopologyKey, scw.ZoneFrPar1),
 		},
+		{
+			req: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{
+						Segments: map[string]string{
+							RegionTopologyKey: string(scw.RegionFrPar),
+						},
+					},
+				},
+			},
+			zone:     scw.ZoneFrPar2,
+			expected: []scw.Zone{scw.ZoneFrPar2},
+			err:      nil,
+		},
+		{
+			req: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{
+						Segments: map[string]string{
+							RegionTopologyKey: string(scw.RegionNlAms),
+						},
+					},
+				},
+			},
+			zone:     scw.ZoneFrPar2,
+			expected: nil,
+			err:      status.Error(codes.ResourceExhausted, "desired volume content source and desired topology are not compatible, different zones"),
+		},
 	}
 
 	for _, test := range testsBench {
@@ -539,10 +580,22 @@ func Test_validateVolumeCapabilities(t *testing.T) {
 		},
 	}
 
+	accessModes := accessModesForClass(scaleway.GetVolumeClass(scaleway.DefaultVolumeType))
 	for _, test := range testsBench {
-		err := validateVolumeCapabilities(test.volCaps)
+		err := validateVolumeCapabilities(test.volCaps, accessModes)
 		Equals(t, test.err, err)
 	}
+
+	multiAttachVolCaps := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Block{},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+	}
+	Equals(t, errAccessModeNotSupported, validateVolumeCapabilities(multiAttachVolCaps, accessModesForClass(scaleway.VolumeClass{MaxAttachments: 2})))
+	AssertNoError(t, validateVolumeCapabilities(multiAttachVolCaps, accessModesForClass(scaleway.VolumeClass{MaxAttachments: 2, MultiWriter: true})))
 }
 
 func Test_getVolumeRequestCapacity(t *testing.T) {
@@ -644,8 +697,190 @@ func Test_getVolumeRequestCapacity(t *testing.T) {
 	}
 
 	for _, test := range testsBench {
-		res, err := getVolumeRequestCapacity(min, max, test.capRange)
+		res, err := getVolumeRequestCapacity(min, max, 0, test.capRange)
 		Equals(t, test.err, err)
 		Equals(t, test.res, res)
 	}
+
+	res, err := getVolumeRequestCapacity(min, max, min+10, &csi.CapacityRange{})
+	AssertNoError(t, err)
+	Equals(t, min+10, res)
+	res, err = getVolumeRequestCapacity(min, max, min+10, nil)
+	AssertNoError(t, err)
+	Equals(t, min+10, res)
+	_, err = getVolumeRequestCapacity(min, max, max+10, nil)
+	Equals(t, errDefaultSizeOutOfRange, err)
+}
+
+func Test_stripSecretFromReq(t *testing.T) {
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId: "fr-par-1/volume-id",
+		Secrets: map[string]string{
+			encryptionPassphraseKey: "myawesomepassphrase",
+		},
+	}
+
+	for _, stripped := range []string{stripSecretFromReq(req).String(), stripSecretFromReqReflect(req)} {
+		AssertTrue(t, strings.Contains(stripped, "fr-par-1/volume-id"))
+		AssertTrue(t, strings.Contains(stripped, encryptionPassphraseKey))
+		AssertFalse(t, strings.Contains(stripped, "myawesomepassphrase"))
+	}
+	Equals(t, "myawesomepassphrase", req.Secrets[encryptionPassphraseKey])
+}
+
+func Test_stripSecretFromReqAllRequests(t *testing.T) {
+	requests := 0
+	protoregistry.GlobalTypes.RangeMessages(func(messageType protoreflect.MessageType) bool {
+		descriptor := messageType.Descriptor()
+		if descriptor.ParentFile().Package() != "csi.v1" || !strings.HasSuffix(string(descriptor.Name()), "Request") {
+			return true
+		}
+		requests++
+
+		// every secret of the request is set, the other fields being left empty
+		message := messageType.New()
+		fields := descriptor.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			if field := fields.Get(i); isSecretField(field) && field.IsMap() {
+				message.Mutable(field).Map().Set(protoreflect.ValueOfString("key").MapKey(), protoreflect.ValueOfString("myawesomesecret"))
+			}
+		}
+		req := protoimpl.X.ProtoMessageV1Of(message.Interface())
+
+		stripped := stripSecretFromReq(req).String()
+		AssertFalse(t, strings.Contains(stripped, "myawesomesecret"))
+		if strings.Contains(req.String(), "myawesomesecret") {
+			AssertTrue(t, strings.Contains(stripped, "key"))
+		}
+		return true
+	})
+	AssertTrue(t, requests > 20)
+}
+
+func Benchmark_stripSecretFromReq(b *testing.B) {
+	req := &csi.CreateVolumeRequest{
+		Name: "pvc-1234",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 10 * 1000 * 1000 * 1000,
+		},
+		Parameters: map[string]string{
+			encryptedKey: "true",
+		},
+		Secrets: map[string]string{
+			encryptionPassphraseKey: "myawesomepassphrase",
+		},
+	}
+
+	b.Run("disabled", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			klog.V(10).Infof("CreateVolume: called with %s", stripSecretFromReq(req))
+		}
+	})
+	b.Run("proto", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = stripSecretFromReq(req).String()
+		}
+	})
+	b.Run("reflect", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = stripSecretFromReqReflect(*req)
+		}
+	})
+}
+
+func Test_withVolumeMetadata(t *testing.T) {
+	creationDate := time.Date(2023, 6, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	volumeContext := map[string]string{encryptedKey: "false"}
+
+	newVolumeContext := withVolumeMetadata(volumeContext, &instance.Volume{ID: "volume-id", CreationDate: &creationDate})
+	Equals(t, map[string]string{
+		encryptedKey:          "false",
+		scwVolumeID:           "volume-id",
+		scwVolumeCreationDate: "2023-06-01T10:30:00Z",
+	}, newVolumeContext)
+	// the original volume context is left untouched
+	Equals(t, 1, len(volumeContext))
+}
+
+func Test_getVolumeTags(t *testing.T) {
+	Equals(t, []string{managedByTag}, getVolumeTags(map[string]string{encryptedKey: "true"}))
+	Equals(t, []string{managedByTag, "pvc-name=data", "namespace=default"}, getVolumeTags(map[string]string{
+		pvcNameKey:      "data",
+		pvcNamespaceKey: "default",
+		pvNameKey:       "pvc-1234",
+	}))
+}
+
+func Test_volumeCondition(t *testing.T) {
+	attached := &instance.Server{ID: "server-id", State: instance.ServerStateRunning}
+	locked := &instance.Server{ID: "server-id", State: instance.ServerStateLocked}
+
+	testsBench := []struct {
+		state    instance.VolumeState
+		server   *instance.Server
+		abnormal bool
+	}{
+		{state: instance.VolumeStateAvailable},
+		{state: instance.VolumeStateAvailable, server: attached},
+		{state: instance.VolumeStateResizing},
+		{state: instance.VolumeStateSnapshotting, server: attached},
+		{state: instance.VolumeStateError, abnormal: true},
+		{state: instance.VolumeStateAvailable, server: locked, abnormal: true},
+	}
+	for _, test := range testsBench {
+		condition := volumeCondition(&instance.Volume{ID: "volume-id", State: test.state}, test.server)
+		if condition.GetAbnormal() != test.abnormal {
+			t.Errorf("expected abnormal %t for volume %s and server %v, got %t (%s)", test.abnormal, test.state, test.server, condition.GetAbnormal(), condition.GetMessage())
+		}
+	}
+}
+
+func Test_expectedDevicePath(t *testing.T) {
+	volume := &instance.Volume{ID: "6f3b6e1a-2f5c-4c8e-9f4b-1d2e3f4a5b6c", VolumeType: instance.VolumeVolumeTypeBSSD}
+	// the hint of the controller is the first candidate of the node with the default prefixes
+	Equals(t, devicePathCandidates(nil, volume.ID)[0], expectedDevicePath(volume))
+	Equals(t, expectedDevicePath(volume), publishContext(volume, false)[scwDevicePath])
+}
+
+func Test_attachableVolumes(t *testing.T) {
+	Equals(t, 16, attachableVolumes(16, 0, 0))
+	Equals(t, 13, attachableVolumes(16, 1, 2))
+	// 0 would mean no limit for the CO
+	Equals(t, 1, attachableVolumes(16, 2, 20))
+}
+
+func Test_getProjectID(t *testing.T) {
+	Equals(t, "", getProjectID(map[string]string{}, nil))
+	Equals(t, "param-project", getProjectID(map[string]string{"projectid": "param-project"}, nil))
+	Equals(t, "default-project", getProjectID(map[string]string{projectIDKey: "param-project"}, map[string]string{
+		defaultProjectIDSecretKey: "default-project",
+	}))
+	// the projectID entry of the secrets takes precedence over everything
+	Equals(t, "secret-project", getProjectID(map[string]string{projectIDKey: "param-project"}, map[string]string{
+		projectIDKey:              "secret-project",
+		defaultProjectIDSecretKey: "default-project",
+	}))
+}
+
+func Test_useScratchDir(t *testing.T) {
+	// restored at the end of the test
+	t.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	t.Setenv("BLKID_FILE", os.Getenv("BLKID_FILE"))
+
+	dir := filepath.Join(t.TempDir(), "scratch")
+	AssertNoError(t, useScratchDir(dir))
+	info, err := os.Stat(dir)
+	AssertNoError(t, err)
+	AssertTrue(t, info.IsDir())
+	Equals(t, dir, os.TempDir())
+	Equals(t, filepath.Join(dir, "blkid.tab"), os.Getenv("BLKID_FILE"))
+}
+
+func Test_readOnlyMountPointError(t *testing.T) {
+	err := readOnlyMountPointError("/target", &fs.PathError{Op: "mkdir", Path: "/target", Err: syscall.EROFS})
+	AssertTrue(t, errors.Is(err, syscall.EROFS))
+	AssertTrue(t, strings.Contains(err.Error(), "read-write"))
+
+	otherErr := &fs.PathError{Op: "mkdir", Path: "/target", Err: syscall.EACCES}
+	Equals(t, error(otherErr), readOnlyMountPointError("/target", otherErr))
 }
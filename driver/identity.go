@@ -2,6 +2,7 @@ package driver
 
 import (
 	"context"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/protobuf/ptypes/wrappers"
@@ -13,47 +14,52 @@ func (d *Driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoReques
 	res := &csi.GetPluginInfoResponse{
 		Name:          DriverName,
 		VendorVersion: driverVersion,
+		Manifest:      d.pluginManifest(),
 	}
 
-	klog.V(4).Infof("GetPluginInfo called")
+	klog.FromContext(ctx).V(4).Info("GetPluginInfo called")
 	return res, nil
 }
 
+// pluginManifest returns the build information of the driver and the zones it manages
+func (d *Driver) pluginManifest() map[string]string {
+	info := GetVersion()
+	manifest := map[string]string{
+		"gitCommit":  info.GitCommit,
+		"buildDate":  info.BuildDate,
+		"sdkVersion": info.SDKVersion,
+		"goVersion":  info.GoVersion,
+		"platform":   info.Platform,
+	}
+
+	var zones []string
+	if d.controllerService.scaleway != nil {
+		for _, zone := range d.controllerService.scaleway.Zones() {
+			zones = append(zones, zone.String())
+		}
+	} else if d.nodeService.nodeZone != "" {
+		zones = append(zones, d.nodeService.nodeZone.String())
+	}
+	manifest["zones"] = strings.Join(zones, ",")
+
+	return manifest
+}
+
 // GetPluginCapabilities allows to query the supported capabilities of the Plugin as a whole
 func (d *Driver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
 	res := &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-					},
-				},
-			},
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
-					},
-				},
-			},
-			{
-				Type: &csi.PluginCapability_VolumeExpansion_{
-					VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
-						Type: csi.PluginCapability_VolumeExpansion_ONLINE,
-					},
-				},
-			},
-		},
+		Capabilities: pluginCapabilities(d.config),
 	}
 
-	klog.V(4).Infof("GetPluginCapabilities called")
+	klog.FromContext(ctx).V(4).Info("GetPluginCapabilities called")
 	return res, nil
 }
 
 // Probe allows to verify that the plugin is in a healthy and ready state
 func (d *Driver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
-	//TODO
+	if err := d.nodeService.hostError(); err != nil {
+		return nil, err
+	}
 	return &csi.ProbeResponse{
 		Ready: &wrappers.BoolValue{
 			Value: true,
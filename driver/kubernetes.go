@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// volumeAttachmentChecker checks if a volume is still attached from the point of view of the CO
+type volumeAttachmentChecker interface {
+	// IsVolumeAttached returns true if a VolumeAttachment still references the volume with the given ID
+	IsVolumeAttached(ctx context.Context, volumeID string) (bool, error)
+}
+
+type kubeVolumeAttachmentChecker struct {
+	client kubernetes.Interface
+}
+
+// newKubeClient returns a Kubernetes client using the in-cluster configuration
+func newKubeClient() (kubernetes.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error getting in-cluster kubernetes config: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes client: %w", err)
+	}
+	return client, nil
+}
+
+func (k *kubeVolumeAttachmentChecker) IsVolumeAttached(ctx context.Context, volumeID string) (bool, error) {
+	attachments, err := k.client.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("error listing volume attachments: %w", err)
+	}
+
+	for _, attachment := range attachments.Items {
+		if attachment.Spec.Attacher != DriverName {
+			continue
+		}
+
+		if inline := attachment.Spec.Source.InlineVolumeSpec; inline != nil && inline.CSI != nil {
+			if handleMatchesVolumeID(inline.CSI.VolumeHandle, volumeID) {
+				return true, nil
+			}
+			continue
+		}
+
+		pvName := attachment.Spec.Source.PersistentVolumeName
+		if pvName == nil {
+			continue
+		}
+
+		pv, err := k.client.CoreV1().PersistentVolumes().Get(ctx, *pvName, metav1.GetOptions{})
+		if err != nil {
+			if kerrors.IsNotFound(err) {
+				continue
+			}
+			return false, fmt.Errorf("error getting persistent volume %s: %w", *pvName, err)
+		}
+
+		if pv.Spec.CSI != nil && handleMatchesVolumeID(pv.Spec.CSI.VolumeHandle, volumeID) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// handleMatchesVolumeID returns true if the given CSI volume handle refers to the volume with the given ID
+func handleMatchesVolumeID(handle string, volumeID string) bool {
+	handleID, _, err := GetVolumeIDAndZone(handle)
+	if err != nil {
+		return false
+	}
+	return handleID == volumeID
+}
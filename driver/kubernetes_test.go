@@ -0,0 +1,50 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_IsVolumeAttached(t *testing.T) {
+	pvName := "pvc-1234"
+	checker := &kubeVolumeAttachmentChecker{
+		client: fake.NewSimpleClientset(
+			&corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: pvName},
+				Spec: corev1.PersistentVolumeSpec{
+					PersistentVolumeSource: corev1.PersistentVolumeSource{
+						CSI: &corev1.CSIPersistentVolumeSource{
+							Driver:       DriverName,
+							VolumeHandle: "fr-par-1/attached-volume",
+						},
+					},
+				},
+			},
+			&storagev1.VolumeAttachment{
+				ObjectMeta: metav1.ObjectMeta{Name: "csi-1234"},
+				Spec: storagev1.VolumeAttachmentSpec{
+					Attacher: DriverName,
+					Source: storagev1.VolumeAttachmentSource{
+						PersistentVolumeName: &pvName,
+					},
+				},
+			},
+		),
+	}
+
+	t.Run("attached", func(t *testing.T) {
+		attached, err := checker.IsVolumeAttached(context.Background(), "attached-volume")
+		AssertNoError(t, err)
+		AssertTrue(t, attached)
+	})
+	t.Run("orphaned", func(t *testing.T) {
+		attached, err := checker.IsVolumeAttached(context.Background(), "orphaned-volume")
+		AssertNoError(t, err)
+		AssertFalse(t, attached)
+	})
+}
@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// legacyParameterLogInterval is the minimum duration between two logs for the same legacy parameter
+	legacyParameterLogInterval = time.Hour
+
+	// legacyVolumeTypeNote is the note for the StorageClasses setting the b_ssd type explicitly. b_ssd is
+	// the only block volume type of the driver and has no replacement yet, the parameter is not deprecated
+	legacyVolumeTypeNote = "the StorageClass pins the Instance API volume type, which will need to be updated once the driver supports another block volume type; no change is needed for now"
+)
+
+// legacyParameters counts the calls using legacy parameters, by parameter
+var legacyParameters = expvar.NewMap("legacy_parameters")
+
+// legacyParameterLogs rate limits the logs about the legacy parameters
+var legacyParameterLogs = &legacyParameterLogger{}
+
+type legacyParameterLogger struct {
+	lastLogs map[string]time.Time
+	mux      sync.Mutex
+}
+
+// log counts the use of the given legacy parameter, and logs the note
+// if it was not logged for this parameter during the last legacyParameterLogInterval
+func (l *legacyParameterLogger) log(parameter string, note string) {
+	legacyParameters.Add(parameter, 1)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.lastLogs == nil {
+		l.lastLogs = make(map[string]time.Time)
+	}
+	if last, ok := l.lastLogs[parameter]; ok && time.Since(last) < legacyParameterLogInterval {
+		return
+	}
+	l.lastLogs[parameter] = time.Now()
+
+	klog.Infof("legacy parameter %s used: %s (logged at most once per %s)", parameter, note, legacyParameterLogInterval)
+}
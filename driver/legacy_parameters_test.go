@@ -0,0 +1,23 @@
+package driver
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_legacyParameterLogger(t *testing.T) {
+	l := &legacyParameterLogger{}
+	parameter := "test=legacy"
+
+	l.log(parameter, "note")
+	first := l.lastLogs[parameter]
+	l.log(parameter, "note")
+	Equals(t, first, l.lastLogs[parameter])
+	Equals(t, "2", legacyParameters.Get(parameter).String())
+
+	// the note is logged again after the interval
+	l.lastLogs[parameter] = first.Add(-legacyParameterLogInterval)
+	l.log(parameter, "note")
+	AssertTrue(t, l.lastLogs[parameter].After(first))
+	AssertTrue(t, time.Since(l.lastLogs[parameter]) < legacyParameterLogInterval)
+}
@@ -0,0 +1,54 @@
+package driver
+
+import "sync"
+
+// namedLocks serializes the operations on the same name (e.g. a target path),
+// while letting the operations on different names run concurrently
+type namedLocks struct {
+	locks map[string]*namedLock
+	mux   sync.Mutex
+}
+
+type namedLock struct {
+	mux  sync.Mutex
+	refs int
+}
+
+// waiting returns the number of calls holding or waiting for each locked name
+func (l *namedLocks) waiting() map[string]int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	waiting := make(map[string]int, len(l.locks))
+	for name, lock := range l.locks {
+		waiting[name] = lock.refs
+	}
+	return waiting
+}
+
+// lock locks the given name, waiting for the current holder if any, and returns the function unlocking it
+func (l *namedLocks) lock(name string) func() {
+	l.mux.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*namedLock)
+	}
+	lock, ok := l.locks[name]
+	if !ok {
+		lock = &namedLock{}
+		l.locks[name] = lock
+	}
+	lock.refs++
+	l.mux.Unlock()
+
+	lock.mux.Lock()
+	return func() {
+		lock.mux.Unlock()
+
+		l.mux.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, name)
+		}
+		l.mux.Unlock()
+	}
+}
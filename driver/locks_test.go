@@ -0,0 +1,39 @@
+package driver
+
+import (
+	"sync"
+	"testing"
+)
+
+func Test_namedLocks(t *testing.T) {
+	locks := &namedLocks{}
+	holders := map[string]int{}
+	maxHolders := 0
+	var mux sync.Mutex
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		name := []string{"/target/a", "/target/b"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := locks.lock(name)
+			defer unlock()
+
+			mux.Lock()
+			holders[name]++
+			if holders[name] > maxHolders {
+				maxHolders = holders[name]
+			}
+			mux.Unlock()
+
+			mux.Lock()
+			holders[name]--
+			mux.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	Equals(t, 1, maxHolders)
+	Equals(t, 0, len(locks.locks))
+}
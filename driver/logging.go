@@ -0,0 +1,134 @@
+package driver
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+)
+
+const (
+	// LoggingFormatText is the default klog text logging format
+	LoggingFormatText = "text"
+	// LoggingFormatJSON outputs one JSON object per log line
+	LoggingFormatJSON = "json"
+)
+
+// klogFlags shares the values of the flags of klog, allowing to change its verbosity while running
+var klogFlags = func() *flag.FlagSet {
+	flags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flags)
+	return flags
+}()
+
+// loggingFormat is the format set by SetupLogging
+var loggingFormat = LoggingFormatText
+
+// SetupLogging configures klog to output the logs with the given format and verbosity
+func SetupLogging(format string, verbosity int) error {
+	loggingFormat = format
+	switch format {
+	case LoggingFormatText:
+	case LoggingFormatJSON:
+		klog.SetLogger(funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(os.Stderr, obj)
+		}, funcr.Options{
+			LogCaller:    funcr.Error,
+			LogTimestamp: true,
+			Verbosity:    verbosity,
+		}))
+	default:
+		return fmt.Errorf("unknown logging format %s", format)
+	}
+	return nil
+}
+
+// loggingVerbosity returns the current verbosity of klog
+func loggingVerbosity() int {
+	verbosity, _ := strconv.Atoi(klogFlags.Lookup("v").Value.String())
+	return verbosity
+}
+
+// setLoggingVerbosity changes the verbosity of klog, and of the JSON logger which filters its logs by itself
+func setLoggingVerbosity(verbosity int) error {
+	if err := klogFlags.Set("v", strconv.Itoa(verbosity)); err != nil {
+		return err
+	}
+	if loggingFormat == LoggingFormatJSON {
+		return SetupLogging(loggingFormat, verbosity)
+	}
+	return nil
+}
+
+// apiErrorReason is the reason of the ErrorInfo detail describing the failed Scaleway API call of an RPC
+const apiErrorReason = "SCALEWAY_API_ERROR"
+
+// apiErrorDetailsInterceptor adds the last Scaleway API call of the RPC to its error when this call failed:
+// the Scaleway request ID asked by the support is appended to the message, and an ErrorInfo detail
+// holds it along with the HTTP status and the resource of the call
+func apiErrorDetailsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+	apiErr, ok := scaleway.LastAPIError(ctx)
+	if !ok {
+		return resp, err
+	}
+	return resp, withAPIErrorDetails(status.Convert(err), apiErr).Err()
+}
+
+// withAPIErrorDetails returns the status with the details of the failed API call appended to its own
+func withAPIErrorDetails(st *status.Status, apiErr scaleway.APIError) *status.Status {
+	message := st.Message()
+	metadata := map[string]string{
+		"method": apiErr.Method,
+		"path":   apiErr.Path,
+	}
+	if apiErr.ScwRequestID != "" {
+		message = fmt.Sprintf("%s (Scaleway request ID %s)", message, apiErr.ScwRequestID)
+		metadata["scwRequestID"] = apiErr.ScwRequestID
+	}
+	if apiErr.StatusCode != 0 {
+		metadata["httpStatus"] = strconv.Itoa(apiErr.StatusCode)
+	}
+	if apiErr.Resource != "" {
+		metadata["resource"] = apiErr.Resource
+	}
+	if apiErr.Error != "" {
+		metadata["error"] = apiErr.Error
+	}
+
+	// the details already set by the handler are kept
+	proto := st.Proto()
+	proto.Message = message
+	withMessage := status.FromProto(proto)
+	detailed, err := withMessage.WithDetails(&errdetails.ErrorInfo{
+		Reason:   apiErrorReason,
+		Domain:   "api.scaleway.com",
+		Metadata: metadata,
+	})
+	if err != nil {
+		return withMessage
+	}
+	return detailed
+}
+
+// requestIDInterceptor adds a logger with a unique request ID to the context of each RPC,
+// which is also used by the Scaleway API calls made with this context. The RPCs and the functions
+// they call with their context log with klog.FromContext, so that the request ID is on all their lines
+func requestIDInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	requestID := uuid.New().String()
+	logger := klog.FromContext(ctx).WithValues("method", info.FullMethod, "requestID", requestID)
+	return handler(scaleway.ContextWithRequestID(klog.NewContext(ctx, logger), requestID), req)
+}
@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+)
+
+func Test_withAPIErrorDetails(t *testing.T) {
+	st := withAPIErrorDetails(status.New(codes.Internal, "error attaching volume"), scaleway.APIError{
+		Method:       "POST",
+		Path:         "/instance/v1/zones/fr-par-1/servers/server-id/action",
+		Resource:     "servers/server-id",
+		StatusCode:   500,
+		ScwRequestID: "scw-request-id",
+	})
+	Equals(t, codes.Internal, st.Code())
+	AssertTrue(t, strings.HasSuffix(st.Message(), "(Scaleway request ID scw-request-id)"))
+
+	Equals(t, 1, len(st.Details()))
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	AssertTrue(t, ok)
+	Equals(t, apiErrorReason, info.GetReason())
+	Equals(t, "500", info.GetMetadata()["httpStatus"])
+	Equals(t, "servers/server-id", info.GetMetadata()["resource"])
+	Equals(t, "scw-request-id", info.GetMetadata()["scwRequestID"])
+}
+
+func Test_withAPIErrorDetailsKeepsDetails(t *testing.T) {
+	st, err := status.New(codes.FailedPrecondition, "volume in use").WithDetails(&errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{{Type: "VOLUME_IN_USE", Subject: "volume-id"}},
+	})
+	AssertNoError(t, err)
+
+	st = withAPIErrorDetails(st, scaleway.APIError{Method: "PATCH", Path: "/instance/v1/zones/fr-par-1/volumes/volume-id"})
+	Equals(t, codes.FailedPrecondition, st.Code())
+	Equals(t, 2, len(st.Details()))
+	_, ok := st.Details()[0].(*errdetails.PreconditionFailure)
+	AssertTrue(t, ok)
+	_, ok = st.Details()[1].(*errdetails.ErrorInfo)
+	AssertTrue(t, ok)
+}
@@ -4,8 +4,21 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// luksCloseRetries is the number of attempts to close a busy LUKS mapping, whose holders
+	// (e.g. udev probing the device after the unmount) usually release it shortly
+	luksCloseRetries = 3
+	// luksCloseRetryInterval is the interval between two attempts to close a busy LUKS mapping
+	luksCloseRetryInterval = time.Second
 )
 
 var (
@@ -15,6 +28,23 @@ var (
 	defaultLuksKeyize = "256"
 )
 
+// cryptsetupPaths are the paths where cryptsetup is looked up when it is not in the PATH,
+// some images only shipping it in the sbin directories
+var cryptsetupPaths = []string{"/usr/sbin/cryptsetup", "/sbin/cryptsetup", "/usr/local/sbin/cryptsetup"}
+
+// findCryptsetup returns the cryptsetup binary to run
+func findCryptsetup() string {
+	if _, err := exec.LookPath("cryptsetup"); err == nil {
+		return "cryptsetup"
+	}
+	for _, cryptsetupPath := range cryptsetupPaths {
+		if info, err := os.Stat(cryptsetupPath); err == nil && !info.IsDir() {
+			return cryptsetupPath
+		}
+	}
+	return "cryptsetup"
+}
+
 func luksFormat(devicePath string, passphrase string) error {
 	args := []string{
 		"-q",                      // don't ask for confirmation
@@ -57,6 +87,81 @@ func luksClose(mapperFile string) error {
 	return luksCloseCmd.Run()
 }
 
+// luksCloseDeferred schedules the removal of the mapping by device-mapper once its last holder releases it
+func luksCloseDeferred(mapperFile string) error {
+	args := []string{
+		"close",      // close
+		"--deferred", // remove the mapping once it is no longer used
+		mapperFile,   // mapper file to close
+	}
+
+	luksCloseCmd := exec.Command(cryptsetupCmd, args...)
+
+	return luksCloseCmd.Run()
+}
+
+// closeLuksMapping closes the mapping, retrying while it is busy. If it is still busy after the retries,
+// its removal is deferred when lazy is true, and the returned error describes its holders otherwise
+func closeLuksMapping(mapperFile string, lazy bool) error {
+	var err error
+	for i := 0; i < luksCloseRetries; i++ {
+		if i > 0 {
+			time.Sleep(luksCloseRetryInterval)
+		}
+		if err = luksClose(mapperFile); err == nil {
+			return nil
+		}
+	}
+
+	holders := luksMappingHolders(mapperFile)
+	if lazy {
+		if deferredErr := luksCloseDeferred(mapperFile); deferredErr == nil {
+			klog.Warningf("LUKS mapping %s is still busy (%s), it will be removed once released", mapperFile, holders)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w, mapping busy (%s)", err, holders)
+}
+
+// luksMappingHolders describes what keeps a mapping busy: its open count reported by dmsetup,
+// when installed, and the devices stacked on it found in sysfs
+func luksMappingHolders(mapperFile string) string {
+	var description []string
+
+	var stdout bytes.Buffer
+	dmsetupCmd := exec.Command("dmsetup", "info", "--columns", "--noheadings", "--options", "open", mapperFile)
+	dmsetupCmd.Stdout = &stdout
+	if err := dmsetupCmd.Run(); err == nil {
+		description = append(description, "open count "+strings.TrimSpace(stdout.String()))
+	}
+
+	if dmDevice, err := filepath.EvalSymlinks(diskLuksMapperPath + mapperFile); err == nil {
+		if holders, err := os.ReadDir(filepath.Join(sysBlockPath, filepath.Base(dmDevice), "holders")); err == nil && len(holders) > 0 {
+			var names []string
+			for _, holder := range holders {
+				names = append(names, holder.Name())
+			}
+			description = append(description, "holders "+strings.Join(names, " "))
+		}
+	}
+
+	if len(description) == 0 {
+		return "no holder found"
+	}
+	return strings.Join(description, ", ")
+}
+
+// luksStatusDevice returns the backing device of a mapping from the output of cryptsetup status, empty if not found
+func luksStatusDevice(statusStdout []byte) string {
+	for _, line := range strings.Split(string(statusStdout), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "device:" {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
 func luksResize(mapperFile, passphrase string) error {
 	args := []string{
 		"resize",                   // resize
@@ -0,0 +1,175 @@
+package driver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	controllerServiceAccount = "scaleway-csi-controller"
+	nodeServiceAccount       = "scaleway-csi-node"
+)
+
+// SidecarImages are the sidecar images matching the capabilities of this version of the driver
+var SidecarImages = map[string]string{
+	"csi-provisioner":           "registry.k8s.io/sig-storage/csi-provisioner:v3.5.0",
+	"csi-attacher":              "registry.k8s.io/sig-storage/csi-attacher:v4.3.0",
+	"csi-snapshotter":           "registry.k8s.io/sig-storage/csi-snapshotter:v6.2.2",
+	"csi-resizer":               "registry.k8s.io/sig-storage/csi-resizer:v1.8.0",
+	"csi-node-driver-registrar": "registry.k8s.io/sig-storage/csi-node-driver-registrar:v2.8.0",
+	"livenessprobe":             "registry.k8s.io/sig-storage/livenessprobe:v2.10.0",
+}
+
+// RenderManifests writes the CSIDriver object and the RBAC needed by the driver and its sidecars
+// in the given namespace, according to the capabilities of the driver
+func RenderManifests(w io.Writer, namespace string) error {
+	sidecars := make([]string, 0, len(SidecarImages))
+	for sidecar := range SidecarImages {
+		sidecars = append(sidecars, sidecar)
+	}
+	sort.Strings(sidecars)
+
+	fmt.Fprintf(w, "# Generated for %s %s (%s)\n", DriverName, driverVersion, gitCommit)
+	fmt.Fprintln(w, "# Sidecar images:")
+	for _, sidecar := range sidecars {
+		fmt.Fprintf(w, "#   %s: %s\n", sidecar, SidecarImages[sidecar])
+	}
+
+	for _, obj := range manifests(namespace) {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "---\n%s", out)
+	}
+	return nil
+}
+
+func manifests(namespace string) []interface{} {
+	attachRequired := true
+	podInfoOnMount := true
+
+	objects := []interface{}{
+		&storagev1.CSIDriver{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "storage.k8s.io/v1", Kind: "CSIDriver"},
+			ObjectMeta: metav1.ObjectMeta{Name: DriverName},
+			Spec: storagev1.CSIDriverSpec{
+				AttachRequired:       &attachRequired,
+				PodInfoOnMount:       &podInfoOnMount,
+				VolumeLifecycleModes: []storagev1.VolumeLifecycleMode{storagev1.VolumeLifecyclePersistent},
+			},
+		},
+		newServiceAccount(controllerServiceAccount, namespace),
+		newServiceAccount(nodeServiceAccount, namespace),
+	}
+
+	roles := map[string][]rbacv1.PolicyRule{
+		"scaleway-csi-provisioner": {
+			{APIGroups: []string{""}, Resources: []string{"persistentvolumes"}, Verbs: []string{"get", "list", "watch", "create", "delete"}},
+			{APIGroups: []string{""}, Resources: []string{"persistentvolumeclaims"}, Verbs: []string{"get", "list", "watch", "update"}},
+			{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"list", "watch", "create", "update", "patch"}},
+			{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get", "list", "watch"}},
+			{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get", "list"}},
+			{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"storageclasses", "csinodes"}, Verbs: []string{"get", "list", "watch"}},
+			{APIGroups: []string{"snapshot.storage.k8s.io"}, Resources: []string{"volumesnapshots", "volumesnapshotcontents"}, Verbs: []string{"get", "list"}},
+			{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get", "watch", "list", "delete", "update", "create"}},
+		},
+	}
+	for _, capability := range controllerCapabilities(nil) {
+		switch capability {
+		case csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME:
+			roles["scaleway-csi-attacher"] = []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"persistentvolumes"}, Verbs: []string{"get", "list", "watch", "update", "patch"}},
+				{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get", "list", "watch"}},
+				{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"csinodes"}, Verbs: []string{"get", "list", "watch"}},
+				{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"volumeattachments"}, Verbs: []string{"get", "list", "watch", "update", "patch"}},
+				{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"volumeattachments/status"}, Verbs: []string{"patch"}},
+				{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get", "watch", "list", "delete", "update", "create"}},
+			}
+		case csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT:
+			roles["scaleway-csi-snapshotter"] = []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"list", "watch", "create", "update", "patch"}},
+				{APIGroups: []string{"snapshot.storage.k8s.io"}, Resources: []string{"volumesnapshotclasses"}, Verbs: []string{"get", "list", "watch"}},
+				{APIGroups: []string{"snapshot.storage.k8s.io"}, Resources: []string{"volumesnapshotcontents"}, Verbs: []string{"create", "get", "list", "watch", "update", "delete", "patch"}},
+				{APIGroups: []string{"snapshot.storage.k8s.io"}, Resources: []string{"volumesnapshotcontents/status"}, Verbs: []string{"update", "patch"}},
+				{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get", "watch", "list", "delete", "update", "create"}},
+			}
+		case csi.ControllerServiceCapability_RPC_EXPAND_VOLUME:
+			roles["scaleway-csi-resizer"] = []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"persistentvolumes"}, Verbs: []string{"get", "list", "watch", "patch"}},
+				{APIGroups: []string{""}, Resources: []string{"persistentvolumeclaims"}, Verbs: []string{"get", "list", "watch"}},
+				{APIGroups: []string{""}, Resources: []string{"persistentvolumeclaims/status"}, Verbs: []string{"patch"}},
+				{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list", "watch"}},
+				{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"list", "watch", "create", "update", "patch"}},
+				{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get", "watch", "list", "delete", "update", "create"}},
+			}
+		}
+	}
+	// needed by the driver itself for --force-delete-detached-grace, --force-detach-interval, --enable-snapshot-scheduler and --emit-events
+	roles["scaleway-csi-controller"] = []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+		{APIGroups: []string{""}, Resources: []string{"persistentvolumes"}, Verbs: []string{"get", "list", "patch"}},
+		{APIGroups: []string{""}, Resources: []string{"persistentvolumeclaims"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"volumeattachments"}, Verbs: []string{"list", "patch"}},
+		{APIGroups: []string{"snapshot.storage.k8s.io"}, Resources: []string{"volumesnapshots"}, Verbs: []string{"list", "create", "delete"}},
+	}
+
+	roleNames := make([]string, 0, len(roles))
+	for name := range roles {
+		roleNames = append(roleNames, name)
+	}
+	sort.Strings(roleNames)
+	for _, name := range roleNames {
+		objects = append(objects,
+			newClusterRole(name, roles[name]),
+			newClusterRoleBinding(name, controllerServiceAccount, namespace),
+		)
+	}
+
+	objects = append(objects,
+		newClusterRole("scaleway-csi-node-driver-registrar", []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"get", "list", "watch", "create", "update", "patch"}},
+		}),
+		newClusterRoleBinding("scaleway-csi-node-driver-registrar", nodeServiceAccount, namespace),
+	)
+
+	return objects
+}
+
+func newServiceAccount(name string, namespace string) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+	}
+}
+
+func newClusterRole(name string, rules []rbacv1.PolicyRule) *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Rules:      rules,
+	}
+}
+
+func newClusterRoleBinding(name string, serviceAccount string, namespace string) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.ServiceAccountKind, Name: serviceAccount, Namespace: namespace},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     name,
+		},
+	}
+}
@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// driverNameRegexp is the format of the names of the CSI drivers: at most 63 characters in the domain name notation
+var driverNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9.]{0,61}[a-zA-Z0-9])?$`)
+
+// SetDriverName changes the name of the driver, for the white-label deployments, along with everything derived
+// from it: the publish and volume context keys, the managed-by tag of the volumes and snapshots, the annotations
+// and the staging directory of the kubelet. The topology keys are prefixed by topologyPrefix, topology.<name>
+// if empty. It must be called before creating the driver, all its instances sharing the same name.
+func SetDriverName(name string, topologyPrefix string) error {
+	if !driverNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid driver name %q, it must be at most 63 characters in the domain name notation", name)
+	}
+	setDerivedNames(name, topologyPrefix)
+	return nil
+}
+
+func init() {
+	setDerivedNames(DefaultDriverName, "")
+}
+
+// setDerivedNames sets DriverName and everything derived from it, the only place where they are defined
+func setDerivedNames(name string, topologyPrefix string) {
+	if topologyPrefix == "" {
+		topologyPrefix = "topology." + name
+	}
+
+	DriverName = name
+	ZoneTopologyKey = topologyPrefix + "/zone"
+	RegionTopologyKey = topologyPrefix + "/region"
+
+	scwVolumeID = DriverName + "/volume-id"
+	scwVolumeName = DriverName + "/volume-name"
+	scwVolumeZone = DriverName + "/volume-zone"
+	scwVolumeReadOnly = DriverName + "/read-only"
+	scwDevicePath = DriverName + "/device-path"
+	scwVolumeManaged = DriverName + "/managed"
+	scwVolumeCreationDate = DriverName + "/creation-date"
+	managedByTag = "managed-by=" + DriverName
+
+	ForceDetachAnnotation = DriverName + "/force-detach"
+	removeForceDetachAnnotationPatch = []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, ForceDetachAnnotation))
+	DefaultStagingGCRoot = "/var/lib/kubelet/plugins/kubernetes.io/csi/" + DriverName
+}
+
+// ManagedByTag returns the tag set on every volume and snapshot created by the driver
+func ManagedByTag() string {
+	return managedByTag
+}
@@ -0,0 +1,34 @@
+package driver
+
+import (
+	"testing"
+)
+
+func Test_SetDriverName(t *testing.T) {
+	defer func() {
+		AssertNoError(t, SetDriverName(DefaultDriverName, ""))
+		Equals(t, "topology.csi.scaleway.com/zone", ZoneTopologyKey)
+		Equals(t, "csi.scaleway.com/volume-id", scwVolumeID)
+		Equals(t, "managed-by=csi.scaleway.com", managedByTag)
+		Equals(t, "csi.scaleway.com/force-detach", ForceDetachAnnotation)
+	}()
+
+	AssertNoError(t, SetDriverName("block.example.com", ""))
+	Equals(t, "block.example.com", DriverName)
+	Equals(t, "topology.block.example.com/zone", ZoneTopologyKey)
+	Equals(t, "topology.block.example.com/region", RegionTopologyKey)
+	Equals(t, "block.example.com/volume-id", scwVolumeID)
+	Equals(t, "block.example.com/device-path", scwDevicePath)
+	Equals(t, "managed-by=block.example.com", ManagedByTag())
+	Equals(t, "block.example.com/managed", scwVolumeManaged)
+	Equals(t, "block.example.com/force-detach", ForceDetachAnnotation)
+	Equals(t, `{"metadata":{"annotations":{"block.example.com/force-detach":null}}}`, string(removeForceDetachAnnotationPatch))
+	Equals(t, "/var/lib/kubelet/plugins/kubernetes.io/csi/block.example.com", DefaultStagingGCRoot)
+
+	AssertNoError(t, SetDriverName("block.example.com", "topology.example.com"))
+	Equals(t, "topology.example.com/zone", ZoneTopologyKey)
+
+	AssertTrue(t, SetDriverName("", "") != nil)
+	AssertTrue(t, SetDriverName("-block.example.com", "") != nil)
+	Equals(t, "block.example.com", DriverName)
+}
@@ -0,0 +1,36 @@
+package driver
+
+import "errors"
+
+// errEncryptionDisabled is the error returned by the LUKS operations when the encryption is disabled
+var errEncryptionDisabled = errors.New("encryption is disabled on this node")
+
+// noLUKSDiskUtils is the DiskUtils of the nodes running with DisableEncryption:
+// cryptsetup is never run, the devices are never probed for a LUKS header and no mapping is ever looked up
+type noLUKSDiskUtils struct {
+	DiskUtils
+}
+
+func (d noLUKSDiskUtils) IsEncrypted(devicePath string) (bool, error) {
+	return false, nil
+}
+
+func (d noLUKSDiskUtils) EncryptAndOpenDevice(volumeID string, passphrase string) (string, error) {
+	return "", errEncryptionDisabled
+}
+
+func (d noLUKSDiskUtils) ResizeEncryptedDevice(mappedDevicePath string, passphrase string) error {
+	return errEncryptionDisabled
+}
+
+func (d noLUKSDiskUtils) CloseDevice(volumeID string) error {
+	return nil
+}
+
+func (d noLUKSDiskUtils) GetMappedDevicePath(volumeID string) (string, error) {
+	return "", nil
+}
+
+func (d noLUKSDiskUtils) CloseStaleDevices() (int, error) {
+	return 0, nil
+}
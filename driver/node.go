@@ -2,9 +2,16 @@ package driver
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/scaleway/scaleway-sdk-go/scw"
@@ -25,27 +32,87 @@ const (
 )
 
 type nodeService struct {
+	config *DriverConfig
+
 	diskUtils DiskUtils
 
 	nodeID   string
 	nodeZone scw.Zone
+
+	// deviceWaitTimeout is the maximum duration to wait for the device of a volume to appear
+	deviceWaitTimeout time.Duration
+
+	// maxVolumes is the number of volumes the CO can attach to this node
+	maxVolumes int64
+
+	// diskPrefixes are the prefixes of the /dev/disk/by-id symlinks of the volumes, DefaultDiskPrefixes if empty
+	diskPrefixes []string
+
+	// encryptionDisabled makes the node refuse to stage encrypted volumes, see DriverConfig.DisableEncryption
+	encryptionDisabled bool
+
+	// hostProblems are the missing dependencies found on startup which prevent staging any volume,
+	// they are reported by Probe and NodeGetInfo
+	hostProblems []string
+
+	// scaleway creates the inline ephemeral volumes, nil if they are not enabled
+	scaleway *scaleway.Scaleway
+
+	// ephemeralAttachMux serializes the attachments of the inline ephemeral volumes to the node, like the
+	// attach/detach lock of the controller, so that they are counted one at a time against maxVolumes
+	ephemeralAttachMux sync.Mutex
+
+	// labelTopology adds labels of the Kubernetes Node to the topology, nil if NodeLabelTopology is not set
+	labelTopology *nodeLabelTopology
+
+	// pathLocks serializes the operations on the same staging or target path, e.g. the concurrent
+	// publications of a volume shared by several pods of the node, which share its staging path
+	pathLocks namedLocks
 }
 
-func newNodeService() nodeService {
-	metadata, err := scaleway.NewMetadata().GetMetadata()
+func newNodeService(config *DriverConfig) nodeService {
+	metadata, err := getNodeMetadata(config, scaleway.NewMetadata())
 	if err != nil {
 		panic(err)
 	}
 
-	zone, err := scw.ParseZone(metadata.Location.ZoneID)
-	if err != nil {
-		panic(err)
+	// the local volumes (root l_ssd, scratch) and the reserved slots use some of the attachment slots of the instance
+	maxVolumes := int64(attachableVolumes(maxVolumesPerNode, metadata.localVolumes, config.ReservedVolumeSlots))
+	klog.V(4).Infof("node %s of type %s can have %d volumes attached", metadata.id, metadata.commercialType, maxVolumes)
+
+	if config.CryptsetupPath != "" {
+		cryptsetupCmd = config.CryptsetupPath
+	} else if !config.DisableEncryption {
+		cryptsetupCmd = findCryptsetup()
+	}
+
+	hostProblems, hostWarnings := checkNodeHost(!config.DisableEncryption, exec.LookPath, sysModulePath)
+	for _, warning := range hostWarnings {
+		klog.Warning(warning)
+	}
+	for _, problem := range hostProblems {
+		klog.Errorf("the node cannot stage volumes: %s", problem)
+	}
+
+	diskUtils := newDiskUtils(config.DiskPrefixes)
+	diskUtils.luksLazyClose = config.LUKSLazyClose
+	diskUtils.formatTimeout = config.FormatTimeout
+
+	var scwClient *scaleway.Scaleway
+	if config.EnableEphemeralVolumes {
+		scwClient = scaleway.NewScaleway(userAgent())
 	}
 
 	return nodeService{
-		diskUtils: newDiskUtils(),
-		nodeID:    metadata.ID,
-		nodeZone:  zone,
+		config:            config,
+		diskUtils:         diskUtils,
+		nodeID:            metadata.id,
+		nodeZone:          metadata.zone,
+		deviceWaitTimeout: config.DeviceWaitTimeout,
+		maxVolumes:        maxVolumes,
+		diskPrefixes:      config.DiskPrefixes,
+		hostProblems:      hostProblems,
+		scaleway:          scwClient,
 	}
 }
 
@@ -58,10 +125,10 @@ func newNodeService() nodeService {
 // for the first time or for the first time since a NodeUnstageVolume call
 // for the specified volume was called and returned success on that node.
 func (d *nodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	klog.V(4).Infof("NodeStageVolume called with %s", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("NodeStageVolume called", "request", stripSecretFromReq(req))
 
 	// check arguments
-	volumeID, _, err := getVolumeIDAndZone(req.GetVolumeId())
+	volumeID, _, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
@@ -75,18 +142,24 @@ func (d *nodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		}
 		encrypted = encryptedValue
 	}
+	if encrypted && d.encryptionDisabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is encrypted and the encryption is disabled on this node", volumeID)
+	}
 
 	stagingTargetPath := req.GetStagingTargetPath()
 	if stagingTargetPath == "" {
 		return nil, status.Error(codes.InvalidArgument, "stagingTargetPath not provided")
 	}
 
+	unlock := d.pathLocks.lock(stagingTargetPath)
+	defer unlock()
+
 	volumeCapability := req.GetVolumeCapability()
 	if volumeCapability == nil {
 		return nil, status.Error(codes.InvalidArgument, "volumeCapability not provided")
 	}
 
-	err = validateVolumeCapabilities([]*csi.VolumeCapability{volumeCapability})
+	err = validateVolumeCapabilities([]*csi.VolumeCapability{volumeCapability}, nodeAccessModes())
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "volumeCapability not supported: %s", err)
 	}
@@ -101,33 +174,67 @@ func (d *nodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		return nil, status.Errorf(codes.InvalidArgument, "%s not found in publish context of volume %s", scwVolumeID, volumeID)
 	}
 
-	devicePath, err := d.diskUtils.GetDevicePath(scwVolumeID)
+	spanCtx, span := startSpan(ctx, "wait device", attributeVolumeID.String(scwVolumeID))
+	waitStart := time.Now()
+	devicePath, err := d.diskUtils.WaitDevicePath(spanCtx, scwVolumeID, req.GetPublishContext()[scwDevicePath], d.deviceWaitTimeout)
+	endSpan(span, err)
+	if err == nil {
+		deviceWaitDurations.since(waitStart)
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, status.Errorf(codes.NotFound, "volume %s is not mounted on node yet", volumeID)
 		}
 		return nil, status.Errorf(codes.Internal, "error getting device path for volume with ID %s: %s", volumeID, err.Error())
 	}
-	klog.V(4).Infof("volume %s with ID %s has device path %s", volumeName, volumeID, devicePath)
+	klog.FromContext(ctx).V(4).Info("found device path", "volumeName", volumeName, "volumeID", volumeID, "devicePath", devicePath)
 
+	passphrase := ""
 	if encrypted {
-		passhrase, ok := req.GetSecrets()[encryptionPassphraseKey]
+		var ok bool
+		passphrase, ok = req.GetSecrets()[encryptionPassphraseKey]
 		if !ok {
 			return nil, status.Errorf(codes.InvalidArgument, "missing passphrase secret for key %s", encryptionPassphraseKey)
 		}
-		devicePath, err = d.diskUtils.EncryptAndOpenDevice(scwVolumeID, passhrase)
+		_, span := startSpan(ctx, "open encrypted device", attributeVolumeID.String(scwVolumeID))
+		devicePath, err = d.diskUtils.EncryptAndOpenDevice(scwVolumeID, passphrase)
+		endSpan(span, err)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "error encrypting/opening volume with ID %s: %s", volumeID, err.Error())
 		}
 	}
 
+	readOnly := req.GetPublishContext()[scwVolumeReadOnly] == "true"
+
+	volumeContext := req.GetVolumeContext()
+	staged := stagedVolume{
+		stagingTargetPath: stagingTargetPath,
+		encrypted:         encrypted,
+		mappedDevicePath:  devicePath,
+		managed:           req.GetPublishContext()[scwVolumeManaged] == "true",
+		readOnly:          readOnly,
+		pvName:            volumeContext[pvNameKey],
+		pvcName:           volumeContext[pvcNameKey],
+		pvcNamespace:      volumeContext[pvcNamespaceKey],
+	}
+
 	switch volumeCapability.GetAccessType().(type) {
 	// no need to mount if it's in block mode
 	case *csi.VolumeCapability_Block:
+		if readOnly {
+			if err := d.setDeviceReadOnly(ctx, volumeID, devicePath); err != nil {
+				return nil, err
+			}
+		}
+		staged.block = true
+		trackStagedVolume(volumeID, staged)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
 	isMounted, err := d.diskUtils.IsSharedMounted(stagingTargetPath, devicePath)
+	if errors.Is(err, errTargetNotMounterOnRightDevice) {
+		return nil, status.Errorf(codes.AlreadyExists, "another device than %s of volume %s is mounted on %s", devicePath, volumeID, stagingTargetPath)
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "error checking mount point of volume %s on path %s: %s", volumeID, stagingTargetPath, err.Error())
 	}
@@ -141,8 +248,17 @@ func (d *nodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 			// block device mounted at stagingTargetPath is not normal
 			return nil, status.Errorf(codes.Unknown, "block device mounted as stagingTargetPath %s for volume with ID %s", stagingTargetPath, volumeID)
 		}
-		klog.V(4).Infof("volume %s with ID %s is already mounted on %s", volumeName, volumeID, stagingTargetPath)
+		klog.FromContext(ctx).V(4).Info("volume already mounted", "volumeName", volumeName, "volumeID", volumeID, "stagingTargetPath", stagingTargetPath)
+		if readOnly {
+			if err := d.setDeviceReadOnly(ctx, volumeID, devicePath); err != nil {
+				return nil, err
+			}
+		} else if err := d.resizeStagedVolume(ctx, volumeID, stagingTargetPath, devicePath, passphrase); err != nil {
+			// retried until the resize of a previous staging succeeds
+			return nil, status.Errorf(codes.Internal, "failed to resize volume %s staged on %s: %v", volumeID, stagingTargetPath, err)
+		}
 		// TODO check volumeCapability
+		trackStagedVolume(volumeID, staged)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -152,28 +268,98 @@ func (d *nodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	}
 
 	mountOptions := mountCap.GetMountFlags()
+	if readOnly {
+		mountOptions = append(mountOptions, "ro")
+	}
 	fsType := mountCap.GetFsType()
+	if volumeContext[xfsQuotaKey] != "" {
+		if fsType != "xfs" {
+			return nil, status.Errorf(codes.InvalidArgument, "volume %s with %s must be staged with the xfs filesystem type", volumeID, xfsQuotaKey)
+		}
+		mountOptions = append(mountOptions, xfsQuotaMountOption)
+	}
+	formatOptions := strings.Fields(req.GetVolumeContext()[mkfsOptionsKey])
+
+	// checked while the device is still writable, e2fsck -p refusing to open the read-only devices
+	if volumeContext[fsckBeforeMountKey] == "true" {
+		spanCtx, span := startSpan(ctx, "check filesystem", attributeDevicePath.String(devicePath))
+		err := d.diskUtils.CheckFilesystem(spanCtx, devicePath)
+		endSpan(span, err)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "error checking the filesystem of volume %s before mounting it: %s", volumeID, err)
+		}
+	}
 
-	klog.V(4).Infof("Volume %s with ID %s will be mounted on %s with type %s and options %s", volumeName, volumeID, stagingTargetPath, fsType, strings.Join(mountOptions, ","))
+	klog.FromContext(ctx).V(4).Info("mounting volume", "volumeName", volumeName, "volumeID", volumeID, "stagingTargetPath", stagingTargetPath, "fsType", fsType, "mountOptions", mountOptions)
 
 	// format and mounting volume
-	err = d.diskUtils.FormatAndMount(stagingTargetPath, devicePath, fsType, mountOptions)
+	_, span = startSpan(ctx, "format and mount", attributeDevicePath.String(devicePath), attributeFsType.String(fsType))
+	err = d.diskUtils.FormatAndMount(stagingTargetPath, devicePath, fsType, mountOptions, formatOptions)
+	endSpan(span, err)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to format and mount device from (%q) to (%q) with fstype (%q) and options (%q): %v",
 			devicePath, stagingTargetPath, fsType, mountOptions, err)
 	}
-	klog.V(4).Infof("Volume %s with ID %s has been mounted on %s with type %s and options %s", volumeName, volumeID, stagingTargetPath, fsType, strings.Join(mountOptions, ","))
+	klog.FromContext(ctx).V(4).Info("volume mounted", "volumeName", volumeName, "volumeID", volumeID, "stagingTargetPath", stagingTargetPath, "fsType", fsType, "mountOptions", mountOptions)
+
+	// the device is only made read-only once it holds a filesystem, checked and mounted
+	if readOnly {
+		if err := d.setDeviceReadOnly(ctx, volumeID, devicePath); err != nil {
+			return nil, err
+		}
+	}
+
+	// the volume stays mounted on failure, the retry finding it mounted resizes it again
+	if !readOnly {
+		if err := d.resizeStagedVolume(ctx, volumeID, stagingTargetPath, devicePath, passphrase); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to resize volume %s staged on %s: %v", volumeID, stagingTargetPath, err)
+		}
+	}
 
+	trackStagedVolume(volumeID, staged)
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// setDeviceReadOnly makes the device of a volume published as read-only read-only itself,
+// the Instance API only attaching the volumes as read-write
+func (d *nodeService) setDeviceReadOnly(ctx context.Context, volumeID string, devicePath string) error {
+	klog.FromContext(ctx).V(4).Info("volume published as read-only, setting its device read-only", "volumeID", volumeID, "devicePath", devicePath)
+	if err := d.diskUtils.SetReadOnly(devicePath, true); err != nil {
+		return status.Errorf(codes.Internal, "error setting device %s of volume with ID %s read-only: %s", devicePath, volumeID, err.Error())
+	}
+	return nil
+}
+
+// resizeStagedVolume grows the LUKS container, if passphrase is set, and the filesystem of a freshly
+// staged volume to the size of its device, which is larger when the volume was expanded while detached.
+// The resize marker of an interrupted NodeExpandVolume is removed once the volume is resized.
+func (d *nodeService) resizeStagedVolume(ctx context.Context, volumeID string, stagingTargetPath string, devicePath string, passphrase string) error {
+	if passphrase != "" {
+		if err := d.diskUtils.ResizeEncryptedDevice(devicePath, passphrase); err != nil {
+			return err
+		}
+	}
+
+	needResize, err := d.diskUtils.NeedResize(devicePath, stagingTargetPath)
+	if err != nil {
+		return err
+	}
+	if needResize {
+		klog.FromContext(ctx).V(4).Info("filesystem smaller than its device, resizing it", "devicePath", devicePath)
+		if err := d.diskUtils.Resize(stagingTargetPath, devicePath, ""); err != nil {
+			return err
+		}
+	}
+	return removeResizeMarker(resizeMarkerPath(stagingTargetPath, volumeID))
+}
+
 // NodeUnstageVolume is a reverse operation of NodeStageVolume.
 // It must undo the work by the corresponding NodeStageVolume.
 func (d *nodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	klog.V(4).Infof("NodeUnstageVolume called with %s", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("NodeUnstageVolume called", "request", stripSecretFromReq(req))
 
 	// check arguments
-	volumeID, _, err := getVolumeIDAndZone(req.GetVolumeId())
+	volumeID, _, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
@@ -183,7 +369,10 @@ func (d *nodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.InvalidArgument, "stagingTargetPath not provided")
 	}
 
-	_, err = d.diskUtils.GetDevicePath(volumeID)
+	unlock := d.pathLocks.lock(stagingTargetPath)
+	defer unlock()
+
+	devicePath, err := d.diskUtils.GetDevicePath(volumeID)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, status.Errorf(codes.NotFound, "volume with ID %s not found", volumeID)
@@ -201,8 +390,10 @@ func (d *nodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	}
 
 	if isMounted {
-		klog.V(4).Infof("Volume with ID %s is mounted on %s, umounting it", volumeID, stagingTargetPath)
+		klog.FromContext(ctx).V(4).Info("volume mounted, unmounting it", "volumeID", volumeID, "stagingTargetPath", stagingTargetPath)
+		_, span := startSpan(ctx, "unmount", attributeVolumeID.String(volumeID))
 		err = d.diskUtils.Unmount(stagingTargetPath)
+		endSpan(span, err)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "error unmounting target path: %s", err.Error())
 		}
@@ -212,6 +403,16 @@ func (d *nodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "error closing device with ID %s: %s", volumeID, err.Error())
 	}
+	// the device of a volume staged as read-only is made writable again for its next stage
+	if err := d.diskUtils.SetReadOnly(devicePath, false); err != nil {
+		return nil, status.Errorf(codes.Internal, "error setting device %s of volume with ID %s writable: %s", devicePath, volumeID, err.Error())
+	}
+	// the marker of an interrupted resize must not delay the resize of the next staging
+	if err := removeResizeMarker(resizeMarkerPath(stagingTargetPath, volumeID)); err != nil {
+		return nil, status.Errorf(codes.Internal, "error removing resize marker of volume %s: %s", volumeID, err.Error())
+	}
+	untrackStagedVolume(volumeID)
+	d.diskUtils.ForgetDevicePath(volumeID)
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
@@ -221,10 +422,14 @@ func (d *nodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 // on a node. The Plugin SHALL assume that this RPC will be executed
 // on the node where the volume will be used.
 func (d *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	klog.V(4).Infof("NodePublishVolume called with %s", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("NodePublishVolume called", "request", stripSecretFromReq(req))
+
+	if isEphemeralVolume(req.GetVolumeContext()) {
+		return d.publishEphemeralVolume(ctx, req)
+	}
 
 	// check arguments
-	volumeID, _, err := getVolumeIDAndZone(req.GetVolumeId())
+	volumeID, _, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
@@ -234,12 +439,15 @@ func (d *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Error(codes.InvalidArgument, "targetPath not provided")
 	}
 
+	unlock := d.pathLocks.lock(targetPath)
+	defer unlock()
+
 	volumeCapability := req.GetVolumeCapability()
 	if volumeCapability == nil {
 		return nil, status.Error(codes.InvalidArgument, "volumeCapability not provided")
 	}
 
-	err = validateVolumeCapabilities([]*csi.VolumeCapability{volumeCapability})
+	err = validateVolumeCapabilities([]*csi.VolumeCapability{volumeCapability}, nodeAccessModes())
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "volumeCapability not supported: %s", err)
 	}
@@ -249,6 +457,11 @@ func (d *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Error(codes.FailedPrecondition, "stagingTargetPath not provided")
 	}
 
+	// the target paths of the pods publishing the same volume differ, the publications
+	// are serialized with each other and with the staging on the staging path
+	unlockStaging := d.pathLocks.lock(stagingTargetPath)
+	defer unlockStaging()
+
 	scwVolumeID, ok := req.GetPublishContext()[scwVolumeID]
 	if !ok {
 		return nil, status.Errorf(codes.InvalidArgument, "%s not found for volume with ID %s", scwVolumeID, volumeID)
@@ -310,7 +523,7 @@ func (d *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 			}
 
 			if (ro == 1) == req.GetReadonly() {
-				klog.V(4).Infof("Volume %s with ID %s is already mounted as a raw device on %s", volumeName, volumeID, targetPath)
+				klog.FromContext(ctx).V(4).Info("volume already mounted as a raw device", "volumeName", volumeName, "volumeID", volumeID, "targetPath", targetPath)
 				return &csi.NodePublishVolumeResponse{}, nil
 			}
 			return nil, status.Errorf(codes.AlreadyExists, "volume with ID %s does not match the given mount mode for the request", volumeID)
@@ -337,7 +550,7 @@ func (d *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 			return nil, status.Errorf(codes.AlreadyExists, "volume with ID %s does not match the given mount mode for the request", volumeID)
 		}
 
-		klog.V(4).Infof("Volume %s with ID %s is already mounted on %s", volumeName, volumeID, stagingTargetPath)
+		klog.FromContext(ctx).V(4).Info("volume already mounted", "volumeName", volumeName, "volumeID", volumeID, "stagingTargetPath", stagingTargetPath)
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
@@ -372,6 +585,20 @@ func (d *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		sourcePath = stagingTargetPath
 		fsType = mount.GetFsType()
 		mountOptions = mount.GetMountFlags()
+
+		if quota := req.GetVolumeContext()[xfsQuotaKey]; quota != "" {
+			limit, err := strconv.ParseInt(quota, 10, 64)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s %s for volume with ID %s: %s", xfsQuotaKey, quota, volumeID, err)
+			}
+			sourcePath = xfsQuotaDir(stagingTargetPath, targetPath)
+			// the directory of a volume staged as read-only was set up by a previous publication
+			if req.GetPublishContext()[scwVolumeReadOnly] != "true" {
+				if err := d.diskUtils.SetXFSProjectQuota(stagingTargetPath, sourcePath, xfsQuotaProjectID(targetPath), limit); err != nil {
+					return nil, status.Errorf(codes.Internal, "error setting up the XFS project quota of volume with ID %s on %s: %s", volumeID, sourcePath, err)
+				}
+			}
+		}
 	}
 
 	mountOptions = append(mountOptions, "bind")
@@ -382,10 +609,12 @@ func (d *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 
 	err = createMountPoint(targetPath, volumeCapability.GetBlock() != nil)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "error creating mount point %s for volume with ID %s", targetPath, volumeID)
+		return nil, status.Errorf(codes.Internal, "error creating mount point %s for volume with ID %s: %s", targetPath, volumeID, err)
 	}
 
+	_, span := startSpan(ctx, "bind mount", attributeFsType.String(fsType))
 	err = d.diskUtils.MountToTarget(sourcePath, targetPath, fsType, mountOptions)
+	endSpan(span, err)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "error mounting source %s to target %s with fs of type %s : %s", sourcePath, targetPath, fsType, err.Error())
 	}
@@ -395,26 +624,71 @@ func (d *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 // NodeUnpublishVolume is a reverse operation of NodePublishVolume.
 // This RPC MUST undo the work by the corresponding NodePublishVolume.
 func (d *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	klog.V(4).Infof("NodeUnpublishVolume called with %s", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("NodeUnpublishVolume called", "request", stripSecretFromReq(req))
 
 	targetPath := req.GetTargetPath()
 	if targetPath == "" {
 		return nil, status.Error(codes.InvalidArgument, "targetPath not provided")
 	}
 
+	unlock := d.pathLocks.lock(targetPath)
+	defer unlock()
+
+	_, span := startSpan(ctx, "unmount")
 	err := d.diskUtils.Unmount(targetPath)
+	endSpan(span, err)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "error unmounting target path: %s", err.Error())
 	}
 
+	if d.scaleway != nil && isEphemeralVolumeID(req.GetVolumeId()) {
+		if err := d.deleteEphemeralVolume(ctx, req.GetVolumeId()); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	if err := d.removeXFSQuotaDir(req.GetVolumeId(), targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "error removing the XFS project quota of volume with ID %s published on %s: %s", req.GetVolumeId(), targetPath, err)
+	}
+
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// removeXFSQuotaDir removes the directory and the XFS project of the publication of a volume with xfsQuotaKey on
+// the target path, if any. The staging path is the one of the volume staged by this process, or the one created by
+// the kubelet in StagingGCRoot when the node plugin restarted since.
+func (d *nodeService) removeXFSQuotaDir(volumeID string, targetPath string) error {
+	var stagingTargetPath string
+	if id, _, err := GetVolumeIDAndZone(volumeID); err == nil {
+		if staged, ok := lookupStagedVolume(id); ok {
+			if staged.block || staged.readOnly {
+				return nil
+			}
+			stagingTargetPath = staged.stagingTargetPath
+		}
+	}
+	if stagingTargetPath == "" {
+		if d.config == nil || d.config.StagingGCRoot == "" {
+			return nil
+		}
+		stagingTargetPath = filepath.Join(d.config.StagingGCRoot, fmt.Sprintf("%x", sha256.Sum256([]byte(volumeID))), stagingDirName)
+	}
+
+	dir := xfsQuotaDir(stagingTargetPath, targetPath)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return d.diskUtils.RemoveXFSProjectQuota(stagingTargetPath, dir, xfsQuotaProjectID(targetPath))
+}
+
 // NodeGetVolumeStats returns the volume capacity statistics available for the volume
 func (d *nodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	klog.V(4).Infof("NodeGetVolumeStats called with %s", stripSecretFromReq(*req))
+	klog.FromContext(ctx).V(4).Info("NodeGetVolumeStats called", "request", stripSecretFromReq(req))
 
-	volumeID, _, err := getVolumeIDAndZone(req.GetVolumeId())
+	volumeID, _, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
@@ -438,12 +712,9 @@ func (d *nodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 		return nil, status.Errorf(codes.NotFound, "volume with ID %s not found", volumeID)
 	}
 
-	_, err = d.diskUtils.GetDevicePath(volumeID)
+	volumeCondition, err := d.getVolumeCondition(volumeID, volumePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, status.Errorf(codes.NotFound, "volume with ID %s not found", volumeID)
-		}
-		return nil, status.Errorf(codes.Internal, "error getting device path for volume with ID %s: %s", volumeID, err.Error())
+		return nil, status.Errorf(codes.Internal, "error getting condition of volume with ID %s: %s", volumeID, err.Error())
 	}
 
 	fs, err := d.diskUtils.GetStatfs(volumePath)
@@ -478,62 +749,102 @@ func (d *nodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 			diskUsage,
 			inodesUsage,
 		},
+		VolumeCondition: volumeCondition,
+	}, nil
+}
+
+// getVolumeCondition checks that the device of the volume is still present,
+// that its filesystem was not remounted read-only after errors and that the
+// LUKS mapping is still active for encrypted volumes
+func (d *nodeService) getVolumeCondition(volumeID string, volumePath string) (*csi.VolumeCondition, error) {
+	devicePath, err := d.diskUtils.GetDevicePath(volumeID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("device for volume %s not found in %s or %s", volumeID, diskByIDPath, sysClassNVMePath),
+			}, nil
+		}
+		return nil, fmt.Errorf("error getting device path: %w", err)
+	}
+
+	mountInfo, err := d.diskUtils.GetMountInfo(volumePath)
+	if err != nil {
+		return nil, fmt.Errorf("error getting mount info of %s: %w", volumePath, err)
+	}
+	if mountInfo != nil {
+		// a read-only bind mount only sets the per-mount option, the
+		// superblock is read-only when the kernel remounted it after I/O errors
+		for _, option := range mountInfo.superOptions {
+			if option == "ro" {
+				return &csi.VolumeCondition{
+					Abnormal: true,
+					Message:  fmt.Sprintf("filesystem on %s is read-only", volumePath),
+				}, nil
+			}
+		}
+	}
+
+	isEncrypted, err := d.diskUtils.IsEncrypted(devicePath)
+	if err != nil {
+		return nil, fmt.Errorf("error checking if device %s is encrypted: %w", devicePath, err)
+	}
+	if isEncrypted {
+		mappedPath, err := d.diskUtils.GetMappedDevicePath(volumeID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting mapped device path: %w", err)
+		}
+		if mappedPath == "" {
+			return &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("LUKS mapping for volume %s is not active", volumeID),
+			}, nil
+		}
+	}
+
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  "volume is healthy",
 	}, nil
 }
 
 // NodeGetCapabilities allows the CO to check the supported capabilities of node service provided by the Plugin.
 func (d *nodeService) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	return &csi.NodeGetCapabilitiesResponse{
-		Capabilities: []*csi.NodeServiceCapability{
-			&csi.NodeServiceCapability{
-				Type: &csi.NodeServiceCapability_Rpc{
-					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
-					},
+	var capabilities []*csi.NodeServiceCapability
+	for _, capability := range nodeCapabilities(d.config) {
+		capabilities = append(capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: capability,
 				},
 			},
-			&csi.NodeServiceCapability{
-				Type: &csi.NodeServiceCapability_Rpc{
-					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
-					},
-				},
-			},
-			&csi.NodeServiceCapability{
-				Type: &csi.NodeServiceCapability_Rpc{
-					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
-					},
-				},
-			},
-			&csi.NodeServiceCapability{
-				Type: &csi.NodeServiceCapability_Rpc{
-					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-					},
-				},
-			},
-		},
-	}, nil
+		})
+	}
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: capabilities}, nil
 }
 
 // NodeGetInfo returns information about node's volumes
 func (d *nodeService) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	if err := d.hostError(); err != nil {
+		return nil, err
+	}
+	segments := topologySegments(d.nodeZone)
+	if err := d.labelTopology.addSegments(ctx, segments); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
 	return &csi.NodeGetInfoResponse{
 		NodeId:            d.nodeZone.String() + "/" + d.nodeID,
-		MaxVolumesPerNode: maxVolumesPerNode - 1, // One is already used by the l_ssd root volume
+		MaxVolumesPerNode: d.maxVolumes,
 		AccessibleTopology: &csi.Topology{
-			Segments: map[string]string{
-				ZoneTopologyKey: d.nodeZone.String(),
-			},
+			Segments: segments,
 		},
 	}, nil
 }
 
 // NodeExpandVolume expands the given volume
 func (d *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	klog.V(4).Infof("NodeExpandVolume called with %s", stripSecretFromReq(*req))
-	volumeID, _, err := getVolumeIDAndZone(req.GetVolumeId())
+	klog.FromContext(ctx).V(4).Info("NodeExpandVolume called", "request", stripSecretFromReq(req))
+	volumeID, _, err := GetVolumeIDAndZone(req.GetVolumeId())
 	if err != nil {
 		return nil, err
 	}
@@ -543,6 +854,13 @@ func (d *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		return nil, status.Error(codes.InvalidArgument, "volumePath not provided")
 	}
 
+	// the resize marker is next to the staging path, which must not be unstaged meanwhile
+	stagingPath := req.GetStagingTargetPath()
+	if stagingPath != "" {
+		unlock := d.pathLocks.lock(stagingPath)
+		defer unlock()
+	}
+
 	devicePath, err := d.diskUtils.GetDevicePath(volumeID)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -558,7 +876,7 @@ func (d *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 
 	volumeCapability := req.GetVolumeCapability()
 	if volumeCapability != nil {
-		err = validateVolumeCapabilities([]*csi.VolumeCapability{volumeCapability})
+		err = validateVolumeCapabilities([]*csi.VolumeCapability{volumeCapability}, nodeAccessModes())
 		if err != nil {
 			return nil, status.Errorf(codes.InvalidArgument, "volumeCapability not supported: %s", err)
 		}
@@ -574,7 +892,7 @@ func (d *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		return &csi.NodeExpandVolumeResponse{}, nil
 	}
 
-	klog.V(4).Infof("resizing volume %s mounted on %s", volumeID, volumePath)
+	klog.FromContext(ctx).V(4).Info("resizing volume", "volumeID", volumeID, "volumePath", volumePath)
 	encrypted, err := d.diskUtils.IsEncrypted(devicePath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "error checking if volume %s is encrypted: %s", volumeID, err.Error())
@@ -586,15 +904,67 @@ func (d *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "error retrieving mapped device path for volume with ID %s: %s", volumeID, err.Error())
 		}
-		klog.V(4).Infof("mapped device path for volume %s is %s", volumeID, devicePath)
+		klog.FromContext(ctx).V(4).Info("found mapped device path", "volumeID", volumeID, "devicePath", devicePath)
 		if passphrase == "" {
 			return nil, status.Errorf(codes.InvalidArgument, "device %s is LUKS encrypted, but no passphrase was provided", devicePath)
 		}
 	}
 
+	markerPath := ""
+	if stagingPath != "" {
+		markerPath = resizeMarkerPath(stagingPath, volumeID)
+		if err := d.startResize(ctx, markerPath, volumeID, req.GetCapacityRange().GetRequiredBytes(), time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
 	if err = d.diskUtils.Resize(volumePath, devicePath, passphrase); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to resize volume %s mounted on %s: %v", volumeID, volumePath, err)
 	}
 
+	if markerPath != "" {
+		if err := removeResizeMarker(markerPath); err != nil {
+			return nil, status.Errorf(codes.Internal, "error removing resize marker of volume %s: %s", volumeID, err.Error())
+		}
+	}
+
 	return &csi.NodeExpandVolumeResponse{}, nil
 }
+
+// startResize persists a resize marker before resizing a volume at now. If a marker for the same size
+// is already present, the previous attempt was interrupted or failed and the resize is resumed once
+// its backoff, doubled at each attempt, is over. Removing the marker resumes it right away.
+func (d *nodeService) startResize(ctx context.Context, markerPath string, volumeID string, requiredBytes int64, now time.Time) error {
+	marker, err := readResizeMarker(markerPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "error reading resize marker of volume %s: %s", volumeID, err.Error())
+	}
+
+	if marker != nil && marker.RequiredBytes == requiredBytes {
+		if next := marker.nextAttempt(); now.Before(next) {
+			return status.Errorf(codes.Unavailable, "resize of volume %s did not complete after %d attempts since %s, it is resumed after %s or once %s is removed", volumeID, marker.Attempts, marker.StartedAt, next.Format(time.RFC3339), markerPath)
+		}
+		klog.FromContext(ctx).Info("previous resize did not complete, resuming it", "volumeID", volumeID, "startedAt", marker.StartedAt, "attempt", marker.Attempts+1)
+	} else {
+		marker = &resizeMarker{
+			VolumeID:      volumeID,
+			RequiredBytes: requiredBytes,
+			StartedAt:     now,
+		}
+	}
+	marker.Attempts++
+	marker.LastAttemptAt = now
+
+	if err := writeResizeMarker(markerPath, marker); err != nil {
+		return status.Errorf(codes.Internal, "error writing resize marker of volume %s: %s", volumeID, err.Error())
+	}
+	return nil
+}
+
+// hostError returns the FailedPrecondition error reporting the problems found on the host, if any
+func (d *nodeService) hostError() error {
+	if len(d.hostProblems) == 0 {
+		return nil
+	}
+	return status.Errorf(codes.FailedPrecondition, "missing dependencies on the node: %s", strings.Join(d.hostProblems, "; "))
+}
@@ -0,0 +1,43 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// sysModulePath is the directory listing the loaded kernel modules
+const sysModulePath = "/sys/module"
+
+// checkNodeHost looks for the binaries, kernel modules and directories the node needs to stage volumes.
+// The problems prevent staging any volume, while the warnings only affect some of them or may resolve themselves.
+func checkNodeHost(encryption bool, lookPath func(file string) (string, error), sysModule string) (problems []string, warnings []string) {
+	if _, err := lookPath("mkfs.ext4"); err != nil {
+		problems = append(problems, "mkfs.ext4 not found in the PATH, install e2fsprogs in the image of the node plugin")
+	}
+	if _, err := lookPath("mkfs.xfs"); err != nil {
+		warnings = append(warnings, "mkfs.xfs not found in the PATH, the volumes with the xfs filesystem type cannot be formatted, install xfsprogs in the image of the node plugin")
+	}
+
+	if _, err := lookPath(wipefsCmd); err != nil {
+		warnings = append(warnings, "wipefs not found in the PATH, only blkid checks that a device is empty before formatting it, install wipefs in the image of the node plugin")
+	}
+
+	if _, err := lookPath(xfsQuotaCmd); err != nil {
+		warnings = append(warnings, "xfs_quota not found in the PATH, the volumes with the xfsQuota parameter cannot be published, install xfsprogs-extra in the image of the node plugin")
+	}
+
+	if encryption {
+		if _, err := lookPath(cryptsetupCmd); err != nil {
+			problems = append(problems, fmt.Sprintf("%s not found, install cryptsetup in the image of the node plugin, set its path with --cryptsetup-path or start the driver with --disable-encryption", cryptsetupCmd))
+		}
+		if _, err := os.Stat(filepath.Join(sysModule, "dm_crypt")); err != nil {
+			warnings = append(warnings, "the dm_crypt kernel module is not loaded, the encrypted volumes cannot be opened unless the kernel loads it on demand, run modprobe dm_crypt on the host")
+		}
+	}
+
+	if _, err := os.Stat(diskByIDPath); err != nil {
+		warnings = append(warnings, fmt.Sprintf("%s not found, the devices of the volumes are looked up in sysfs, mount the /dev of the host in the node plugin if udev runs on the host", diskByIDPath))
+	}
+	return problems, warnings
+}
@@ -0,0 +1,42 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_checkNodeHost(t *testing.T) {
+	sysModule := t.TempDir()
+	installed := map[string]bool{"mkfs.ext4": true, "mkfs.xfs": true, cryptsetupCmd: true}
+	lookPath := func(file string) (string, error) {
+		if installed[file] {
+			return "/usr/sbin/" + file, nil
+		}
+		return "", errors.New("not found")
+	}
+
+	AssertNoError(t, os.Mkdir(filepath.Join(sysModule, "dm_crypt"), 0750))
+	problems, _ := checkNodeHost(true, lookPath, sysModule)
+	Equals(t, 0, len(problems))
+
+	installed[cryptsetupCmd] = false
+	problems, _ = checkNodeHost(true, lookPath, sysModule)
+	Equals(t, 1, len(problems))
+	problems, _ = checkNodeHost(false, lookPath, sysModule)
+	Equals(t, 0, len(problems))
+
+	installed["mkfs.ext4"] = false
+	problems, _ = checkNodeHost(false, lookPath, sysModule)
+	Equals(t, 1, len(problems))
+
+	d := &Driver{nodeService: nodeService{hostProblems: problems}}
+	_, err := d.Probe(context.Background(), &csi.ProbeRequest{})
+	Equals(t, codes.FailedPrecondition, status.Code(err))
+}
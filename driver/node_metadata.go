@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"k8s.io/klog/v2"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+)
+
+// providerIDPrefix prefixes the providerID of the Kubernetes Nodes of the Scaleway instances, followed by <zone>/<id>
+const providerIDPrefix = "scaleway://instance/"
+
+// nodeMetadata describes the instance the node plugin runs on
+type nodeMetadata struct {
+	id             string
+	zone           scw.Zone
+	commercialType string
+	// localVolumes is the number of local volumes of the instance, unknown without the metadata API
+	localVolumes int
+}
+
+// getNodeMetadata returns the description of the instance given by the metadata API, falling back to
+// the NodeID and NodeZone of the config when it is unreachable, so that the node plugin still starts
+func getNodeMetadata(config *DriverConfig, metadataAPI scaleway.Metadata) (*nodeMetadata, error) {
+	metadata, metadataErr := metadataAPI.GetMetadata()
+	if metadataErr == nil {
+		zone, err := scw.ParseZone(metadata.Location.ZoneID)
+		if err != nil {
+			return nil, err
+		}
+		if config.NodeID != "" {
+			if id, _, err := configuredNodeID(config); err != nil || id != metadata.ID {
+				klog.Warningf("the configured node ID %s does not match the instance %s of the metadata API, which is used", config.NodeID, metadata.ID)
+			}
+		}
+		return &nodeMetadata{
+			id:             metadata.ID,
+			zone:           zone,
+			commercialType: metadata.CommercialType,
+			localVolumes:   scaleway.LocalVolumesCount(metadata),
+		}, nil
+	}
+
+	if config.NodeID == "" {
+		return nil, fmt.Errorf("error getting the metadata of the instance, set the node ID and zone if the metadata API is unreachable: %w", metadataErr)
+	}
+	id, zone, err := configuredNodeID(config)
+	if err != nil {
+		return nil, err
+	}
+	klog.Warningf("the metadata API is unreachable (%s), using the configured node ID %s in zone %s: the local volumes of the instance are unknown, keep their attachment slots with the reserved volume slots", metadataErr, id, zone)
+	return &nodeMetadata{
+		id:   id,
+		zone: zone,
+	}, nil
+}
+
+// configuredNodeID returns the instance ID and zone of the config, its NodeID being either
+// an instance ID or the providerID of the Kubernetes Node (scaleway://instance/<zone>/<id>)
+func configuredNodeID(config *DriverConfig) (string, scw.Zone, error) {
+	id, zone := config.NodeID, config.NodeZone
+	if strings.HasPrefix(id, providerIDPrefix) {
+		parts := strings.Split(strings.TrimPrefix(id, providerIDPrefix), "/")
+		if len(parts) != 2 || parts[1] == "" {
+			return "", "", fmt.Errorf("invalid providerID %s, expected %s<zone>/<id>", id, providerIDPrefix)
+		}
+		providerZone, err := scw.ParseZone(parts[0])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid zone in providerID %s: %w", id, err)
+		}
+		if zone != "" && zone != providerZone {
+			return "", "", fmt.Errorf("the zone %s of providerID %s does not match the node zone %s", providerZone, id, zone)
+		}
+		id, zone = parts[1], providerZone
+	}
+	if zone == "" {
+		return "", "", fmt.Errorf("the zone of node %s must be set along with its ID", id)
+	}
+	return id, zone, nil
+}
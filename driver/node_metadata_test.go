@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+type fakeMetadata struct {
+	metadata *instance.Metadata
+}
+
+func (m fakeMetadata) GetMetadata() (*instance.Metadata, error) {
+	if m.metadata == nil {
+		return nil, errors.New("metadata API unreachable")
+	}
+	return m.metadata, nil
+}
+
+func Test_getNodeMetadata(t *testing.T) {
+	metadata := &instance.Metadata{}
+	err := json.Unmarshal([]byte(`{"id": "server-id", "commercial_type": "DEV1-S", "location": {"zone_id": "fr-par-1"}, "volumes": {"0": {"volume_type": "l_ssd"}}}`), metadata)
+	AssertNoError(t, err)
+
+	// the metadata API is preferred over the configuration
+	node, err := getNodeMetadata(&DriverConfig{NodeID: "other-id", NodeZone: scw.ZoneNlAms1}, fakeMetadata{metadata})
+	AssertNoError(t, err)
+	Equals(t, &nodeMetadata{id: "server-id", zone: scw.ZoneFrPar1, commercialType: "DEV1-S", localVolumes: 1}, node)
+
+	_, err = getNodeMetadata(&DriverConfig{}, fakeMetadata{})
+	AssertTrue(t, err != nil)
+
+	node, err = getNodeMetadata(&DriverConfig{NodeID: "server-id", NodeZone: scw.ZoneFrPar1}, fakeMetadata{})
+	AssertNoError(t, err)
+	Equals(t, &nodeMetadata{id: "server-id", zone: scw.ZoneFrPar1}, node)
+
+	node, err = getNodeMetadata(&DriverConfig{NodeID: "scaleway://instance/fr-par-2/server-id"}, fakeMetadata{})
+	AssertNoError(t, err)
+	Equals(t, &nodeMetadata{id: "server-id", zone: scw.ZoneFrPar2}, node)
+
+	_, err = getNodeMetadata(&DriverConfig{NodeID: "server-id"}, fakeMetadata{})
+	AssertTrue(t, err != nil)
+
+	_, err = getNodeMetadata(&DriverConfig{NodeID: "scaleway://instance/fr-par-2/server-id", NodeZone: scw.ZoneFrPar1}, fakeMetadata{})
+	AssertTrue(t, err != nil)
+}
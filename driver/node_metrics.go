@@ -0,0 +1,88 @@
+package driver
+
+import (
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// nodeOperationBuckets are the upper bounds in seconds of the buckets of the node operation durations
+var nodeOperationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// the durations of the node operations delaying the start of the pods, in the node_operation_seconds metrics:
+// the wait for the /dev/disk/by-id symlink of the device after its attachment, the formatting of the empty
+// devices and the mount of the staged volumes
+var (
+	deviceWaitDurations = newDurationHistogram(nodeOperationBuckets)
+	formatDurations     = newDurationHistogram(nodeOperationBuckets)
+	mountDurations      = newDurationHistogram(nodeOperationBuckets)
+)
+
+func init() {
+	nodeOperations := expvar.NewMap("node_operation_seconds")
+	nodeOperations.Set("device_wait", deviceWaitDurations)
+	nodeOperations.Set("format", formatDurations)
+	nodeOperations.Set("mount", mountDurations)
+}
+
+// durationHistogram counts durations in cumulative buckets, like a Prometheus histogram
+type durationHistogram struct {
+	bounds []float64
+
+	// counts has one more element than bounds, the last one being the +Inf bucket
+	counts []uint64
+	count  uint64
+	sum    float64
+	mux    sync.Mutex
+}
+
+func newDurationHistogram(bounds []float64) *durationHistogram {
+	return &durationHistogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// observe adds the duration to the histogram
+func (h *durationHistogram) observe(duration time.Duration) {
+	seconds := duration.Seconds()
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for i, bound := range h.bounds {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.counts[len(h.bounds)]++
+	h.count++
+	h.sum += seconds
+}
+
+// since adds the duration elapsed since start to the histogram
+func (h *durationHistogram) since(start time.Time) {
+	h.observe(time.Since(start))
+}
+
+// String returns the histogram in JSON, implementing expvar.Var
+func (h *durationHistogram) String() string {
+	h.mux.Lock()
+	buckets := make(map[string]uint64, len(h.counts))
+	for i, bound := range h.bounds {
+		buckets[strconv.FormatFloat(bound, 'g', -1, 64)] = h.counts[i]
+	}
+	buckets["+Inf"] = h.counts[len(h.bounds)]
+	histogram := struct {
+		Buckets map[string]uint64 `json:"buckets"`
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+	}{buckets, h.count, h.sum}
+	h.mux.Unlock()
+
+	out, err := json.Marshal(histogram)
+	if err != nil {
+		return "{}"
+	}
+	return string(out)
+}
@@ -0,0 +1,24 @@
+package driver
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func Test_durationHistogram(t *testing.T) {
+	h := newDurationHistogram([]float64{0.5, 1, 5})
+	h.observe(200 * time.Millisecond)
+	h.observe(time.Second)
+	h.observe(10 * time.Second)
+
+	var histogram struct {
+		Buckets map[string]uint64 `json:"buckets"`
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+	}
+	AssertNoError(t, json.Unmarshal([]byte(h.String()), &histogram))
+	Equals(t, map[string]uint64{"0.5": 1, "1": 2, "5": 2, "+Inf": 3}, histogram.Buckets)
+	Equals(t, uint64(3), histogram.Count)
+	Equals(t, 11.2, histogram.Sum)
+}
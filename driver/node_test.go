@@ -0,0 +1,377 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	kmount "k8s.io/mount-utils"
+	kexec "k8s.io/utils/exec"
+)
+
+func newNodeStageRequest(t *testing.T, volumeID string, readOnly bool) *csi.NodeStageVolumeRequest {
+	publishContext := map[string]string{
+		scwVolumeID:   volumeID,
+		scwVolumeName: "volume",
+	}
+	if readOnly {
+		publishContext[scwVolumeReadOnly] = "true"
+	}
+	return &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: filepath.Join(t.TempDir(), "globalmount"),
+		PublishContext:    publishContext,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}
+}
+
+func Test_NodeStageVolumeReadOnly(t *testing.T) {
+	fake := &fakeHelper{
+		fakeDiskUtils: fakeDiskUtils{
+			kMounter: &kmount.SafeFormatAndMount{
+				Interface: kmount.New(""),
+				Exec:      kexec.New(),
+			},
+			devices:     map[string]*mountpoint{},
+			allAttached: true,
+		},
+	}
+	d := &nodeService{diskUtils: fake}
+	volumeID := "0f6dd2d4-fd4b-4de8-9e65-2e8b0f6c7d6a"
+	devicePath := path.Join(diskByIDPath, diskSCWPrefix+volumeID)
+
+	// the first read-only stage formats the empty device before setting it read-only
+	req := newNodeStageRequest(t, volumeID, true)
+	_, err := d.NodeStageVolume(context.Background(), req)
+	AssertNoError(t, err)
+	AssertTrue(t, fake.formattedDevices[devicePath])
+	AssertTrue(t, fake.readOnlyDevices[devicePath])
+
+	AssertNoError(t, os.MkdirAll(req.GetStagingTargetPath(), 0750))
+	_, err = d.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: req.GetStagingTargetPath(),
+	})
+	AssertNoError(t, err)
+	AssertFalse(t, fake.readOnlyDevices[devicePath])
+
+	// the device is writable again for a read-write stage on the same node
+	_, err = d.NodeStageVolume(context.Background(), newNodeStageRequest(t, volumeID, false))
+	AssertNoError(t, err)
+	AssertFalse(t, fake.readOnlyDevices[devicePath])
+}
+
+func Test_NodeStageVolumeMkfsOptions(t *testing.T) {
+	fake := &fakeHelper{fakeDiskUtils: fakeDiskUtils{devices: map[string]*mountpoint{}, allAttached: true}}
+	d := &nodeService{diskUtils: fake}
+	volumeID := "b3c1f0a2-5d4e-4f6a-8b7c-9d0e1f2a3b4c"
+	devicePath := path.Join(diskByIDPath, diskSCWPrefix+volumeID)
+
+	// the mkfsOptions of the volume context are passed to mkfs, split on the spaces
+	req := newNodeStageRequest(t, volumeID, false)
+	req.VolumeContext = map[string]string{mkfsOptionsKey: "-E lazy_itable_init=1,lazy_journal_init=1"}
+	_, err := d.NodeStageVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, []string{"-E", "lazy_itable_init=1,lazy_journal_init=1"}, fake.devices[devicePath].formatOptions)
+}
+
+// stageResizeFake reports a filesystem smaller than its device, as after an offline expansion
+type stageResizeFake struct {
+	*fakeHelper
+	resizeErr error
+	resized   []string
+}
+
+func (f *stageResizeFake) NeedResize(devicePath string, targetPath string) (bool, error) {
+	return true, nil
+}
+
+func (f *stageResizeFake) Resize(targetPath string, devicePath, passphrase string) error {
+	if f.resizeErr != nil {
+		return f.resizeErr
+	}
+	f.resized = append(f.resized, targetPath)
+	return nil
+}
+
+func Test_NodeStageVolumeResize(t *testing.T) {
+	fake := &stageResizeFake{
+		fakeHelper: &fakeHelper{fakeDiskUtils: fakeDiskUtils{devices: map[string]*mountpoint{}, allAttached: true}},
+		resizeErr:  errors.New("resize2fs failed"),
+	}
+	d := &nodeService{diskUtils: fake}
+	volumeID := "8e2d4c6a-1b3f-4d5e-9a7c-0f1e2d3c4b5a"
+	req := newNodeStageRequest(t, volumeID, false)
+
+	// a failed resize fails the staging, while the volume stays mounted
+	_, err := d.NodeStageVolume(context.Background(), req)
+	Equals(t, codes.Internal, status.Code(err))
+	Equals(t, 0, len(fake.resized))
+
+	// the retry finds the volume mounted and resizes it again
+	fake.resizeErr = nil
+	_, err = d.NodeStageVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, []string{req.GetStagingTargetPath()}, fake.resized)
+
+	// the marker of a NodeExpandVolume interrupted before the volume was unstaged is removed once resized
+	markerPath := resizeMarkerPath(req.GetStagingTargetPath(), volumeID)
+	AssertNoError(t, d.startResize(context.Background(), markerPath, volumeID, 10, time.Now()))
+	_, err = d.NodeStageVolume(context.Background(), req)
+	AssertNoError(t, err)
+	marker, err := readResizeMarker(markerPath)
+	AssertNoError(t, err)
+	AssertTrue(t, marker == nil)
+
+	// the read-only volumes are never resized
+	req = newNodeStageRequest(t, volumeID+"-ro", true)
+	fake.resized = nil
+	_, err = d.NodeStageVolume(context.Background(), req)
+	AssertNoError(t, err)
+	Equals(t, 0, len(fake.resized))
+}
+
+func Test_NodeStageVolumeReadOnlyCheckFilesystem(t *testing.T) {
+	fake := &fakeHelper{fakeDiskUtils: fakeDiskUtils{devices: map[string]*mountpoint{}, allAttached: true}}
+	d := &nodeService{diskUtils: fake}
+	volumeID := "6c0f3b1e-8f55-4a4e-a3d1-0d4c2b8e9f10"
+	devicePath := path.Join(diskByIDPath, diskSCWPrefix+volumeID)
+
+	// the filesystem is checked while the device is still writable
+	req := newNodeStageRequest(t, volumeID, true)
+	req.VolumeContext = map[string]string{fsckBeforeMountKey: "true"}
+	_, err := d.NodeStageVolume(context.Background(), req)
+	AssertNoError(t, err)
+	AssertTrue(t, fake.readOnlyDevices[devicePath])
+}
+
+func Test_NodePublishVolumeXFSQuota(t *testing.T) {
+	fake := &fakeHelper{
+		fakeDiskUtils: fakeDiskUtils{
+			kMounter: &kmount.SafeFormatAndMount{
+				Interface: kmount.New(""),
+				Exec:      kexec.New(),
+			},
+			devices:     map[string]*mountpoint{},
+			allAttached: true,
+		},
+	}
+	d := &nodeService{diskUtils: fake, config: &DriverConfig{}}
+	volumeID := "3a1c9e4f-7b2d-4e8a-9c6f-5d0b1a2e3f47"
+
+	stageReq := newNodeStageRequest(t, volumeID, false)
+	stageReq.VolumeCapability.GetMount().FsType = "xfs"
+	stageReq.VolumeContext = map[string]string{xfsQuotaKey: "1073741824"}
+	_, err := d.NodeStageVolume(context.Background(), stageReq)
+	AssertNoError(t, err)
+
+	publish := func(targetPath string) {
+		_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:          volumeID,
+			StagingTargetPath: stageReq.GetStagingTargetPath(),
+			TargetPath:        targetPath,
+			PublishContext:    stageReq.GetPublishContext(),
+			VolumeCapability:  stageReq.GetVolumeCapability(),
+			VolumeContext:     stageReq.GetVolumeContext(),
+		})
+		AssertNoError(t, err)
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	// each publication gets its own directory, the same on retries
+	pods := t.TempDir()
+	targetA, targetB := filepath.Join(pods, "a", "volume"), filepath.Join(pods, "b", "volume")
+	dirA, dirB := xfsQuotaDir(stageReq.GetStagingTargetPath(), targetA), xfsQuotaDir(stageReq.GetStagingTargetPath(), targetB)
+	AssertTrue(t, dirA != dirB)
+	publish(targetA)
+	publish(targetA)
+	publish(targetB)
+	AssertTrue(t, exists(dirA))
+	AssertTrue(t, exists(dirB))
+	Equals(t, targetA, fake.devices[dirA].targetPath)
+
+	// the directory and its project are removed when the pod is unpublished
+	_, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: targetA})
+	AssertNoError(t, err)
+	AssertFalse(t, exists(dirA))
+	AssertTrue(t, exists(dirB))
+}
+
+func Test_NodePublishVolumeLocksStagingPath(t *testing.T) {
+	fake := &fakeHelper{fakeDiskUtils: fakeDiskUtils{devices: map[string]*mountpoint{}, allAttached: true}}
+	d := &nodeService{diskUtils: fake}
+	volumeID := "9d2e7c41-0b6a-4f3e-8a5d-c1f0e2b3a4d5"
+	stageReq := newNodeStageRequest(t, volumeID, false)
+	_, err := d.NodeStageVolume(context.Background(), stageReq)
+	AssertNoError(t, err)
+
+	// a publication waits for the operation running on the staging path, e.g. the publication of another pod
+	unlock := d.pathLocks.lock(stageReq.GetStagingTargetPath())
+	done := make(chan error)
+	go func() {
+		_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:          volumeID,
+			StagingTargetPath: stageReq.GetStagingTargetPath(),
+			TargetPath:        filepath.Join(t.TempDir(), "volume"),
+			PublishContext:    stageReq.GetPublishContext(),
+			VolumeCapability:  stageReq.GetVolumeCapability(),
+		})
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("the publication did not wait for the lock of the staging path")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	AssertNoError(t, <-done)
+}
+
+// volumeConditionFake reports the state of a staged volume checked by getVolumeCondition
+type volumeConditionFake struct {
+	*fakeHelper
+	deviceMissing bool
+	superOptions  []string
+	encrypted     bool
+	mappedPath    string
+}
+
+func (f *volumeConditionFake) GetDevicePath(volumeID string) (string, error) {
+	if f.deviceMissing {
+		return "", os.ErrNotExist
+	}
+	return path.Join(diskByIDPath, diskSCWPrefix+volumeID), nil
+}
+
+func (f *volumeConditionFake) GetMountInfo(targetPath string) (*mountInfo, error) {
+	return &mountInfo{mountPoint: targetPath, mountOptions: []string{"ro"}, superOptions: f.superOptions}, nil
+}
+
+func (f *volumeConditionFake) IsEncrypted(devicePath string) (bool, error) {
+	return f.encrypted, nil
+}
+
+func (f *volumeConditionFake) GetMappedDevicePath(volumeID string) (string, error) {
+	return f.mappedPath, nil
+}
+
+func Test_getVolumeCondition(t *testing.T) {
+	volumeID := "1d5b7e8f-3a2c-4b6d-9e0f-7a8b9c0d1e2f"
+	volumePath := "/var/lib/kubelet/plugins/kubernetes.io/csi/csi.scaleway.com/globalmount"
+
+	tests := []struct {
+		name     string
+		fake     *volumeConditionFake
+		abnormal bool
+	}{
+		{
+			// a read-only bind mount does not make the volume abnormal
+			name: "healthy",
+			fake: &volumeConditionFake{superOptions: []string{"rw"}},
+		},
+		{
+			name:     "device missing",
+			fake:     &volumeConditionFake{deviceMissing: true},
+			abnormal: true,
+		},
+		{
+			name:     "filesystem remounted read-only",
+			fake:     &volumeConditionFake{superOptions: []string{"ro", "errors=remount-ro"}},
+			abnormal: true,
+		},
+		{
+			name:     "LUKS mapping closed",
+			fake:     &volumeConditionFake{superOptions: []string{"rw"}, encrypted: true},
+			abnormal: true,
+		},
+		{
+			name: "LUKS mapping open",
+			fake: &volumeConditionFake{superOptions: []string{"rw"}, encrypted: true, mappedPath: diskLuksMapperPath + diskLuksMapperPrefix + volumeID},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fake.fakeHelper = &fakeHelper{}
+			d := &nodeService{diskUtils: tt.fake}
+			condition, err := d.getVolumeCondition(volumeID, volumePath)
+			AssertNoError(t, err)
+			Equals(t, tt.abnormal, condition.GetAbnormal())
+		})
+	}
+}
+
+func Test_NodeUnstageVolumeRemovesResizeMarker(t *testing.T) {
+	fake := &fakeHelper{
+		fakeDiskUtils: fakeDiskUtils{
+			kMounter: &kmount.SafeFormatAndMount{
+				Interface: kmount.New(""),
+				Exec:      kexec.New(),
+			},
+			devices:     map[string]*mountpoint{},
+			allAttached: true,
+		},
+	}
+	d := &nodeService{diskUtils: fake}
+	volumeID := "4b7e1d2c-3a5f-4e6d-8c9b-0a1f2e3d4c5b"
+	req := newNodeStageRequest(t, volumeID, false)
+	_, err := d.NodeStageVolume(context.Background(), req)
+	AssertNoError(t, err)
+
+	// an interrupted NodeExpandVolume leaves its marker and the temporary file of its write
+	markerPath := resizeMarkerPath(req.GetStagingTargetPath(), volumeID)
+	AssertNoError(t, d.startResize(context.Background(), markerPath, volumeID, 10, time.Now()))
+	AssertNoError(t, os.WriteFile(markerPath+".tmp", nil, 0600))
+
+	AssertNoError(t, os.MkdirAll(req.GetStagingTargetPath(), 0750))
+	_, err = d.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: req.GetStagingTargetPath(),
+	})
+	AssertNoError(t, err)
+	for _, p := range []string{markerPath, markerPath + ".tmp"} {
+		_, err = os.Stat(p)
+		AssertTrue(t, os.IsNotExist(err))
+	}
+}
+
+func Test_NodeExpandVolumeLocksStagingPath(t *testing.T) {
+	fake := &fakeHelper{fakeDiskUtils: fakeDiskUtils{devices: map[string]*mountpoint{}, allAttached: true}}
+	d := &nodeService{diskUtils: fake}
+	volumeID := "7a1c3e5f-2b4d-4f6a-9c8e-1d3b5f7a9c2e"
+	stageReq := newNodeStageRequest(t, volumeID, false)
+	_, err := d.NodeStageVolume(context.Background(), stageReq)
+	AssertNoError(t, err)
+
+	// an expansion waits for the operation running on the staging path, e.g. its unstaging
+	unlock := d.pathLocks.lock(stageReq.GetStagingTargetPath())
+	done := make(chan error)
+	go func() {
+		_, err := d.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+			VolumeId:          volumeID,
+			VolumePath:        stageReq.GetStagingTargetPath(),
+			StagingTargetPath: stageReq.GetStagingTargetPath(),
+			CapacityRange:     &csi.CapacityRange{RequiredBytes: 10},
+		})
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("the expansion did not wait for the lock of the staging path")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	AssertNoError(t, <-done)
+}
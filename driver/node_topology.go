@@ -0,0 +1,56 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// nodeLabelTopology adds the values of some labels of the Kubernetes Node to the topology of the node,
+// e.g. a rack label, so that the volumes can be scheduled on placement domains finer than the zone.
+// A nil *nodeLabelTopology adds nothing.
+type nodeLabelTopology struct {
+	client   kubernetes.Interface
+	nodeName string
+	labels   []string
+}
+
+// newNodeLabelTopology returns the nodeLabelTopology of the given labels of the Kubernetes Node nodeName
+func newNodeLabelTopology(client kubernetes.Interface, nodeName string, labels []string) (*nodeLabelTopology, error) {
+	if nodeName == "" {
+		return nil, fmt.Errorf("the name of the Kubernetes Node is required to read its topology labels, see $%s", NodeNameEnv)
+	}
+	for _, label := range labels {
+		if label == ZoneTopologyKey || label == RegionTopologyKey {
+			return nil, fmt.Errorf("the topology label %s is already set by the driver", label)
+		}
+	}
+	return &nodeLabelTopology{
+		client:   client,
+		nodeName: nodeName,
+		labels:   labels,
+	}, nil
+}
+
+// addSegments adds the values of the labels of the Kubernetes Node to the segments, skipping the missing labels
+func (t *nodeLabelTopology) addSegments(ctx context.Context, segments map[string]string) error {
+	if t == nil {
+		return nil
+	}
+	node, err := t.client.CoreV1().Nodes().Get(ctx, t.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting Kubernetes Node %s: %w", t.nodeName, err)
+	}
+	for _, label := range t.labels {
+		value, ok := node.Labels[label]
+		if !ok {
+			klog.Warningf("Kubernetes Node %s has no label %s, it is not added to its topology", t.nodeName, label)
+			continue
+		}
+		segments[label] = value
+	}
+	return nil
+}
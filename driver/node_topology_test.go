@@ -0,0 +1,42 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_nodeLabelTopology(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node-1",
+			Labels: map[string]string{"example.com/rack": "rack-2"},
+		},
+	})
+
+	_, err := newNodeLabelTopology(client, "", []string{"example.com/rack"})
+	AssertTrue(t, err != nil)
+	_, err = newNodeLabelTopology(client, "node-1", []string{ZoneTopologyKey})
+	AssertTrue(t, err != nil)
+
+	topology, err := newNodeLabelTopology(client, "node-1", []string{"example.com/rack", "example.com/row"})
+	AssertNoError(t, err)
+	segments := topologySegments(scw.ZoneFrPar2)
+	AssertNoError(t, topology.addSegments(context.Background(), segments))
+	Equals(t, map[string]string{
+		ZoneTopologyKey:    "fr-par-2",
+		RegionTopologyKey:  "fr-par",
+		"example.com/rack": "rack-2",
+	}, segments)
+
+	topology.nodeName = "node-2"
+	AssertTrue(t, topology.addSegments(context.Background(), segments) != nil)
+
+	// nothing is added without labels
+	var disabled *nodeLabelTopology
+	AssertNoError(t, disabled.addSegments(context.Background(), segments))
+}
@@ -0,0 +1,121 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// runOrphanGC looks for the orphaned volumes of the cluster every interval until stop is closed
+func (d *controllerService) runOrphanGC(client kubernetes.Interface, interval time.Duration, stop <-chan struct{}) {
+	action := "reporting"
+	if d.config.OrphanGCDelete {
+		action = "deleting"
+	}
+	klog.Infof("%s the volumes of cluster %s without PersistentVolume for %s every %s", action, d.config.ClusterID, d.config.OrphanGCGrace, interval)
+
+	// the first time each volume was found orphaned
+	orphanedSince := make(map[string]time.Time)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			orphans, err := d.collectOrphanedVolumes(ctx, client, orphanedSince, time.Now())
+			cancel()
+			if err != nil {
+				klog.Errorf("error looking for orphaned volumes: %s", err)
+			}
+			if orphans > 0 {
+				klog.Infof("found %d orphaned volume(s) past the grace period", orphans)
+			}
+		}
+	}
+}
+
+// collectOrphanedVolumes reports, or deletes with OrphanGCDelete, the detached volumes tagged with the ID of the cluster
+// which have had no PersistentVolume for OrphanGCGrace. orphanedSince keeps the first time each volume was found orphaned
+// across the calls. It returns the number of volumes past the grace period.
+func (d *controllerService) collectOrphanedVolumes(ctx context.Context, client kubernetes.Interface, orphanedSince map[string]time.Time, now time.Time) (int, error) {
+	// the volumes are listed first, so that a volume created in between has its PersistentVolume listed
+	zones := d.scaleway.Zones()
+	if len(zones) == 0 {
+		zones = []scw.Zone{""} // this will use the default zone of the client
+	}
+	var volumes []*instance.Volume
+	for _, zone := range zones {
+		volumesResp, err := d.scaleway.ListVolumes(&instance.ListVolumesRequest{
+			Zone: zone,
+			Tags: []string{managedByTag, clusterIDTagPrefix + d.config.ClusterID},
+		}, scw.WithContext(ctx), scw.WithAllPages())
+		if err != nil {
+			return 0, fmt.Errorf("error listing volumes: %w", err)
+		}
+		volumes = append(volumes, volumesResp.Volumes...)
+	}
+
+	pvs, err := client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("error listing persistent volumes: %w", err)
+	}
+	referenced := make(map[string]bool, len(pvs.Items))
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != DriverName {
+			continue
+		}
+		if volumeID, _, err := GetVolumeIDAndZone(pv.Spec.CSI.VolumeHandle); err == nil {
+			referenced[volumeID] = true
+		}
+	}
+
+	orphans := 0
+	seen := make(map[string]bool, len(volumes))
+	for _, volume := range volumes {
+		if referenced[volume.ID] || volume.Server != nil || hasTag(volume.Tags, deletionProtectionTag) {
+			continue
+		}
+		seen[volume.ID] = true
+		since, ok := orphanedSince[volume.ID]
+		if !ok {
+			orphanedSince[volume.ID] = now
+			continue
+		}
+		if now.Sub(since) < d.config.OrphanGCGrace {
+			continue
+		}
+		orphans++
+
+		if !d.config.OrphanGCDelete {
+			klog.Warningf("volume %s (%s) in zone %s has had no PersistentVolume since %s", volume.ID, volume.Name, volume.Zone, since.Format(time.RFC3339))
+			continue
+		}
+		klog.Warningf("deleting volume %s (%s) in zone %s, which has had no PersistentVolume since %s", volume.ID, volume.Name, volume.Zone, since.Format(time.RFC3339))
+		err := d.scaleway.DeleteVolume(&instance.DeleteVolumeRequest{
+			VolumeID: volume.ID,
+			Zone:     volume.Zone,
+		}, scw.WithContext(ctx))
+		if err != nil {
+			klog.Errorf("error deleting orphaned volume %s: %s", volume.ID, err)
+			continue
+		}
+		delete(orphanedSince, volume.ID)
+		delete(seen, volume.ID)
+	}
+
+	// the volumes deleted or given a PersistentVolume since are forgotten
+	for volumeID := range orphanedSince {
+		if !seen[volumeID] {
+			delete(orphanedSince, volumeID)
+		}
+	}
+	return orphans, nil
+}
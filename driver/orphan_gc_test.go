@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_collectOrphanedVolumes(t *testing.T) {
+	clusterTags := []string{managedByTag, clusterIDTagPrefix + "cluster-a"}
+	fakeAPI := &fakeHelper{
+		fakeInstanceAPI: fakeInstanceAPI{
+			volumesMap: map[string]*instance.Volume{
+				"bound":     {ID: "bound", Zone: scw.ZoneFrPar1, Tags: clusterTags},
+				"orphan":    {ID: "orphan", Zone: scw.ZoneFrPar1, Tags: clusterTags},
+				"attached":  {ID: "attached", Zone: scw.ZoneFrPar1, Tags: clusterTags, Server: &instance.ServerSummary{ID: "server-id"}},
+				"protected": {ID: "protected", Zone: scw.ZoneFrPar1, Tags: append([]string{deletionProtectionTag}, clusterTags...)},
+				"other":     {ID: "other", Zone: scw.ZoneFrPar1, Tags: []string{managedByTag, clusterIDTagPrefix + "cluster-b"}},
+			},
+			defaultZone: scw.ZoneFrPar1,
+		},
+	}
+	d := &controllerService{
+		scaleway: &scaleway.Scaleway{InstanceAPI: fakeAPI},
+		config:   &DriverConfig{ClusterID: "cluster-a", OrphanGCGrace: time.Hour},
+	}
+	client := fake.NewSimpleClientset(&corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1234"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: DriverName, VolumeHandle: "fr-par-1/bound"},
+			},
+		},
+	})
+
+	orphanedSince := make(map[string]time.Time)
+	now := time.Now()
+	orphans, err := d.collectOrphanedVolumes(context.Background(), client, orphanedSince, now)
+	AssertNoError(t, err)
+	Equals(t, 0, orphans)
+	Equals(t, map[string]time.Time{"orphan": now}, orphanedSince)
+
+	// only reported until OrphanGCDelete is set
+	orphans, err = d.collectOrphanedVolumes(context.Background(), client, orphanedSince, now.Add(2*time.Hour))
+	AssertNoError(t, err)
+	Equals(t, 1, orphans)
+	_, ok := fakeAPI.volumesMap["orphan"]
+	AssertTrue(t, ok)
+
+	d.config.OrphanGCDelete = true
+	orphans, err = d.collectOrphanedVolumes(context.Background(), client, orphanedSince, now.Add(2*time.Hour))
+	AssertNoError(t, err)
+	Equals(t, 1, orphans)
+	Equals(t, 4, len(fakeAPI.volumesMap))
+	_, ok = fakeAPI.volumesMap["orphan"]
+	AssertFalse(t, ok)
+	Equals(t, 0, len(orphanedSince))
+}
@@ -0,0 +1,144 @@
+package driver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+// listCursor is the position of a paginated list merging the resources of several zones, encoded
+// in its opaque starting token. The resources of each zone are listed by increasing ID and the cursor
+// keeps the last ID returned in each zone, so that the resources created or deleted between two pages
+// do not shift the following ones, as an offset would.
+type listCursor struct {
+	// Kind is the kind of the listed resources, a token of a list being refused by the others
+	Kind    string            `json:"kind"`
+	LastIDs map[string]string `json:"lastIDs"`
+}
+
+const (
+	volumesListKind   = "volumes"
+	snapshotsListKind = "snapshots"
+)
+
+// errInvalidListCursor is returned for the starting tokens which cannot have been returned as the next token of the list
+var errInvalidListCursor = errors.New("invalid starting token")
+
+// decodeListCursor decodes a starting token of the given kind of list, an empty token being the start of the list.
+// A next token always holds the last ID of at least one zone, the other tokens are refused with errInvalidListCursor.
+func decodeListCursor(token string, kind string) (*listCursor, error) {
+	cursor := &listCursor{Kind: kind, LastIDs: make(map[string]string)}
+	if token == "" {
+		return cursor, nil
+	}
+	content, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidListCursor
+	}
+	decoded := &listCursor{}
+	if err := json.Unmarshal(content, decoded); err != nil {
+		return nil, errInvalidListCursor
+	}
+	if decoded.Kind != kind || len(decoded.LastIDs) == 0 {
+		return nil, errInvalidListCursor
+	}
+	for _, lastID := range decoded.LastIDs {
+		if lastID == "" {
+			return nil, errInvalidListCursor
+		}
+	}
+	return decoded, nil
+}
+
+// next returns a copy of the cursor, to be moved forward while listing the page following it.
+// It returns errInvalidListCursor if the cursor holds a zone which is not listed.
+func (c *listCursor) next(zones []scw.Zone) (*listCursor, error) {
+	next := &listCursor{Kind: c.Kind, LastIDs: make(map[string]string, len(c.LastIDs))}
+	for zone, lastID := range c.LastIDs {
+		if !containsZone(zones, scw.Zone(zone)) {
+			return nil, errInvalidListCursor
+		}
+		next.LastIDs[zone] = lastID
+	}
+	return next, nil
+}
+
+// encode returns the starting token of the cursor
+func (c *listCursor) encode() string {
+	content, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// paginateSnapshots returns the snapshots of the zones following the cursor, at most maxEntries
+// if not zero, and the token of the next page, empty if there is none.
+// The zones are listed one after the other, the following ones being only listed when needed.
+func paginateSnapshots(zones []scw.Zone, cursor *listCursor, maxEntries int, list func(zone scw.Zone) ([]*instance.Snapshot, error)) ([]*instance.Snapshot, string, error) {
+	next, err := cursor.next(zones)
+	if err != nil {
+		return nil, "", err
+	}
+
+	snapshots := []*instance.Snapshot{}
+	for _, zone := range zones {
+		zoneSnapshots, err := list(zone)
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Slice(zoneSnapshots, func(i, j int) bool {
+			return zoneSnapshots[i].ID < zoneSnapshots[j].ID
+		})
+
+		lastID, started := cursor.LastIDs[zone.String()]
+		for _, snapshot := range zoneSnapshots {
+			if started && snapshot.ID <= lastID {
+				continue
+			}
+			if maxEntries > 0 && len(snapshots) == maxEntries {
+				return snapshots, next.encode(), nil
+			}
+			snapshots = append(snapshots, snapshot)
+			next.LastIDs[zone.String()] = snapshot.ID
+		}
+	}
+	return snapshots, "", nil
+}
+
+// paginateVolumes returns the volumes of the zones following the cursor like paginateSnapshots,
+// except that the zones which cannot be listed are skipped and their errors returned in zoneErrs,
+// so that the following zones are still listed and all the unavailable zones reported at once.
+func paginateVolumes(zones []scw.Zone, cursor *listCursor, maxEntries int, list func(zone scw.Zone) ([]*instance.Volume, error)) ([]*instance.Volume, string, map[scw.Zone]error, error) {
+	next, err := cursor.next(zones)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	volumes := []*instance.Volume{}
+	zoneErrs := make(map[scw.Zone]error)
+	for _, zone := range zones {
+		zoneVolumes, err := list(zone)
+		if err != nil {
+			zoneErrs[zone] = err
+			continue
+		}
+		sort.Slice(zoneVolumes, func(i, j int) bool {
+			return zoneVolumes[i].ID < zoneVolumes[j].ID
+		})
+
+		lastID, started := cursor.LastIDs[zone.String()]
+		for _, volume := range zoneVolumes {
+			if started && volume.ID <= lastID {
+				continue
+			}
+			if maxEntries > 0 && len(volumes) == maxEntries {
+				return volumes, next.encode(), zoneErrs, nil
+			}
+			volumes = append(volumes, volume)
+			next.LastIDs[zone.String()] = volume.ID
+		}
+	}
+	return volumes, "", zoneErrs, nil
+}
@@ -0,0 +1,96 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+func Test_paginateSnapshots(t *testing.T) {
+	zones := []scw.Zone{scw.ZoneFrPar1, scw.ZoneFrPar2}
+	snapshots := map[scw.Zone][]string{
+		scw.ZoneFrPar1: {"c", "a", "b"},
+		scw.ZoneFrPar2: {"e", "d"},
+	}
+	list := func(zone scw.Zone) ([]*instance.Snapshot, error) {
+		var zoneSnapshots []*instance.Snapshot
+		for _, id := range snapshots[zone] {
+			zoneSnapshots = append(zoneSnapshots, &instance.Snapshot{ID: id, Zone: zone})
+		}
+		return zoneSnapshots, nil
+	}
+	ids := func(snapshots []*instance.Snapshot) []string {
+		var ids []string
+		for _, snapshot := range snapshots {
+			ids = append(ids, snapshot.ID)
+		}
+		return ids
+	}
+
+	cursor, err := decodeListCursor("", snapshotsListKind)
+	AssertNoError(t, err)
+	page, token, err := paginateSnapshots(zones, cursor, 2, list)
+	AssertNoError(t, err)
+	Equals(t, []string{"a", "b"}, ids(page))
+
+	// a deleted snapshot already returned and a new one before the cursor do not shift the next page
+	snapshots[scw.ZoneFrPar1] = []string{"c", "b", "0"}
+	cursor, err = decodeListCursor(token, snapshotsListKind)
+	AssertNoError(t, err)
+	page, token, err = paginateSnapshots(zones, cursor, 2, list)
+	AssertNoError(t, err)
+	Equals(t, []string{"c", "d"}, ids(page))
+
+	cursor, err = decodeListCursor(token, snapshotsListKind)
+	AssertNoError(t, err)
+	page, token, err = paginateSnapshots(zones, cursor, 2, list)
+	AssertNoError(t, err)
+	Equals(t, []string{"e"}, ids(page))
+	Equals(t, "", token)
+
+	_, err = decodeListCursor("10", snapshotsListKind)
+	Equals(t, errInvalidListCursor, err)
+
+	// the tokens of another list or holding unknown zones are refused
+	cursor, err = decodeListCursor("", snapshotsListKind)
+	AssertNoError(t, err)
+	_, token, err = paginateSnapshots(zones, cursor, 1, list)
+	AssertNoError(t, err)
+	_, err = decodeListCursor(token, volumesListKind)
+	Equals(t, errInvalidListCursor, err)
+	_, err = decodeListCursor((&listCursor{Kind: snapshotsListKind}).encode(), snapshotsListKind)
+	Equals(t, errInvalidListCursor, err)
+	cursor, err = decodeListCursor((&listCursor{Kind: snapshotsListKind, LastIDs: map[string]string{"nl-ams-1": "a"}}).encode(), snapshotsListKind)
+	AssertNoError(t, err)
+	_, _, err = paginateSnapshots(zones, cursor, 1, list)
+	Equals(t, errInvalidListCursor, err)
+}
+
+func Test_paginateVolumesUnavailableZone(t *testing.T) {
+	zones := []scw.Zone{scw.ZoneFrPar1, scw.ZoneFrPar2}
+	errUnavailable := errors.New("zone unavailable")
+	unavailable := map[scw.Zone]bool{scw.ZoneFrPar1: true}
+	list := func(zone scw.Zone) ([]*instance.Volume, error) {
+		if unavailable[zone] {
+			return nil, errUnavailable
+		}
+		return []*instance.Volume{{ID: "a", Zone: zone}, {ID: "b", Zone: zone}}, nil
+	}
+
+	// the zones following an unavailable one are still listed
+	cursor, err := decodeListCursor("", volumesListKind)
+	AssertNoError(t, err)
+	page, token, zoneErrs, err := paginateVolumes(zones, cursor, 0, list)
+	AssertNoError(t, err)
+	Equals(t, 2, len(page))
+	Equals(t, "", token)
+	Equals(t, map[scw.Zone]error{scw.ZoneFrPar1: errUnavailable}, zoneErrs)
+
+	// the errors of all the unavailable zones are returned
+	unavailable[scw.ZoneFrPar2] = true
+	_, _, zoneErrs, err = paginateVolumes(zones, cursor, 0, list)
+	AssertNoError(t, err)
+	Equals(t, map[scw.Zone]error{scw.ZoneFrPar1: errUnavailable, scw.ZoneFrPar2: errUnavailable}, zoneErrs)
+}
@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// resizeMarkerPrefix is the prefix of the file written next to the staging path while a volume is resized
+	resizeMarkerPrefix = "scw-resize-in-progress-"
+
+	// resizeAttemptBackoff is the duration after which an interrupted or failed resize is resumed,
+	// doubled at each attempt up to maxResizeAttemptBackoff
+	resizeAttemptBackoff    = 30 * time.Second
+	maxResizeAttemptBackoff = time.Hour
+)
+
+// resizeMarker is persisted during a NodeExpandVolume so that a subsequent call knows
+// that a previous attempt did not complete
+type resizeMarker struct {
+	VolumeID      string    `json:"volumeID"`
+	RequiredBytes int64     `json:"requiredBytes"`
+	StartedAt     time.Time `json:"startedAt"`
+	Attempts      int       `json:"attempts"`
+	LastAttemptAt time.Time `json:"lastAttemptAt"`
+}
+
+// nextAttempt returns the time after which the resize can be attempted again
+func (m *resizeMarker) nextAttempt() time.Time {
+	backoff := resizeAttemptBackoff
+	for i := 1; i < m.Attempts && backoff < maxResizeAttemptBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxResizeAttemptBackoff {
+		backoff = maxResizeAttemptBackoff
+	}
+	return m.LastAttemptAt.Add(backoff)
+}
+
+// resizeMarkerPath returns the path of the resize marker of the given volume,
+// which is stored next to the staging metadata of the CO
+func resizeMarkerPath(stagingTargetPath string, volumeID string) string {
+	return filepath.Join(filepath.Dir(stagingTargetPath), resizeMarkerPrefix+volumeID+".json")
+}
+
+// readResizeMarker returns the resize marker stored at the given path, or nil if there is none
+func readResizeMarker(path string) (*resizeMarker, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	marker := &resizeMarker{}
+	if err := json.Unmarshal(content, marker); err != nil {
+		return nil, fmt.Errorf("error parsing resize marker %s: %w", path, err)
+	}
+	return marker, nil
+}
+
+// writeResizeMarker atomically writes the given resize marker at the given path
+func writeResizeMarker(path string, marker *resizeMarker) error {
+	content, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// removeResizeMarker removes the resize marker at the given path, if any, with the temporary file
+// of an interrupted write
+func removeResizeMarker(path string) error {
+	for _, p := range []string{path, path + ".tmp"} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_startResize(t *testing.T) {
+	d := &nodeService{}
+	markerPath := resizeMarkerPath(filepath.Join(t.TempDir(), "globalmount"), "volume-id")
+	now := time.Now()
+
+	AssertNoError(t, d.startResize(context.Background(), markerPath, "volume-id", 10, now))
+	marker, err := readResizeMarker(markerPath)
+	AssertNoError(t, err)
+	Equals(t, 1, marker.Attempts)
+
+	// an interrupted resize is resumed once its backoff is over, doubled at each attempt
+	err = d.startResize(context.Background(), markerPath, "volume-id", 10, now.Add(resizeAttemptBackoff-time.Second))
+	Equals(t, codes.Unavailable, status.Code(err))
+	now = now.Add(resizeAttemptBackoff)
+	AssertNoError(t, d.startResize(context.Background(), markerPath, "volume-id", 10, now))
+	err = d.startResize(context.Background(), markerPath, "volume-id", 10, now.Add(2*resizeAttemptBackoff-time.Second))
+	Equals(t, codes.Unavailable, status.Code(err))
+	now = now.Add(2 * resizeAttemptBackoff)
+	AssertNoError(t, d.startResize(context.Background(), markerPath, "volume-id", 10, now))
+
+	// the resizes are never refused for good, the backoff being capped
+	for i := 0; i < 20; i++ {
+		now = now.Add(maxResizeAttemptBackoff)
+		AssertNoError(t, d.startResize(context.Background(), markerPath, "volume-id", 10, now))
+	}
+	marker, err = readResizeMarker(markerPath)
+	AssertNoError(t, err)
+	Equals(t, 23, marker.Attempts)
+
+	// removing the marker resumes the resize right away
+	AssertTrue(t, d.startResize(context.Background(), markerPath, "volume-id", 10, now) != nil)
+	AssertNoError(t, removeResizeMarker(markerPath))
+	AssertNoError(t, d.startResize(context.Background(), markerPath, "volume-id", 10, now))
+
+	// a resize to another size starts over
+	AssertNoError(t, d.startResize(context.Background(), markerPath, "volume-id", 20, now))
+	marker, err = readResizeMarker(markerPath)
+	AssertNoError(t, err)
+	Equals(t, 1, marker.Attempts)
+
+	// the temporary file of an interrupted write is removed with the marker
+	AssertNoError(t, os.WriteFile(markerPath+".tmp", nil, 0600))
+	AssertNoError(t, removeResizeMarker(markerPath))
+	marker, err = readResizeMarker(markerPath)
+	AssertNoError(t, err)
+	AssertTrue(t, marker == nil)
+	_, err = os.Stat(markerPath + ".tmp")
+	AssertTrue(t, os.IsNotExist(err))
+}
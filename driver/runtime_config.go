@@ -0,0 +1,178 @@
+package driver
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+)
+
+// configReloadInterval is the interval at which ConfigFile is checked for changes,
+// a mounted ConfigMap being updated by the kubelet within a minute or so
+const configReloadInterval = 10 * time.Second
+
+// runtimeConfig is the content of ConfigFile, applied again each time the file changes.
+// The unset settings have the value given by the flags of the driver.
+type runtimeConfig struct {
+	// Verbosity is the verbosity of the logs, like the -v flag
+	Verbosity *int `json:"verbosity,omitempty"`
+	// APIRateLimit is the maximum number of calls per second to the Scaleway API, unlimited if zero
+	APIRateLimit *float64 `json:"apiRateLimit,omitempty"`
+	// APIRateBurst is the number of calls to the Scaleway API allowed in a burst above APIRateLimit
+	APIRateBurst *int `json:"apiRateBurst,omitempty"`
+	// EmitEvents enables the Events posted by the controller, like --emit-events
+	EmitEvents *bool `json:"emitEvents,omitempty"`
+	// Metrics enables the metrics endpoint started with --metrics-addr
+	Metrics *bool `json:"metrics,omitempty"`
+}
+
+// runtimeConfigWatcher applies the runtime configuration of a file each time its content changes
+type runtimeConfigWatcher struct {
+	path string
+	// defaults are the values of the settings unset in the file
+	defaults runtimeConfig
+	// content is the content of the file last applied
+	content []byte
+
+	apply func(config runtimeConfig) error
+}
+
+// parseRuntimeConfig parses a runtime configuration, refusing the unknown settings
+func parseRuntimeConfig(content []byte) (runtimeConfig, error) {
+	var config runtimeConfig
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return config, err
+	}
+	if config.Verbosity != nil && *config.Verbosity < 0 {
+		return config, fmt.Errorf("invalid verbosity %d", *config.Verbosity)
+	}
+	if config.APIRateLimit != nil && *config.APIRateLimit < 0 {
+		return config, fmt.Errorf("invalid apiRateLimit %g", *config.APIRateLimit)
+	}
+	if config.APIRateBurst != nil && *config.APIRateBurst < 0 {
+		return config, fmt.Errorf("invalid apiRateBurst %d", *config.APIRateBurst)
+	}
+	return config, nil
+}
+
+// withDefaults returns the configuration with the unset settings taken from defaults
+func (c runtimeConfig) withDefaults(defaults runtimeConfig) runtimeConfig {
+	if c.Verbosity == nil {
+		c.Verbosity = defaults.Verbosity
+	}
+	if c.APIRateLimit == nil {
+		c.APIRateLimit = defaults.APIRateLimit
+	}
+	if c.APIRateBurst == nil {
+		c.APIRateBurst = defaults.APIRateBurst
+	}
+	if c.EmitEvents == nil {
+		c.EmitEvents = defaults.EmitEvents
+	}
+	if c.Metrics == nil {
+		c.Metrics = defaults.Metrics
+	}
+	return c
+}
+
+// reload applies the configuration of the file if its content changed since the last time.
+// An invalid configuration is not applied, the previous one being kept.
+func (w *runtimeConfigWatcher) reload() error {
+	content, err := os.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+	if w.content != nil && bytes.Equal(content, w.content) {
+		return nil
+	}
+	config, err := parseRuntimeConfig(content)
+	if err != nil {
+		return fmt.Errorf("invalid configuration file %s: %w", w.path, err)
+	}
+	if err := w.apply(config.withDefaults(w.defaults)); err != nil {
+		return fmt.Errorf("error applying configuration file %s: %w", w.path, err)
+	}
+	w.content = content
+	return nil
+}
+
+// run reloads the file every interval until stop is closed
+func (w *runtimeConfigWatcher) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := w.reload(); err != nil {
+				klog.Errorf("error reloading the runtime configuration, the previous one is kept: %s", err)
+			}
+		}
+	}
+}
+
+// newRuntimeConfigWatcher returns the watcher of ConfigFile, its defaults being the flags of the driver
+func (d *Driver) newRuntimeConfigWatcher() *runtimeConfigWatcher {
+	verbosity := loggingVerbosity()
+	var apiRateLimit float64
+	var apiRateBurst int
+	metrics := true
+	return &runtimeConfigWatcher{
+		path: d.config.ConfigFile,
+		defaults: runtimeConfig{
+			Verbosity:    &verbosity,
+			APIRateLimit: &apiRateLimit,
+			APIRateBurst: &apiRateBurst,
+			EmitEvents:   &d.config.EmitEvents,
+			Metrics:      &metrics,
+		},
+		apply: d.applyRuntimeConfig,
+	}
+}
+
+// applyRuntimeConfig applies a runtime configuration with all its settings set
+func (d *Driver) applyRuntimeConfig(config runtimeConfig) error {
+	if *config.Verbosity != loggingVerbosity() {
+		klog.Infof("changing the verbosity of the logs from %d to %d", loggingVerbosity(), *config.Verbosity)
+		if err := setLoggingVerbosity(*config.Verbosity); err != nil {
+			return err
+		}
+	}
+
+	scaleway.SetAPIRateLimit(*config.APIRateLimit, *config.APIRateBurst)
+
+	if d.config.Mode != NodeMode && d.controllerService.events != nil {
+		d.controllerService.events.muted.Store(!*config.EmitEvents)
+	}
+	d.metricsDisabled.Store(!*config.Metrics)
+
+	klog.Infof("runtime configuration applied: verbosity %d, API rate limit %g/s with bursts of %d, events %t, metrics %t",
+		*config.Verbosity, *config.APIRateLimit, *config.APIRateBurst, *config.EmitEvents, *config.Metrics)
+	return nil
+}
+
+// metricsMux is the handler of the server of MetricsAddr, which serves the metrics on /debug/vars
+func (d *Driver) metricsMux() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", d.metricsHandler(expvar.Handler()))
+	return mux
+}
+
+// metricsHandler serves the metrics unless they are disabled by the runtime configuration
+func (d *Driver) metricsHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.metricsDisabled.Load() {
+			http.Error(w, "the metrics are disabled by the runtime configuration", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,71 @@
+package driver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_runtimeConfigWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	verbosity, apiRateLimit, apiRateBurst, emitEvents, metrics := 2, 0., 0, false, true
+
+	var applied []runtimeConfig
+	watcher := &runtimeConfigWatcher{
+		path: path,
+		defaults: runtimeConfig{
+			Verbosity:    &verbosity,
+			APIRateLimit: &apiRateLimit,
+			APIRateBurst: &apiRateBurst,
+			EmitEvents:   &emitEvents,
+			Metrics:      &metrics,
+		},
+		apply: func(config runtimeConfig) error {
+			applied = append(applied, config)
+			return nil
+		},
+	}
+
+	AssertNoError(t, os.WriteFile(path, []byte("verbosity: 5\napiRateLimit: 2.5\n"), 0600))
+	AssertNoError(t, watcher.reload())
+	Equals(t, 1, len(applied))
+	Equals(t, 5, *applied[0].Verbosity)
+	Equals(t, 2.5, *applied[0].APIRateLimit)
+	Equals(t, 0, *applied[0].APIRateBurst)
+	AssertFalse(t, *applied[0].EmitEvents)
+	AssertTrue(t, *applied[0].Metrics)
+
+	// the configuration is only applied again when the file changes
+	AssertNoError(t, watcher.reload())
+	Equals(t, 1, len(applied))
+
+	// the unset settings are back to their defaults
+	AssertNoError(t, os.WriteFile(path, []byte("emitEvents: true\n"), 0600))
+	AssertNoError(t, watcher.reload())
+	Equals(t, 2, len(applied))
+	Equals(t, 2, *applied[1].Verbosity)
+	Equals(t, 0., *applied[1].APIRateLimit)
+	AssertTrue(t, *applied[1].EmitEvents)
+
+	// an invalid configuration is not applied
+	for _, content := range []string{"verbosty: 4\n", "verbosity: -1\n", "apiRateLimit: fast\n"} {
+		AssertNoError(t, os.WriteFile(path, []byte(content), 0600))
+		AssertTrue(t, watcher.reload() != nil)
+		Equals(t, 2, len(applied))
+	}
+}
+
+func Test_metricsMux(t *testing.T) {
+	d := &Driver{}
+	get := func() int {
+		recorder := httptest.NewRecorder()
+		d.metricsMux().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+		return recorder.Code
+	}
+
+	Equals(t, http.StatusOK, get())
+	d.metricsDisabled.Store(true)
+	Equals(t, http.StatusServiceUnavailable, get())
+}
@@ -0,0 +1,150 @@
+package driver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	sysClassSCSIGenericPath = "/sys/class/scsi_generic"
+
+	// sgIO is the SG_IO ioctl sending a SCSI command to a /dev/sgX device, see scsi/sg.h
+	sgIO = 0x2285
+	// sgDxferFromDev is the direction of the commands reading data from the device
+	sgDxferFromDev = -3
+	// sgInfoOKMask masks the info of the result of the command, sgInfoOK meaning no error
+	sgInfoOKMask = 0x1
+	sgInfoOK     = 0x0
+
+	scsiInquiry = 0x12
+	// scsiInquiryEVPD asks for a Vital Product Data page instead of the standard inquiry data
+	scsiInquiryEVPD = 0x01
+	// vpdUnitSerialNumber is the VPD page of the serial of the device, SCW_b_ssd_volume-<volume ID> for the volumes
+	vpdUnitSerialNumber = 0x80
+
+	vpdPageMaxLength = 0xff
+	sgSenseMaxLength = 32
+	sgInquiryTimeout = 5000 // milliseconds
+)
+
+// sgIOHdr is the struct sg_io_hdr of scsi/sg.h, the Go alignment of the fields matching the C one
+type sgIOHdr struct {
+	interfaceID    int32
+	dxferDirection int32
+	cmdLen         uint8
+	mxSbLen        uint8
+	iovecCount     uint16
+	dxferLen       uint32
+	dxferp         uintptr
+	cmdp           uintptr
+	sbp            uintptr
+	timeout        uint32
+	flags          uint32
+	packID         int32
+	usrPtr         uintptr
+	status         uint8
+	maskedStatus   uint8
+	msgStatus      uint8
+	sbLenWr        uint8
+	hostStatus     uint16
+	driverStatus   uint16
+	resid          int32
+	duration       uint32
+	info           uint32
+}
+
+// inquireSerial returns the unit serial number of the SCSI generic device, e.g. /dev/sg1,
+// read from its VPD page 0x80 with an INQUIRY command
+func inquireSerial(devicePath string) (string, error) {
+	device, err := os.OpenFile(devicePath, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer device.Close()
+
+	page := make([]byte, vpdPageMaxLength)
+	sense := make([]byte, sgSenseMaxLength)
+	cmd := []byte{scsiInquiry, scsiInquiryEVPD, vpdUnitSerialNumber, 0, vpdPageMaxLength, 0}
+	hdr := sgIOHdr{
+		interfaceID:    'S',
+		dxferDirection: sgDxferFromDev,
+		cmdLen:         uint8(len(cmd)),
+		mxSbLen:        uint8(len(sense)),
+		dxferLen:       uint32(len(page)),
+		dxferp:         uintptr(unsafe.Pointer(&page[0])),
+		cmdp:           uintptr(unsafe.Pointer(&cmd[0])),
+		sbp:            uintptr(unsafe.Pointer(&sense[0])),
+		timeout:        sgInquiryTimeout,
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, device.Fd(), sgIO, uintptr(unsafe.Pointer(&hdr)))
+	// the buffers are only referenced by the uintptr of the header during the call
+	runtime.KeepAlive(page)
+	runtime.KeepAlive(sense)
+	runtime.KeepAlive(cmd)
+	if errno != 0 {
+		return "", fmt.Errorf("error sending INQUIRY to %s: %w", devicePath, errno)
+	}
+	if hdr.info&sgInfoOKMask != sgInfoOK {
+		return "", fmt.Errorf("INQUIRY to %s failed with status %#x, host status %#x and driver status %#x", devicePath, hdr.status, hdr.hostStatus, hdr.driverStatus)
+	}
+	if hdr.resid > 0 && int(hdr.resid) <= len(page) {
+		page = page[:len(page)-int(hdr.resid)]
+	}
+	return parseVPDSerial(page)
+}
+
+// parseVPDSerial returns the serial of the VPD page 0x80, which has the same content as
+// the vpd_pg80 attribute of the SCSI devices in sysfs
+func parseVPDSerial(page []byte) (string, error) {
+	if len(page) < 4 {
+		return "", fmt.Errorf("VPD page of %d bytes is too short", len(page))
+	}
+	if page[1] != vpdUnitSerialNumber {
+		return "", fmt.Errorf("unexpected VPD page %#x instead of %#x", page[1], vpdUnitSerialNumber)
+	}
+	length := int(binary.BigEndian.Uint16(page[2:4]))
+	if len(page) < 4+length {
+		return "", fmt.Errorf("VPD page of %d bytes is shorter than its length %d", len(page), length)
+	}
+	return string(page[4 : 4+length]), nil
+}
+
+// findSCSIGenericDevicePath returns the block device of the SCSI generic device whose serial contains the volume ID,
+// asking the serial to the devices themselves when the by-id symlinks are missing or were renamed by custom udev
+// rules, or an empty string if there is none
+func findSCSIGenericDevicePath(sysClassSCSIGeneric string, volumeID string, inquire func(devicePath string) (string, error)) (string, error) {
+	devices, err := os.ReadDir(sysClassSCSIGeneric)
+	if err != nil {
+		return "", err
+	}
+
+	wantedID := normalizeDeviceID(volumeID)
+	for _, device := range devices {
+		serial, err := inquire(path.Join("/dev", device.Name()))
+		if err != nil {
+			klog.V(5).Infof("error reading the serial of SCSI device %s: %s", device.Name(), err)
+			continue
+		}
+		if !strings.Contains(normalizeDeviceID(serial), wantedID) {
+			continue
+		}
+		blocks, err := os.ReadDir(filepath.Join(sysClassSCSIGeneric, device.Name(), "device", "block"))
+		if err != nil {
+			return "", fmt.Errorf("error looking for the block device of SCSI device %s: %w", device.Name(), err)
+		}
+		if len(blocks) != 1 {
+			return "", fmt.Errorf("SCSI device %s has %d block devices", device.Name(), len(blocks))
+		}
+		return path.Join("/dev", blocks[0].Name()), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,164 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// selfTestPath is the HTTP path used to trigger a self-test
+	selfTestPath = "/selftest"
+
+	// selfTestNamePrefix is the prefix of the resources created during a self-test
+	selfTestNamePrefix = "csi-self-test-"
+
+	// selfTestCleanupTimeout is the maximum duration of the removal of the resources created during a self-test
+	selfTestCleanupTimeout = 5 * time.Minute
+)
+
+// SelfTestStep represents the result of one step of a self-test
+type SelfTestStep struct {
+	Name     string `json:"name"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SelfTestReport represents the result of a self-test
+type SelfTestReport struct {
+	Zone     scw.Zone        `json:"zone"`
+	Success  bool            `json:"success"`
+	Duration string          `json:"duration"`
+	Steps    []*SelfTestStep `json:"steps"`
+}
+
+// runSelfTest runs a miniature volume lifecycle (create volume, snapshot, delete) in the given zone.
+// All the created resources are removed, even if a step fails or ctx is done.
+func (d *controllerService) runSelfTest(ctx context.Context, zone scw.Zone) *SelfTestReport {
+	report := &SelfTestReport{
+		Zone:    zone,
+		Success: true,
+	}
+	start := time.Now()
+
+	step := func(name string, fn func() error) bool {
+		stepStart := time.Now()
+		err := fn()
+		s := &SelfTestStep{
+			Name:     name,
+			Duration: time.Since(stepStart).String(),
+		}
+		if err != nil {
+			s.Error = err.Error()
+			report.Success = false
+		}
+		report.Steps = append(report.Steps, s)
+		return err == nil
+	}
+
+	name := d.config.Prefix + selfTestNamePrefix + uuid.New().String()
+
+	var size int64
+	var volume *instance.Volume
+	var snapshot *instance.Snapshot
+
+	defer func() {
+		// the resources are removed even if the client went away
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), selfTestCleanupTimeout)
+		defer cancel()
+
+		if snapshot != nil {
+			step("delete-snapshot", func() error {
+				return d.scaleway.DeleteSnapshot(&instance.DeleteSnapshotRequest{
+					SnapshotID: snapshot.ID,
+					Zone:       snapshot.Zone,
+				}, scw.WithContext(cleanupCtx))
+			})
+		}
+		if volume != nil {
+			step("delete-volume", func() error {
+				return d.scaleway.DeleteVolume(&instance.DeleteVolumeRequest{
+					VolumeID: volume.ID,
+					Zone:     volume.Zone,
+				}, scw.WithContext(cleanupCtx))
+			})
+		}
+		report.Duration = time.Since(start).String()
+	}()
+
+	if !step("get-volume-limits", func() (err error) {
+		size, _, err = d.scaleway.GetVolumeLimits(string(scaleway.DefaultVolumeType))
+		return err
+	}) {
+		return report
+	}
+
+	if !step("create-volume", func() error {
+		volumeResp, err := d.scaleway.CreateVolume(&instance.CreateVolumeRequest{
+			Zone:       zone,
+			Name:       name,
+			VolumeType: scaleway.DefaultVolumeType,
+			Size:       scw.SizePtr(scw.Size(size)),
+			Tags:       []string{managedByTag},
+		}, scw.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		volume = volumeResp.Volume
+		_, err = d.scaleway.WaitForVolumeContext(ctx, &instance.WaitForVolumeRequest{
+			VolumeID: volume.ID,
+			Zone:     volume.Zone,
+		})
+		return err
+	}) {
+		return report
+	}
+
+	step("create-snapshot", func() error {
+		snapshotResp, err := d.scaleway.CreateSnapshot(&instance.CreateSnapshotRequest{
+			Zone:     volume.Zone,
+			Name:     name,
+			VolumeID: &volume.ID,
+			Tags:     &[]string{managedByTag},
+		}, scw.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		snapshot = snapshotResp.Snapshot
+		_, err = d.scaleway.WaitForSnapshotContext(ctx, &instance.WaitForSnapshotRequest{
+			SnapshotID: snapshot.ID,
+			Zone:       snapshot.Zone,
+		})
+		return err
+	})
+
+	return report
+}
+
+// selfTestHandler returns an HTTP handler running a self-test in the given zone at each request
+func (d *controllerService) selfTestHandler(zone scw.Zone) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		klog.Infof("running self-test in zone %s", zone)
+		report := d.runSelfTest(r.Context(), zone)
+		if report.Success {
+			klog.Infof("self-test succeeded in %s", report.Duration)
+		} else {
+			klog.Errorf("self-test failed after %s", report.Duration)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			klog.Errorf("error writing self-test report: %s", err)
+		}
+	})
+}
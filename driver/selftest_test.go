@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+func Test_runSelfTest(t *testing.T) {
+	fake := &fakeHelper{
+		fakeInstanceAPI: fakeInstanceAPI{
+			volumesMap:   map[string]*instance.Volume{},
+			serversMap:   map[string]*instance.Server{},
+			snapshotsMap: map[string]*instance.Snapshot{},
+			defaultZone:  scw.ZoneFrPar1,
+		},
+	}
+	d := &controllerService{
+		scaleway: &scaleway.Scaleway{InstanceAPI: fake},
+		config:   &DriverConfig{},
+	}
+
+	report := d.runSelfTest(context.Background(), scw.ZoneFrPar2)
+	AssertTrue(t, report.Success)
+	Equals(t, scw.ZoneFrPar2, report.Zone)
+
+	var steps []string
+	for _, step := range report.Steps {
+		Equals(t, "", step.Error)
+		steps = append(steps, step.Name)
+	}
+	Equals(t, []string{"get-volume-limits", "create-volume", "create-snapshot", "delete-snapshot", "delete-volume"}, steps)
+
+	// everything should have been cleaned up
+	Equals(t, 0, len(fake.volumesMap))
+	Equals(t, 0, len(fake.snapshotsMap))
+}
+
+type cancelingCreateFake struct {
+	*fakeHelper
+	cancel context.CancelFunc
+}
+
+func (s *cancelingCreateFake) CreateVolume(req *instance.CreateVolumeRequest, opts ...scw.RequestOption) (*instance.CreateVolumeResponse, error) {
+	// the client goes away while the volume is being created
+	defer s.cancel()
+	return s.fakeHelper.CreateVolume(req, opts...)
+}
+
+func Test_runSelfTestCanceled(t *testing.T) {
+	fake := &fakeHelper{
+		fakeInstanceAPI: fakeInstanceAPI{
+			volumesMap:   map[string]*instance.Volume{},
+			serversMap:   map[string]*instance.Server{},
+			snapshotsMap: map[string]*instance.Snapshot{},
+			defaultZone:  scw.ZoneFrPar1,
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &controllerService{
+		scaleway: &scaleway.Scaleway{InstanceAPI: &cancelingCreateFake{fakeHelper: fake, cancel: cancel}},
+		config:   &DriverConfig{},
+	}
+
+	d.runSelfTest(ctx, scw.ZoneFrPar1)
+
+	// the resources created before the cancellation are still removed
+	Equals(t, 0, len(fake.volumesMap))
+	Equals(t, 0, len(fake.snapshotsMap))
+}
@@ -0,0 +1,106 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+	utilsio "k8s.io/utils/io"
+)
+
+// cleanupVolumes unmounts all the publish and staging paths of the volumes managed by the driver
+// and closes their LUKS mappings, it is used when the node is shutting down
+func (d *nodeService) cleanupVolumes() error {
+	diskPrefixes := d.diskPrefixes
+	if len(diskPrefixes) == 0 {
+		diskPrefixes = DefaultDiskPrefixes
+	}
+	// the symlinks named after the serial of the architecture are not specific to the volumes, they are not looked up
+	var devices []string
+	for _, prefix := range diskPrefixes {
+		prefixDevices, err := filepath.Glob(path.Join(diskByIDPath, prefix+"*"))
+		if err != nil {
+			return err
+		}
+		devices = append(devices, prefixDevices...)
+	}
+	mappings, err := filepath.Glob(path.Join(diskLuksMapperPath, diskLuksMapperPrefix+"*"))
+	if err != nil {
+		return err
+	}
+
+	content, err := utilsio.ConsistentRead(procMountInfoPath, procMountInfoMaxListTries)
+	if err != nil {
+		return err
+	}
+	mountPoints, err := managedMountPoints(string(content), append(devices, mappings...))
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, mountPoint := range mountPoints {
+		klog.Infof("unmounting %s", mountPoint)
+		if err := d.diskUtils.Unmount(mountPoint); err != nil {
+			errs = append(errs, fmt.Sprintf("error unmounting %s: %s", mountPoint, err))
+		}
+	}
+
+	for _, mapping := range mappings {
+		volumeID := strings.TrimPrefix(filepath.Base(mapping), diskLuksMapperPrefix)
+		klog.Infof("closing encrypted device of volume %s", volumeID)
+		if err := d.diskUtils.CloseDevice(volumeID); err != nil {
+			errs = append(errs, fmt.Sprintf("error closing encrypted device of volume %s: %s", volumeID, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// managedMountPoints returns the mount points, from the given mountinfo content, of the given devices.
+// Raw block volumes are bind mounts of the device node, which show up as the device name on devtmpfs.
+func managedMountPoints(mountInfo string, devicePaths []string) ([]string, error) {
+	majorMinors := make(map[string]bool)
+	deviceNames := make(map[string]bool)
+	for _, devicePath := range devicePaths {
+		realDevicePath, err := filepath.EvalSymlinks(devicePath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var stat unix.Stat_t
+		if err := unix.Stat(realDevicePath, &stat); err != nil {
+			return nil, err
+		}
+		majorMinors[fmt.Sprintf("%d:%d", unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev)))] = true
+		deviceNames["/"+filepath.Base(realDevicePath)] = true
+	}
+
+	mountPoints := []string{}
+	for _, line := range strings.Split(mountInfo, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < expectedAtLeastNumFieldsPerMountInfo {
+			continue
+		}
+		fsType := ""
+		for i := 6; i < len(fields)-1; i++ {
+			if fields[i] == "-" {
+				fsType = fields[i+1]
+				break
+			}
+		}
+		if majorMinors[fields[2]] || (fsType == "devtmpfs" && deviceNames[fields[3]]) {
+			mountPoints = append(mountPoints, fields[4])
+		}
+	}
+	return mountPoints, nil
+}
@@ -0,0 +1,150 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// DefaultStagingGCRoot is the directory in which the kubelet creates the staging directories of the volumes of the driver
+var DefaultStagingGCRoot string
+
+const (
+	// stagingDirName is the name of the staging directory created by the kubelet for each volume
+	stagingDirName = "globalmount"
+	// kubeletVolumeDataFile is the metadata of the volume written by the kubelet next to its staging directory
+	kubeletVolumeDataFile = "vol_data.json"
+)
+
+// runStagingGC sweeps the stale staging directories every interval until stop is closed
+func (d *nodeService) runStagingGC(root string, interval time.Duration, minAge time.Duration, stop <-chan struct{}) {
+	klog.Infof("removing the empty staging directories older than %s in %s every %s", minAge, root, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			removed, err := d.sweepStagingDirs(root, minAge)
+			if err != nil {
+				klog.Errorf("error sweeping staging directories in %s: %s", root, err)
+				continue
+			}
+			if removed > 0 {
+				klog.Infof("removed %d stale staging directories in %s", removed, root)
+			}
+		}
+	}
+}
+
+// runLUKSJanitor closes the stale LUKS mappings every interval until stop is closed
+func (d *nodeService) runLUKSJanitor(interval time.Duration, stop <-chan struct{}) {
+	klog.Infof("closing the LUKS mappings whose device no longer exists every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			closed, err := d.diskUtils.CloseStaleDevices()
+			if err != nil {
+				klog.Errorf("error closing stale LUKS mappings: %s", err)
+				continue
+			}
+			if closed > 0 {
+				klog.Infof("closed %d stale LUKS mappings", closed)
+			}
+		}
+	}
+}
+
+// sweepStagingDirs removes the staging directories left behind by failed NodeStageVolume calls,
+// that is the ones which are empty, not mounted and older than minAge. It returns the number of removed directories.
+func (d *nodeService) sweepStagingDirs(root string, minAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		volumeDir := filepath.Join(root, entry.Name())
+		stagingDir := filepath.Join(volumeDir, stagingDirName)
+
+		// the kubelet still knows the volume, e.g. after a reboot it stages it again in this directory
+		if _, err := os.Stat(filepath.Join(volumeDir, kubeletVolumeDataFile)); err == nil {
+			continue
+		}
+
+		if d.sweepStagingDir(stagingDir, minAge) {
+			removed++
+			// the volume directory is only removed if nothing else is left in it
+			_ = os.Remove(volumeDir)
+		}
+	}
+	return removed, nil
+}
+
+// sweepStagingDir removes the given staging directory if it is stale, and returns true if it was removed.
+// The staging path is locked so that the directory can't be staged while it is being checked.
+func (d *nodeService) sweepStagingDir(stagingDir string, minAge time.Duration) bool {
+	unlock := d.pathLocks.lock(stagingDir)
+	defer unlock()
+
+	stale, err := d.isStaleStagingDir(stagingDir, minAge)
+	if err != nil {
+		klog.Warningf("error checking staging directory %s: %s", stagingDir, err)
+		return false
+	}
+	if !stale {
+		return false
+	}
+
+	// os.Remove refuses to remove a directory which is not empty
+	if err := os.Remove(stagingDir); err != nil {
+		klog.Warningf("error removing staging directory %s: %s", stagingDir, err)
+		return false
+	}
+	klog.V(4).Infof("removed stale staging directory %s", stagingDir)
+	return true
+}
+
+// isStaleStagingDir returns true if the given path is an empty directory, not mounted and older than minAge
+func (d *nodeService) isStaleStagingDir(path string, minAge time.Duration) (bool, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !info.IsDir() || time.Since(info.ModTime()) < minAge {
+		return false, nil
+	}
+
+	mountInfo, err := d.diskUtils.GetMountInfo(path)
+	if err != nil {
+		return false, err
+	}
+	if mountInfo != nil {
+		return false, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
@@ -0,0 +1,92 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_sweepStagingDirs(t *testing.T) {
+	root := t.TempDir()
+	d := &nodeService{diskUtils: &diskUtils{}}
+
+	old := time.Now().Add(-48 * time.Hour)
+	newStagingDir := func(name string, modTime time.Time) string {
+		dir := filepath.Join(root, name, stagingDirName)
+		AssertNoError(t, os.MkdirAll(dir, 0750))
+		AssertNoError(t, os.Chtimes(dir, modTime, modTime))
+		return dir
+	}
+
+	stale := newStagingDir("stale", old)
+	recent := newStagingDir("recent", time.Now())
+	notEmpty := newStagingDir("not-empty", old)
+	AssertNoError(t, os.WriteFile(filepath.Join(notEmpty, "data"), []byte("data"), 0600))
+	AssertNoError(t, os.Chtimes(notEmpty, old, old))
+	withMetadata := newStagingDir("with-metadata", old)
+	AssertNoError(t, os.WriteFile(filepath.Join(filepath.Dir(withMetadata), "vol_data.json"), []byte("{}"), 0600))
+
+	removed, err := d.sweepStagingDirs(root, 24*time.Hour)
+	AssertNoError(t, err)
+	Equals(t, 1, removed)
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	AssertFalse(t, exists(filepath.Dir(stale)))
+	AssertTrue(t, exists(recent))
+	AssertTrue(t, exists(notEmpty))
+	// the kubelet stages the volume again in the directory after a reboot
+	AssertTrue(t, exists(withMetadata))
+
+	// a missing root is not an error
+	removed, err = d.sweepStagingDirs(filepath.Join(root, "missing"), 24*time.Hour)
+	AssertNoError(t, err)
+	Equals(t, 0, removed)
+}
+
+type staleDevicesFake struct {
+	*fakeHelper
+	calls chan struct{}
+}
+
+func (s *staleDevicesFake) CloseStaleDevices() (int, error) {
+	s.calls <- struct{}{}
+	return 1, nil
+}
+
+func Test_runLUKSJanitor(t *testing.T) {
+	fake := &staleDevicesFake{fakeHelper: &fakeHelper{}, calls: make(chan struct{})}
+	d := &nodeService{diskUtils: fake}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		d.runLUKSJanitor(time.Millisecond, stop)
+		close(done)
+	}()
+
+	// the stale mappings are closed at every tick
+	for i := 0; i < 2; i++ {
+		select {
+		case <-fake.calls:
+		case <-time.After(5 * time.Second):
+			t.Fatal("stale LUKS mappings not closed")
+		}
+	}
+
+	close(stop)
+	// drain a tick which fired before stop was closed
+	for {
+		select {
+		case <-fake.calls:
+			continue
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("LUKS janitor not stopped")
+		}
+		break
+	}
+}
@@ -0,0 +1,86 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/scaleway/scaleway-csi/scaleway"
+)
+
+// supportBundlePath is the path of the support bundle, served on the self-test address
+const supportBundlePath = "/debug/support-bundle"
+
+// SupportBundle is the state of the controller to attach to a Scaleway support ticket
+type SupportBundle struct {
+	Driver      string    `json:"driver"`
+	Version     string    `json:"version"`
+	GitCommit   string    `json:"gitCommit"`
+	GeneratedAt time.Time `json:"generatedAt"`
+
+	// APIErrors are the last failed Scaleway API calls, the oldest first
+	APIErrors []scaleway.APIError `json:"apiErrors"`
+	// InflightOperations are the CSI calls in progress
+	InflightOperations []SupportOperation `json:"inflightOperations"`
+}
+
+// SupportOperation is a CSI call in progress
+type SupportOperation struct {
+	Method    string    `json:"method"`
+	VolumeID  string    `json:"volumeID,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	Duration  string    `json:"duration"`
+}
+
+// supportBundle returns the current support bundle of the driver
+func (d *Driver) supportBundle() *SupportBundle {
+	bundle := &SupportBundle{
+		Driver:             DriverName,
+		Version:            driverVersion,
+		GitCommit:          gitCommit,
+		GeneratedAt:        time.Now().UTC(),
+		APIErrors:          scaleway.RecentAPIErrors(),
+		InflightOperations: []SupportOperation{},
+	}
+	for _, op := range d.inflight.list() {
+		bundle.InflightOperations = append(bundle.InflightOperations, SupportOperation{
+			Method:    op.method,
+			VolumeID:  op.volumeID,
+			StartedAt: op.startedAt.UTC(),
+			Duration:  time.Since(op.startedAt).Round(time.Millisecond).String(),
+		})
+	}
+	return bundle
+}
+
+// supportBundleHandler returns an HTTP handler serving the support bundle as JSON
+func (d *Driver) supportBundleHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(d.supportBundle()); err != nil {
+			klog.Errorf("error writing support bundle: %s", err)
+		}
+	})
+}
+
+// WriteSupportBundle fetches the support bundle from the controller serving the self-test trigger on addr and writes it to w
+func WriteSupportBundle(w io.Writer, addr string) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get("http://" + addr + supportBundlePath)
+	if err != nil {
+		return fmt.Errorf("error fetching support bundle: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching support bundle: %s", resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,23 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_WriteSupportBundle(t *testing.T) {
+	d := &Driver{}
+	server := httptest.NewServer(d.supportBundleHandler())
+	defer server.Close()
+
+	var buf bytes.Buffer
+	AssertNoError(t, WriteSupportBundle(&buf, strings.TrimPrefix(server.URL, "http://")))
+
+	bundle := &SupportBundle{}
+	AssertNoError(t, json.Unmarshal(buf.Bytes(), bundle))
+	Equals(t, DriverName, bundle.Driver)
+	Equals(t, 0, len(bundle.InflightOperations))
+}
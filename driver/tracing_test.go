@@ -0,0 +1,50 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func Test_tracingInterceptor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defaultProvider, defaultPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(defaultProvider)
+		otel.SetTextMapPropagator(defaultPropagator)
+	}()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, span := startSpan(ctx, "child")
+		endSpan(span, nil)
+		return nil, status.Error(codes.ResourceExhausted, "no capacity")
+	}
+
+	// the trace of the client is continued
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"))
+	_, err := tracingInterceptor(ctx, &csi.CreateVolumeRequest{}, info, handler)
+	Equals(t, codes.ResourceExhausted, status.Code(err))
+
+	spans := recorder.Ended()
+	Equals(t, 2, len(spans))
+	child, rpc := spans[0], spans[1]
+	Equals(t, "child", child.Name())
+	Equals(t, rpc.SpanContext().SpanID(), child.Parent().SpanID())
+	Equals(t, "/csi.v1.Controller/CreateVolume", rpc.Name())
+	Equals(t, "0af7651916cd43dd8448eb211c80319c", rpc.SpanContext().TraceID().String())
+	Equals(t, otelcodes.Error, rpc.Status().Code)
+	Equals(t, "no capacity", rpc.Status().Description)
+}
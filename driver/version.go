@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"runtime"
+	"runtime/debug"
 )
 
+const scalewaySDKModule = "github.com/scaleway/scaleway-sdk-go"
+
 // taken from https://github.com/kubernetes-sigs/aws-ebs-csi-driver/blob/db95482f8963350d70e9932b0936e6794fe76bf2/pkg/driver/version.go
 
 // These are set during build time via -ldflags
@@ -20,6 +23,7 @@ type VersionInfo struct {
 	DriverVersion string `json:"driverVersion"`
 	GitCommit     string `json:"gitCommit"`
 	BuildDate     string `json:"buildDate"`
+	SDKVersion    string `json:"sdkVersion"`
 	GoVersion     string `json:"goVersion"`
 	Compiler      string `json:"compiler"`
 	Platform      string `json:"platform"`
@@ -31,6 +35,7 @@ func GetVersion() VersionInfo {
 		DriverVersion: driverVersion,
 		GitCommit:     gitCommit,
 		BuildDate:     buildDate,
+		SDKVersion:    sdkVersion(),
 		GoVersion:     runtime.Version(),
 		Compiler:      runtime.Compiler,
 		Platform:      fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
@@ -46,3 +51,20 @@ func GetVersionJSON() (string, error) {
 	}
 	return string(marshalled), nil
 }
+
+// sdkVersion returns the version of the Scaleway SDK the driver was built with
+func sdkVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == scalewaySDKModule {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"expvar"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// stagedVolume is a volume staged on the node, as reported in the volume_stats metrics
+type stagedVolume struct {
+	stagingTargetPath string
+	block             bool
+	encrypted         bool
+	mappedDevicePath  string
+	managed           bool
+	readOnly          bool
+
+	pvName       string
+	pvcName      string
+	pvcNamespace string
+}
+
+// volumeStats are the metrics of a staged volume, computed when they are scraped
+type volumeStats struct {
+	PVName       string `json:"pvName,omitempty"`
+	PVCName      string `json:"pvcName,omitempty"`
+	PVCNamespace string `json:"pvcNamespace,omitempty"`
+
+	BytesTotal  int64 `json:"bytesTotal,omitempty"`
+	BytesUsed   int64 `json:"bytesUsed,omitempty"`
+	BytesFree   int64 `json:"bytesFree,omitempty"`
+	InodesTotal int64 `json:"inodesTotal,omitempty"`
+	InodesUsed  int64 `json:"inodesUsed,omitempty"`
+	InodesFree  int64 `json:"inodesFree,omitempty"`
+
+	Encrypted bool `json:"encrypted"`
+	// Managed is true for the volumes created by the driver, false for the ones created manually or before the managed-by tag
+	Managed bool `json:"managed"`
+	// LUKSOpen is false when the LUKS mapping of an encrypted volume is no longer active
+	LUKSOpen bool   `json:"luksOpen,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// stagedVolumes are the volumes staged by this process, the ones staged before a restart
+// of the node plugin are only reported once staged again
+var stagedVolumes = struct {
+	volumes map[string]stagedVolume
+	mux     sync.Mutex
+}{volumes: make(map[string]stagedVolume)}
+
+func init() {
+	expvar.Publish("volume_stats", expvar.Func(func() interface{} {
+		return collectVolumeStats()
+	}))
+}
+
+func trackStagedVolume(volumeID string, volume stagedVolume) {
+	stagedVolumes.mux.Lock()
+	defer stagedVolumes.mux.Unlock()
+	stagedVolumes.volumes[volumeID] = volume
+}
+
+func lookupStagedVolume(volumeID string) (stagedVolume, bool) {
+	stagedVolumes.mux.Lock()
+	defer stagedVolumes.mux.Unlock()
+	volume, ok := stagedVolumes.volumes[volumeID]
+	return volume, ok
+}
+
+func untrackStagedVolume(volumeID string) {
+	stagedVolumes.mux.Lock()
+	defer stagedVolumes.mux.Unlock()
+	delete(stagedVolumes.volumes, volumeID)
+}
+
+// collectVolumeStats returns the current metrics of the staged volumes, by volume ID
+func collectVolumeStats() map[string]volumeStats {
+	stagedVolumes.mux.Lock()
+	volumes := make(map[string]stagedVolume, len(stagedVolumes.volumes))
+	for volumeID, volume := range stagedVolumes.volumes {
+		volumes[volumeID] = volume
+	}
+	stagedVolumes.mux.Unlock()
+
+	stats := make(map[string]volumeStats, len(volumes))
+	for volumeID, volume := range volumes {
+		volumeStat := volumeStats{
+			PVName:       volume.pvName,
+			PVCName:      volume.pvcName,
+			PVCNamespace: volume.pvcNamespace,
+			Encrypted:    volume.encrypted,
+			Managed:      volume.managed,
+		}
+		if volume.encrypted {
+			_, err := os.Stat(volume.mappedDevicePath)
+			volumeStat.LUKSOpen = err == nil
+		}
+		if !volume.block {
+			fs := &unix.Statfs_t{}
+			if err := unix.Statfs(volume.stagingTargetPath, fs); err != nil {
+				volumeStat.Error = err.Error()
+			} else {
+				volumeStat.BytesTotal = int64(fs.Blocks) * int64(fs.Bsize)
+				volumeStat.BytesFree = int64(fs.Bavail) * int64(fs.Bsize)
+				volumeStat.BytesUsed = (int64(fs.Blocks) - int64(fs.Bfree)) * int64(fs.Bsize)
+				volumeStat.InodesTotal = int64(fs.Files)
+				volumeStat.InodesFree = int64(fs.Ffree)
+				volumeStat.InodesUsed = int64(fs.Files) - int64(fs.Ffree)
+			}
+		}
+		stats[volumeID] = volumeStat
+	}
+	return stats
+}
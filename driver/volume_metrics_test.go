@@ -0,0 +1,28 @@
+package driver
+
+import "testing"
+
+func Test_collectVolumeStats(t *testing.T) {
+	trackStagedVolume("volume-id", stagedVolume{
+		stagingTargetPath: t.TempDir(),
+		encrypted:         true,
+		mappedDevicePath:  "/dev/mapper/does-not-exist",
+		pvName:            "pvc-1234",
+		pvcName:           "data",
+		pvcNamespace:      "default",
+	})
+	defer untrackStagedVolume("volume-id")
+
+	stats, ok := collectVolumeStats()["volume-id"]
+	AssertTrue(t, ok)
+	Equals(t, "pvc-1234", stats.PVName)
+	Equals(t, "default", stats.PVCNamespace)
+	Equals(t, "", stats.Error)
+	AssertTrue(t, stats.BytesTotal > 0)
+	AssertTrue(t, stats.Encrypted)
+	AssertFalse(t, stats.LUKSOpen)
+
+	untrackStagedVolume("volume-id")
+	_, ok = collectVolumeStats()["volume-id"]
+	AssertFalse(t, ok)
+}
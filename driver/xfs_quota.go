@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	xfsQuotaCmd = "xfs_quota"
+
+	// xfsQuotaDirPrefix prefixes the directories of the staged volume published with an XFS project quota,
+	// followed by the project ID
+	xfsQuotaDirPrefix = "quota-"
+	// xfsQuotaMountOption enables the project quotas on the staged volumes with xfsQuotaKey
+	xfsQuotaMountOption = "prjquota"
+)
+
+// xfsQuotaProjectID returns the XFS project ID of the directory published on the target path, which is stable across the retries
+func xfsQuotaProjectID(targetPath string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(targetPath))
+	if id := hash.Sum32(); id != 0 {
+		return id
+	}
+	// the project 0 is the default one of all the files
+	return 1
+}
+
+// xfsQuotaDir returns the directory of the staged volume published on the target path with an XFS project quota
+func xfsQuotaDir(stagingTargetPath string, targetPath string) string {
+	return filepath.Join(stagingTargetPath, xfsQuotaDirPrefix+strconv.FormatUint(uint64(xfsQuotaProjectID(targetPath)), 10))
+}
+
+func (d *diskUtils) SetXFSProjectQuota(mountPath string, dir string, projectID uint32, limit int64) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	project := strconv.FormatUint(uint64(projectID), 10)
+	return runXFSQuota(mountPath,
+		fmt.Sprintf("project -s -p %s %s", dir, project),
+		fmt.Sprintf("limit -p bhard=%d %s", limit, project),
+	)
+}
+
+func (d *diskUtils) RemoveXFSProjectQuota(mountPath string, dir string, projectID uint32) error {
+	project := strconv.FormatUint(uint64(projectID), 10)
+	err := runXFSQuota(mountPath,
+		fmt.Sprintf("limit -p bhard=0 %s", project),
+		fmt.Sprintf("project -C -p %s %s", dir, project),
+	)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// runXFSQuota runs the given expert commands of xfs_quota on the XFS filesystem mounted on mountPath
+func runXFSQuota(mountPath string, commands ...string) error {
+	xfsQuotaPath, err := exec.LookPath(xfsQuotaCmd)
+	if err != nil {
+		return err
+	}
+	for _, command := range commands {
+		out, err := exec.Command(xfsQuotaPath, "-x", "-c", command, mountPath).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("error running %s %q on %s: %w: %s", xfsQuotaCmd, command, mountPath, err, string(out))
+		}
+	}
+	return nil
+}
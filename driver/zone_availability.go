@@ -0,0 +1,23 @@
+package driver
+
+import (
+	"expvar"
+
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+// unavailableZones is 1 for the zones whose last listing failed and 0 for the others, by zone
+var unavailableZones = expvar.NewMap("unavailable_zones")
+
+// setZoneAvailability records in unavailableZones whether the last listing of the zone failed
+func setZoneAvailability(zone scw.Zone, err error) {
+	name := zone.String()
+	if name == "" {
+		name = "default"
+	}
+	unavailable := new(expvar.Int)
+	if err != nil {
+		unavailable.Set(1)
+	}
+	unavailableZones.Set(name, unavailable)
+}
@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+// zoneExhaustion remembers the zones which recently returned an out of stock error when creating a volume,
+// so that the next creations try the other zones allowed by their topology first
+type zoneExhaustion struct {
+	cooldown time.Duration
+
+	// exhaustedUntil is the end of the cooldown of each exhausted zone
+	exhaustedUntil map[scw.Zone]time.Time
+	mux            sync.Mutex
+}
+
+func newZoneExhaustion(cooldown time.Duration) *zoneExhaustion {
+	return &zoneExhaustion{
+		cooldown:       cooldown,
+		exhaustedUntil: make(map[scw.Zone]time.Time),
+	}
+}
+
+// record remembers that the zone was out of stock at now, for the cooldown
+func (z *zoneExhaustion) record(zone scw.Zone, now time.Time) {
+	if z == nil || z.cooldown <= 0 || zone == "" {
+		return
+	}
+	z.mux.Lock()
+	defer z.mux.Unlock()
+	z.exhaustedUntil[zone] = now.Add(z.cooldown)
+}
+
+// order returns the zones to try, the ones which are not in their cooldown first in their declared order,
+// then the exhausted ones by end of cooldown, the zone recovering first being tried first. The exhausted
+// zones are still tried last, as they may have recovered before the end of their cooldown.
+func (z *zoneExhaustion) order(zones []scw.Zone, now time.Time) []scw.Zone {
+	if z == nil {
+		return zones
+	}
+	z.mux.Lock()
+	defer z.mux.Unlock()
+
+	var available, exhausted []scw.Zone
+	for _, zone := range zones {
+		until, ok := z.exhaustedUntil[zone]
+		if ok && now.Before(until) {
+			exhausted = append(exhausted, zone)
+			continue
+		}
+		if ok {
+			delete(z.exhaustedUntil, zone)
+		}
+		available = append(available, zone)
+	}
+	sort.SliceStable(exhausted, func(i, j int) bool {
+		return z.exhaustedUntil[exhausted[i]].Before(z.exhaustedUntil[exhausted[j]])
+	})
+	return append(available, exhausted...)
+}
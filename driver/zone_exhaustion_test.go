@@ -0,0 +1,29 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+func Test_zoneExhaustion(t *testing.T) {
+	now := time.Now()
+	zones := []scw.Zone{scw.ZoneFrPar1, scw.ZoneFrPar2, scw.ZoneFrPar3}
+	exhaustion := newZoneExhaustion(10 * time.Minute)
+	Equals(t, zones, exhaustion.order(zones, now))
+
+	// the exhausted zones are tried last, the one recovering first before the other
+	exhaustion.record(scw.ZoneFrPar2, now)
+	exhaustion.record(scw.ZoneFrPar1, now.Add(time.Minute))
+	Equals(t, []scw.Zone{scw.ZoneFrPar3, scw.ZoneFrPar2, scw.ZoneFrPar1}, exhaustion.order(zones, now.Add(time.Minute)))
+
+	// and in their declared order again after their cooldown
+	Equals(t, []scw.Zone{scw.ZoneFrPar2, scw.ZoneFrPar3, scw.ZoneFrPar1}, exhaustion.order(zones, now.Add(10*time.Minute)))
+	Equals(t, zones, exhaustion.order(zones, now.Add(11*time.Minute)))
+
+	// nothing is remembered without cooldown
+	exhaustion = newZoneExhaustion(0)
+	exhaustion.record(scw.ZoneFrPar1, now)
+	Equals(t, zones, exhaustion.order(zones, now))
+}
@@ -0,0 +1,125 @@
+package scaleway
+
+import (
+	"sync"
+	"time"
+
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+// DefaultAPICacheTTL is the default duration during which the cached lookups are reused
+const DefaultAPICacheTTL = 5 * time.Second
+
+// apiCache caches the responses of the read-only lookups, by resource ID
+type apiCache struct {
+	ttl     time.Duration
+	volumes map[string]cachedVolume
+	servers map[string]cachedServer
+	mux     sync.Mutex
+}
+
+type cachedVolume struct {
+	resp      *instance.GetVolumeResponse
+	expiresAt time.Time
+}
+
+type cachedServer struct {
+	resp      *instance.GetServerResponse
+	expiresAt time.Time
+}
+
+// SetCacheTTL sets the duration during which the responses of GetVolumeCached and GetServerCached
+// are reused, caching is disabled if zero
+func (s *Scaleway) SetCacheTTL(ttl time.Duration) {
+	s.cache.mux.Lock()
+	defer s.cache.mux.Unlock()
+	s.cache.ttl = ttl
+	s.cache.volumes = make(map[string]cachedVolume)
+	s.cache.servers = make(map[string]cachedServer)
+}
+
+// GetVolumeCached is GetVolume with the response cached for the TTL set with SetCacheTTL.
+// It must only be used for lookups which can tolerate changes made outside of the driver being seen late.
+func (s *Scaleway) GetVolumeCached(req *instance.GetVolumeRequest, opts ...scw.RequestOption) (*instance.GetVolumeResponse, error) {
+	s.cache.mux.Lock()
+	cached, ok := s.cache.volumes[req.VolumeID]
+	ttl := s.cache.ttl
+	s.cache.mux.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.resp, nil
+	}
+
+	resp, err := s.InstanceAPI.GetVolume(req, opts...)
+	if err != nil || ttl <= 0 {
+		return resp, err
+	}
+
+	s.cache.mux.Lock()
+	s.cache.volumes[req.VolumeID] = cachedVolume{resp: resp, expiresAt: time.Now().Add(ttl)}
+	s.cache.mux.Unlock()
+	return resp, nil
+}
+
+// GetServerCached is GetServer with the response cached for the TTL set with SetCacheTTL.
+// It must only be used for lookups which can tolerate changes made outside of the driver being seen late.
+func (s *Scaleway) GetServerCached(req *instance.GetServerRequest, opts ...scw.RequestOption) (*instance.GetServerResponse, error) {
+	s.cache.mux.Lock()
+	cached, ok := s.cache.servers[req.ServerID]
+	ttl := s.cache.ttl
+	s.cache.mux.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.resp, nil
+	}
+
+	resp, err := s.InstanceAPI.GetServer(req, opts...)
+	if err != nil || ttl <= 0 {
+		return resp, err
+	}
+
+	s.cache.mux.Lock()
+	s.cache.servers[req.ServerID] = cachedServer{resp: resp, expiresAt: time.Now().Add(ttl)}
+	s.cache.mux.Unlock()
+	return resp, nil
+}
+
+// AttachVolume attaches the volume and invalidates the cached lookups of the volume and the server
+func (s *Scaleway) AttachVolume(req *instance.AttachVolumeRequest, opts ...scw.RequestOption) (*instance.AttachVolumeResponse, error) {
+	resp, err := s.InstanceAPI.AttachVolume(req, opts...)
+	s.cache.mux.Lock()
+	delete(s.cache.volumes, req.VolumeID)
+	delete(s.cache.servers, req.ServerID)
+	s.cache.mux.Unlock()
+	return resp, err
+}
+
+// DetachVolume detaches the volume and invalidates the cached lookups of the volume and the servers,
+// the server the volume was attached to being unknown when the call fails
+func (s *Scaleway) DetachVolume(req *instance.DetachVolumeRequest, opts ...scw.RequestOption) (*instance.DetachVolumeResponse, error) {
+	resp, err := s.InstanceAPI.DetachVolume(req, opts...)
+	s.cache.mux.Lock()
+	delete(s.cache.volumes, req.VolumeID)
+	if s.cache.servers != nil {
+		s.cache.servers = make(map[string]cachedServer)
+	}
+	s.cache.mux.Unlock()
+	return resp, err
+}
+
+// UpdateVolume updates the volume and invalidates its cached lookup
+func (s *Scaleway) UpdateVolume(req *instance.UpdateVolumeRequest, opts ...scw.RequestOption) (*instance.UpdateVolumeResponse, error) {
+	resp, err := s.InstanceAPI.UpdateVolume(req, opts...)
+	s.cache.mux.Lock()
+	delete(s.cache.volumes, req.VolumeID)
+	s.cache.mux.Unlock()
+	return resp, err
+}
+
+// DeleteVolume deletes the volume and invalidates its cached lookup
+func (s *Scaleway) DeleteVolume(req *instance.DeleteVolumeRequest, opts ...scw.RequestOption) error {
+	err := s.InstanceAPI.DeleteVolume(req, opts...)
+	s.cache.mux.Lock()
+	delete(s.cache.volumes, req.VolumeID)
+	s.cache.mux.Unlock()
+	return err
+}
@@ -1,8 +1,11 @@
 package scaleway
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
 	"github.com/scaleway/scaleway-sdk-go/scw"
@@ -19,8 +22,6 @@ const (
 var (
 	// ErrMultipleVolumes is the error returned when multiples volumes exists with the same name
 	ErrMultipleVolumes = errors.New("multiple volumes exists with the same name")
-	// ErrDifferentSize is the error returned when a volume match the given name, but the size doesn't match
-	ErrDifferentSize = errors.New("volume exists with a different size")
 	// ErrVolumeNotFound is the error returned when the volume was not found
 	ErrVolumeNotFound = errors.New("volume not found")
 
@@ -32,22 +33,102 @@ var (
 	ErrSnapshotStillSnapshotting = errors.New("snapshot is still snapshotting")
 )
 
+// VolumeClass describes the attachment capabilities of a class of volumes
+type VolumeClass struct {
+	// MaxAttachments is the number of servers a volume can be attached to at the same time
+	MaxAttachments int
+	// MultiWriter is true if a volume can be written from several servers at the same time
+	MultiWriter bool
+}
+
+var (
+	// defaultVolumeClass is the class of the volume types not listed in volumeClasses
+	defaultVolumeClass = VolumeClass{MaxAttachments: 1}
+
+	// volumeClasses are the capabilities of each volume type
+	volumeClasses = map[instance.VolumeVolumeType]VolumeClass{
+		instance.VolumeVolumeTypeBSSD: {MaxAttachments: 1},
+	}
+)
+
+// GetVolumeClass returns the capabilities of the volumes of the given type
+func GetVolumeClass(volumeType instance.VolumeVolumeType) VolumeClass {
+	if class, ok := volumeClasses[volumeType]; ok {
+		return class
+	}
+	return defaultVolumeClass
+}
+
+// VolumeClasses returns the capabilities of all the known volume types
+func VolumeClasses() []VolumeClass {
+	classes := []VolumeClass{defaultVolumeClass}
+	for _, class := range volumeClasses {
+		classes = append(classes, class)
+	}
+	return classes
+}
+
 // Scaleway is the struct used to communicate withe the Scaleway provider
 type Scaleway struct {
 	InstanceAPI
+
+	// zones are the zones in which resources are looked up when their zone is unknown
+	zones []scw.Zone
+
+	// serverTypes caches the server types of each zone, they are not expected to change while running
+	serverTypes    map[scw.Zone]map[string]*instance.ServerType
+	serverTypesMux sync.Mutex
+
+	// cache holds the responses of GetVolumeCached and GetServerCached
+	cache apiCache
 }
 
 // NewScaleway returns a new Scaleway object which will use the given user agent
 func NewScaleway(userAgent string) *Scaleway {
-	client, err := scw.NewClient(
+	s, err := newScaleway(
 		scw.WithEnv(),
 		scw.WithUserAgent(userAgent),
+		scw.WithHTTPClient(newHTTPClient()),
 	)
 	if err != nil {
 		panic(err)
 	}
+	return s
+}
+
+// NewScalewayWithCredentials returns a new Scaleway object which will use the given user agent
+// and credentials instead of the ones from the environment
+func NewScalewayWithCredentials(userAgent string, accessKey string, secretKey string, projectID string) (*Scaleway, error) {
+	return newScaleway(
+		scw.WithEnv(),
+		scw.WithUserAgent(userAgent),
+		scw.WithHTTPClient(newHTTPClient()),
+		scw.WithAuth(accessKey, secretKey),
+		scw.WithDefaultProjectID(projectID),
+	)
+}
+
+func newScaleway(opts ...scw.ClientOption) (*Scaleway, error) {
+	client, err := scw.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
 	api := instance.NewAPI(client)
-	return &Scaleway{api}
+
+	zones := api.Zones()
+	if region, ok := client.GetDefaultRegion(); ok {
+		zones = region.GetZones()
+	}
+
+	return &Scaleway{
+		InstanceAPI: api,
+		zones:       zones,
+	}, nil
+}
+
+// Zones returns the zones in which resources are looked up when their zone is unknown
+func (s *Scaleway) Zones() []scw.Zone {
+	return s.zones
 }
 
 // Metadata is an interface for the instance metadata
@@ -101,8 +182,20 @@ type InstanceAPI interface {
 	// DeleteSnapshot is an interface for the SDK CreateSnapshot method
 	DeleteSnapshot(req *instance.DeleteSnapshotRequest, opts ...scw.RequestOption) error
 
+	// ExportSnapshot is an interface for the SDK ExportSnapshot method
+	ExportSnapshot(req *instance.ExportSnapshotRequest, opts ...scw.RequestOption) (*instance.ExportSnapshotResponse, error)
+
+	// UpdateSnapshot is an interface for the SDK UpdateSnapshot method
+	UpdateSnapshot(req *instance.UpdateSnapshotRequest, opts ...scw.RequestOption) (*instance.UpdateSnapshotResponse, error)
+
+	// WaitForSnapshot is an interface for the SDK WaitForSnapshot method
+	WaitForSnapshot(req *instance.WaitForSnapshotRequest, opts ...scw.RequestOption) (*instance.Snapshot, error)
+
 	// ListVolumesTypes is an interface for the SDK ListVolumesTypes method
 	ListVolumesTypes(req *instance.ListVolumesTypesRequest, opts ...scw.RequestOption) (*instance.ListVolumesTypesResponse, error)
+
+	// ListServersTypes is an interface for the SDK ListServersTypes method
+	ListServersTypes(req *instance.ListServersTypesRequest, opts ...scw.RequestOption) (*instance.ListServersTypesResponse, error)
 }
 
 func (s *Scaleway) GetVolumeLimits(volumeType string) (int64, int64, error) {
@@ -118,35 +211,51 @@ func (s *Scaleway) GetVolumeLimits(volumeType string) (int64, int64, error) {
 	return 0, 0, fmt.Errorf("volume type %s not found", volumeType)
 }
 
-// GetVolumeByName is a helper to find a volume by it's name, type and given size
-func (s *Scaleway) GetVolumeByName(name string, size int64, volumeType instance.VolumeVolumeType) (*instance.Volume, error) {
-	volumesResp, err := s.ListVolumes(&instance.ListVolumesRequest{
-		Name:       &name,
-		VolumeType: &volumeType,
-	}, scw.WithAllPages())
-	if err != nil {
-		return nil, err
+// GetVolumeByName is a helper to find a volume by it's name in all the zones, whatever its type and size.
+// If projectID is not empty, only the volumes of this project are considered
+func (s *Scaleway) GetVolumeByName(name string, projectID string, opts ...scw.RequestOption) (*instance.Volume, error) {
+	zones := s.zones
+	if len(zones) == 0 {
+		zones = []scw.Zone{scw.Zone("")} // the default zone of the client
 	}
-	volumes := volumesResp.Volumes
+
+	volumes := []*instance.Volume{}
+	seen := map[string]bool{}
+	for _, zone := range zones {
+		req := &instance.ListVolumesRequest{
+			Zone: zone,
+			Name: &name,
+		}
+		if projectID != "" {
+			req.Project = &projectID
+		}
+		volumesResp, err := s.ListVolumes(req, append(opts, scw.WithAllPages())...)
+		if err != nil {
+			return nil, err
+		}
+		for _, volume := range volumesResp.Volumes {
+			if volume.Name == name && !seen[volume.ID] { // fuzzy search on the API
+				seen[volume.ID] = true
+				volumes = append(volumes, volume)
+			}
+		}
+	}
+
 	if len(volumes) != 0 {
 		if len(volumes) > 1 {
 			return nil, ErrMultipleVolumes
 		}
-		volume := volumes[0]
-		if uint64(volume.Size) != uint64(size) {
-			return nil, ErrDifferentSize
-		}
-		return volume, nil
+		return volumes[0], nil
 	}
 	return nil, ErrVolumeNotFound
 }
 
 // GetSnapshotByName is a helper to find a snapshot by it's name and it's source volume ID and zone
-func (s *Scaleway) GetSnapshotByName(name string, sourceVolumeID string, sourceVolumeZone scw.Zone) (*instance.Snapshot, error) {
+func (s *Scaleway) GetSnapshotByName(name string, sourceVolumeID string, sourceVolumeZone scw.Zone, opts ...scw.RequestOption) (*instance.Snapshot, error) {
 	snapshots, err := s.ListSnapshots(&instance.ListSnapshotsRequest{
 		Name: &name,
 		Zone: sourceVolumeZone,
-	}, scw.WithAllPages())
+	}, append(opts, scw.WithAllPages())...)
 	if err != nil {
 		return nil, err
 	}
@@ -161,3 +270,232 @@ func (s *Scaleway) GetSnapshotByName(name string, sourceVolumeID string, sourceV
 	}
 	return nil, ErrSnapshotNotFound
 }
+
+// GetVolumeInAllZones is a helper to find a volume by it's ID when it's zone is unknown
+func (s *Scaleway) GetVolumeInAllZones(volumeID string, opts ...scw.RequestOption) (*instance.Volume, error) {
+	for _, zone := range s.zones {
+		volumeResp, err := s.GetVolume(&instance.GetVolumeRequest{
+			VolumeID: volumeID,
+			Zone:     zone,
+		}, opts...)
+		if err != nil {
+			if _, ok := err.(*scw.ResourceNotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+		return volumeResp.Volume, nil
+	}
+	return nil, &scw.ResourceNotFoundError{Resource: "instance_volume", ResourceID: volumeID}
+}
+
+// GetSnapshotInAllZones is a helper to find a snapshot by it's ID when it's zone is unknown
+func (s *Scaleway) GetSnapshotInAllZones(snapshotID string, opts ...scw.RequestOption) (*instance.Snapshot, error) {
+	for _, zone := range s.zones {
+		snapshotResp, err := s.GetSnapshot(&instance.GetSnapshotRequest{
+			SnapshotID: snapshotID,
+			Zone:       zone,
+		}, opts...)
+		if err != nil {
+			if _, ok := err.(*scw.ResourceNotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+		return snapshotResp.Snapshot, nil
+	}
+	return nil, &scw.ResourceNotFoundError{Resource: "instance_snapshot", ResourceID: snapshotID}
+}
+
+// CreateVolumeFromSnapshot is a helper to create a volume from a snapshot with the given size.
+// The API does not allow to set both the base snapshot and the size, so the volume is
+// resized once created if the given size is greater than the one of the snapshot
+func (s *Scaleway) CreateVolumeFromSnapshot(ctx context.Context, req *instance.CreateVolumeRequest, size scw.Size, opts ...scw.RequestOption) (*instance.Volume, error) {
+	volumeResp, err := s.CreateVolume(req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s.ResizeRestoredVolume(ctx, volumeResp.Volume, size, opts...)
+}
+
+// ResizeRestoredVolume grows a volume created from a snapshot to the given size once it is available,
+// it is also used to finish the restores whose resize failed, which left the volume at the size of the snapshot
+func (s *Scaleway) ResizeRestoredVolume(ctx context.Context, volume *instance.Volume, size scw.Size, opts ...scw.RequestOption) (*instance.Volume, error) {
+	if volume.Size >= size {
+		return volume, nil
+	}
+
+	volume, err := s.WaitForVolumeContext(ctx, &instance.WaitForVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.UpdateVolume(&instance.UpdateVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+		Size:     &size,
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error resizing volume %s created from snapshot: %w", volume.ID, err)
+	}
+
+	return s.WaitForVolumeContext(ctx, &instance.WaitForVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	}, opts...)
+}
+
+// WaitForVolumeContext waits for the volume to be in a terminal state like WaitForVolume,
+// but returns the error of the context as soon as it is done
+func (s *Scaleway) WaitForVolumeContext(ctx context.Context, req *instance.WaitForVolumeRequest, opts ...scw.RequestOption) (*instance.Volume, error) {
+	var volume *instance.Volume
+	err := waitWithContext(ctx, func() (err error) {
+		volume, err = s.WaitForVolume(req, append(opts, scw.WithContext(ctx))...)
+		return err
+	})
+	if err != nil {
+		// volume must not be read if the wait was abandoned
+		return nil, err
+	}
+	return volume, nil
+}
+
+// WaitForSnapshotContext waits for the snapshot to be in a terminal state like WaitForSnapshot,
+// but returns the error of the context as soon as it is done
+func (s *Scaleway) WaitForSnapshotContext(ctx context.Context, req *instance.WaitForSnapshotRequest, opts ...scw.RequestOption) (*instance.Snapshot, error) {
+	var snapshot *instance.Snapshot
+	err := waitWithContext(ctx, func() (err error) {
+		snapshot, err = s.WaitForSnapshot(req, append(opts, scw.WithContext(ctx))...)
+		return err
+	})
+	if err != nil {
+		// snapshot must not be read if the wait was abandoned
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// VolumeBusyError is the error returned by WaitUntilVolumeSettled when an operation
+// (e.g. a snapshot or a resize) is still running on the volume at the end of the wait
+type VolumeBusyError struct {
+	VolumeID string
+	State    instance.VolumeState
+}
+
+func (e *VolumeBusyError) Error() string {
+	return fmt.Sprintf("volume %s is still in state %s", e.VolumeID, e.State)
+}
+
+// IsVolumeSettled returns true if no operation is running on a volume in the given state
+func IsVolumeSettled(state instance.VolumeState) bool {
+	switch state {
+	case instance.VolumeStateSnapshotting, instance.VolumeStateResizing, instance.VolumeStateFetching, instance.VolumeStateSaving, instance.VolumeStateHotsyncing:
+		return false
+	}
+	return true
+}
+
+// WaitUntilVolumeSettled waits up to timeout for the operation running on the volume, if any, to end
+// and returns the refreshed volume, or a *VolumeBusyError if the operation is still running
+func (s *Scaleway) WaitUntilVolumeSettled(ctx context.Context, volume *instance.Volume, timeout time.Duration, opts ...scw.RequestOption) (*instance.Volume, error) {
+	if IsVolumeSettled(volume.State) {
+		return volume, nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	settled, err := s.WaitForVolumeContext(waitCtx, &instance.WaitForVolumeRequest{
+		VolumeID: volume.ID,
+		Zone:     volume.Zone,
+	}, opts...)
+	if err != nil {
+		if waitCtx.Err() != nil {
+			return nil, &VolumeBusyError{VolumeID: volume.ID, State: volume.State}
+		}
+		return nil, err
+	}
+	if !IsVolumeSettled(settled.State) {
+		return nil, &VolumeBusyError{VolumeID: volume.ID, State: settled.State}
+	}
+	return settled, nil
+}
+
+// waitWithContext runs the given wait until it returns or the context is done.
+// The SDK waits only check the context between two polls, the abandoned wait
+// stops at its next poll since its requests are made with the same context.
+func waitWithContext(ctx context.Context, wait func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SupportsBlockStorage returns false if a server of the given commercial type can't get block storage (SBS)
+// volumes attached, e.g. the legacy types with only local volumes. The unknown types are assumed to support them.
+func (s *Scaleway) SupportsBlockStorage(commercialType string, zone scw.Zone, opts ...scw.RequestOption) (bool, error) {
+	s.serverTypesMux.Lock()
+	defer s.serverTypesMux.Unlock()
+
+	if s.serverTypes == nil {
+		s.serverTypes = make(map[scw.Zone]map[string]*instance.ServerType)
+	}
+	serverTypes, ok := s.serverTypes[zone]
+	if !ok {
+		serverTypesResp, err := s.ListServersTypes(&instance.ListServersTypesRequest{
+			Zone: zone,
+		}, append(opts, scw.WithAllPages())...)
+		if err != nil {
+			return false, err
+		}
+		serverTypes = serverTypesResp.Servers
+		s.serverTypes[zone] = serverTypes
+	}
+
+	serverType, ok := serverTypes[commercialType]
+	if !ok || serverType.Capabilities == nil || serverType.Capabilities.BlockStorage == nil {
+		return true, nil
+	}
+	return *serverType.Capabilities.BlockStorage, nil
+}
+
+// CachedServerTypesZones returns the number of zones for which the server types are cached
+func (s *Scaleway) CachedServerTypesZones() int {
+	s.serverTypesMux.Lock()
+	defer s.serverTypesMux.Unlock()
+	return len(s.serverTypes)
+}
+
+// ServerLocalVolumesCount returns the number of local volumes (l_ssd and scratch) of the server,
+// which use some of its attachment slots
+func ServerLocalVolumesCount(server *instance.Server) int {
+	count := 0
+	for _, volume := range server.Volumes {
+		switch instance.VolumeVolumeType(volume.VolumeType) {
+		case instance.VolumeVolumeTypeLSSD, instance.VolumeVolumeTypeScratch:
+			count++
+		}
+	}
+	return count
+}
+
+// LocalVolumesCount returns the number of local volumes (l_ssd and scratch) of the instance
+// described by the given metadata, which use some of the attachment slots of the instance
+func LocalVolumesCount(metadata *instance.Metadata) int {
+	count := 0
+	for _, volume := range metadata.Volumes {
+		switch instance.VolumeVolumeType(volume.VolumeType) {
+		case instance.VolumeVolumeTypeLSSD, instance.VolumeVolumeTypeScratch:
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,77 @@
+package scaleway
+
+import (
+	"testing"
+
+	"github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+// zonedAPI only finds the volumes and the snapshots in their own zone, like the Instance API
+type zonedAPI struct {
+	InstanceAPI
+	volumes   map[string]*instance.Volume
+	snapshots map[string]*instance.Snapshot
+	// lookups are the zones in which the resources were looked up
+	lookups []scw.Zone
+}
+
+func (a *zonedAPI) GetVolume(req *instance.GetVolumeRequest, opts ...scw.RequestOption) (*instance.GetVolumeResponse, error) {
+	a.lookups = append(a.lookups, req.Zone)
+	if req.Zone == scw.ZoneNlAms1 {
+		return nil, &scw.PermissionsDeniedError{}
+	}
+	if volume, ok := a.volumes[req.VolumeID]; ok && volume.Zone == req.Zone {
+		return &instance.GetVolumeResponse{Volume: volume}, nil
+	}
+	return nil, &scw.ResourceNotFoundError{}
+}
+
+func (a *zonedAPI) GetSnapshot(req *instance.GetSnapshotRequest, opts ...scw.RequestOption) (*instance.GetSnapshotResponse, error) {
+	a.lookups = append(a.lookups, req.Zone)
+	if snapshot, ok := a.snapshots[req.SnapshotID]; ok && snapshot.Zone == req.Zone {
+		return &instance.GetSnapshotResponse{Snapshot: snapshot}, nil
+	}
+	return nil, &scw.ResourceNotFoundError{}
+}
+
+func Test_GetInAllZones(t *testing.T) {
+	api := &zonedAPI{
+		volumes:   map[string]*instance.Volume{"volume-id": {ID: "volume-id", Zone: scw.ZoneFrPar2}},
+		snapshots: map[string]*instance.Snapshot{"snapshot-id": {ID: "snapshot-id", Zone: scw.ZoneFrPar3}},
+	}
+	s := &Scaleway{InstanceAPI: api, zones: []scw.Zone{scw.ZoneFrPar1, scw.ZoneFrPar2, scw.ZoneFrPar3}}
+
+	volume, err := s.GetVolumeInAllZones("volume-id")
+	if err != nil || volume.Zone != scw.ZoneFrPar2 {
+		t.Fatalf("volume not found in its zone: %v", err)
+	}
+	// the lookup stops at the zone of the volume
+	if len(api.lookups) != 2 {
+		t.Fatalf("volume looked up in %v", api.lookups)
+	}
+
+	snapshot, err := s.GetSnapshotInAllZones("snapshot-id")
+	if err != nil || snapshot.Zone != scw.ZoneFrPar3 {
+		t.Fatalf("snapshot not found in its zone: %v", err)
+	}
+
+	if _, err := s.GetVolumeInAllZones("missing-volume-id"); err == nil {
+		t.Fatal("missing volume found")
+	} else if _, ok := err.(*scw.ResourceNotFoundError); !ok {
+		t.Fatalf("unexpected error %v for a missing volume", err)
+	}
+	if _, err := s.GetSnapshotInAllZones("missing-snapshot-id"); err == nil {
+		t.Fatal("missing snapshot found")
+	} else if _, ok := err.(*scw.ResourceNotFoundError); !ok {
+		t.Fatalf("unexpected error %v for a missing snapshot", err)
+	}
+
+	// the errors other than not found stop the lookup
+	s.zones = []scw.Zone{scw.ZoneNlAms1, scw.ZoneFrPar2}
+	if _, err := s.GetVolumeInAllZones("volume-id"); err == nil {
+		t.Fatal("volume found despite the error of the first zone")
+	} else if _, ok := err.(*scw.PermissionsDeniedError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
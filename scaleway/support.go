@@ -0,0 +1,123 @@
+package scaleway
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRecordedAPIErrors is the number of failed API calls kept for the support bundles
+const maxRecordedAPIErrors = 100
+
+// APIError is a failed Scaleway API call, as reported in the support bundles.
+// It does not contain any credential, query string or request body.
+type APIError struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Resource   string    `json:"resource,omitempty"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Duration   string    `json:"duration"`
+	// ScwRequestID is the ID of the call on the Scaleway side, asked by the support
+	ScwRequestID string `json:"scwRequestID,omitempty"`
+	// RequestID is the ID of the CSI call which made the API call, as found in the logs of the driver
+	RequestID string `json:"requestID,omitempty"`
+}
+
+// apiErrors is a ring buffer of the last failed API calls
+type apiErrors struct {
+	errors []APIError
+	next   int
+	mux    sync.Mutex
+}
+
+var recordedAPIErrors = &apiErrors{}
+
+func (a *apiErrors) record(apiErr APIError) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if len(a.errors) < maxRecordedAPIErrors {
+		a.errors = append(a.errors, apiErr)
+		return
+	}
+	a.errors[a.next] = apiErr
+	a.next = (a.next + 1) % maxRecordedAPIErrors
+}
+
+func (a *apiErrors) list() []APIError {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	errors := make([]APIError, 0, len(a.errors))
+	errors = append(errors, a.errors[a.next:]...)
+	return append(errors, a.errors[:a.next]...)
+}
+
+// LastAPIError returns the last API call made with the request ID of the context if it failed: a call which
+// succeeded since, e.g. a retry, is not the cause of the error of the request
+func LastAPIError(ctx context.Context) (APIError, bool) {
+	calls, ok := ctx.Value(requestIDKey{}).(*requestCalls)
+	if !ok {
+		return APIError{}, false
+	}
+	calls.mux.Lock()
+	defer calls.mux.Unlock()
+	if calls.lastError == nil {
+		return APIError{}, false
+	}
+	return *calls.lastError, true
+}
+
+// RecentAPIErrors returns the last failed Scaleway API calls, the oldest first
+func RecentAPIErrors() []APIError {
+	return recordedAPIErrors.list()
+}
+
+type requestIDKey struct{}
+
+// requestCalls holds the request ID of the API calls made with a context and the outcome of the last one
+type requestCalls struct {
+	id        string
+	lastError *APIError
+	mux       sync.Mutex
+}
+
+// ContextWithRequestID returns a context in which the API calls are recorded with the given request ID
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, &requestCalls{id: requestID})
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	if calls, ok := ctx.Value(requestIDKey{}).(*requestCalls); ok {
+		return calls.id
+	}
+	return ""
+}
+
+// recordAPICall records the outcome of an API call made with the given context, apiErr being nil if it succeeded
+func recordAPICall(ctx context.Context, apiErr *APIError) {
+	if apiErr != nil {
+		recordedAPIErrors.record(*apiErr)
+	}
+	if calls, ok := ctx.Value(requestIDKey{}).(*requestCalls); ok {
+		calls.mux.Lock()
+		calls.lastError = apiErr
+		calls.mux.Unlock()
+	}
+}
+
+// resourceFromPath returns the type and the ID of the resource targeted by the given API path
+// (e.g. volumes/<id> for /instance/v1/zones/fr-par-1/volumes/<id>), if any
+func resourceFromPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := len(parts) - 2; i >= 0; i-- {
+		switch parts[i] {
+		case "volumes", "snapshots", "servers":
+			return parts[i] + "/" + parts[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package scaleway
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_LastAPIError(t *testing.T) {
+	ctx := ContextWithRequestID(context.Background(), "request-id")
+	otherCtx := ContextWithRequestID(context.Background(), "other-request-id")
+
+	if _, ok := LastAPIError(ctx); ok {
+		t.Fatal("no API call made yet")
+	}
+
+	recordAPICall(ctx, &APIError{Path: "/volumes/volume-id", StatusCode: 404})
+	recordAPICall(otherCtx, &APIError{Path: "/servers/server-id", StatusCode: 500})
+	apiErr, ok := LastAPIError(ctx)
+	if !ok || apiErr.Path != "/volumes/volume-id" {
+		t.Fatalf("unexpected last API error %+v of the request", apiErr)
+	}
+
+	// a call which succeeded since is not the cause of the error of the request
+	recordAPICall(ctx, nil)
+	if apiErr, ok := LastAPIError(ctx); ok {
+		t.Fatalf("unexpected last API error %+v after a successful call", apiErr)
+	}
+
+	// the calls made without request ID are only recorded for the support bundles
+	recordAPICall(context.Background(), &APIError{Path: "/snapshots/snapshot-id"})
+	if _, ok := LastAPIError(context.Background()); ok {
+		t.Fatal("unexpected last API error without request ID")
+	}
+	errors := RecentAPIErrors()
+	if errors[len(errors)-1].Path != "/snapshots/snapshot-id" {
+		t.Fatalf("unexpected recent API errors %+v", errors)
+	}
+}
@@ -0,0 +1,164 @@
+package scaleway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+)
+
+// attributeScwRequestID is the span attribute of the Scaleway request ID of an API call, asked by the support
+const attributeScwRequestID = attribute.Key("scaleway.request_id")
+
+// TracerName is the name of the tracer of the driver spans, a no-op one unless the tracing is set up
+const TracerName = "github.com/scaleway/scaleway-csi"
+
+// apiRateLimiter limits the rate of the calls to the Scaleway API of all the clients, unlimited by default
+var apiRateLimiter = rate.NewLimiter(rate.Inf, 0)
+
+// SetAPIRateLimit limits the calls to the Scaleway API to qps per second, with bursts of burst calls,
+// the calls waiting for their turn until the deadline of their context. It is unlimited if qps is zero.
+func SetAPIRateLimit(qps float64, burst int) {
+	if qps <= 0 {
+		apiRateLimiter.SetLimit(rate.Inf)
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	apiRateLimiter.SetBurst(burst)
+	apiRateLimiter.SetLimit(rate.Limit(qps))
+}
+
+// apiReadSlots and apiWriteSlots limit the concurrent reads (GET) and writes to the Scaleway API,
+// unlimited if nil. They are separate so that a burst of reads, e.g. listing the volumes, can't delay
+// the attachments and detachments.
+var apiReadSlots, apiWriteSlots chan struct{}
+
+// SetMaxConcurrentAPICalls limits the number of concurrent reads and writes to the Scaleway API of all the clients,
+// unlimited if zero. It must be called before the first call.
+func SetMaxConcurrentAPICalls(reads int, writes int) {
+	apiReadSlots, apiWriteSlots = nil, nil
+	if reads > 0 {
+		apiReadSlots = make(chan struct{}, reads)
+	}
+	if writes > 0 {
+		apiWriteSlots = make(chan struct{}, writes)
+	}
+}
+
+type writeSlotsKey struct{}
+
+// ContextWithWriteSlots returns a context whose reads use the slots of the writes, for the polls waiting
+// for the end of an attachment or a detachment, which must not be delayed by a burst of reads either
+func ContextWithWriteSlots(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writeSlotsKey{}, true)
+}
+
+// acquireAPISlot waits for a free slot for the request until the end of its context, returning the function
+// releasing it
+func acquireAPISlot(req *http.Request) (func(), error) {
+	slots := apiWriteSlots
+	if (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.Context().Value(writeSlotsKey{}) == nil {
+		slots = apiReadSlots
+	}
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+// newHTTPClient returns the same HTTP client as the SDK one, with the API calls being logged
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &loggingTransport{
+			rt: &http.Transport{
+				DialContext:           (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
+				TLSHandshakeTimeout:   5 * time.Second,
+				ResponseHeaderTimeout: 30 * time.Second,
+				MaxIdleConnsPerHost:   20,
+			},
+		},
+	}
+}
+
+// loggingTransport logs the API calls with the logger of the request context,
+// allowing to correlate them with the CSI RPC which triggered them
+type loggingTransport struct {
+	rt http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger := klog.FromContext(req.Context())
+
+	// the span includes the waits for the client limits, showing the throttled calls in the traces
+	ctx, span := otel.Tracer(TracerName).Start(req.Context(), "Scaleway API "+req.Method+" "+resourceFromPath(req.URL.Path),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPMethod(req.Method), attribute.String("url.path", req.URL.Path)),
+	)
+	defer span.End()
+	req = req.WithContext(ctx)
+
+	if err := apiRateLimiter.Wait(req.Context()); err != nil {
+		logger.V(4).Info("Scaleway API call not sent because of the client rate limit", "method", req.Method, "path", req.URL.Path, "err", err)
+		span.SetStatus(otelcodes.Error, err.Error())
+		return nil, err
+	}
+	release, err := acquireAPISlot(req)
+	if err != nil {
+		logger.V(4).Info("Scaleway API call not sent because of the concurrent calls limit", "method", req.Method, "path", req.URL.Path, "err", err)
+		span.SetStatus(otelcodes.Error, err.Error())
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
+
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		logger.V(4).Info("Scaleway API call failed", "method", req.Method, "path", req.URL.Path, "duration", time.Since(start), "err", err)
+		span.SetStatus(otelcodes.Error, err.Error())
+		recordAPICall(req.Context(), &APIError{
+			Time:      start,
+			Method:    req.Method,
+			Path:      req.URL.Path,
+			Resource:  resourceFromPath(req.URL.Path),
+			Error:     err.Error(),
+			Duration:  time.Since(start).String(),
+			RequestID: requestIDFromContext(req.Context()),
+		})
+		return nil, err
+	}
+
+	logger.V(4).Info("Scaleway API call", "method", req.Method, "path", req.URL.Path, "status", resp.StatusCode, "duration", time.Since(start), "scwRequestID", resp.Header.Get("X-Request-Id"))
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode), attributeScwRequestID.String(resp.Header.Get("X-Request-Id")))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(otelcodes.Error, resp.Status)
+		recordAPICall(req.Context(), &APIError{
+			Time:         start,
+			Method:       req.Method,
+			Path:         req.URL.Path,
+			Resource:     resourceFromPath(req.URL.Path),
+			StatusCode:   resp.StatusCode,
+			Duration:     time.Since(start).String(),
+			ScwRequestID: resp.Header.Get("X-Request-Id"),
+			RequestID:    requestIDFromContext(req.Context()),
+		})
+		return resp, nil
+	}
+	recordAPICall(req.Context(), nil)
+	return resp, nil
+}
@@ -0,0 +1,70 @@
+package scaleway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_acquireAPISlot(t *testing.T) {
+	SetMaxConcurrentAPICalls(1, 1)
+	defer SetMaxConcurrentAPICalls(0, 0)
+
+	newRequest := func(ctx context.Context, method string) *http.Request {
+		req, err := http.NewRequestWithContext(ctx, method, "https://api.scaleway.com/instance/v1/zones/fr-par-1/volumes", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	// tryAcquire returns the function releasing the slot, or nil if no slot was free
+	tryAcquire := func(ctx context.Context, method string) func() {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		release, err := acquireAPISlot(newRequest(ctx, method))
+		if err != nil {
+			return nil
+		}
+		return release
+	}
+
+	releaseRead := tryAcquire(context.Background(), http.MethodGet)
+	if releaseRead == nil {
+		t.Fatal("no read slot acquired")
+	}
+	if tryAcquire(context.Background(), http.MethodGet) != nil {
+		t.Fatal("read slot acquired above the limit")
+	}
+
+	// the writes and the polls of the attachments don't wait for the reads
+	writeCtx := ContextWithWriteSlots(context.Background())
+	releasePoll := tryAcquire(writeCtx, http.MethodGet)
+	if releasePoll == nil {
+		t.Fatal("no write slot acquired for the poll while the reads are exhausted")
+	}
+	if tryAcquire(context.Background(), http.MethodPost) != nil {
+		t.Fatal("write slot acquired above the limit")
+	}
+	releasePoll()
+	releaseWrite := tryAcquire(context.Background(), http.MethodPost)
+	if releaseWrite == nil {
+		t.Fatal("write slot not released")
+	}
+	releaseWrite()
+
+	releaseRead()
+	if release := tryAcquire(context.Background(), http.MethodGet); release == nil {
+		t.Fatal("read slot not released")
+	} else {
+		release()
+	}
+
+	// unlimited without limits
+	SetMaxConcurrentAPICalls(0, 0)
+	for i := 0; i < 3; i++ {
+		if tryAcquire(context.Background(), http.MethodGet) == nil {
+			t.Fatal("read slot refused without limit")
+		}
+	}
+}
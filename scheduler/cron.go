@@ -0,0 +1,180 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScheduleYears bounds the search of the next activation of a schedule
+// which can never match (e.g. "0 0 30 2 *")
+const maxScheduleYears = 5
+
+// scheduleDescriptors are the supported shorthands of the cron format
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Schedule is a parsed cron schedule, each field is a bitset of the matching values
+type Schedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+
+	// restrictedDays is true if both the day of month and the day of week are restricted,
+	// in which case a day matching any of them matches, as in cron
+	restrictedDays bool
+}
+
+// scheduleField describes the range of a field of the cron format
+type scheduleField struct {
+	name string
+	min  int
+	max  int
+}
+
+var scheduleFields = []scheduleField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// ParseSchedule parses a schedule in the standard cron format: minute, hour, day of month,
+// month and day of week, with lists, ranges and steps (e.g. "*/15 2-4 * * 1,3"), or one of the @daily like shorthands
+func ParseSchedule(spec string) (*Schedule, error) {
+	if expanded, ok := scheduleDescriptors[strings.TrimSpace(spec)]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("invalid schedule %q: expected %d fields, got %d", spec, len(scheduleFields), len(fields))
+	}
+
+	bitsets := make([]uint64, len(fields))
+	for i, field := range fields {
+		bitset, err := parseScheduleField(field, scheduleFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		bitsets[i] = bitset
+	}
+
+	// 7 is also sunday
+	weekdays := bitsets[4]
+	if weekdays&(1<<7) != 0 {
+		weekdays = weekdays&^(1<<7) | 1
+	}
+
+	return &Schedule{
+		minutes:        bitsets[0],
+		hours:          bitsets[1],
+		days:           bitsets[2],
+		months:         bitsets[3],
+		weekdays:       weekdays,
+		restrictedDays: !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseScheduleField returns the bitset of the values matched by the given field
+func parseScheduleField(field string, desc scheduleField) (uint64, error) {
+	var bitset uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s %q", desc.name, part)
+			}
+		}
+
+		start, end := desc.min, desc.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = parseScheduleValue(bounds[0], desc); err != nil {
+				return 0, err
+			}
+			if end, err = parseScheduleValue(bounds[1], desc); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range in %s %q", desc.name, part)
+			}
+		default:
+			value, err := parseScheduleValue(rangePart, desc)
+			if err != nil {
+				return 0, err
+			}
+			start = value
+			if step == 1 {
+				end = value
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			bitset |= 1 << uint(value)
+		}
+	}
+	return bitset, nil
+}
+
+func parseScheduleValue(value string, desc scheduleField) (int, error) {
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < desc.min || parsed > desc.max {
+		return 0, fmt.Errorf("invalid %s %q, must be between %d and %d", desc.name, value, desc.min, desc.max)
+	}
+	return parsed, nil
+}
+
+// Next returns the first activation of the schedule strictly after t, in the location of t.
+// It returns the zero time if the schedule does not match in the next years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + maxScheduleYears
+
+	for t.Year() <= limit {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dayMatches := s.days&(1<<uint(t.Day())) != 0
+	weekdayMatches := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.restrictedDays {
+		return dayMatches || weekdayMatches
+	}
+	return dayMatches && weekdayMatches
+}
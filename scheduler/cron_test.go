@@ -0,0 +1,41 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	from := time.Date(2023, time.January, 31, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 2 * * *", time.Date(2023, time.February, 1, 2, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2023, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, time.January, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2023, time.February, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// the day of month or the day of week must match when both are set
+		{"0 0 15 * 3", time.Date(2023, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %s", tt.spec, err)
+		}
+		if got := schedule.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Next(%s) of %q = %s, want %s", from, tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseSchedule_invalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@never"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) should have failed", spec)
+		}
+	}
+}
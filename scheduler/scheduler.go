@@ -0,0 +1,222 @@
+// Package scheduler creates and rotates VolumeSnapshots of the PersistentVolumeClaims
+// annotated with a cron schedule
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ScheduleAnnotation is the annotation of the PersistentVolumeClaims holding their snapshot schedule, in the cron format
+	ScheduleAnnotation = "csi.scaleway.com/snapshot-schedule"
+	// RetentionAnnotation is the annotation of the PersistentVolumeClaims holding the number of scheduled snapshots to keep
+	RetentionAnnotation = "csi.scaleway.com/snapshot-retention"
+	// SnapshotClassAnnotation is the annotation of the PersistentVolumeClaims holding the VolumeSnapshotClass of their scheduled snapshots
+	SnapshotClassAnnotation = "csi.scaleway.com/snapshot-class"
+
+	// scheduledFromLabel is the label of the scheduled VolumeSnapshots holding the UID of their PersistentVolumeClaim
+	scheduledFromLabel = "csi.scaleway.com/scheduled-from"
+
+	// DefaultRetention is the number of scheduled snapshots kept when RetentionAnnotation is not set
+	DefaultRetention = 7
+
+	// checkInterval is the interval between two checks of the schedules, the precision of the cron format
+	checkInterval = time.Minute
+
+	// snapshotNameTimeFormat is the format of the creation time in the names of the scheduled snapshots
+	snapshotNameTimeFormat = "20060102-1504"
+	maxNameLength          = 253
+)
+
+var volumeSnapshotsResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+// Scheduler creates VolumeSnapshots of the PersistentVolumeClaims of a CSI driver
+// following their ScheduleAnnotation, and deletes the oldest ones above their retention
+type Scheduler struct {
+	client        kubernetes.Interface
+	dynamicClient dynamic.Interface
+	driverName    string
+}
+
+// New returns a Scheduler for the volumes of the given CSI driver
+func New(client kubernetes.Interface, dynamicClient dynamic.Interface, driverName string) *Scheduler {
+	return &Scheduler{
+		client:        client,
+		dynamicClient: dynamicClient,
+		driverName:    driverName,
+	}
+}
+
+// NewInCluster returns a Scheduler for the volumes of the given CSI driver using the in-cluster Kubernetes configuration
+func NewInCluster(driverName string) (*Scheduler, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error getting in-cluster kubernetes config: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes client: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes dynamic client: %w", err)
+	}
+
+	return New(client, dynamicClient, driverName), nil
+}
+
+// Run checks the schedules every minute until stop is closed
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	klog.Infof("creating the snapshots of the volumes annotated with %s", ScheduleAnnotation)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), checkInterval)
+			if err := s.reconcile(ctx, now); err != nil {
+				klog.Errorf("error reconciling scheduled snapshots: %s", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// reconcile creates the snapshots due at the given time and deletes the ones above the retention
+func (s *Scheduler) reconcile(ctx context.Context, now time.Time) error {
+	pvcs, err := s.client.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing persistent volume claims: %w", err)
+	}
+
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		spec, ok := pvc.Annotations[ScheduleAnnotation]
+		if !ok || pvc.Status.Phase != corev1.ClaimBound {
+			continue
+		}
+
+		if err := s.reconcilePVC(ctx, pvc, spec, now); err != nil {
+			klog.Errorf("error reconciling scheduled snapshots of persistent volume claim %s/%s: %s", pvc.Namespace, pvc.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) reconcilePVC(ctx context.Context, pvc *corev1.PersistentVolumeClaim, spec string, now time.Time) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	retention := DefaultRetention
+	if value, ok := pvc.Annotations[RetentionAnnotation]; ok {
+		retention, err = strconv.Atoi(value)
+		if err != nil || retention < 1 {
+			return fmt.Errorf("invalid %s %q, must be a positive integer", RetentionAnnotation, value)
+		}
+	}
+
+	pv, err := s.client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error getting persistent volume %s: %w", pvc.Spec.VolumeName, err)
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != s.driverName {
+		return nil
+	}
+
+	snapshotsClient := s.dynamicClient.Resource(volumeSnapshotsResource).Namespace(pvc.Namespace)
+	snapshotsList, err := snapshotsClient.List(ctx, metav1.ListOptions{
+		LabelSelector: scheduledFromLabel + "=" + string(pvc.UID),
+	})
+	if err != nil {
+		return fmt.Errorf("error listing volume snapshots: %w", err)
+	}
+	snapshots := snapshotsList.Items
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].GetCreationTimestamp().Time.Before(snapshots[j].GetCreationTimestamp().Time)
+	})
+
+	last := pvc.CreationTimestamp.Time
+	if len(snapshots) > 0 {
+		last = snapshots[len(snapshots)-1].GetCreationTimestamp().Time
+	}
+	if next := schedule.Next(last); !next.IsZero() && !next.After(now) {
+		snapshot, err := snapshotsClient.Create(ctx, newScheduledSnapshot(pvc, now), metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("error creating volume snapshot: %w", err)
+		}
+		klog.Infof("created scheduled volume snapshot %s/%s of persistent volume claim %s", snapshot.GetNamespace(), snapshot.GetName(), pvc.Name)
+		snapshots = append(snapshots, *snapshot)
+	}
+
+	for len(snapshots) > retention {
+		oldest := snapshots[0]
+		err := snapshotsClient.Delete(ctx, oldest.GetName(), metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting volume snapshot %s: %w", oldest.GetName(), err)
+		}
+		klog.Infof("deleted scheduled volume snapshot %s/%s of persistent volume claim %s", oldest.GetNamespace(), oldest.GetName(), pvc.Name)
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
+
+// newScheduledSnapshot returns the VolumeSnapshot of the given PersistentVolumeClaim taken at the given time
+func newScheduledSnapshot(pvc *corev1.PersistentVolumeClaim, now time.Time) *unstructured.Unstructured {
+	suffix := "-" + now.UTC().Format(snapshotNameTimeFormat)
+	name := pvc.Name
+	if len(name)+len(suffix) > maxNameLength {
+		name = name[:maxNameLength-len(suffix)]
+	}
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": pvc.Name,
+		},
+	}
+	if class := pvc.Annotations[SnapshotClassAnnotation]; class != "" {
+		spec["volumeSnapshotClassName"] = class
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": volumeSnapshotsResource.GroupVersion().String(),
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]interface{}{
+				"name":      name + suffix,
+				"namespace": pvc.Namespace,
+				"labels": map[string]interface{}{
+					scheduledFromLabel: string(pvc.UID),
+				},
+			},
+			"spec": spec,
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestScheduler_reconcile(t *testing.T) {
+	now := time.Date(2023, time.February, 1, 2, 0, 30, 0, time.UTC)
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "data",
+			Namespace: "default",
+			UID:       "pvc-uid",
+			Annotations: map[string]string{
+				ScheduleAnnotation:  "0 2 * * *",
+				RetentionAnnotation: "2",
+			},
+			CreationTimestamp: metav1.NewTime(now.Add(-72 * time.Hour)),
+		},
+		Spec:   corev1.PersistentVolumeClaimSpec{VolumeName: "pv-data"},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-data"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: "csi.scaleway.com", VolumeHandle: "fr-par-1/volume-id"},
+			},
+		},
+	}
+
+	scheduledSnapshot := func(age time.Duration) *unstructured.Unstructured {
+		snapshot := newScheduledSnapshot(pvc, now.Add(-age))
+		snapshot.SetCreationTimestamp(metav1.NewTime(now.Add(-age)))
+		return snapshot
+	}
+	listKinds := map[schema.GroupVersionResource]string{volumeSnapshotsResource: "VolumeSnapshotList"}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		scheduledSnapshot(48*time.Hour),
+		scheduledSnapshot(24*time.Hour),
+	)
+	s := New(fake.NewSimpleClientset(pvc, pv), dynamicClient, "csi.scaleway.com")
+
+	snapshotNames := func() map[string]bool {
+		list, err := dynamicClient.Resource(volumeSnapshotsResource).Namespace("default").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := map[string]bool{}
+		for _, item := range list.Items {
+			names[item.GetName()] = true
+		}
+		return names
+	}
+
+	if err := s.reconcile(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	names := snapshotNames()
+	if len(names) != 2 || !names["data-20230201-0200"] || !names["data-20230131-0200"] {
+		t.Errorf("expected the new snapshot and the one of the previous day, got %v", names)
+	}
+}
@@ -0,0 +1,177 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scaleway/scaleway-sdk-go/api/k8s/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+const (
+	defaultFocus    = `External.Storage`
+	defaultSkip     = `\[Disruptive\]|\[Serial\]`
+	defaultNodeType = "DEV1-M"
+
+	clusterTimeout = 30 * time.Minute
+)
+
+// TestE2E runs the upstream Kubernetes external storage tests against the cluster of KUBECONFIG,
+// or against a Kapsule cluster created for the tests when E2E_KAPSULE is set to true
+func TestE2E(t *testing.T) {
+	kubeconfig := os.Getenv("KUBECONFIG")
+	if kubeconfig == "" {
+		if os.Getenv("E2E_KAPSULE") != "true" {
+			t.Skip("KUBECONFIG is not set and E2E_KAPSULE is not true, no cluster to run the tests against")
+		}
+		kubeconfig = createKapsuleCluster(t)
+	}
+
+	if manifest := os.Getenv("E2E_DRIVER_MANIFEST"); manifest != "" {
+		deployDriver(t, kubeconfig, manifest)
+	}
+
+	runExternalStorageTests(t, kubeconfig)
+}
+
+// createKapsuleCluster creates a Kapsule cluster with the Scaleway credentials of the environment,
+// and returns the path of its kubeconfig. The cluster is deleted at the end of the test unless E2E_KEEP_CLUSTER is true.
+func createKapsuleCluster(t *testing.T) string {
+	client, err := scw.NewClient(scw.WithEnv(), scw.WithUserAgent("scaleway-csi-e2e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := k8s.NewAPI(client)
+
+	version := os.Getenv("E2E_KAPSULE_VERSION")
+	if version == "" {
+		versions, err := api.ListVersions(&k8s.ListVersionsRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions.Versions) == 0 {
+			t.Fatal("no Kapsule version available")
+		}
+		version = versions.Versions[0].Name
+	}
+
+	nodeType := os.Getenv("E2E_KAPSULE_NODE_TYPE")
+	if nodeType == "" {
+		nodeType = defaultNodeType
+	}
+
+	cluster, err := api.CreateCluster(&k8s.CreateClusterRequest{
+		Name:    fmt.Sprintf("scaleway-csi-e2e-%d", time.Now().Unix()),
+		Version: version,
+		Cni:     k8s.CNICilium,
+		Tags:    []string{"scaleway-csi-e2e"},
+		Pools: []*k8s.CreateClusterRequestPoolConfig{
+			{
+				Name:             "e2e",
+				NodeType:         nodeType,
+				Size:             2,
+				ContainerRuntime: k8s.RuntimeContainerd,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("cluster %s created with version %s", cluster.ID, version)
+
+	t.Cleanup(func() {
+		if os.Getenv("E2E_KEEP_CLUSTER") == "true" {
+			t.Logf("keeping cluster %s", cluster.ID)
+			return
+		}
+		if _, err := api.DeleteCluster(&k8s.DeleteClusterRequest{
+			Region:                  cluster.Region,
+			ClusterID:               cluster.ID,
+			WithAdditionalResources: true,
+		}); err != nil {
+			t.Errorf("error deleting cluster %s: %s", cluster.ID, err)
+		}
+	})
+
+	timeout := clusterTimeout
+	cluster, err = api.WaitForClusterPool(&k8s.WaitForClusterRequest{
+		ClusterID: cluster.ID,
+		Region:    cluster.Region,
+		Timeout:   &timeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status != k8s.ClusterStatusReady {
+		t.Fatalf("cluster %s is %s", cluster.ID, cluster.Status)
+	}
+
+	kubeconfig, err := api.GetClusterKubeConfig(&k8s.GetClusterKubeConfigRequest{
+		Region:    cluster.Region,
+		ClusterID: cluster.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeconfigPath := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfigPath, kubeconfig.GetRaw(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return kubeconfigPath
+}
+
+// deployDriver applies the given manifest and waits for the driver to be rolled out
+func deployDriver(t *testing.T, kubeconfig string, manifest string) {
+	runCommand(t, "kubectl", "--kubeconfig", kubeconfig, "apply", "-f", manifest)
+	runCommand(t, "kubectl", "--kubeconfig", kubeconfig, "-n", "kube-system", "rollout", "status", "deployment/scaleway-csi-controller", "--timeout=10m")
+	runCommand(t, "kubectl", "--kubeconfig", kubeconfig, "-n", "kube-system", "rollout", "status", "daemonset/scaleway-csi-node", "--timeout=10m")
+}
+
+// runExternalStorageTests runs the e2e.test binary of the Kubernetes release of the cluster with testdriver.yaml
+func runExternalStorageTests(t *testing.T, kubeconfig string) {
+	testBinary := os.Getenv("E2E_TEST_BINARY")
+	if testBinary == "" {
+		testBinary = "e2e.test"
+	}
+	focus := os.Getenv("E2E_FOCUS")
+	if focus == "" {
+		focus = defaultFocus
+	}
+	skip := os.Getenv("E2E_SKIP")
+	if skip == "" {
+		skip = defaultSkip
+	}
+
+	testDriver, err := filepath.Abs("testdriver.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{
+		"-kubeconfig=" + kubeconfig,
+		"-storage.testdriver=" + testDriver,
+		"-ginkgo.focus=" + focus,
+		"-ginkgo.skip=" + skip,
+		"-ginkgo.v",
+	}
+	if reportDir := os.Getenv("E2E_REPORT_DIR"); reportDir != "" {
+		args = append(args, "-report-dir="+reportDir)
+	}
+	runCommand(t, testBinary, args...)
+}
+
+func runCommand(t *testing.T, name string, args ...string) {
+	t.Logf("running %s %v", name, args)
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("%s failed: %s", name, err)
+	}
+}